@@ -1,106 +1,513 @@
 package main
 
 import (
-	"fmt"
 	"os"
 
 	"github.com/spf13/cobra"
 	"mydbportal.com/dbmigrate/internal/cli"
+	"mydbportal.com/dbmigrate/internal/datadiff"
+	"mydbportal.com/dbmigrate/internal/migrate"
 	
 	// Register engines
 	_ "mydbportal.com/dbmigrate/internal/engine/mongo"
 	_ "mydbportal.com/dbmigrate/internal/engine/mysql"
 	_ "mydbportal.com/dbmigrate/internal/engine/postgres"
+	_ "mydbportal.com/dbmigrate/internal/engine/redis"
+	_ "mydbportal.com/dbmigrate/internal/engine/sqlite"
 )
 
 func main() {
 	var rootCmd = &cobra.Command{
-		Use:   "dbmigrate",
-		Short: "DB-Migrate-Go CLI",
+		Use:     "dbmigrate",
+		Short:   "DB-Migrate-Go CLI",
+		Version: cli.Version,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			logLevel, _ := cmd.Flags().GetString("log-level")
+			logFormat, _ := cmd.Flags().GetString("log-format")
+			logFile, _ := cmd.Flags().GetString("log-file")
+			logMaxSize, _ := cmd.Flags().GetInt("log-max-size")
+			if err := cli.SetupLogging(logLevel, logFormat, logFile, logMaxSize); err != nil {
+				cli.Fail(err)
+			}
+			progress, _ := cmd.Flags().GetString("progress")
+			if err := cli.SetProgress(progress); err != nil {
+				cli.Fail(err)
+			}
+			output, _ := cmd.Flags().GetString("output")
+			if err := cli.SetOutput(output); err != nil {
+				cli.Fail(err)
+			}
+			// Remote stores connect when opened, so commands that do not read
+			// or write backups must not depend on them
+			if !usesStorage(cmd) {
+				return
+			}
+			profile := ""
+			if f := cmd.Flags().Lookup("storage"); f != nil {
+				profile = f.Value.String()
+			}
+			if err := cli.OpenStorage(profile); err != nil {
+				cli.Fail(err)
+			}
+			identity := ""
+			if f := cmd.Flags().Lookup("identity"); f != nil {
+				identity = f.Value.String()
+			}
+			if err := cli.UseIdentity(identity); err != nil {
+				cli.Fail(err)
+			}
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 			// Default to help
 			cmd.Help()
 		},
 	}
 
+	rootCmd.PersistentFlags().StringP("output", "o", cli.OutputTable, "Output format of results: table, json or yaml")
+	rootCmd.PersistentFlags().String("log-level", "", "Log level: error, warn, info, debug or trace (default warn, info with --log-file)")
+	rootCmd.PersistentFlags().String("log-format", "text", "Log format: text or json")
+	rootCmd.PersistentFlags().String("log-file", "", "Write logs to this file instead of stderr")
+	rootCmd.PersistentFlags().Int("log-max-size", 10, "Rotate the log file at this size in MB, keeping 5 old files")
+	rootCmd.PersistentFlags().String("progress", "auto", "Progress of dumps and restores: auto (bars on a terminal, log lines otherwise), bars, log or off")
+
 	var initCmd = &cobra.Command{
 		Use:   "init",
 		Short: "Add a source server",
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := cli.RunInit(); err != nil {
-				fmt.Println("Error:", err)
-				os.Exit(1)
+			asTarget, _ := cmd.Flags().GetBool("target")
+			if err := cli.RunInit(cli.HumanOutput(), asTarget); err != nil {
+				cli.Fail(err)
 			}
 		},
 	}
+	initCmd.Flags().Bool("target", false, "Add a target server instead of a source")
 
 	var backupCmd = &cobra.Command{
 		Use:   "backup",
 		Short: "Backup databases",
 		Run: func(cmd *cobra.Command, args []string) {
 			source, _ := cmd.Flags().GetString("source")
-			db, _ := cmd.Flags().GetString("db")
+			dbs, _ := cmd.Flags().GetStringSlice("db")
 			
 			if source == "" {
-				fmt.Println("Error: --source required")
-				os.Exit(1)
+				cli.Fail(cli.Usagef("--source required"))
 			}
 
-			if err := cli.RunBackup(source, db); err != nil {
-				fmt.Println("Error:", err)
-				os.Exit(1)
+			tags, _ := cmd.Flags().GetStringSlice("tag")
+			if err := cli.RunBackup(cli.HumanOutput(), source, dbs, tags); err != nil {
+				cli.Fail(err)
 			}
 		},
 	}
 	backupCmd.Flags().String("source", "", "Source ID")
-	backupCmd.Flags().String("db", "", "Specific database name (optional)")
+	backupCmd.Flags().StringSlice("db", nil, "Only back up these databases (comma separated, optional)")
+	backupCmd.Flags().StringSlice("tag", nil, "Label the backup with these tags (comma separated, repeatable)")
+	backupCmd.Flags().String("storage", "", "Storage profile, directory or URL (default: the source's profile or default_storage)")
 
 	var listCmd = &cobra.Command{
 		Use:   "list",
 		Short: "List backups",
 		Run: func(cmd *cobra.Command, args []string) {
 			source, _ := cmd.Flags().GetString("source")
-			if err := cli.RunList(source); err != nil {
-				fmt.Println("Error:", err)
-				os.Exit(1)
+			db, _ := cmd.Flags().GetString("db")
+			tables, _ := cmd.Flags().GetBool("tables")
+			if err := cli.RunList(cli.HumanOutput(), source, db, tables); err != nil {
+				cli.Fail(err)
 			}
 		},
 	}
 	listCmd.Flags().String("source", "", "Filter by Source ID (optional)")
+	listCmd.Flags().String("db", "", "Only backups holding this database (optional)")
+	listCmd.Flags().Bool("tables", false, "Show the tables, row counts and schema fingerprints of each backup")
+	listCmd.Flags().String("storage", "", "Storage profile, directory or URL (default: the source's profile or default_storage)")
+
+	var showCmd = &cobra.Command{
+		Use:     "show <backup-id>",
+		Aliases: []string{"inspect"},
+		Short:   "Show the files, options and contents of a backup",
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var opts cli.ShowOptions
+			opts.NoContents, _ = cmd.Flags().GetBool("no-contents")
+			opts.Scan, _ = cmd.Flags().GetBool("scan")
+			opts.DDL, _ = cmd.Flags().GetBool("ddl")
+			if err := cli.RunShow(cli.HumanOutput(), args[0], opts); err != nil {
+				cli.Fail(err)
+			}
+		},
+	}
+	showCmd.Flags().Bool("no-contents", false, "Do not list the tables of the backup files")
+	showCmd.Flags().Bool("scan", false, "Read the backup files to list their tables instead of taking them from the metadata")
+	showCmd.Flags().Bool("ddl", false, "Read the backup files and print their CREATE TABLE statements (or collection metadata)")
+	showCmd.Flags().String("storage", "", "Storage profile, directory or URL (default: default_storage)")
+	showCmd.Flags().String("identity", "", "age identity or SSH private key file for encrypted backups (default $DBMIGRATE_IDENTITY)")
 
 	var restoreCmd = &cobra.Command{
 		Use:   "restore",
 		Short: "Restore a backup",
 		Run: func(cmd *cobra.Command, args []string) {
 			backup, _ := cmd.Flags().GetString("backup")
+			backupID, _ := cmd.Flags().GetString("backup-id")
+			latest, _ := cmd.Flags().GetBool("latest")
+			source, _ := cmd.Flags().GetString("source")
+			dbs, _ := cmd.Flags().GetStringSlice("db")
 			target, _ := cmd.Flags().GetString("target")
+			yes, _ := cmd.Flags().GetBool("yes")
+			safety, _ := cmd.Flags().GetBool("safety-backup")
 
-			if backup == "" || target == "" {
-				fmt.Println("Error: --backup and --target required")
-				os.Exit(1)
+			selected := 0
+			for _, set := range []bool{backup != "", backupID != "", latest} {
+				if set {
+					selected++
+				}
+			}
+			if selected != 1 || target == "" {
+				cli.Fail(cli.Usagef("--target and one of --backup, --backup-id or --latest required"))
+			}
+			if latest && source == "" {
+				cli.Fail(cli.Usagef("--latest requires --source"))
+			}
+			if backup != "" && len(dbs) > 0 {
+				cli.Fail(cli.Usagef("--db can only be used with --backup-id or --latest"))
 			}
 
-			if err := cli.RunRestore(backup, target); err != nil {
-				fmt.Println("Error:", err)
-				os.Exit(1)
+			opts := cli.RestoreOptions{Yes: yes, SafetyBackup: safety}
+			var err error
+			if backup != "" {
+				err = cli.RunRestore(cli.HumanOutput(), backup, target, opts)
+			} else {
+				err = cli.RunRestoreBackup(cli.HumanOutput(), backupID, source, dbs, target, opts)
+			}
+			if err != nil {
+				cli.Fail(err)
 			}
 		},
 	}
 	restoreCmd.Flags().String("backup", "", "Path to backup file")
-	restoreCmd.Flags().String("target", "", "Target Source ID")
+	restoreCmd.Flags().String("backup-id", "", "Restore the backup with this ID (see metadata.json)")
+	restoreCmd.Flags().Bool("latest", false, "Restore the latest backup of --source")
+	restoreCmd.Flags().String("source", "", "Source ID for --latest")
+	restoreCmd.Flags().StringSlice("db", nil, "Only restore these databases of the backup (comma separated)")
+	restoreCmd.Flags().String("target", "", "Target ID")
+	restoreCmd.Flags().Bool("yes", false, "Skip the confirmation prompt")
+	restoreCmd.Flags().String("identity", "", "age identity or SSH private key file for encrypted backups (default $DBMIGRATE_IDENTITY)")
+	restoreCmd.Flags().Bool("safety-backup", false, "Back up the affected target databases before restoring")
+	restoreCmd.Flags().String("storage", "", "Storage profile, directory or URL (default: the source's profile or default_storage)")
+
+	var migrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrate a database to a target of a different engine (mysql -> postgres, mysql/postgres -> mongo)",
+		Run: func(cmd *cobra.Command, args []string) {
+			from, _ := cmd.Flags().GetString("from")
+			to, _ := cmd.Flags().GetString("to")
+			db, _ := cmd.Flags().GetString("db")
+			targetDB, _ := cmd.Flags().GetString("target-db")
+			tables, _ := cmd.Flags().GetStringSlice("tables")
+			enumMode, _ := cmd.Flags().GetString("enum")
+			drop, _ := cmd.Flags().GetBool("drop-existing")
+			report, _ := cmd.Flags().GetString("report")
+			mappingPath, _ := cmd.Flags().GetString("mapping")
+			checkpoint, _ := cmd.Flags().GetString("checkpoint")
+			batchSize, _ := cmd.Flags().GetInt("batch-size")
+
+			if from == "" || to == "" || db == "" {
+				cli.Fail(cli.Usagef("--from, --to and --db required"))
+			}
+
+			var mapping *migrate.Mapping
+			if mappingPath != "" {
+				m, err := migrate.LoadMapping(mappingPath)
+				if err != nil {
+					cli.Fail(err)
+				}
+				mapping = m
+			}
+
+			opts := migrate.Options{
+				Database:       db,
+				TargetDatabase: targetDB,
+				Tables:         tables,
+				EnumMode:       enumMode,
+				DropExisting:   drop,
+				Mapping:        mapping,
+				Checkpoint:     checkpoint,
+				BatchSize:      batchSize,
+			}
+			if err := cli.RunMigrate(cli.HumanOutput(), from, to, opts, report); err != nil {
+				cli.Fail(err)
+			}
+		},
+	}
+	migrateCmd.Flags().String("from", "", "Source ID")
+	migrateCmd.Flags().String("to", "", "Target ID")
+	migrateCmd.Flags().String("db", "", "Database to migrate")
+	migrateCmd.Flags().String("target-db", "", "Database name on the target (defaults to --db)")
+	migrateCmd.Flags().StringSlice("tables", nil, "Only migrate these tables (comma separated)")
+	migrateCmd.Flags().String("enum", migrate.EnumCheck, "ENUM mapping: check (varchar + CHECK) or type (CREATE TYPE)")
+	migrateCmd.Flags().Bool("drop-existing", false, "Drop tables (or collections) that already exist on the target")
+	migrateCmd.Flags().String("report", "", "Write the translation report as JSON to this file")
+	migrateCmd.Flags().String("mapping", "", "Table to collection mapping file (JSON, mongo targets)")
+	migrateCmd.Flags().String("checkpoint", "", "Progress file for resuming a failed migration (mongo targets)")
+	migrateCmd.Flags().Int("batch-size", 0, "Documents per insert batch (mongo targets, default 1000)")
+
+	var verifyCmd = &cobra.Command{
+		Use:   "verify",
+		Short: "Verify backup checksums and archives",
+		Run: func(cmd *cobra.Command, args []string) {
+			backupID, _ := cmd.Flags().GetString("backup-id")
+			all, _ := cmd.Flags().GetBool("all")
+			report, _ := cmd.Flags().GetString("report")
+
+			if (backupID == "") == !all {
+				cli.Fail(cli.Usagef("one of --backup-id or --all required"))
+			}
+
+			if err := cli.RunVerify(cli.HumanOutput(), backupID, all, report); err != nil {
+				cli.Fail(err)
+			}
+		},
+	}
+	verifyCmd.Flags().String("backup-id", "", "Backup ID to verify")
+	verifyCmd.Flags().String("storage", "", "Storage profile, directory or URL (default: default_storage)")
+	verifyCmd.Flags().String("identity", "", "age identity or SSH private key file for encrypted backups (default $DBMIGRATE_IDENTITY)")
+	verifyCmd.Flags().Bool("all", false, "Verify every backup in the store")
+	verifyCmd.Flags().String("report", "", "Write the results as JSON to this file")
+
+	var pruneCmd = &cobra.Command{
+		Use:   "prune",
+		Short: "Remove backups according to the retention rules",
+		Run: func(cmd *cobra.Command, args []string) {
+			source, _ := cmd.Flags().GetString("source")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			if err := cli.RunPrune(cli.HumanOutput(), source, dryRun); err != nil {
+				cli.Fail(err)
+			}
+		},
+	}
+	pruneCmd.Flags().String("source", "", "Only prune the backups of this source")
+	pruneCmd.Flags().String("storage", "", "Storage profile, directory or URL (default: the source's profile or default_storage)")
+	pruneCmd.Flags().Bool("dry-run", false, "Show what would be removed and why, without removing anything")
+
+	var gcCmd = &cobra.Command{
+		Use:   "gc",
+		Short: "Remove chunks no deduplicated backup references",
+		Run: func(cmd *cobra.Command, args []string) {
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			if err := cli.RunGC(cli.HumanOutput(), dryRun); err != nil {
+				cli.Fail(err)
+			}
+		},
+	}
+	gcCmd.Flags().String("storage", "", "Storage profile, directory or URL (default: default_storage)")
+	gcCmd.Flags().Bool("dry-run", false, "Show how much would be removed, without removing anything")
+
+	var drillCmd = &cobra.Command{
+		Use:   "drill",
+		Short: "Test-restore a backup into scratch databases and check it",
+		Run: func(cmd *cobra.Command, args []string) {
+			backupID, _ := cmd.Flags().GetString("backup-id")
+			scratch, _ := cmd.Flags().GetString("scratch")
+			dbs, _ := cmd.Flags().GetStringSlice("db")
+			assertions, _ := cmd.Flags().GetStringArray("assert")
+			assertFile, _ := cmd.Flags().GetString("assert-file")
+			keep, _ := cmd.Flags().GetBool("keep")
+
+			if backupID == "" || scratch == "" {
+				cli.Fail(cli.Usagef("--backup-id and --scratch required"))
+			}
+			if assertFile != "" {
+				fromFile, err := cli.LoadAssertions(assertFile)
+				if err != nil {
+					cli.Fail(err)
+				}
+				assertions = append(assertions, fromFile...)
+			}
+
+			opts := cli.DrillOptions{Databases: dbs, Assertions: assertions, Keep: keep}
+			if err := cli.RunDrill(cli.HumanOutput(), backupID, scratch, opts); err != nil {
+				cli.Fail(err)
+			}
+		},
+	}
+	drillCmd.Flags().String("backup-id", "", "Backup ID to drill")
+	drillCmd.Flags().String("scratch", "", "Target ID to restore into (scratch databases are created and dropped)")
+	drillCmd.Flags().StringSlice("db", nil, "Only drill these databases of the backup (comma separated)")
+	drillCmd.Flags().StringArray("assert", nil, "SQL query that must return a true/non-zero value (repeatable)")
+	drillCmd.Flags().String("assert-file", "", "File with one assertion query per line")
+	drillCmd.Flags().String("storage", "", "Storage profile, directory or URL (default: default_storage)")
+	drillCmd.Flags().String("identity", "", "age identity or SSH private key file for encrypted backups (default $DBMIGRATE_IDENTITY)")
+	drillCmd.Flags().Bool("keep", false, "Keep the scratch databases instead of dropping them")
 
 	var interactiveCmd = &cobra.Command{
 		Use:   "interactive",
-		Short: "Launch interactive menu",
+		Short: "Launch the full-screen interactive menu",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := cli.InteractiveMenu(); err != nil {
+				cli.Fail(err)
+			}
+		},
+	}
+	interactiveCmd.Flags().String("storage", "", "Storage profile, directory or URL to browse and restore from (default: default_storage)")
+	interactiveCmd.Flags().String("identity", "", "age identity or SSH private key file for encrypted backups (default $DBMIGRATE_IDENTITY)")
+
+	var catalogCmd = &cobra.Command{
+		Use:   "catalog",
+		Short: "Query and maintain the local backup catalog",
+	}
+
+	var catalogRebuildCmd = &cobra.Command{
+		Use:   "rebuild",
+		Short: "Regenerate the catalog from the metadata in storage",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := cli.RunCatalogRebuild(cli.HumanOutput()); err != nil {
+				cli.Fail(err)
+			}
+		},
+	}
+	catalogRebuildCmd.Flags().String("storage", "", "Storage profile, directory or URL (default: default_storage)")
+
+	var catalogQueryCmd = &cobra.Command{
+		Use:   "query",
+		Short: "List the backups matching the given filters",
+		Run: func(cmd *cobra.Command, args []string) {
+			var q cli.CatalogQuery
+			q.Source, _ = cmd.Flags().GetString("source")
+			q.Engine, _ = cmd.Flags().GetString("engine")
+			q.Database, _ = cmd.Flags().GetString("db")
+			q.Status, _ = cmd.Flags().GetString("status")
+			q.Since, _ = cmd.Flags().GetString("since")
+			q.Until, _ = cmd.Flags().GetString("until")
+			q.Tags, _ = cmd.Flags().GetStringSlice("tag")
+			q.Tables, _ = cmd.Flags().GetBool("tables")
+			if err := cli.RunCatalogQuery(cli.HumanOutput(), q); err != nil {
+				cli.Fail(err)
+			}
+		},
+	}
+	catalogQueryCmd.Flags().String("source", "", "Backups of this source")
+	catalogQueryCmd.Flags().String("engine", "", "Backups of this engine")
+	catalogQueryCmd.Flags().String("db", "", "Backups holding this database")
+	catalogQueryCmd.Flags().String("status", "", "Backups with this status (success, partial, failed)")
+	catalogQueryCmd.Flags().String("since", "", "Backups taken at or after this date, time or age (e.g. 2025-11-01 or 7d)")
+	catalogQueryCmd.Flags().String("until", "", "Backups taken at or before this date, time or age")
+	catalogQueryCmd.Flags().StringSlice("tag", nil, "Backups carrying all these tags")
+	catalogQueryCmd.Flags().Bool("tables", false, "Show the tables recorded for each backup")
+	catalogQueryCmd.Flags().String("storage", "", "Storage profile, directory or URL (default: the source's profile or default_storage)")
+
+	var catalogTagCmd = &cobra.Command{
+		Use:   "tag <backup-id> <tag>...",
+		Short: "Add tags to a backup, or remove them with --remove",
+		Args:  cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			remove, _ := cmd.Flags().GetBool("remove")
+			if err := cli.RunCatalogTag(cli.HumanOutput(), args[0], args[1:], remove); err != nil {
+				cli.Fail(err)
+			}
+		},
+	}
+	catalogTagCmd.Flags().Bool("remove", false, "Remove the tags instead of adding them")
+	catalogTagCmd.Flags().String("storage", "", "Storage profile, directory or URL (default: default_storage)")
+	catalogCmd.AddCommand(catalogRebuildCmd, catalogQueryCmd, catalogTagCmd)
+
+	var diffCmd = &cobra.Command{
+		Use:   "diff",
+		Short: "Compare backups, schemas and data",
+	}
+
+	var diffBackupsCmd = &cobra.Command{
+		Use:   "backups <left-id> <right-id>",
+		Short: "Compare the tables, row counts and schema fingerprints of two backups",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := cli.RunDiffBackups(cli.HumanOutput(), args[0], args[1]); err != nil {
+				cli.Fail(err)
+			}
+		},
+	}
+	diffBackupsCmd.Flags().String("storage", "", "Storage profile, directory or URL (default: default_storage)")
+
+	var diffSchemaCmd = &cobra.Command{
+		Use:   "schema",
+		Short: "Compare the schemas of two databases, live or from backups",
 		Run: func(cmd *cobra.Command, args []string) {
-			cli.InteractiveMenu()
+			left, _ := cmd.Flags().GetString("left")
+			right, _ := cmd.Flags().GetString("right")
+			report, _ := cmd.Flags().GetString("report")
+			alter, _ := cmd.Flags().GetString("alter-script")
+
+			if left == "" || right == "" {
+				cli.Fail(cli.Usagef("--left and --right required"))
+			}
+
+			if err := cli.RunDiffSchema(cli.HumanOutput(), left, right, report, alter); err != nil {
+				cli.Fail(err)
+			}
 		},
 	}
+	diffSchemaCmd.Flags().String("left", "", "Reference side: <server-id>:<db> or <backup-id>:<db>")
+	diffSchemaCmd.Flags().String("right", "", "Compared side: <server-id>:<db> or <backup-id>:<db>")
+	diffSchemaCmd.Flags().String("report", "", "Write the differences as JSON to this file")
+	diffSchemaCmd.Flags().String("alter-script", "", "Write SQL that brings the right side in line with the left (mysql and postgres)")
 
-	rootCmd.AddCommand(initCmd, backupCmd, listCmd, restoreCmd, interactiveCmd)
+	var diffDataCmd = &cobra.Command{
+		Use:   "data",
+		Short: "Compare the rows of two live databases of the same engine by checksums",
+		Run: func(cmd *cobra.Command, args []string) {
+			left, _ := cmd.Flags().GetString("left")
+			right, _ := cmd.Flags().GetString("right")
+			tables, _ := cmd.Flags().GetStringSlice("tables")
+			chunkSize, _ := cmd.Flags().GetInt64("chunk-size")
+			pause, _ := cmd.Flags().GetDuration("pause")
+			maxRows, _ := cmd.Flags().GetInt("max-rows")
+			report, _ := cmd.Flags().GetString("report")
+
+			if left == "" || right == "" {
+				cli.Fail(cli.Usagef("--left and --right required"))
+			}
+
+			opts := datadiff.Options{
+				Tables:    tables,
+				ChunkSize: chunkSize,
+				Pause:     pause,
+				MaxRows:   maxRows,
+			}
+			if err := cli.RunDiffData(cli.HumanOutput(), left, right, opts, report); err != nil {
+				cli.Fail(err)
+			}
+		},
+	}
+	diffDataCmd.Flags().String("left", "", "Reference side: <server-id>:<db>")
+	diffDataCmd.Flags().String("right", "", "Compared side: <server-id>:<db>")
+	diffDataCmd.Flags().StringSlice("tables", nil, "Only compare these tables (comma separated)")
+	diffDataCmd.Flags().Int64("chunk-size", 1000, "Rows per checksummed key range")
+	diffDataCmd.Flags().Duration("pause", 0, "Pause before every query to throttle the load (e.g. 50ms)")
+	diffDataCmd.Flags().Int("max-rows", 1000, "Maximum differing rows reported per table")
+	diffDataCmd.Flags().String("report", "", "Write the differences as JSON to this file")
+	diffCmd.AddCommand(diffBackupsCmd, diffSchemaCmd, diffDataCmd)
+
+	rootCmd.AddCommand(initCmd, backupCmd, listCmd, showCmd, restoreCmd, migrateCmd, verifyCmd, pruneCmd, gcCmd, drillCmd, diffCmd, catalogCmd, interactiveCmd)
+
+	// The backup store is opened before these commands (and the catalog
+	// subcommands) run; diff schema opens it when it reads a backup
+	for _, cmd := range []*cobra.Command{backupCmd, listCmd, showCmd, restoreCmd, verifyCmd, pruneCmd, gcCmd, drillCmd, catalogCmd, diffBackupsCmd, interactiveCmd} {
+		cmd.Annotations = map[string]string{storageAnnotation: "true"}
+	}
 
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		// Cobra has printed the flag or argument error
+		os.Exit(cli.ExitUsage)
+	}
+}
+
+// storageAnnotation marks the commands that use the backup store.
+const storageAnnotation = "storage"
+
+// usesStorage reports whether cmd or one of its parents uses the backup store.
+func usesStorage(cmd *cobra.Command) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c.Annotations[storageAnnotation] != "" {
+			return true
+		}
 	}
-}
\ No newline at end of file
+	return false
+}
@@ -3,11 +3,16 @@ package cli
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
+	"filippo.io/age"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/term"
 
 	"mydbportal.com/dbmigrate/internal/config"
@@ -17,34 +22,37 @@ import (
 )
 
 // Helper to read line from stdin
-func readLine(prompt string) string {
-	fmt.Print(prompt)
+func readLine(out io.Writer, prompt string) string {
+	fmt.Fprint(out, prompt)
 	reader := bufio.NewReader(os.Stdin)
 	text, _ := reader.ReadString('\n')
 	return strings.TrimSpace(text)
 }
 
 // Helper to read password
-func readPassword(prompt string) string {
-	fmt.Print(prompt)
+func readPassword(out io.Writer, prompt string) string {
+	fmt.Fprint(out, prompt)
 	bytePassword, _ := term.ReadPassword(int(os.Stdin.Fd()))
-	fmt.Println()
+	fmt.Fprintln(out)
 	return string(bytePassword)
 }
 
-func RunInit() error {
-	fmt.Println("=== Add Source Server ===")
-	mgr, err := config.NewManager()
-	if err != nil {
-		return err
+// RunInit prompts for a server and saves it as a source, or as a target
+// when asTarget is set.
+func RunInit(out io.Writer, asTarget bool) error {
+	kind := "Source"
+	if asTarget {
+		kind = "Target"
 	}
-
-	id := readLine("Source ID (name): ")
-	engineType := readLine("Engine (mysql, postgres, mongo): ")
-	host := readLine("Host (IP/Domain): ")
-	portStr := readLine("Port: ")
-	user := readLine("User: ")
-	pass := readPassword("Password: ")
+	fmt.Fprintf(out, "=== Add %s Server ===\n", kind)
+	id := readLine(out, kind+" ID (name): ")
+	engines := engine.ListEngines()
+	sort.Strings(engines)
+	engineType := readLine(out, fmt.Sprintf("Engine (%s): ", strings.Join(engines, ", ")))
+	host := readLine(out, "Host (IP/Domain, or file path/glob for sqlite): ")
+	portStr := readLine(out, "Port: ")
+	user := readLine(out, "User: ")
+	pass := readPassword(out, "Password: ")
 
 	var port int
 	fmt.Sscanf(portStr, "%d", &port)
@@ -58,29 +66,355 @@ func RunInit() error {
 		Password: pass,
 	}
 
-	if err := mgr.AddSource(server); err != nil {
+	if err := AddServer(server, asTarget); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s added successfully!\n", kind)
+	return nil
+}
+
+// AddServer saves a source server, or a target server when asTarget is set.
+func AddServer(server config.ServerConfig, asTarget bool) error {
+	if _, err := engine.Get(server.Engine); err != nil {
+		return err
+	}
+	mgr, err := config.NewManager()
+	if err != nil {
+		return err
+	}
+	add := mgr.AddSource
+	if asTarget {
+		add = mgr.AddTarget
+	}
+	if err := add(server); err != nil {
 		return fmt.Errorf("failed to save config: %w", err)
 	}
-	fmt.Println("Source added successfully!")
 	return nil
 }
 
-func RunList(sourceID string) error {
-	backups, err := storage.ListBackups()
+// Version is the dbmigrate release, set at build time with
+// -ldflags "-X mydbportal.com/dbmigrate/internal/cli.Version=v1.2.3"
+var Version = "dev"
+
+// storageChosen is set when the storage profile was picked explicitly, so
+// per-source profiles do not override it.
+var storageChosen bool
+
+// storageOpened is set once OpenStorage selected the backup store.
+var storageOpened bool
+
+// storageProfile is the profile of the current backup store
+var storageProfile config.StorageProfile
+
+// storageRecipients encrypt new backups in the current store, identified
+// in metadata by storageKeyIDs
+var (
+	storageRecipients []age.Recipient
+	storageKeyIDs     []string
+)
+
+// OpenStorage makes the named storage profile the backup store, or the
+// default profile when name is empty.
+func OpenStorage(name string) error {
+	mgr, err := config.NewManager()
 	if err != nil {
 		return err
 	}
+	profile, err := mgr.StorageArg(name)
+	if err != nil {
+		return err
+	}
+	if err := openProfile(mgr, profile); err != nil {
+		return err
+	}
+	storageChosen = name != ""
+	storageOpened = true
+	return nil
+}
 
-	fmt.Printf("% -25s | % -10s | % -20s | % -10s\n", "TIMESTAMP", "ENGINE", "SOURCE HOST", "STATUS")
-	fmt.Println(strings.Repeat("-", 80))
+// requireStorage opens the default storage profile for commands that only
+// sometimes read backups, unless a store was opened already.
+func requireStorage() error {
+	if storageOpened {
+		return nil
+	}
+	return OpenStorage("")
+}
 
-	for _, b := range backups {
-		fmt.Printf("% -25s | % -10s | % -20s | % -10s\n", b.Timestamp, b.Engine, b.Host, b.Status)
+func openProfile(mgr *config.Manager, profile config.StorageProfile) error {
+	if err := profile.Validate(); err != nil {
+		return err
+	}
+	codec, err := profile.Codec()
+	if err != nil {
+		return err
+	}
+	// Deduplicated dumps are streamed uncompressed and compressed chunk by chunk
+	streamCodec := codec
+	if profile.Dedup {
+		if streamCodec, err = util.GetCodec("none"); err != nil {
+			return err
+		}
+	}
+	if err := util.SetCompression(streamCodec, profile.CompressionLevel); err != nil {
+		return fmt.Errorf("storage profile %s: %w", profile.Name, err)
+	}
+	var recipients []age.Recipient
+	var keyIDs []string
+	for _, spec := range profile.Recipients {
+		r, ids, err := util.ParseRecipient(spec)
+		if err != nil {
+			return fmt.Errorf("storage profile %s: invalid recipient: %w", profile.Name, err)
+		}
+		recipients = append(recipients, r...)
+		keyIDs = append(keyIDs, ids...)
+	}
+	if err := storage.Use(profile.Location); err != nil {
+		return fmt.Errorf("failed to open storage %s: %w", profile.Name, err)
+	}
+	storage.UseCatalog(mgr.CatalogDir())
+	if profile.Dedup {
+		storage.UseDedup(codec, profile.CompressionLevel)
+	} else {
+		storage.UseDedup(nil, 0)
+	}
+	storageProfile = profile
+	storageRecipients, storageKeyIDs = recipients, keyIDs
+	return nil
+}
+
+// identityEnv names the identity file when --identity is not given
+const identityEnv = "DBMIGRATE_IDENTITY"
+
+// UseIdentity loads the identity that decrypts encrypted backups, from path
+// or the file named by DBMIGRATE_IDENTITY.
+func UseIdentity(path string) error {
+	if path == "" {
+		path = os.Getenv(identityEnv)
+	}
+	if path == "" {
+		return nil
+	}
+	ids, err := util.LoadIdentities(path)
+	if err != nil {
+		return err
+	}
+	util.SetIdentities(ids)
+	return nil
+}
+
+// useSourceStorage switches to the storage profile of sourceID, if it has
+// one and no profile was chosen explicitly.
+func useSourceStorage(mgr *config.Manager, sourceID string) error {
+	if storageChosen || sourceID == "" {
+		return nil
+	}
+	for _, s := range mgr.Config.Sources {
+		if s.ID == sourceID && s.Storage != "" {
+			profile, err := mgr.StorageProfile(s.Storage)
+			if err != nil {
+				return err
+			}
+			return openProfile(mgr, profile)
+		}
 	}
 	return nil
 }
 
-func RunBackup(sourceID string, dbName string) error {
+// RunList lists the backups in the store, only those of a source or
+// holding a database if sourceID or dbName is set. With showTables, the
+// tables recorded for each file are listed below the backup.
+func RunList(out io.Writer, sourceID string, dbName string, showTables bool) error {
+	query := storage.Query{Database: dbName}
+	if sourceID != "" {
+		mgr, err := config.NewManager()
+		if err != nil {
+			return err
+		}
+		if query, err = sourceQuery(mgr, sourceID); err != nil {
+			return err
+		}
+		query.Database = dbName
+	}
+	backups, err := storage.QueryBackups(query)
+	if err != nil {
+		return err
+	}
+	res, err := listBackups(backups, showTables)
+	if err != nil {
+		return err
+	}
+	return render(out, res)
+}
+
+// sourceQuery selects the backups of a source, and opens the source's
+// storage profile unless one was chosen.
+func sourceQuery(mgr *config.Manager, sourceID string) (storage.Query, error) {
+	source, err := mgr.GetSource(sourceID)
+	if err != nil {
+		return storage.Query{}, err
+	}
+	if err := useSourceStorage(mgr, source.ID); err != nil {
+		return storage.Query{}, err
+	}
+	return storage.Query{Source: source.ID, Engine: source.Engine, Host: source.Host, Port: source.Port}, nil
+}
+
+// ListResult is the outcome of list and catalog query.
+type ListResult struct {
+	Backups []BackupRow `json:"backups"`
+	// Repository sums up the chunk store if some backups are deduplicated
+	Repository *RepositorySummary `json:"repository,omitempty"`
+}
+
+// BackupRow is one backup of a listing.
+type BackupRow struct {
+	ID        string   `json:"id"`
+	Timestamp string   `json:"timestamp"`
+	Engine    string   `json:"engine"`
+	Host      string   `json:"host"`
+	Port      int      `json:"port"`
+	Source    string   `json:"source,omitempty"`
+	Status    string   `json:"status"`
+	Kind      string   `json:"kind,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Size      int64    `json:"size"`
+	// StoredSize is what a deduplicated backup added to the store
+	StoredSize *int64 `json:"stored_size,omitempty"`
+	// Databases holds the recorded tables, with --tables
+	Databases []DatabaseTables `json:"databases,omitempty"`
+}
+
+// DatabaseTables lists the tables recorded for one file of a backup.
+type DatabaseTables struct {
+	Database   string               `json:"database"`
+	SchemaHash string               `json:"schema_hash,omitempty"`
+	Tables     []storage.TableStats `json:"tables"`
+}
+
+// RepositorySummary compares the logical size of deduplicated backups with
+// what their chunks take up.
+type RepositorySummary struct {
+	LogicalSize int64 `json:"logical_size"`
+	StoredSize  int64 `json:"stored_size"`
+	Chunks      int   `json:"chunks"`
+}
+
+// listBackups builds the listing of backups. With showTables, the tables
+// recorded for each file are included.
+func listBackups(backups []storage.Metadata, showTables bool) (ListResult, error) {
+	res := ListResult{Backups: []BackupRow{}}
+	var logical int64
+	deduplicated := false
+	for _, b := range backups {
+		row := BackupRow{
+			ID:        b.ID,
+			Timestamp: b.Timestamp,
+			Engine:    b.Engine,
+			Host:      b.Host,
+			Port:      b.Port,
+			Source:    b.Source,
+			Status:    b.Status,
+			Kind:      b.Kind,
+			Tags:      b.Tags,
+			Size:      b.Size(),
+		}
+		for _, f := range b.Files {
+			if f.Manifest != "" {
+				deduplicated = true
+				logical += f.Size
+				stored := b.StoredSize()
+				row.StoredSize = &stored
+			}
+			if showTables {
+				row.Databases = append(row.Databases, DatabaseTables{Database: f.Database(), SchemaHash: f.SchemaHash, Tables: f.Tables})
+			}
+		}
+		res.Backups = append(res.Backups, row)
+	}
+
+	if deduplicated {
+		chunks, stored, err := storage.RepositorySize()
+		if err != nil {
+			return res, err
+		}
+		res.Repository = &RepositorySummary{LogicalSize: logical, StoredSize: stored, Chunks: chunks}
+	}
+	return res, nil
+}
+
+// printTable prints the backups as a table, followed by the size of the
+// chunk store if some of them are deduplicated.
+func (r ListResult) printTable(out io.Writer) {
+	if len(r.Backups) == 0 {
+		fmt.Fprintln(out, "No matching backups")
+		return
+	}
+	fmt.Fprintf(out, "% -25s | % -10s | % -20s | % -10s | % -22s | %s\n", "TIMESTAMP", "ENGINE", "SOURCE HOST", "STATUS", "SIZE", "ID")
+	fmt.Fprintln(out, strings.Repeat("-", 125))
+	for _, b := range r.Backups {
+		size := formatSize(b.Size)
+		if b.StoredSize != nil {
+			size = fmt.Sprintf("%s (+%s)", size, formatSize(*b.StoredSize))
+		}
+		fmt.Fprintf(out, "% -25s | % -10s | % -20s | % -10s | % -22s | %s\n", b.Timestamp, b.Engine, b.Host, b.Status, size, b.ID)
+		printTables(out, b.Databases)
+	}
+	if s := r.Repository; s != nil {
+		fmt.Fprintf(out, "\nDeduplicated backups: %s logical, %s stored in %d chunks\n", formatSize(s.LogicalSize), formatSize(s.StoredSize), s.Chunks)
+	}
+}
+
+// backupSize renders the logical size of a backup, followed by what it
+// added to the store if it is deduplicated.
+func backupSize(b storage.Metadata) string {
+	for _, f := range b.Files {
+		if f.Manifest != "" {
+			return fmt.Sprintf("%s (+%s)", formatSize(b.Size()), formatSize(b.StoredSize()))
+		}
+	}
+	return formatSize(b.Size())
+}
+
+func printTables(out io.Writer, dbs []DatabaseTables) {
+	for _, db := range dbs {
+		if len(db.Tables) == 0 {
+			fmt.Fprintf(out, "    %s: no table information\n", db.Database)
+			continue
+		}
+		fmt.Fprintf(out, "    %s: %d tables, schema %s\n", db.Database, len(db.Tables), shortHash(db.SchemaHash))
+		for _, t := range db.Tables {
+			fmt.Fprintf(out, "      % -30s %12s  %s\n", t.Name, formatRows(t), shortHash(t.DDLHash))
+		}
+	}
+}
+
+// formatRows renders the row count of a table, "~n" if it is an estimate.
+func formatRows(t storage.TableStats) string {
+	switch {
+	case t.Rows == nil:
+		return "-"
+	case t.Estimated:
+		return fmt.Sprintf("~%d", *t.Rows)
+	}
+	return fmt.Sprintf("%d", *t.Rows)
+}
+
+// shortHash abbreviates a "sha256:<hex>" fingerprint for display.
+func shortHash(h string) string {
+	h = strings.TrimPrefix(h, "sha256:")
+	if h == "" {
+		return "-"
+	}
+	if len(h) > 12 {
+		h = h[:12]
+	}
+	return h
+}
+
+// RunBackup backs up the named databases of a source, or all of them when
+// dbNames is empty, and labels the backup with tags.
+func RunBackup(out io.Writer, sourceID string, dbNames []string, tags []string) error {
 	mgr, err := config.NewManager()
 	if err != nil {
 		return err
@@ -90,40 +424,190 @@ func RunBackup(sourceID string, dbName string) error {
 	if err != nil {
 		return err
 	}
+	if err := useSourceStorage(mgr, source.ID); err != nil {
+		return err
+	}
 
-	eng, err := engine.Get(source.Engine)
+	dir, meta, err := backupServer(out, source, dbNames, "", "", tags)
 	if err != nil {
 		return err
 	}
 
+	res := BackupSummary{
+		ID:         meta.ID,
+		Source:     meta.Source,
+		Location:   storage.Store.Location(dir),
+		Status:     meta.Status,
+		Tags:       meta.Tags,
+		DurationMS: meta.Duration().Milliseconds(),
+		Databases:  []DatabaseResult{},
+	}
+	failed := 0
+	for _, f := range meta.Files {
+		res.Databases = append(res.Databases, DatabaseResult{
+			Database:   f.Database(),
+			File:       f.Name,
+			Status:     f.Status,
+			Error:      f.Error,
+			Size:       f.Size,
+			StoredSize: f.StoredSize,
+			Checksum:   f.Checksum,
+			DurationMS: f.DurationMS,
+		})
+		if f.Status != "success" {
+			failed++
+		}
+	}
+	if err := render(out, res); err != nil {
+		return err
+	}
+	switch meta.Status {
+	case "failed":
+		return fmt.Errorf("backup %s failed", meta.ID)
+	case "partial":
+		return fmt.Errorf("backup %s: %d of %d databases failed: %w", meta.ID, failed, len(meta.Files), ErrPartial)
+	}
+	return nil
+}
+
+// BackupSummary is the outcome of backup.
+type BackupSummary struct {
+	ID         string           `json:"id"`
+	Source     string           `json:"source"`
+	Location   string           `json:"location"`
+	Status     string           `json:"status"`
+	Tags       []string         `json:"tags,omitempty"`
+	DurationMS int64            `json:"duration_ms"`
+	Databases  []DatabaseResult `json:"databases"`
+}
+
+// DatabaseResult is the outcome of the backup of one database (or of a
+// whole server, for engines that dump everything into one file).
+type DatabaseResult struct {
+	Database   string `json:"database"`
+	File       string `json:"file"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	Size       int64  `json:"size"`
+	StoredSize int64  `json:"stored_size,omitempty"`
+	Checksum   string `json:"checksum,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+func (r BackupSummary) printTable(out io.Writer) {
+	fmt.Fprintf(out, "Backup operation completed with status: %s\n", r.Status)
+	fmt.Fprintf(out, "Backup ID: %s\n", r.ID)
+}
+
+// backupServer backs up the given databases of server (all of them when
+// dbNames is empty) into a new catalog entry of the given kind, and returns
+// the entry's key prefix in the store and its metadata.
+func backupServer(out io.Writer, server config.ServerConfig, dbNames []string, kind string, note string, tags []string) (string, storage.Metadata, error) {
+	eng, err := engine.Get(server.Engine)
+	if err != nil {
+		return "", storage.Metadata{}, err
+	}
+
 	timestamp := time.Now()
-	path, tsStr, err := storage.InitBackupDir(source.Engine, source.Host, timestamp)
+	dir, tsStr, err := storage.InitBackupDir(server.Engine, server.Host, timestamp)
 	if err != nil {
-		return err
+		return "", storage.Metadata{}, err
 	}
 
-	fmt.Printf("Starting backup for %s to %s...\n", source.ID, path)
+	defer util.LogScope(logrus.Fields{"source": server.ID})()
+	util.Log().WithField("location", storage.Store.Location(dir)).Info("backup started")
+	fmt.Fprintf(out, "Starting backup for %s to %s...\n", server.ID, storage.Store.Location(dir))
+
+	if storage.Deduplicating() {
+		unlock, err := storage.LockRepository(false)
+		if err != nil {
+			return "", storage.Metadata{}, err
+		}
+		defer unlock()
+	}
+
+	// Dumps are streamed into the store as they are produced. Engines write
+	// one file at a time, so the previous file is complete when the next one
+	// is created; it is removed again if its result turns out to be an error.
+	writers := make(map[string]*storage.Writer)
+	var last *storage.Writer
+	var lastName string
+	started := make(map[string]time.Time)
+	durations := make(map[string]time.Duration)
+	// Logs of a dump carry its database
+	var endScope func()
+	var progress *util.Progress
+	defer func() {
+		progress.Done()
+		if endScope != nil {
+			endScope()
+		}
+	}()
+	create := func(filename string) (io.Writer, error) {
+		retry := last != nil && lastName == filename
+		switch {
+		case retry:
+			// A retried dump starts the file over
+			last.Abort(fmt.Errorf("dump of %s is retried", filename))
+		case last != nil:
+			last.Close()
+			durations[lastName] = time.Since(started[lastName])
+		}
+		progress.Done()
+		if endScope != nil {
+			endScope()
+		}
+		db := storage.DatabaseFromFilename(filename)
+		endScope = util.LogScope(logrus.Fields{"database": db})
+		progress = util.StartDumpProgress(db, estimateSize(eng, server, db))
+		if !retry {
+			started[filename] = time.Now()
+		}
+		lastName = filename
+		w := storage.NewWriter(path.Join(dir, filename))
+		if len(storageRecipients) > 0 {
+			if err := w.Seal(storageRecipients, storageKeyIDs); err != nil {
+				w.Abort(err)
+				return nil, err
+			}
+		}
+		last = w
+		writers[filename] = w
+		return w, nil
+	}
 
 	var backupResults []engine.BackupResult
-	
-	if dbName != "" {
-		filename := fmt.Sprintf("%s_%s.sql.gz", dbName, tsStr)
-		destPath := filepath.Join(path, filename)
-		
-		// Retry logic is now handled within Postgres engine, but for others or generic single calls:
-		err := eng.BackupDatabase(source, dbName, destPath)
-		backupResults = append(backupResults, engine.BackupResult{
-			Database: dbName,
-			Filename: filename,
-			Error:    err,
-		})
+	mode := "staged"
+	if streamer, ok := eng.(engine.StreamBackuper); ok {
+		mode = "stream"
+		if len(dbNames) > 0 {
+			for _, dbName := range dbNames {
+				filename := fmt.Sprintf("%s_%s%s", dbName, tsStr, engine.Extension(eng, server))
+				err := engine.RetryStream(engine.DumpAttempts(eng), filename, create, func(w io.Writer) error {
+					return streamer.BackupDatabaseTo(server, dbName, w)
+				})
+				backupResults = append(backupResults, engine.BackupResult{
+					Database: dbName,
+					Filename: filename,
+					Error:    err,
+				})
+			}
+		} else {
+			backupResults, err = streamer.BackupAllTo(server, create)
+		}
 	} else {
-		// Backup All
-		var err error
-		backupResults, err = eng.BackupAll(source, path)
-		if err != nil {
-			return fmt.Errorf("critical failure listing/backing up databases: %w", err)
+		backupResults, err = stagedBackup(eng, server, dbNames, tsStr, create)
+	}
+	progress.Done()
+	if endScope != nil {
+		endScope()
+		endScope = nil
+	}
+	if err != nil {
+		for _, w := range writers {
+			w.Abort(err)
 		}
+		return "", storage.Metadata{}, fmt.Errorf("critical failure listing/backing up databases: %w", err)
 	}
 
 	// Process Results
@@ -133,29 +617,69 @@ func RunBackup(sourceID string, dbName string) error {
 
 	for _, res := range backupResults {
 		bf := storage.BackupFile{
-			Name: res.Filename,
+			Name:        res.Filename,
+			Compression: util.Compression().Name(),
+		}
+
+		w := writers[res.Filename]
+		delete(writers, res.Filename)
+		switch {
+		case w == nil:
+			if res.Error == nil {
+				res.Error = fmt.Errorf("no output was written for %s", res.Filename)
+			}
+		case res.Error != nil:
+			w.Abort(res.Error)
+		default:
+			res.Error = w.Close()
+		}
+		if _, ok := durations[res.Filename]; !ok && w != nil {
+			durations[res.Filename] = time.Since(started[res.Filename])
 		}
+		bf.DurationMS = (res.Duration + durations[res.Filename]).Milliseconds()
+		log := util.Log().WithField("database", res.Database)
 
 		if res.Error != nil {
 			bf.Status = "failed"
 			bf.Error = res.Error.Error()
 			failCount++
-			fmt.Printf(" [FAILED] %s: %v\n", res.Database, res.Error)
+			log.WithError(res.Error).Error("database backup failed")
+			fmt.Fprintf(out, " [FAILED] %s: %v\n", res.Database, res.Error)
 		} else {
 			bf.Status = "success"
 			successCount++
-			fullPath := filepath.Join(path, res.Filename)
-			checksum, _ := util.ComputeChecksum(fullPath)
-			info, _ := os.Stat(fullPath)
-			bf.Checksum = checksum
-			if info != nil {
-				bf.Size = info.Size()
+			bf.Checksum = w.Checksum()
+			bf.Size = w.Size()
+			if bf.Manifest = w.Manifest(); bf.Manifest != "" {
+				bf.StoredSize = w.StoredSize()
 			}
-			fmt.Printf(" [OK] %s\n", res.Database)
+			// Collected after the dump, so a busy database may have drifted slightly
+			if res.Database != "all" {
+				stats, s, err := tableStats(eng, server, res.Database)
+				if err != nil {
+					log.WithError(err).Warn("could not collect table stats")
+					fmt.Fprintf(out, " [WARN] %s: %v\n", res.Database, err)
+				}
+				bf.Tables = stats
+				if s != nil {
+					bf.SchemaHash = s.Fingerprint()
+					if bf.SchemaFile, err = storage.WriteSchemaSnapshot(dir, res.Filename, s); err != nil {
+						log.WithError(err).Warn("could not store schema snapshot")
+						fmt.Fprintf(out, " [WARN] %s: could not store schema snapshot: %v\n", res.Database, err)
+					}
+				}
+			}
+			log.WithFields(logrus.Fields{"size": bf.Size, "duration_ms": bf.DurationMS}).Info("database backed up")
+			fmt.Fprintf(out, " [OK] %s\n", res.Database)
 		}
 		files = append(files, bf)
 	}
 
+	// Writers the engine opened without reporting a result
+	for _, w := range writers {
+		w.Abort(fmt.Errorf("backup abandoned"))
+	}
+
 	status := "success"
 	if failCount > 0 {
 		if successCount == 0 {
@@ -165,47 +689,111 @@ func RunBackup(sourceID string, dbName string) error {
 		}
 	}
 
+	hostname, _ := os.Hostname()
 	meta := storage.Metadata{
-		ID:        fmt.Sprintf("%s_%s_%s", source.Engine, source.Host, tsStr),
-		Engine:    source.Engine,
-		Host:      source.Host,
-		Port:      source.Port,
-		User:      source.User,
+		Version:   storage.MetadataVersion,
+		ID:        fmt.Sprintf("%s_%s_%s", server.Engine, server.Host, tsStr),
+		Engine:    server.Engine,
+		Host:      server.Host,
+		Port:      server.Port,
+		User:      server.User,
 		Timestamp: tsStr,
 		Files:     files,
 		Status:    status,
+		Kind:      kind,
+		Note:      note,
+		Tags:      tags,
+		Source:    server.ID,
+		StartedAt: tsStr,
+		// The metadata is written last, so this is when the files were stored
+		FinishedAt: time.Now().Format(time.RFC3339),
+		Provenance: &storage.Provenance{
+			Hostname:    hostname,
+			ToolVersion: Version,
+			CommandLine: util.RedactArgs(os.Args),
+			Mode:        mode,
+			Options:     server.Options,
+			RunID:       util.RunID,
+		},
+	}
+	if len(storageRecipients) > 0 {
+		meta.Encryption = &storage.Encryption{Format: "age", KeyIDs: storageKeyIDs}
 	}
 
-	if err := storage.WriteMetadata(path, meta); err != nil {
-		return err
+	if err := storage.WriteMetadata(dir, meta); err != nil {
+		return "", meta, err
 	}
+	util.Log().WithFields(logrus.Fields{"backup_id": meta.ID, "status": status}).Info("backup finished")
 
-	fmt.Printf("Backup operation completed with status: %s\n", status)
-	return nil
+	return dir, meta, nil
 }
 
-func RunRestore(backupPath string, targetID string) error {
-	mgr, err := config.NewManager()
+// stagedBackup runs the file based backup of engines that cannot stream in
+// a temporary directory and copies the results to the writers of create.
+func stagedBackup(eng engine.Engine, server config.ServerConfig, dbNames []string, tsStr string, create func(filename string) (io.Writer, error)) ([]engine.BackupResult, error) {
+	staging, err := os.MkdirTemp("", "dbmigrate-backup-*")
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to create staging dir: %w", err)
 	}
-	
-target, err := mgr.GetSource(targetID)
-	if err != nil {
-		return err
+	defer os.RemoveAll(staging)
+
+	var results []engine.BackupResult
+	if len(dbNames) > 0 {
+		for _, dbName := range dbNames {
+			filename := fmt.Sprintf("%s_%s%s", dbName, tsStr, engine.Extension(eng, server))
+			start := time.Now()
+			endScope := util.LogScope(logrus.Fields{"database": dbName})
+			progress := util.StartDumpProgress(dbName, estimateSize(eng, server, dbName))
+			err := eng.BackupDatabase(server, dbName, filepath.Join(staging, filename))
+			progress.Done()
+			endScope()
+			results = append(results, engine.BackupResult{
+				Database: dbName,
+				Filename: filename,
+				Error:    err,
+				Duration: time.Since(start),
+			})
+		}
+	} else {
+		if results, err = eng.BackupAll(server, staging); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, res := range results {
+		if res.Error != nil {
+			continue
+		}
+		w, err := create(res.Filename)
+		if err == nil {
+			err = copyFile(filepath.Join(staging, res.Filename), w)
+		}
+		results[i].Error = err
+	}
+	return results, nil
+}
+
+// estimateSize returns the size the engine estimates for dbName, 0 if it
+// cannot tell or progress is not shown.
+func estimateSize(eng engine.Engine, server config.ServerConfig, dbName string) int64 {
+	estimator, ok := eng.(engine.SizeEstimator)
+	if !ok || !util.ProgressShown() || dbName == "all-databases" {
+		return 0
 	}
-	
-	eng, err := engine.Get(target.Engine)
+	size, err := estimator.EstimateSize(server, dbName)
 	if err != nil {
-		return err
+		util.Log().WithError(err).Debug("could not estimate the database size")
+		return 0
 	}
-	
-	fmt.Printf("Restoring %s to %s (%s)...\n", backupPath, target.ID, target.Host)
-	
-	if err := eng.RestoreBackup(target, backupPath, ""); err != nil {
+	return size
+}
+
+func copyFile(srcPath string, w io.Writer) error {
+	f, err := os.Open(srcPath)
+	if err != nil {
 		return err
 	}
-	
-	fmt.Println("Restore completed!")
-	return nil
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
 }
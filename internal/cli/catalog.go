@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/storage"
+)
+
+// CatalogQuery selects backups from the catalog. Empty fields match
+// everything.
+type CatalogQuery struct {
+	Source   string
+	Engine   string
+	Database string
+	Status   string
+	// Since and Until are dates (2025-11-29), RFC 3339 times or ages such
+	// as 7d, counted back from now
+	Since string
+	Until string
+	Tags  []string
+	// Tables lists the tables of each backup
+	Tables bool
+}
+
+// CatalogRebuildResult is the outcome of catalog rebuild.
+type CatalogRebuildResult struct {
+	Location string `json:"location"`
+	Backups  int    `json:"backups"`
+	// Unreadable lists the backup directories whose metadata could not be read
+	Unreadable []string `json:"unreadable,omitempty"`
+}
+
+func (r CatalogRebuildResult) printTable(out io.Writer) {
+	for _, u := range r.Unreadable {
+		fmt.Fprintf(out, " [WARN] unreadable metadata: %s\n", u)
+	}
+	fmt.Fprintf(out, "Catalog of %s rebuilt: %d backups\n", r.Location, r.Backups)
+}
+
+// RunCatalogRebuild regenerates the catalog of the store from the metadata
+// in storage.
+func RunCatalogRebuild(out io.Writer) error {
+	stats, err := storage.RebuildCatalog()
+	if err != nil {
+		return err
+	}
+	res := CatalogRebuildResult{Location: storage.Store.Location(""), Backups: stats.Backups, Unreadable: stats.Unreadable}
+	if err := render(out, res); err != nil {
+		return err
+	}
+	if len(stats.Unreadable) > 0 {
+		return fmt.Errorf("%d backups have unreadable metadata: %w", len(stats.Unreadable), ErrPartial)
+	}
+	return nil
+}
+
+// RunCatalogQuery lists the backups in the catalog that match q.
+func RunCatalogQuery(out io.Writer, q CatalogQuery) error {
+	query := storage.Query{Engine: q.Engine, Database: q.Database, Status: q.Status, Tags: q.Tags}
+	if q.Source != "" {
+		mgr, err := config.NewManager()
+		if err != nil {
+			return err
+		}
+		sq, err := sourceQuery(mgr, q.Source)
+		if err != nil {
+			return err
+		}
+		if q.Engine != "" && q.Engine != sq.Engine {
+			return Usagef("--engine %s conflicts with source %s, which is a %s server", q.Engine, q.Source, sq.Engine)
+		}
+		query.Source, query.Engine, query.Host, query.Port = sq.Source, sq.Engine, sq.Host, sq.Port
+	}
+	var err error
+	if query.Since, err = parseDate(q.Since); err != nil {
+		return Usagef("invalid --since: %v", err)
+	}
+	if query.Until, err = parseDate(q.Until); err != nil {
+		return Usagef("invalid --until: %v", err)
+	}
+	if !query.Until.IsZero() && len(q.Until) == len("2006-01-02") {
+		// A date includes the whole day
+		query.Until = query.Until.Add(24*time.Hour - time.Nanosecond)
+	}
+
+	backups, err := storage.QueryBackups(query)
+	if err != nil {
+		return err
+	}
+	res, err := listBackups(backups, q.Tables)
+	if err != nil {
+		return err
+	}
+	return render(out, res)
+}
+
+// parseDate reads a date, an RFC 3339 time or an age such as 7d or 36h.
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if age, err := parseAge(s); err == nil {
+		return time.Now().Add(-age), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date (2025-11-29), time (2025-11-29T10:00:00Z) or age (7d)", s)
+}
+
+// RunCatalogTag adds tags to a backup, or removes them with remove.
+func RunCatalogTag(out io.Writer, backupID string, tags []string, remove bool) error {
+	meta, err := storage.FindBackup(backupID)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			return Usagef("empty tag")
+		}
+		switch {
+		case remove:
+			kept := meta.Tags[:0]
+			for _, t := range meta.Tags {
+				if t != tag {
+					kept = append(kept, t)
+				}
+			}
+			meta.Tags = kept
+		case !meta.HasTag(tag):
+			meta.Tags = append(meta.Tags, tag)
+		}
+	}
+	if err := storage.WriteMetadata(meta.Dir, meta); err != nil {
+		return err
+	}
+	return render(out, TagResult{ID: meta.ID, Tags: append([]string{}, meta.Tags...)})
+}
+
+// TagResult is the outcome of catalog tag: the tags a backup has now.
+type TagResult struct {
+	ID   string   `json:"id"`
+	Tags []string `json:"tags"`
+}
+
+func (r TagResult) printTable(out io.Writer) {
+	if len(r.Tags) == 0 {
+		fmt.Fprintf(out, "%s has no tags\n", r.ID)
+	} else {
+		fmt.Fprintf(out, "%s tags: %s\n", r.ID, strings.Join(r.Tags, ", "))
+	}
+}
@@ -0,0 +1,389 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/datadiff"
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/schema"
+	"mydbportal.com/dbmigrate/internal/storage"
+)
+
+// BackupDiff is the outcome of diff backups.
+type BackupDiff struct {
+	Left        string         `json:"left"`
+	Right       string         `json:"right"`
+	Databases   []DatabaseDiff `json:"databases"`
+	Differences int            `json:"differences"`
+}
+
+// DatabaseDiff describes a database that differs between two backups.
+type DatabaseDiff struct {
+	Database string `json:"database"`
+	// Change is removed, added, changed, or unknown when a backup has no
+	// table information
+	Change string      `json:"change"`
+	Tables []TableDiff `json:"tables,omitempty"`
+}
+
+// TableDiff describes a table that differs between two backups.
+type TableDiff struct {
+	Table  string              `json:"table"`
+	Change string              `json:"change"` // removed, added, rows, definition
+	Left   *storage.TableStats `json:"left,omitempty"`
+	Right  *storage.TableStats `json:"right,omitempty"`
+}
+
+// RunDiffBackups compares the table information recorded for two backups:
+// databases and tables present in only one of them, changed row counts and
+// changed table definitions. The dumps themselves are not opened.
+func RunDiffBackups(out io.Writer, leftID string, rightID string) error {
+	left, err := storage.FindBackup(leftID)
+	if err != nil {
+		return err
+	}
+	right, err := storage.FindBackup(rightID)
+	if err != nil {
+		return err
+	}
+
+	leftFiles := filesByDatabase(left)
+	rightFiles := filesByDatabase(right)
+	var dbs []string
+	for db := range leftFiles {
+		dbs = append(dbs, db)
+	}
+	for db := range rightFiles {
+		if _, ok := leftFiles[db]; !ok {
+			dbs = append(dbs, db)
+		}
+	}
+	sort.Strings(dbs)
+
+	res := BackupDiff{Left: left.ID, Right: right.ID, Databases: []DatabaseDiff{}}
+	for _, db := range dbs {
+		l, inLeft := leftFiles[db]
+		r, inRight := rightFiles[db]
+		switch {
+		case !inRight:
+			res.Databases = append(res.Databases, DatabaseDiff{Database: db, Change: "removed"})
+			res.Differences++
+			continue
+		case !inLeft:
+			res.Databases = append(res.Databases, DatabaseDiff{Database: db, Change: "added"})
+			res.Differences++
+			continue
+		case len(l.Tables) == 0 || len(r.Tables) == 0:
+			res.Databases = append(res.Databases, DatabaseDiff{Database: db, Change: "unknown"})
+			continue
+		}
+
+		var tables []TableDiff
+		for i := range l.Tables {
+			lt := &l.Tables[i]
+			rt := r.Table(lt.Name)
+			if rt == nil {
+				tables = append(tables, TableDiff{Table: lt.Name, Change: "removed", Left: lt})
+				continue
+			}
+			if lt.Rows != nil && rt.Rows != nil && *lt.Rows != *rt.Rows {
+				tables = append(tables, TableDiff{Table: lt.Name, Change: "rows", Left: lt, Right: rt})
+			}
+			if lt.DDLHash != "" && rt.DDLHash != "" && lt.DDLHash != rt.DDLHash {
+				tables = append(tables, TableDiff{Table: lt.Name, Change: "definition", Left: lt, Right: rt})
+			}
+		}
+		for i := range r.Tables {
+			if rt := &r.Tables[i]; l.Table(rt.Name) == nil {
+				tables = append(tables, TableDiff{Table: rt.Name, Change: "added", Right: rt})
+			}
+		}
+		if len(tables) > 0 {
+			res.Databases = append(res.Databases, DatabaseDiff{Database: db, Change: "changed", Tables: tables})
+			res.Differences += len(tables)
+		}
+	}
+	return render(out, res)
+}
+
+func (r BackupDiff) printTable(out io.Writer) {
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", r.Left, r.Right)
+	for _, db := range r.Databases {
+		switch db.Change {
+		case "removed":
+			fmt.Fprintf(out, "- database %s\n", db.Database)
+		case "added":
+			fmt.Fprintf(out, "+ database %s\n", db.Database)
+		case "unknown":
+			fmt.Fprintf(out, "? database %s: no table information recorded in both backups\n", db.Database)
+		default:
+			fmt.Fprintf(out, "~ database %s\n", db.Database)
+		}
+		for _, t := range db.Tables {
+			switch t.Change {
+			case "removed":
+				fmt.Fprintf(out, "  - table %s (%s rows)\n", t.Table, formatRows(*t.Left))
+			case "added":
+				fmt.Fprintf(out, "  + table %s (%s rows)\n", t.Table, formatRows(*t.Right))
+			case "rows":
+				fmt.Fprintf(out, "  ~ table %s: rows %s -> %s (%+d)\n", t.Table, formatRows(*t.Left), formatRows(*t.Right), *t.Right.Rows-*t.Left.Rows)
+			case "definition":
+				fmt.Fprintf(out, "  ~ table %s: definition changed\n", t.Table)
+			}
+		}
+	}
+	if r.Differences == 0 {
+		fmt.Fprintln(out, "No differences")
+	}
+}
+
+// filesByDatabase maps the successful files of a backup by database name.
+func filesByDatabase(meta storage.Metadata) map[string]storage.BackupFile {
+	files := make(map[string]storage.BackupFile)
+	for _, f := range meta.Files {
+		if f.Status == "success" {
+			files[f.Database()] = f
+		}
+	}
+	return files
+}
+
+// SchemaDiffReport is the JSON report of a schema diff.
+type SchemaDiffReport struct {
+	Left        string          `json:"left"`
+	LeftEngine  string          `json:"left_engine"`
+	Right       string          `json:"right"`
+	RightEngine string          `json:"right_engine"`
+	Changes     []schema.Change `json:"changes"`
+}
+
+func (r SchemaDiffReport) printTable(out io.Writer) {
+	fmt.Fprintf(out, "--- %s (%s)\n+++ %s (%s)\n", r.Left, r.LeftEngine, r.Right, r.RightEngine)
+	printSchemaChanges(out, r.Changes)
+}
+
+// RunDiffSchema compares the schemas of two databases, each given as
+// <server-id>:<db> for a live server or <backup-id>:<db> for the snapshot
+// taken with a backup. reportPath receives the changes as JSON and alterPath
+// the SQL that brings the right side in line with the left.
+func RunDiffSchema(out io.Writer, leftSpec string, rightSpec string, reportPath string, alterPath string) error {
+	left, err := loadSchema(leftSpec)
+	if err != nil {
+		return fmt.Errorf("left side: %w", err)
+	}
+	right, err := loadSchema(rightSpec)
+	if err != nil {
+		return fmt.Errorf("right side: %w", err)
+	}
+
+	report := SchemaDiffReport{Left: leftSpec, LeftEngine: left.Engine, Right: rightSpec, RightEngine: right.Engine, Changes: schema.Diff(left, right)}
+	if report.Changes == nil {
+		report.Changes = []schema.Change{}
+	}
+	if err := render(out, report); err != nil {
+		return err
+	}
+
+	if reportPath != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(reportPath, data, 0644); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+		fmt.Fprintf(out, "Report written to %s\n", reportPath)
+	}
+	if alterPath != "" {
+		script, err := schema.AlterScript(left, right)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(alterPath, []byte(script), 0644); err != nil {
+			return fmt.Errorf("failed to write ALTER script: %w", err)
+		}
+		fmt.Fprintf(out, "ALTER script written to %s\n", alterPath)
+	}
+	return nil
+}
+
+// loadSchema inspects <server-id>:<db> on a configured server, or reads the
+// schema snapshot of <backup-id>:<db>. Backup IDs contain colons themselves,
+// so the database name follows the last one.
+func loadSchema(spec string) (*schema.Schema, error) {
+	ref, dbName, err := splitSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	mgr, err := config.NewManager()
+	if err != nil {
+		return nil, err
+	}
+	if server, err := mgr.GetTarget(ref); err == nil {
+		eng, err := engine.Get(server.Engine)
+		if err != nil {
+			return nil, err
+		}
+		inspector, ok := eng.(engine.SchemaInspector)
+		if !ok {
+			return nil, fmt.Errorf("engine %s does not support schema inspection", server.Engine)
+		}
+		return inspector.InspectSchema(server, dbName)
+	}
+
+	if err := requireStorage(); err != nil {
+		return nil, err
+	}
+	meta, err := storage.FindBackup(ref)
+	if err != nil {
+		return nil, fmt.Errorf("%s is neither a configured server nor a backup ID", ref)
+	}
+	return storage.LoadSchemaSnapshot(meta, dbName)
+}
+
+// splitSpec splits <ref>:<db> at the last colon.
+func splitSpec(spec string) (string, string, error) {
+	i := strings.LastIndex(spec, ":")
+	if i <= 0 || i == len(spec)-1 {
+		return "", "", fmt.Errorf("%q is not of the form <server-id>:<db> or <backup-id>:<db>", spec)
+	}
+	return spec[:i], spec[i+1:], nil
+}
+
+// printSchemaChanges prints changes grouped by table: "-" marks objects only
+// on the left, "+" objects only on the right and "~" changed objects.
+func printSchemaChanges(out io.Writer, changes []schema.Change) {
+	marks := map[string]string{schema.OnlyLeft: "-", schema.OnlyRight: "+", schema.Changed: "~"}
+	table := ""
+	for _, c := range changes {
+		mark := marks[c.Side]
+		if c.Table == "" {
+			table = ""
+			switch {
+			case c.Object == "table":
+				fmt.Fprintf(out, "%s table %s (%s)\n", mark, c.Name, c.Left+c.Right)
+			case c.Side == schema.Changed:
+				fmt.Fprintf(out, "~ %s %s: definition differs\n", c.Object, c.Name)
+			default:
+				fmt.Fprintf(out, "%s %s %s\n", mark, c.Object, c.Name)
+			}
+			continue
+		}
+		if c.Table != table {
+			table = c.Table
+			fmt.Fprintf(out, "~ table %s\n", table)
+		}
+		switch c.Side {
+		case schema.OnlyLeft:
+			fmt.Fprintf(out, "  - %s %s: %s\n", c.Object, c.Name, c.Left)
+		case schema.OnlyRight:
+			fmt.Fprintf(out, "  + %s %s: %s\n", c.Object, c.Name, c.Right)
+		default:
+			fmt.Fprintf(out, "  ~ %s %s: %s -> %s\n", c.Object, c.Name, c.Left, c.Right)
+		}
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(out, "No differences")
+	} else {
+		fmt.Fprintf(out, "%d differences\n", len(changes))
+	}
+}
+
+// RunDiffData compares the rows of two live databases, each given as
+// <server-id>:<db>, and prints the keys of missing, extra and changed rows
+// per table. It fails if any row differs, so it can gate a cutover.
+func RunDiffData(out io.Writer, leftSpec string, rightSpec string, opts datadiff.Options, reportPath string) error {
+	leftID, leftDB, err := splitSpec(leftSpec)
+	if err != nil {
+		return err
+	}
+	rightID, rightDB, err := splitSpec(rightSpec)
+	if err != nil {
+		return err
+	}
+	left, _, err := loadTarget(leftID)
+	if err != nil {
+		return err
+	}
+	right, _, err := loadTarget(rightID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Comparing %s with %s...\n", leftSpec, rightSpec)
+	report, runErr := datadiff.Run(left, right, leftDB, rightDB, opts)
+	if report == nil {
+		return runErr
+	}
+	if err := render(out, dataDiffResult{report}); err != nil {
+		return err
+	}
+
+	if reportPath != "" {
+		if err := report.WriteJSON(reportPath); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+		fmt.Fprintf(out, "Report written to %s\n", reportPath)
+	}
+	if runErr != nil {
+		return runErr
+	}
+	if n, incomplete := report.Differences(); n > 0 || incomplete {
+		return fmt.Errorf("data differs: %d rows", n)
+	}
+	return nil
+}
+
+// dataDiffResult is the outcome of diff data.
+type dataDiffResult struct {
+	*datadiff.Report
+}
+
+func (r dataDiffResult) printTable(out io.Writer) {
+	for _, t := range r.Tables {
+		switch {
+		case t.Skipped != "":
+			fmt.Fprintf(out, "[SKIP] %s: %s\n", t.Name, t.Skipped)
+			continue
+		case t.Error != "":
+			fmt.Fprintf(out, "[FAIL] %s: %s\n", t.Name, t.Error)
+			continue
+		case t.Differences() == 0:
+			fmt.Fprintf(out, "[OK]   %s: %d rows, %d chunks\n", t.Name, t.LeftRows, t.Chunks)
+			continue
+		}
+		fmt.Fprintf(out, "[DIFF] %s: %d rows left, %d right, %d of %d chunks differ\n", t.Name, t.LeftRows, t.RightRows, t.MismatchedChunks, t.Chunks)
+		printKeys(out, "missing", t.Missing)
+		printKeys(out, "extra", t.Extra)
+		printKeys(out, "changed", t.Changed)
+		if t.Truncated {
+			fmt.Fprintln(out, "  (more differences not listed; raise --max-rows)")
+		}
+	}
+	if n, incomplete := r.Differences(); n == 0 && !incomplete {
+		fmt.Fprintln(out, "No differences")
+	}
+}
+
+// printKeys prints up to ten row keys of one kind of difference.
+func printKeys(out io.Writer, kind string, keys [][]string) {
+	if len(keys) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "  %d %s:", len(keys), kind)
+	for i, key := range keys {
+		if i == 10 {
+			fmt.Fprint(out, " ...")
+			break
+		}
+		fmt.Fprintf(out, " (%s)", strings.Join(key, ", "))
+	}
+	fmt.Fprintln(out)
+}
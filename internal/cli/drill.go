@@ -0,0 +1,240 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/schema"
+	"mydbportal.com/dbmigrate/internal/storage"
+)
+
+// DrillOptions controls a restore drill.
+type DrillOptions struct {
+	// Databases limits the drill to these databases of the backup
+	Databases []string
+	// Assertions are SQL queries run in every scratch database; each must
+	// return a true or non-zero value
+	Assertions []string
+	// Keep leaves the scratch databases in place for inspection
+	Keep bool
+}
+
+var unsafeScratchChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// scratchName builds a temporary database name that cannot collide with
+// the original: drill_<db>_<timestamp>, restricted to [a-z0-9_].
+func scratchName(dbName string, ts time.Time) string {
+	name := unsafeScratchChars.ReplaceAllString(strings.ToLower(dbName), "_")
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	return fmt.Sprintf("drill_%s_%s", name, ts.Format("20060102150405"))
+}
+
+// RunDrill restores every database of a backup into scratch databases on
+// scratchID, checks them against the row counts recorded at backup time and
+// the user's assertions, drops them again and records the outcome in the
+// backup's metadata.
+func RunDrill(out io.Writer, backupID string, scratchID string, opts DrillOptions) error {
+	target, eng, err := loadTarget(scratchID)
+	if err != nil {
+		return err
+	}
+	meta, err := storage.FindBackup(backupID)
+	if err != nil {
+		return err
+	}
+	if err := checkEngine(meta, target); err != nil {
+		return err
+	}
+	restorer, ok1 := eng.(engine.RenamingRestorer)
+	dropper, ok2 := eng.(engine.DatabaseDropper)
+	if !ok1 || !ok2 {
+		return fmt.Errorf("engine %s does not support restore drills", target.Engine)
+	}
+	executor, canExec := eng.(engine.SQLExecutor)
+	if len(opts.Assertions) > 0 && !canExec {
+		return fmt.Errorf("engine %s does not support SQL assertions", target.Engine)
+	}
+
+	paths, release, err := selectBackupFiles(out, meta, opts.Databases)
+	if err != nil {
+		return err
+	}
+	defer release()
+	files := make(map[string]storage.BackupFile)
+	for _, f := range meta.Files {
+		files[f.Name] = f
+	}
+	existing, err := eng.ListDatabases(target)
+	if err != nil {
+		return fmt.Errorf("failed to list databases on %s: %w", target.ID, err)
+	}
+
+	start := time.Now()
+	rec := storage.DrillRecord{
+		Time:   start.UTC().Format(time.RFC3339),
+		Target: target.ID,
+		Status: "passed",
+	}
+
+	for _, path := range paths {
+		f := files[filepath.Base(path)]
+		res := storage.DrillDatabase{Database: f.Database(), Scratch: scratchName(f.Database(), start)}
+		problem := func(format string, args ...interface{}) {
+			res.Problems = append(res.Problems, fmt.Sprintf(format, args...))
+		}
+
+		switch {
+		case res.Database == "all-databases":
+			res.Scratch = ""
+			problem("whole-server backups cannot be restored under a temporary name")
+		case containsString(existing, res.Scratch):
+			problem("scratch database %s already exists", res.Scratch)
+		default:
+			fmt.Fprintf(out, "Restoring %s into %s on %s...\n", res.Database, res.Scratch, target.ID)
+			if err := restorer.RestoreBackupAs(target, path, res.Scratch); err != nil {
+				problem("restore failed: %v", err)
+			} else {
+				checkScratch(eng, target, f, &res, problem)
+				for _, query := range opts.Assertions {
+					out, err := executor.ExecSQL(target, res.Scratch, query)
+					if err != nil {
+						problem("assertion %q failed: %v", query, err)
+					} else if !truthy(out) {
+						problem("assertion %q returned %q", query, strings.TrimSpace(out))
+					}
+				}
+			}
+
+			if !opts.Keep {
+				if err := dropper.DropDatabase(target, res.Scratch); err != nil {
+					problem("failed to drop scratch database: %v", err)
+				}
+			}
+		}
+
+		res.Status = "passed"
+		if len(res.Problems) > 0 {
+			res.Status = "failed"
+			rec.Status = "failed"
+			fmt.Fprintf(out, " [FAILED] %s\n", res.Database)
+			for _, p := range res.Problems {
+				fmt.Fprintf(out, "   - %s\n", p)
+			}
+		} else {
+			fmt.Fprintf(out, " [OK] %s (%d tables)\n", res.Database, res.Tables)
+		}
+		rec.Databases = append(rec.Databases, res)
+	}
+
+	if err := storage.RecordDrill(meta, rec); err != nil {
+		return fmt.Errorf("failed to record drill result: %w", err)
+	}
+	if err := render(out, DrillResult{BackupID: meta.ID, DrillRecord: rec}); err != nil {
+		return err
+	}
+	if rec.Status != "passed" {
+		return fmt.Errorf("restore drill of %s failed", meta.ID)
+	}
+	return nil
+}
+
+// DrillResult is the outcome of drill, as recorded in the backup metadata.
+type DrillResult struct {
+	BackupID string `json:"backup_id"`
+	storage.DrillRecord
+}
+
+func (r DrillResult) printTable(out io.Writer) {
+	if r.Status == "passed" {
+		fmt.Fprintf(out, "Restore drill of %s passed\n", r.BackupID)
+	}
+}
+
+// checkScratch compares the restored database with the tables, row counts
+// and definitions captured at backup time, or only counts its tables if
+// nothing was captured.
+func checkScratch(eng engine.Engine, target config.ServerConfig, f storage.BackupFile, res *storage.DrillDatabase, problem func(string, ...interface{})) {
+	var tables []string
+	counts := make(map[string]int64)
+	if counter, ok := eng.(engine.RowCounter); ok {
+		c, err := counter.CountRows(target, res.Scratch)
+		if err != nil {
+			problem("failed to count rows: %v", err)
+			return
+		}
+		counts = c
+		for name := range counts {
+			tables = append(tables, name)
+		}
+	} else if lister, ok := eng.(engine.TableLister); ok {
+		var err error
+		if tables, err = lister.ListTables(target, res.Scratch); err != nil {
+			problem("failed to list tables: %v", err)
+			return
+		}
+	}
+	res.Tables = len(tables)
+	if len(f.Tables) == 0 {
+		return
+	}
+
+	var s *schema.Schema
+	if inspector, ok := eng.(engine.SchemaInspector); ok && f.SchemaHash != "" {
+		var err error
+		if s, err = inspector.InspectSchema(target, res.Scratch); err != nil {
+			problem("failed to read schema: %v", err)
+		}
+	}
+
+	if len(tables) != len(f.Tables) {
+		problem("%d tables restored, %d at backup time", len(tables), len(f.Tables))
+	}
+	for _, want := range f.Tables {
+		if !containsString(tables, want.Name) {
+			problem("table %s is missing", want.Name)
+			continue
+		}
+		if got, ok := counts[want.Name]; ok && want.Rows != nil && !want.Estimated && got != *want.Rows {
+			problem("table %s has %d rows, %d at backup time", want.Name, got, *want.Rows)
+		}
+		if s != nil && want.DDLHash != "" {
+			if t := s.Table(want.Name); t != nil && t.Fingerprint() != want.DDLHash {
+				problem("definition of table %s differs from backup time", want.Name)
+			}
+		}
+	}
+}
+
+// LoadAssertions reads assertion queries from a file, one per line.
+// Empty lines and -- comments are skipped.
+func LoadAssertions(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var assertions []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "--") {
+			assertions = append(assertions, line)
+		}
+	}
+	return assertions, nil
+}
+
+// truthy reports whether an assertion result counts as passed.
+func truthy(out string) bool {
+	switch strings.ToLower(strings.TrimSpace(out)) {
+	case "", "0", "f", "false", "null":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"mydbportal.com/dbmigrate/internal/storage"
+)
+
+// GCSummary is the outcome of gc.
+type GCSummary struct {
+	Location string `json:"location"`
+	DryRun   bool   `json:"dry_run"`
+	storage.GCResult
+}
+
+func (r GCSummary) printTable(out io.Writer) {
+	if r.Chunks == 0 {
+		fmt.Fprintf(out, "No chunks in %s\n", r.Location)
+		return
+	}
+	fmt.Fprintf(out, "%d chunks, %d referenced by backups\n", r.Chunks, r.Referenced)
+	if r.DryRun {
+		fmt.Fprintf(out, "Dry run: would remove %d chunks (%s)\n", r.Removed, formatSize(r.Freed))
+		return
+	}
+	fmt.Fprintf(out, "Removed %d chunks (%s)\n", r.Removed, formatSize(r.Freed))
+}
+
+// RunGC removes the chunks of deduplicated backups that no backup
+// references anymore, such as those of pruned backups.
+func RunGC(out io.Writer, dryRun bool) error {
+	res, err := storage.CollectGarbage(dryRun)
+	if err != nil {
+		return err
+	}
+	if err := render(out, GCSummary{Location: storage.Store.Location(""), DryRun: dryRun, GCResult: res}); err != nil {
+		return err
+	}
+	if res.Failed > 0 {
+		return fmt.Errorf("failed to remove %d chunks", res.Failed)
+	}
+	return nil
+}
@@ -2,81 +2,810 @@ package cli
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 	"golang.org/x/term"
 	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/storage"
+	"mydbportal.com/dbmigrate/internal/util"
 )
 
-func InteractiveMenu() {
-	for {
-		fmt.Println("\n=== DB Migrate Interactive Mode ===")
-		fmt.Println("1) Init/Add source server")
-		fmt.Println("2) Backup all databases from source")
-		fmt.Println("3) List backups")
-		fmt.Println("4) Restore a backup to target")
-		fmt.Println("5) Exit")
-		fmt.Print("Choose an option: ")
-
-		key, err := readKey()
-		if err != nil {
-			fmt.Println("Error reading input:", err)
-			break
+// InteractiveMenu runs the full-screen menu. It drives the same functions
+// as the commands: RunBackup, the planning and restore steps of
+// RunRestoreBackup, RunShow and AddServer.
+func InteractiveMenu() error {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("interactive mode needs a terminal")
+	}
+	mgr, err := config.NewManager()
+	if err != nil {
+		return err
+	}
+	// Results are shown in the output view, and progress by the menu itself
+	outputFormat = OutputTable
+	if util.ProgressShown() {
+		util.SetProgressMode(util.ProgressLog)
+	}
+	_, err = tea.NewProgram(newMenuModel(mgr), tea.WithAltScreen(), tea.WithOutput(os.Stdout)).Run()
+	return err
+}
+
+type screen int
+
+const (
+	screenMenu screen = iota
+	screenSources
+	screenDatabases
+	screenCatalog
+	screenBackup
+	screenTargets
+	screenForm
+	screenConfirm
+	screenRun
+)
+
+// Flows of the main menu entries.
+const (
+	flowBackup  = "backup"
+	flowRestore = "restore"
+	flowBrowse  = "browse"
+)
+
+// dialog asks to confirm an operation.
+type dialog struct {
+	title string
+	lines []string
+	// safetyToggle offers a safety backup before a restore
+	safetyToggle bool
+	// typeToConfirm must be typed to confirm instead of answering y
+	typeToConfirm string
+	confirm       func(m *menuModel) tea.Cmd
+}
+
+type databasesMsg struct {
+	names []string
+	err   error
+}
+
+type catalogMsg struct {
+	backups []storage.Metadata
+	err     error
+}
+
+// restorePlanMsg delivers the plan of the restore numbered seq, with the
+// warnings printed while its files were fetched.
+type restorePlanMsg struct {
+	seq      int
+	plan     *restorePlan
+	release  func()
+	warnings []string
+	err      error
+}
+
+type menuModel struct {
+	mgr           *config.Manager
+	width, height int
+
+	screen  screen
+	history []screen
+	flow    string
+
+	menu, sources, databases, catalog, targets picker
+
+	backups []storage.Metadata
+	source  config.ServerConfig
+	backup  storage.Metadata
+	dbNames []string
+	target  config.ServerConfig
+	safety  bool
+
+	form    serverForm
+	dialog  dialog
+	typed   textinput.Model
+	op      *operation
+	bar     progress.Model
+	loading string
+	// planSeq numbers restore plans so a late one is not shown for another
+	// dialog; releasePlan removes the files fetched for the shown one
+	planSeq     int
+	releasePlan func()
+	// status reports an error, notice the outcome of the last action
+	status, notice string
+}
+
+func newMenuModel(mgr *config.Manager) menuModel {
+	return menuModel{
+		mgr: mgr,
+		menu: newPicker([]pickItem{
+			{label: "Back up a source", value: flowBackup},
+			{label: "Restore a backup", value: flowRestore},
+			{label: "Browse backups", value: flowBrowse},
+			{label: "Add a source server", value: "source"},
+			{label: "Add a target server", value: "target"},
+			{label: "Quit", value: "quit"},
+		}, false, ""),
+		bar:    progress.New(progress.WithDefaultGradient()),
+		width:  80,
+		height: 24,
+	}
+}
+
+func (m menuModel) Init() tea.Cmd {
+	return nil
+}
+
+// push shows s, remembering the current screen for esc.
+func (m *menuModel) push(s screen) {
+	m.history = append(m.history, m.screen)
+	m.screen = s
+	m.status, m.notice = "", ""
+}
+
+func (m *menuModel) back() {
+	if m.screen == screenConfirm {
+		m.dropPlan()
+	}
+	m.status, m.notice, m.loading = "", "", ""
+	if n := len(m.history); n > 0 {
+		m.screen = m.history[n-1]
+		m.history = m.history[:n-1]
+	}
+}
+
+func (m *menuModel) home() {
+	m.screen, m.history = screenMenu, nil
+	m.loading = ""
+}
+
+func (m menuModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.bar.Width = msg.Width - 4
+		if m.bar.Width > 60 {
+			m.bar.Width = 60
 		}
-		fmt.Println(key) // Echo choice
+		return m, nil
 
-		switch key {
-		case "1":
-			if err := RunInit(); err != nil {
-				fmt.Println("Error:", err)
+	case databasesMsg:
+		m.loading = ""
+		if msg.err != nil {
+			m.status = "Could not list databases: " + msg.err.Error()
+		}
+		var items []pickItem
+		for _, name := range msg.names {
+			items = append(items, pickItem{label: name, value: name})
+		}
+		m.databases = newPicker(items, true, "No databases listed; enter backs up the whole server.")
+		return m, nil
+
+	case catalogMsg:
+		m.loading = ""
+		if msg.err != nil {
+			m.status = msg.err.Error()
+		}
+		m.backups = msg.backups
+		m.catalog = newPicker(catalogItems(msg.backups), false, "No backups in "+storage.Store.Location(""))
+		return m, nil
+
+	case restorePlanMsg:
+		if msg.seq != m.planSeq || m.screen != screenConfirm {
+			if msg.release != nil {
+				msg.release()
 			}
-		case "2":
-			// Ask for source
-			mgr, _ := config.NewManager()
-			fmt.Println("Available Sources:")
-			for _, s := range mgr.ListSources() {
-				fmt.Printf("- %s (%s)\n", s.ID, s.Engine)
+			return m, nil
+		}
+		if msg.err != nil {
+			m.back()
+			m.status = "Cannot restore: " + msg.err.Error()
+			return m, nil
+		}
+		m.loading = ""
+		return m, m.showRestorePlan(msg)
+
+	case opLineMsg:
+		msg.op.addLine(msg.line)
+		return m, msg.op.wait()
+
+	case opDoneMsg:
+		msg.op.done, msg.op.err, msg.op.active = true, msg.err, false
+		return m, nil
+
+	case progressTickMsg:
+		if m.op == nil || m.op.done {
+			return m, nil
+		}
+		m.op.progress, m.op.active = util.CurrentProgress()
+		return m, progressTick()
+
+	case tea.KeyMsg:
+		return m.updateKey(msg)
+	}
+
+	if m.screen == screenForm {
+		_, cmd := m.form.update(msg)
+		return m, cmd
+	}
+	if m.screen == screenConfirm && m.dialog.typeToConfirm != "" {
+		var cmd tea.Cmd
+		m.typed, cmd = m.typed.Update(msg)
+		return m, cmd
+	}
+	return m, nil
+}
+
+func (m menuModel) updateKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	running := m.op != nil && !m.op.done
+	switch msg.String() {
+	case "ctrl+c":
+		if running {
+			m.status = "Wait for the operation to finish"
+			return m, nil
+		}
+		return m, tea.Quit
+	case "esc":
+		if running {
+			return m, nil
+		}
+		if m.screen == screenRun {
+			m.home()
+		} else {
+			m.back()
+		}
+		return m, nil
+	}
+
+	switch m.screen {
+	case screenMenu:
+		if msg.String() == "q" {
+			return m, tea.Quit
+		}
+		if m.menu.update(msg) {
+			return m.chooseMenu()
+		}
+
+	case screenSources:
+		if m.sources.update(msg) {
+			item, _ := m.sources.current()
+			source, err := m.mgr.GetSource(item.value)
+			if err != nil {
+				m.status = err.Error()
+				return m, nil
 			}
-			id := readLine("Enter Source ID to backup: ")
-			if err := RunBackup(id, ""); err != nil {
-				fmt.Println("Error:", err)
+			m.source = source
+			m.databases = newPicker(nil, true, "")
+			m.loading = "Listing the databases of " + source.ID + "..."
+			m.push(screenDatabases)
+			return m, listDatabases(source)
+		}
+
+	case screenDatabases:
+		if m.loading != "" {
+			return m, nil
+		}
+		if m.databases.update(msg) {
+			m.dbNames = m.databases.chosen()
+			if m.flow == flowBackup {
+				m.confirmBackup()
+			} else {
+				m.targets = newPicker(targetItems(m.mgr, m.backup.Engine), false, "No "+m.backup.Engine+" servers configured; add a target first.")
+				m.push(screenTargets)
 			}
-		case "3":
-			if err := RunList(""); err != nil {
-				fmt.Println("Error:", err)
+		}
+
+	case screenCatalog:
+		if m.loading != "" {
+			return m, nil
+		}
+		if m.catalog.update(msg) {
+			m.backup = m.backups[m.catalog.cursor]
+			m.push(screenBackup)
+		}
+
+	case screenBackup:
+		switch msg.String() {
+		case "enter", "r":
+			var items []pickItem
+			for _, f := range m.backup.Files {
+				if f.Status == "success" {
+					items = append(items, pickItem{label: f.Database(), detail: formatSize(f.Size), value: f.Database()})
+				}
 			}
-		case "4":
-			// Restore
-			path := readLine("Enter full path to backup file: ")
-			mgr, _ := config.NewManager()
-			fmt.Println("Available Targets (Sources):")
-			for _, s := range mgr.ListSources() {
-				fmt.Printf("- %s\n", s.ID)
+			m.flow = flowRestore
+			m.databases = newPicker(items, true, "The backup has no restorable files.")
+			m.push(screenDatabases)
+		case "s":
+			id := m.backup.ID
+			return m.run("Contents of "+id, func(out io.Writer) error { return RunShow(out, id, ShowOptions{}) })
+		}
+
+	case screenTargets:
+		if m.targets.update(msg) {
+			item, _ := m.targets.current()
+			target, err := m.mgr.GetTarget(item.value)
+			if err != nil {
+				m.status = err.Error()
+				return m, nil
+			}
+			m.target = target
+			return m, m.confirmRestore()
+		}
+
+	case screenForm:
+		submit, cmd := m.form.update(msg)
+		if !submit {
+			return m, cmd
+		}
+		server, err := m.form.server()
+		if err == nil {
+			err = AddServer(server, m.form.asTarget)
+		}
+		if err != nil {
+			m.form.err = err.Error()
+			return m, nil
+		}
+		if mgr, err := config.NewManager(); err == nil {
+			m.mgr = mgr
+		}
+		m.home()
+		m.notice = fmt.Sprintf("Added %s", server.ID)
+
+	case screenConfirm:
+		if m.loading != "" {
+			return m, nil
+		}
+		if m.dialog.typeToConfirm != "" {
+			switch msg.String() {
+			case "enter":
+				if strings.TrimSpace(m.typed.Value()) != m.dialog.typeToConfirm {
+					m.status = "Type " + m.dialog.typeToConfirm + " to continue, or esc to cancel"
+					return m, nil
+				}
+				return m, m.dialog.confirm(&m)
+			case "tab":
+				if m.dialog.safetyToggle {
+					m.safety = !m.safety
+				}
+				return m, nil
 			}
-			targetID := readLine("Enter Target ID: ")
-			if err := RunRestore(path, targetID); err != nil {
-				fmt.Println("Error:", err)
+			m.status = ""
+			var cmd tea.Cmd
+			m.typed, cmd = m.typed.Update(msg)
+			return m, cmd
+		}
+		switch msg.String() {
+		case "y", "Y":
+			return m, m.dialog.confirm(&m)
+		case "n", "N":
+			m.back()
+		case "s":
+			if m.dialog.safetyToggle {
+				m.safety = !m.safety
 			}
-		case "5":
-			fmt.Println("Bye!")
-			return
-		default:
-			fmt.Println("Invalid option")
+		}
+
+	case screenRun:
+		if m.op.done && (msg.String() == "enter" || msg.String() == "q") {
+			m.home()
 		}
 	}
+	return m, nil
 }
 
-func readKey() (string, error) {
-	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
-	if err != nil {
-		return "", err
+// chooseMenu starts the flow of the main menu entry under the cursor.
+func (m menuModel) chooseMenu() (tea.Model, tea.Cmd) {
+	item, _ := m.menu.current()
+	m.status, m.notice = "", ""
+	switch item.value {
+	case flowBackup:
+		m.flow = flowBackup
+		var items []pickItem
+		for _, s := range m.mgr.ListSources() {
+			items = append(items, pickItem{label: s.ID, detail: serverDetail(s), value: s.ID})
+		}
+		m.sources = newPicker(items, false, "No sources configured; add one first.")
+		m.push(screenSources)
+	case flowRestore, flowBrowse:
+		m.flow = item.value
+		m.catalog = newPicker(nil, false, "")
+		m.loading = "Reading the catalog of " + storage.Store.Location("") + "..."
+		m.push(screenCatalog)
+		return m, loadCatalog()
+	case "source", "target":
+		m.form = newServerForm(item.value == "target")
+		m.push(screenForm)
+		return m, m.form.inputs[0].Focus()
+	case "quit":
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+func (m *menuModel) confirmBackup() {
+	source, dbNames := m.source, m.dbNames
+	m.dialog = dialog{
+		title: "Back up " + source.ID,
+		lines: []string{
+			"Server:    " + serverDetail(source),
+			"Databases: " + databaseList(dbNames),
+		},
+		confirm: func(m *menuModel) tea.Cmd {
+			_, cmd := m.run("Backup of "+source.ID, func(out io.Writer) error {
+				err := RunBackup(out, source.ID, dbNames, nil)
+				// The source's storage profile may have replaced the
+				// one the catalog is browsed in
+				if !storageChosen {
+					if openErr := OpenStorage(""); err == nil {
+						err = openErr
+					}
+				}
+				return err
+			})
+			return cmd
+		},
+	}
+	m.push(screenConfirm)
+}
+
+// confirmRestore fetches the chosen files and works out what they change
+// on the target, to be confirmed in a dialog once the plan arrives.
+func (m *menuModel) confirmRestore() tea.Cmd {
+	backup, dbNames, target := m.backup, m.dbNames, m.target
+	m.planSeq++
+	seq := m.planSeq
+	m.dialog = dialog{title: "Restore to " + target.ID}
+	m.push(screenConfirm)
+	m.loading = "Reading the backup and the databases on " + target.ID + "..."
+	return func() tea.Msg {
+		msg := restorePlanMsg{seq: seq}
+		msg.warnings, msg.err = collectOutput(func(out io.Writer) error {
+			eng, err := engine.Get(target.Engine)
+			if err != nil {
+				return err
+			}
+			msg.plan, msg.release, err = prepareBackupRestore(out, backup, dbNames, target, eng)
+			return err
+		})
+		return msg
 	}
-	defer term.Restore(int(os.Stdin.Fd()), oldState)
+}
 
-	b := make([]byte, 1)
-	_, err = os.Stdin.Read(b)
+// showRestorePlan shows the plan of a restore and asks for the target ID.
+func (m *menuModel) showRestorePlan(msg restorePlanMsg) tea.Cmd {
+	plan, target := msg.plan, m.target
+	m.releasePlan = msg.release
+	lines := []string{
+		"Backup:    " + m.backup.ID,
+		"Files:     " + strings.Join(plan.fileNames(), ", "),
+		"Target:    " + serverDetail(target),
+		"",
+	}
+	for _, w := range msg.warnings {
+		lines = append(lines, dimStyle.Render(w))
+	}
+	if len(msg.warnings) > 0 {
+		lines = append(lines, "")
+	}
+	for i, line := range plan.summary() {
+		if i == 0 && plan.wholeServer {
+			line = errorStyle.Render(line)
+		}
+		lines = append(lines, line)
+	}
+
+	m.dialog = dialog{
+		title:         "Restore to " + target.ID,
+		lines:         lines,
+		safetyToggle:  true,
+		typeToConfirm: target.ID,
+		confirm: func(m *menuModel) tea.Cmd {
+			release := m.releasePlan
+			m.releasePlan = nil
+			// The target ID was typed in the dialog
+			opts := RestoreOptions{Yes: true, SafetyBackup: m.safety}
+			_, cmd := m.run("Restore of "+plan.backup+" to "+target.ID, func(out io.Writer) error {
+				defer release()
+				return restoreFiles(out, plan, opts)
+			})
+			if cmd == nil {
+				// Still on the dialog
+				m.releasePlan = release
+			}
+			return cmd
+		},
+	}
+	m.typed = textinput.New()
+	m.typed.Prompt = ""
+	m.typed.Width = 30
+	return m.typed.Focus()
+}
+
+// dropPlan removes the files fetched for a restore that was not started.
+func (m *menuModel) dropPlan() {
+	if m.releasePlan != nil {
+		m.releasePlan()
+		m.releasePlan = nil
+	}
+}
+
+// run starts fn in the output view.
+func (m *menuModel) run(title string, fn func(out io.Writer) error) (tea.Model, tea.Cmd) {
+	op, cmd, err := startOperation(title, fn)
 	if err != nil {
-		return "", err
+		m.status = err.Error()
+		return *m, nil
+	}
+	m.op = op
+	m.push(screenRun)
+	return *m, cmd
+}
+
+func listDatabases(source config.ServerConfig) tea.Cmd {
+	return func() tea.Msg {
+		eng, err := engine.Get(source.Engine)
+		if err != nil {
+			return databasesMsg{err: err}
+		}
+		names, err := eng.ListDatabases(source)
+		return databasesMsg{names: names, err: err}
+	}
+}
+
+func loadCatalog() tea.Cmd {
+	return func() tea.Msg {
+		backups, err := storage.ListBackups()
+		return catalogMsg{backups: backups, err: err}
+	}
+}
+
+func catalogItems(backups []storage.Metadata) []pickItem {
+	var items []pickItem
+	for _, b := range backups {
+		source := b.Source
+		if source == "" {
+			source = b.Host
+		}
+		detail := fmt.Sprintf("%s, %s, %s", b.Engine, b.Status, backupSize(b))
+		if b.Kind != "" {
+			detail += ", " + b.Kind
+		}
+		if len(b.Tags) > 0 {
+			detail += ", tags " + strings.Join(b.Tags, " ")
+		}
+		items = append(items, pickItem{label: fmt.Sprintf("%-25s %s", b.Timestamp, source), detail: detail, value: b.ID})
+	}
+	return items
+}
+
+// targetItems lists the servers a backup of eng can be restored to, in
+// the lookup order of GetTarget.
+func targetItems(mgr *config.Manager, eng string) []pickItem {
+	var items []pickItem
+	seen := make(map[string]bool)
+	for _, t := range mgr.ListTargets() {
+		if t.Engine != eng || seen[t.ID] {
+			continue
+		}
+		seen[t.ID] = true
+		items = append(items, pickItem{label: t.ID, detail: serverDetail(t), value: t.ID})
+	}
+	return items
+}
+
+func serverDetail(s config.ServerConfig) string {
+	if s.Port == 0 {
+		return fmt.Sprintf("%s on %s", s.Engine, s.Host)
+	}
+	return fmt.Sprintf("%s on %s:%d", s.Engine, s.Host, s.Port)
+}
+
+func databaseList(dbNames []string) string {
+	if len(dbNames) == 0 {
+		return "all"
+	}
+	return strings.Join(dbNames, ", ")
+}
+
+func (m menuModel) View() string {
+	var title, body, help string
+	// Rows left for the body: title, blank line, blank line, help, status
+	rows := m.height - 5
+
+	switch m.screen {
+	case screenMenu:
+		title = "dbmigrate"
+		body = m.menu.view(rows)
+		help = "↑/↓ move • enter choose • q quit"
+	case screenSources:
+		title = "Back up: choose a source"
+		body = m.sources.view(rows)
+		help = "↑/↓ move • enter choose • esc back"
+	case screenDatabases:
+		if m.flow == flowBackup {
+			title = "Back up " + m.source.ID + ": choose databases"
+		} else {
+			title = "Restore " + m.backup.ID + ": choose databases"
+		}
+		body = m.databases.view(rows)
+		help = "space select • a all • enter continue (none selected: all) • esc back"
+	case screenCatalog:
+		title = "Backups in " + storage.Store.Location("")
+		body = m.catalog.view(rows)
+		help = "↑/↓ move • enter details • esc back"
+	case screenBackup:
+		title = "Backup " + m.backup.ID
+		body = backupDetail(m.backup, rows)
+		help = "r restore • s show contents • esc back"
+	case screenTargets:
+		title = "Restore " + m.backup.ID + ": choose a target"
+		body = m.targets.view(rows)
+		help = "↑/↓ move • enter choose • esc back"
+	case screenForm:
+		title = "Add a source server"
+		if m.form.asTarget {
+			title = "Add a target server"
+		}
+		body = m.form.view()
+		help = "tab/↓ next field • shift+tab/↑ previous • enter on the last field saves • esc cancel"
+	case screenConfirm:
+		title = m.dialog.title
+		body = m.dialogView()
+		help = "y confirm • n cancel"
+		if m.dialog.typeToConfirm != "" {
+			help = "type the target ID • enter confirm • tab toggle safety backup • esc cancel"
+		} else if m.dialog.safetyToggle {
+			help = "y confirm • s toggle safety backup • n cancel"
+		}
+	case screenRun:
+		title = m.op.title
+		body = m.runView(rows)
+		help = "running..."
+		if m.op.done {
+			help = "enter back to the menu"
+		}
+	}
+	if m.loading != "" {
+		body = dimStyle.Render(m.loading)
+	}
+
+	var b strings.Builder
+	b.WriteString(titleStyle.Render(title) + "\n\n")
+	b.WriteString(body + "\n\n")
+	b.WriteString(dimStyle.Render(help))
+	if m.status != "" {
+		b.WriteString("\n" + errorStyle.Render(m.status))
+	} else if m.notice != "" {
+		b.WriteString("\n" + okStyle.Render(m.notice))
+	}
+	return b.String()
+}
+
+func (m menuModel) dialogView() string {
+	lines := append([]string{}, m.dialog.lines...)
+	if m.dialog.safetyToggle {
+		box := "[ ]"
+		if m.safety {
+			box = selectedStyle.Render("[x]")
+		}
+		lines = append(lines, "", box+" Take a safety backup of the affected databases first")
+	}
+	if m.dialog.typeToConfirm != "" {
+		lines = append(lines, "", fmt.Sprintf("Type the target ID (%s) to continue: %s", m.dialog.typeToConfirm, m.typed.View()))
+	} else {
+		lines = append(lines, "", "Continue? (y/n)")
+	}
+	return dialogStyle.Render(strings.Join(lines, "\n"))
+}
+
+// runView shows the end of the output of the operation and the progress of
+// the running dump or restore.
+func (m menuModel) runView(rows int) string {
+	op := m.op
+	var footer []string
+	if op.active {
+		p := op.progress
+		line := p.Label
+		if p.Percent >= 0 {
+			line += "  " + m.bar.ViewAs(p.Percent/100)
+		}
+		footer = append(footer, line, dimStyle.Render(p.String()))
+	}
+	if op.done {
+		if op.err != nil {
+			footer = append(footer, errorStyle.Render("Failed: "+op.err.Error()))
+		} else {
+			footer = append(footer, okStyle.Render("Done"))
+		}
+	}
+
+	n := rows - len(footer) - 1
+	if n < 1 {
+		n = 1
+	}
+	lines := op.lines
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		out[i] = truncate(l, m.width)
+	}
+	if len(footer) == 0 {
+		return strings.Join(out, "\n")
+	}
+	return strings.Join(out, "\n") + "\n\n" + strings.Join(footer, "\n")
+}
+
+// backupDetail describes a backup and its files.
+func backupDetail(b storage.Metadata, rows int) string {
+	source := b.Source
+	if source == "" {
+		source = b.Host
+	}
+	lines := []string{
+		fmt.Sprintf("Source:   %s (%s on %s)", source, b.Engine, b.Host),
+		fmt.Sprintf("Status:   %s", b.Status),
+		fmt.Sprintf("Started:  %s, took %s", b.StartedAt, b.Duration().Round(time.Second)),
+		fmt.Sprintf("Size:     %s", backupSize(b)),
+	}
+	if b.Kind != "" {
+		lines = append(lines, fmt.Sprintf("Kind:     %s (%s)", b.Kind, b.Note))
+	}
+	if len(b.Tags) > 0 {
+		lines = append(lines, "Tags:     "+strings.Join(b.Tags, ", "))
+	}
+	if b.Encryption != nil {
+		lines = append(lines, "Encrypted with "+b.Encryption.Format)
+	}
+	if d := b.LastDrill; d != nil {
+		lines = append(lines, fmt.Sprintf("Drill:    %s on %s at %s", d.Status, d.Target, d.Time))
+	}
+
+	lines = append(lines, "", dimStyle.Render(fmt.Sprintf("%-40s %10s %6s %8s %9s  %s", "FILE", "SIZE", "CODEC", "TABLES", "DURATION", "STATUS")))
+	for _, f := range b.Files {
+		codec := f.Compression
+		if codec == "" {
+			codec = "gzip"
+		}
+		tables := "-"
+		if len(f.Tables) > 0 {
+			tables = fmt.Sprint(len(f.Tables))
+		}
+		status := okStyle.Render(f.Status)
+		if f.Status != "success" {
+			status = errorStyle.Render(f.Status)
+		}
+		duration := "-"
+		if f.DurationMS > 0 {
+			duration = (time.Duration(f.DurationMS) * time.Millisecond).String()
+		}
+		lines = append(lines, fmt.Sprintf("%-40s %10s %6s %8s %9s  %s", f.Name, formatSize(f.Size), codec, tables, duration, status))
+		if f.Error != "" {
+			lines = append(lines, errorStyle.Render("  "+f.Error))
+		}
+	}
+	if len(lines) > rows && rows > 0 {
+		lines = append(lines[:rows-1], dimStyle.Render(fmt.Sprintf("... %d more lines", len(lines)-rows+1)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// truncate cuts s to width cells.
+func truncate(s string, width int) string {
+	if width <= 0 || lipgloss.Width(s) <= width {
+		return s
+	}
+	r := []rune(s)
+	for len(r) > 0 && lipgloss.Width(string(r)) > width {
+		r = r[:len(r)-1]
 	}
-	return string(b), nil
+	return string(r)
 }
@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// maxOutputLines caps the output an operation view keeps.
+const maxOutputLines = 1000
+
+// operation runs one of the Run functions while the menu shows its output.
+// Everything the function prints to its writer, and logs written to stderr,
+// is captured line by line instead of reaching the terminal.
+type operation struct {
+	title string
+	lines []string
+	done  bool
+	err   error
+	// progress is the running dump or restore, if active
+	progress util.ProgressStatus
+	active   bool
+
+	out    chan string
+	result chan error
+}
+
+type opLineMsg struct {
+	op   *operation
+	line string
+}
+
+type opDoneMsg struct {
+	op  *operation
+	err error
+}
+
+type progressTickMsg struct{}
+
+// startOperation runs fn in the background with its output, and the logs,
+// redirected to the operation. The returned command delivers the output to
+// the menu.
+func startOperation(title string, fn func(out io.Writer) error) (*operation, tea.Cmd, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	op := &operation{
+		title:  title,
+		out:    make(chan string, 256),
+		result: make(chan error, 1),
+	}
+
+	restore := redirectLogs(w)
+	go func() {
+		defer close(op.out)
+		defer r.Close()
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for sc.Scan() {
+			op.out <- sc.Text()
+		}
+		// Keep the writer from blocking after an overlong line
+		io.Copy(io.Discard, r)
+	}()
+	go func() {
+		err := fn(w)
+		restore()
+		w.Close()
+		op.result <- err
+	}()
+	return op, tea.Batch(op.wait(), progressTick()), nil
+}
+
+// redirectLogs sends logs written to stderr to w until the returned function
+// restores them.
+func redirectLogs(w io.Writer) func() {
+	logOut := util.Logger.Out
+	if logOut == os.Stderr {
+		util.Logger.SetOutput(w)
+	}
+	return func() {
+		util.Logger.SetOutput(logOut)
+	}
+}
+
+// collectOutput runs fn with its output, and the logs, captured instead of
+// drawn over the menu, and returns the lines they printed.
+func collectOutput(fn func(out io.Writer) error) ([]string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer r.Close()
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for sc.Scan() {
+			lines = append(lines, sc.Text())
+		}
+		io.Copy(io.Discard, r)
+	}()
+	restore := redirectLogs(w)
+	err = fn(w)
+	restore()
+	w.Close()
+	<-done
+	return lines, err
+}
+
+// wait delivers the next line of output, and the result once the output
+// ends.
+func (op *operation) wait() tea.Cmd {
+	return func() tea.Msg {
+		if line, ok := <-op.out; ok {
+			return opLineMsg{op: op, line: line}
+		}
+		return opDoneMsg{op: op, err: <-op.result}
+	}
+}
+
+func (op *operation) addLine(line string) {
+	op.lines = append(op.lines, line)
+	if len(op.lines) > maxOutputLines {
+		op.lines = op.lines[len(op.lines)-maxOutputLines:]
+	}
+}
+
+func progressTick() tea.Cmd {
+	return tea.Tick(200*time.Millisecond, func(time.Time) tea.Msg { return progressTickMsg{} })
+}
@@ -0,0 +1,252 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+)
+
+var (
+	titleStyle    = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("12"))
+	cursorStyle   = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("13"))
+	dimStyle      = lipgloss.NewStyle().Faint(true)
+	errorStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	okStyle       = lipgloss.NewStyle().Foreground(lipgloss.Color("10"))
+	dialogStyle   = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(lipgloss.Color("12")).Padding(0, 1)
+	selectedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("10"))
+)
+
+// pickItem is one row of a picker.
+type pickItem struct {
+	label string
+	// detail is shown dimmed after the label
+	detail string
+	value  string
+}
+
+// picker is a scrolling list. With multi set, space toggles items and
+// chosen returns the selected ones.
+type picker struct {
+	items    []pickItem
+	cursor   int
+	multi    bool
+	selected map[int]bool
+	// empty is shown when there are no items
+	empty string
+}
+
+func newPicker(items []pickItem, multi bool, empty string) picker {
+	return picker{items: items, multi: multi, selected: make(map[int]bool), empty: empty}
+}
+
+// update handles navigation keys and reports whether enter was pressed.
+func (p *picker) update(msg tea.KeyMsg) bool {
+	last := len(p.items) - 1
+	switch msg.String() {
+	case "up", "k":
+		p.cursor--
+	case "down", "j":
+		p.cursor++
+	case "pgup":
+		p.cursor -= 10
+	case "pgdown":
+		p.cursor += 10
+	case "home", "g":
+		p.cursor = 0
+	case "end", "G":
+		p.cursor = last
+	case " ", "x":
+		if p.multi && last >= 0 {
+			p.selected[p.cursor] = !p.selected[p.cursor]
+		}
+	case "a":
+		if p.multi {
+			all := len(p.chosen()) < len(p.items)
+			for i := range p.items {
+				p.selected[i] = all
+			}
+		}
+	case "enter":
+		return last >= 0 || p.multi
+	}
+	if p.cursor > last {
+		p.cursor = last
+	}
+	if p.cursor < 0 {
+		p.cursor = 0
+	}
+	return false
+}
+
+// current returns the item under the cursor.
+func (p picker) current() (pickItem, bool) {
+	if len(p.items) == 0 {
+		return pickItem{}, false
+	}
+	return p.items[p.cursor], true
+}
+
+// chosen returns the values of the selected items, in list order.
+func (p picker) chosen() []string {
+	var values []string
+	for i, item := range p.items {
+		if p.selected[i] {
+			values = append(values, item.value)
+		}
+	}
+	return values
+}
+
+// view renders at most height rows, scrolled to keep the cursor visible.
+func (p picker) view(height int) string {
+	if len(p.items) == 0 {
+		return dimStyle.Render(p.empty)
+	}
+	if height < 1 {
+		height = 1
+	}
+	start := 0
+	if p.cursor >= height {
+		start = p.cursor - height + 1
+	}
+	end := start + height
+	if end > len(p.items) {
+		end = len(p.items)
+	}
+
+	var b strings.Builder
+	for i := start; i < end; i++ {
+		item := p.items[i]
+		prefix := "  "
+		if i == p.cursor {
+			prefix = cursorStyle.Render("> ")
+		}
+		if p.multi {
+			if p.selected[i] {
+				prefix += selectedStyle.Render("[x] ")
+			} else {
+				prefix += "[ ] "
+			}
+		}
+		label := item.label
+		if i == p.cursor {
+			label = cursorStyle.Render(label)
+		}
+		b.WriteString(prefix + label)
+		if item.detail != "" {
+			b.WriteString("  " + dimStyle.Render(item.detail))
+		}
+		if i < end-1 {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// serverForm asks for the settings of a new source or target server.
+type serverForm struct {
+	asTarget bool
+	inputs   []textinput.Model
+	focus    int
+	err      string
+}
+
+var serverFormLabels = []string{"ID", "Engine", "Host", "Port", "User", "Password"}
+
+func newServerForm(asTarget bool) serverForm {
+	engines := engine.ListEngines()
+	sort.Strings(engines)
+	placeholders := []string{
+		"a name for the server",
+		strings.Join(engines, ", "),
+		"IP/domain, or file path/glob for sqlite",
+		"",
+		"",
+		"",
+	}
+	f := serverForm{asTarget: asTarget}
+	for i, ph := range placeholders {
+		in := textinput.New()
+		in.Placeholder = ph
+		in.Prompt = ""
+		in.Width = 50
+		if serverFormLabels[i] == "Password" {
+			in.EchoMode = textinput.EchoPassword
+		}
+		f.inputs = append(f.inputs, in)
+	}
+	f.inputs[0].Focus()
+	return f
+}
+
+// update moves between the fields and edits them. It reports whether the
+// form was submitted with enter on the last field.
+func (f *serverForm) update(msg tea.Msg) (bool, tea.Cmd) {
+	if key, ok := msg.(tea.KeyMsg); ok {
+		next := f.focus
+		switch key.String() {
+		case "tab", "down":
+			next++
+		case "shift+tab", "up":
+			next--
+		case "enter":
+			if f.focus == len(f.inputs)-1 {
+				return true, nil
+			}
+			next++
+		}
+		if next != f.focus && next >= 0 && next < len(f.inputs) {
+			f.inputs[f.focus].Blur()
+			f.focus = next
+			return false, f.inputs[f.focus].Focus()
+		}
+	}
+	var cmd tea.Cmd
+	f.inputs[f.focus], cmd = f.inputs[f.focus].Update(msg)
+	return false, cmd
+}
+
+// server returns the entered settings.
+func (f serverForm) server() (config.ServerConfig, error) {
+	value := func(i int) string { return strings.TrimSpace(f.inputs[i].Value()) }
+	s := config.ServerConfig{
+		ID:       value(0),
+		Engine:   value(1),
+		Host:     value(2),
+		User:     value(4),
+		Password: f.inputs[5].Value(),
+	}
+	if s.ID == "" {
+		return s, fmt.Errorf("the ID is required")
+	}
+	if p := value(3); p != "" {
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return s, fmt.Errorf("invalid port %q", p)
+		}
+		s.Port = port
+	}
+	return s, nil
+}
+
+func (f serverForm) view() string {
+	var b strings.Builder
+	for i, in := range f.inputs {
+		label := fmt.Sprintf("%-10s", serverFormLabels[i])
+		if i == f.focus {
+			label = cursorStyle.Render(label)
+		}
+		b.WriteString(label + " " + in.View() + "\n")
+	}
+	if f.err != "" {
+		b.WriteString("\n" + errorStyle.Render(f.err) + "\n")
+	}
+	return b.String()
+}
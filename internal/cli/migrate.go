@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/sirupsen/logrus"
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/migrate"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// RunMigrate copies a database from a source to a target of a different engine.
+func RunMigrate(out io.Writer, sourceID string, targetID string, opts migrate.Options, reportPath string) error {
+	mgr, err := config.NewManager()
+	if err != nil {
+		return err
+	}
+
+	source, err := mgr.GetSource(sourceID)
+	if err != nil {
+		return err
+	}
+	target, err := mgr.GetTarget(targetID)
+	if err != nil {
+		return err
+	}
+
+	defer util.LogScope(logrus.Fields{"source": source.ID, "target": target.ID, "database": opts.Database})()
+	opts.Out = out
+	report, migrateErr := migrate.Run(source, target, opts)
+	if report == nil {
+		return migrateErr
+	}
+
+	if reportPath != "" {
+		if err := report.WriteJSON(reportPath); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+		fmt.Fprintf(out, "Report written to %s\n", reportPath)
+	}
+	res := MigrateResult{Report: report, Status: "success"}
+	if migrateErr != nil {
+		res.Status = "failed"
+		for _, t := range report.Tables {
+			if t.Error == "" {
+				res.Status = "partial"
+				break
+			}
+		}
+	}
+	if err := render(out, res); err != nil {
+		return err
+	}
+	if res.Status == "partial" {
+		return fmt.Errorf("%w: %w", migrateErr, ErrPartial)
+	}
+	return migrateErr
+}
+
+// MigrateResult is the outcome of migrate: the translation report, and
+// whether every table was copied.
+type MigrateResult struct {
+	Status string `json:"status"` // success, partial, failed
+	*migrate.Report
+}
+
+func (r MigrateResult) printTable(out io.Writer) {
+	if len(r.Issues) > 0 {
+		fmt.Fprintln(out, "\nTranslation report:")
+		for _, issue := range r.Issues {
+			fmt.Fprintf(out, " - %s\n", issue)
+		}
+	}
+	if r.Status == "success" {
+		fmt.Fprintln(out, "Migration completed!")
+	}
+}
@@ -0,0 +1,203 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// Output formats of command results.
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+)
+
+// Exit codes. Successful commands exit with 0.
+const (
+	ExitFailure = 1
+	ExitUsage   = 2
+	// ExitPartial means the command succeeded for some databases only
+	ExitPartial = 3
+)
+
+// ErrPartial is wrapped by the errors of commands that succeeded in part,
+// such as backups with status "partial".
+var ErrPartial = errors.New("partial success")
+
+// UsageError reports invalid flags or arguments.
+type UsageError struct {
+	Msg string
+}
+
+func (e *UsageError) Error() string { return e.Msg }
+
+// Usagef returns a UsageError with a formatted message.
+func Usagef(format string, args ...interface{}) error {
+	return &UsageError{Msg: fmt.Sprintf(format, args...)}
+}
+
+// ExitCode returns the process exit code for the error of a command.
+func ExitCode(err error) int {
+	var usage *UsageError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &usage):
+		return ExitUsage
+	case errors.Is(err, ErrPartial):
+		return ExitPartial
+	}
+	return ExitFailure
+}
+
+var (
+	outputFormat = OutputTable
+	// resultOut receives the results of commands
+	resultOut io.Writer = os.Stdout
+	// rendered is set once a command wrote its result
+	rendered bool
+)
+
+// SetOutput selects the format of command results. In the json and yaml
+// formats stdout carries only the result: see HumanOutput.
+func SetOutput(format string) error {
+	switch format {
+	case OutputTable, OutputJSON, OutputYAML:
+	default:
+		return Usagef("unknown output format %q (table, json, yaml)", format)
+	}
+	outputFormat = format
+	return nil
+}
+
+// HumanOutput returns where commands print for people, such as progress
+// and prompts: stdout, or stderr in the json and yaml formats.
+func HumanOutput() io.Writer {
+	if outputFormat == OutputTable {
+		return os.Stdout
+	}
+	return os.Stderr
+}
+
+// SetProgress selects how the progress of dumps and restores is shown.
+func SetProgress(mode string) error {
+	if err := util.SetProgressMode(mode); err != nil {
+		return &UsageError{Msg: err.Error()}
+	}
+	return nil
+}
+
+// SetupLogging configures the logs: level, text or json format, and the log
+// file, rotated at maxSizeMB, that replaces stderr.
+func SetupLogging(level, format, file string, maxSizeMB int) error {
+	err := util.SetupLogging(util.LogOptions{
+		Level:   level,
+		Format:  format,
+		File:    file,
+		MaxSize: int64(maxSizeMB) << 20,
+	})
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return err
+	} else if err != nil {
+		// An unknown level or format
+		return &UsageError{Msg: err.Error()}
+	}
+	util.Log().WithFields(logrus.Fields{
+		"version": Version,
+		"args":    strings.Join(util.RedactArgs(os.Args), " "),
+	}).Info("dbmigrate started")
+	return nil
+}
+
+// result is the outcome of a command. printTable writes it for people.
+type result interface {
+	printTable(out io.Writer)
+}
+
+// render writes the result of a command in the selected format: json and
+// yaml to stdout, and tables to out.
+func render(out io.Writer, r result) error {
+	rendered = true
+	switch outputFormat {
+	case OutputJSON:
+		enc := json.NewEncoder(resultOut)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case OutputYAML:
+		data, err := toYAML(r)
+		if err != nil {
+			return err
+		}
+		_, err = resultOut.Write(data)
+		return err
+	}
+	r.printTable(out)
+	return nil
+}
+
+// toYAML converts v through its JSON encoding, so YAML output uses the same
+// field names and order as JSON output.
+func toYAML(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	blockStyle(&doc)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// blockStyle drops the flow and quoting styles of parsed JSON; the encoder
+// still quotes strings that would otherwise read as other types.
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// errorResult is the result of a command that failed before producing one.
+type errorResult struct {
+	Error    string `json:"error"`
+	ExitCode int    `json:"exit_code"`
+}
+
+func (errorResult) printTable(io.Writer) {}
+
+// Fail reports the error of a command and exits with its exit code. In the
+// json and yaml formats a command that failed without a result writes an
+// error result.
+func Fail(err error) {
+	code := ExitCode(err)
+	// On stderr the log entry would repeat the message printed below
+	if util.Logger.Out != os.Stderr {
+		util.Log().WithError(err).WithField("exit_code", code).Error("command failed")
+	}
+	if outputFormat != OutputTable && !rendered {
+		render(HumanOutput(), errorResult{Error: err.Error(), ExitCode: code})
+	}
+	fmt.Fprintln(HumanOutput(), "Error:", err)
+	os.Exit(code)
+}
@@ -0,0 +1,266 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/storage"
+)
+
+// PruneResult is the outcome of prune: a verdict for each backup, per
+// source.
+type PruneResult struct {
+	Location string       `json:"location"`
+	DryRun   bool         `json:"dry_run"`
+	Groups   []PruneGroup `json:"groups"`
+	// Removed and Freed count what was (or would be) removed
+	Removed int   `json:"removed"`
+	Freed   int64 `json:"freed"`
+	Failed  int   `json:"failed"`
+}
+
+// PruneGroup holds the verdicts for the backups of one source, or of one
+// server for backups without a source ID. Rules is empty if no retention
+// rules apply, and everything is kept.
+type PruneGroup struct {
+	Server    string         `json:"server"`
+	Rules     string         `json:"rules,omitempty"`
+	Decisions []PruneVerdict `json:"decisions"`
+}
+
+// PruneVerdict is the decision for one backup.
+type PruneVerdict struct {
+	ID        string `json:"id"`
+	Timestamp string `json:"timestamp"`
+	Status    string `json:"status"`
+	Keep      bool   `json:"keep"`
+	Reason    string `json:"reason"`
+	// Error is set if the backup could not be removed
+	Error string `json:"error,omitempty"`
+}
+
+func (r PruneResult) printTable(out io.Writer) {
+	if len(r.Groups) == 0 {
+		fmt.Fprintf(out, "No backups in %s\n", r.Location)
+		return
+	}
+	for _, g := range r.Groups {
+		if g.Rules == "" {
+			fmt.Fprintf(out, "%s: no retention rules, keeping %d backups\n", g.Server, len(g.Decisions))
+			continue
+		}
+		remove := 0
+		for _, d := range g.Decisions {
+			if !d.Keep {
+				remove++
+			}
+		}
+		fmt.Fprintf(out, "%s (%s): keep %d, remove %d\n", g.Server, g.Rules, len(g.Decisions)-remove, remove)
+		for _, d := range g.Decisions {
+			verdict := "keep"
+			if !d.Keep {
+				verdict = "remove"
+			}
+			fmt.Fprintf(out, "  %-6s %-25s %-8s %s\n", verdict, d.Timestamp, d.Status, d.Reason)
+			if d.Error != "" {
+				fmt.Fprintf(out, "  [FAIL] %s: %s\n", d.ID, d.Error)
+			}
+		}
+	}
+
+	if r.DryRun {
+		fmt.Fprintf(out, "Dry run: would remove %d backups (%s)\n", r.Removed, formatSize(r.Freed))
+		return
+	}
+	fmt.Fprintf(out, "Removed %d backups (%s)\n", r.Removed, formatSize(r.Freed))
+	if r.Removed > 0 && storage.Deduplicating() {
+		fmt.Fprintln(out, "Run gc to free the chunks of removed deduplicated backups")
+	}
+}
+
+// RunPrune applies the retention rules to the backups in the store, one
+// source at a time (one server for backups without a source ID): the
+// source's own rules, else those of the storage profile. With dryRun, the verdicts are reported but nothing is removed.
+func RunPrune(out io.Writer, sourceID string, dryRun bool) error {
+	mgr, err := config.NewManager()
+	if err != nil {
+		return err
+	}
+	var only *storage.Query
+	if sourceID != "" {
+		q, err := sourceQuery(mgr, sourceID)
+		if err != nil {
+			return err
+		}
+		only = &q
+	}
+
+	backups, err := storage.ListBackups()
+	if err != nil {
+		return err
+	}
+	groups := make(map[string][]storage.Metadata)
+	var keys []string
+	for _, b := range backups {
+		if only != nil && (!only.Match(b) || b.Kind != "") {
+			continue
+		}
+		// Sources on the same server keep separate series; backups from
+		// before source IDs were recorded are grouped by server
+		key := "source " + b.Source
+		if b.Source == "" {
+			key = fmt.Sprintf("%s %s:%d", b.Engine, b.Host, b.Port)
+		}
+		if b.Kind != "" {
+			key += " (" + b.Kind + ")"
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], b)
+	}
+	sort.Strings(keys)
+
+	res := PruneResult{Location: storage.Store.Location(""), DryRun: dryRun, Groups: []PruneGroup{}}
+	now := time.Now()
+	for _, key := range keys {
+		group := groups[key]
+		g := PruneGroup{Server: key}
+		rules, origin := retentionFor(mgr, group[0])
+		if rules == nil {
+			for _, b := range group {
+				g.Decisions = append(g.Decisions, PruneVerdict{ID: b.ID, Timestamp: b.Timestamp, Status: b.Status, Keep: true, Reason: "no retention rules"})
+			}
+			res.Groups = append(res.Groups, g)
+			continue
+		}
+		policy, err := retentionPolicy(*rules)
+		if err != nil {
+			return fmt.Errorf("%s: %w", origin, err)
+		}
+		g.Rules = origin
+
+		for _, d := range storage.PlanRetention(group, policy, now) {
+			v := PruneVerdict{ID: d.Backup.ID, Timestamp: d.Backup.Timestamp, Status: d.Backup.Status, Keep: d.Keep, Reason: d.Reason}
+			if !d.Keep {
+				var err error
+				if !dryRun {
+					err = storage.DeleteBackup(d.Backup)
+				}
+				if err != nil {
+					v.Error = err.Error()
+					res.Failed++
+				} else {
+					res.Removed++
+					res.Freed += d.Backup.Size()
+				}
+			}
+			g.Decisions = append(g.Decisions, v)
+		}
+		res.Groups = append(res.Groups, g)
+	}
+
+	if err := render(out, res); err != nil {
+		return err
+	}
+	if res.Failed > 0 {
+		return fmt.Errorf("failed to remove %d backups", res.Failed)
+	}
+	return nil
+}
+
+// retentionFor returns the retention rules for a backup and where they
+// come from: the source that took the backup, else the storage profile. Safety backups only follow the profile.
+func retentionFor(mgr *config.Manager, b storage.Metadata) (*config.Retention, string) {
+	if b.Kind == "" {
+		for _, s := range mgr.Config.Sources {
+			q := storage.Query{Source: s.ID, Engine: s.Engine, Host: s.Host, Port: s.Port}
+			if q.Match(b) && s.Retention != nil {
+				return s.Retention, "retention of source " + s.ID
+			}
+		}
+	}
+	return storageProfile.Retention, "retention of storage " + storageProfile.Name
+}
+
+// retentionPolicy parses the durations and sizes of configured rules.
+func retentionPolicy(r config.Retention) (storage.RetentionPolicy, error) {
+	policy := storage.RetentionPolicy{
+		KeepLast:    r.KeepLast,
+		KeepDaily:   r.KeepDaily,
+		KeepWeekly:  r.KeepWeekly,
+		KeepMonthly: r.KeepMonthly,
+		KeepYearly:  r.KeepYearly,
+	}
+	var err error
+	if r.MaxAge != "" {
+		if policy.MaxAge, err = parseAge(r.MaxAge); err != nil {
+			return policy, err
+		}
+	}
+	if r.MaxSize != "" {
+		if policy.MaxSize, err = parseSize(r.MaxSize); err != nil {
+			return policy, err
+		}
+	}
+	return policy, nil
+}
+
+// parseAge accepts Go durations plus the units d (days) and w (weeks).
+func parseAge(s string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, suffix)); err == nil && strings.HasSuffix(s, suffix) && n > 0 {
+			return time.Duration(n) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid max_age %q (e.g. 90d, 12w or 36h)", s)
+	}
+	return d, nil
+}
+
+// parseSize accepts sizes such as 500MB, 2GiB or 1.5TB.
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   float64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+		{"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12}, {"B", 1},
+	}
+	value := strings.TrimSpace(s)
+	unit := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(strings.ToUpper(value), strings.ToUpper(u.suffix)) {
+			value, unit = strings.TrimSpace(value[:len(value)-len(u.suffix)]), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid max_size %q (e.g. 500MB or 2GiB)", s)
+	}
+	return int64(n * unit), nil
+}
+
+// formatSize renders a byte count with a binary unit.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,454 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/storage"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// RestoreOptions controls the safety checks of a restore.
+type RestoreOptions struct {
+	// Yes skips the typed confirmation
+	Yes bool
+	// SafetyBackup backs up the affected target databases before restoring
+	SafetyBackup bool
+}
+
+// restoreTarget is a database the restore will create or overwrite.
+type restoreTarget struct {
+	Name   string `json:"database"`
+	Exists bool   `json:"exists"`
+	Tables int    `json:"tables"` // -1 if unknown
+}
+
+// RestoreResult is the outcome of restore.
+type RestoreResult struct {
+	// Backup is the backup ID, or the path of a restored file
+	Backup string `json:"backup"`
+	Target string `json:"target"`
+	Status string `json:"status"` // success, partial, failed
+	// WholeServer is set if the backup replaces every database on the target
+	WholeServer bool            `json:"whole_server,omitempty"`
+	Databases   []restoreTarget `json:"databases"`
+	// SafetyBackup is the ID of the backup taken of the target first
+	SafetyBackup string         `json:"safety_backup,omitempty"`
+	Files        []RestoredFile `json:"files"`
+}
+
+// RestoredFile is the outcome of restoring one backup file.
+type RestoredFile struct {
+	File   string `json:"file"`
+	Status string `json:"status"` // success, failed, skipped
+	Error  string `json:"error,omitempty"`
+}
+
+func (r RestoreResult) printTable(out io.Writer) {
+	if r.Status == "success" {
+		fmt.Fprintln(out, "Restore completed!")
+	}
+	if r.SafetyBackup != "" {
+		if r.Status != "success" {
+			fmt.Fprint(out, "Restore failed. ")
+		}
+		fmt.Fprintln(out, "To roll back, restore the safety backup:")
+		printRollback(out, r.SafetyBackup, r.Target)
+	}
+}
+
+// RunRestore restores a backup file to a target.
+func RunRestore(out io.Writer, backupPath string, targetID string, opts RestoreOptions) error {
+	target, eng, err := loadTarget(targetID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(backupPath); err != nil {
+		return fmt.Errorf("backup file not found: %w", err)
+	}
+	// Files from the catalog have their engine recorded next to them
+	if meta, err := storage.LoadMetadata(filepath.Join(filepath.Dir(backupPath), "metadata.json")); err == nil {
+		if err := checkEngine(meta, target); err != nil {
+			return err
+		}
+	}
+
+	plan, err := planRestore(out, backupPath, target, eng, []string{backupPath})
+	if err != nil {
+		return err
+	}
+	return restoreFiles(out, plan, opts)
+}
+
+// RunRestoreBackup restores a backup from the catalog, chosen by ID or as the
+// latest backup of sourceID. dbNames limits the restore to those databases.
+func RunRestoreBackup(out io.Writer, backupID string, sourceID string, dbNames []string, targetID string, opts RestoreOptions) error {
+	target, eng, err := loadTarget(targetID)
+	if err != nil {
+		return err
+	}
+
+	var meta storage.Metadata
+	if backupID != "" {
+		meta, err = storage.FindBackup(backupID)
+	} else {
+		mgr, mgrErr := config.NewManager()
+		if mgrErr != nil {
+			return mgrErr
+		}
+		q, srcErr := sourceQuery(mgr, sourceID)
+		if srcErr != nil {
+			return srcErr
+		}
+		meta, err = storage.LatestBackup(q.Source, q.Engine, q.Host, q.Port)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Using backup %s (%s, status %s)\n", meta.ID, meta.Timestamp, meta.Status)
+
+	plan, release, err := prepareBackupRestore(out, meta, dbNames, target, eng)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return restoreFiles(out, plan, opts)
+}
+
+// prepareBackupRestore checks that meta can be restored to target, fetches
+// the files holding dbNames and plans their restore. release removes the
+// fetched files.
+func prepareBackupRestore(out io.Writer, meta storage.Metadata, dbNames []string, target config.ServerConfig, eng engine.Engine) (*restorePlan, func(), error) {
+	if err := checkEngine(meta, target); err != nil {
+		return nil, nil, err
+	}
+	files, release, err := selectBackupFiles(out, meta, dbNames)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan, err := planRestore(out, meta.ID, target, eng, files)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return plan, release, nil
+}
+
+func loadTarget(targetID string) (config.ServerConfig, engine.Engine, error) {
+	mgr, err := config.NewManager()
+	if err != nil {
+		return config.ServerConfig{}, nil, err
+	}
+	target, err := mgr.GetTarget(targetID)
+	if err != nil {
+		return config.ServerConfig{}, nil, err
+	}
+	eng, err := engine.Get(target.Engine)
+	if err != nil {
+		return config.ServerConfig{}, nil, err
+	}
+	return target, eng, nil
+}
+
+func checkEngine(meta storage.Metadata, target config.ServerConfig) error {
+	if meta.Engine != target.Engine {
+		return fmt.Errorf("backup %s is a %s backup and cannot be restored into %s target %s (use migrate for cross-engine copies)", meta.ID, meta.Engine, target.Engine, target.ID)
+	}
+	return nil
+}
+
+// selectBackupFiles returns local paths of the files of meta that hold
+// dbNames (all when empty), downloading them from remote storage if needed;
+// release removes the downloads. Failed files are skipped with a warning;
+// checksums are verified so a corrupt file is never restored.
+func selectBackupFiles(out io.Writer, meta storage.Metadata, dbNames []string) ([]string, func(), error) {
+	if meta.Encryption != nil && !util.HasIdentities() {
+		return nil, nil, fmt.Errorf("backup %s is encrypted for %s: pass --identity or set %s", meta.ID, strings.Join(meta.Encryption.KeyIDs, ", "), identityEnv)
+	}
+	for _, name := range dbNames {
+		found := false
+		for _, f := range meta.Files {
+			if f.Database() == name {
+				found = true
+			}
+		}
+		if !found {
+			return nil, nil, fmt.Errorf("backup %s has no database %s", meta.ID, name)
+		}
+	}
+
+	var files []string
+	var releases []func()
+	release := func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+	for _, f := range meta.Files {
+		if len(dbNames) > 0 && !containsString(dbNames, f.Database()) {
+			continue
+		}
+		if f.Status != "success" {
+			fmt.Fprintf(out, "Warning: skipping %s (status %s: %s)\n", f.Name, f.Status, f.Error)
+			continue
+		}
+		key := path.Join(meta.Dir, f.Name)
+		local, releaseFile, err := storage.FileCopy(meta, f)
+		if err != nil {
+			release()
+			return nil, nil, fmt.Errorf("failed to read %s: %w", storage.Store.Location(key), err)
+		}
+		releases = append(releases, releaseFile)
+		if f.Checksum == "" {
+			fmt.Fprintf(out, "Warning: %s has no recorded checksum\n", f.Name)
+		} else {
+			sum, err := util.ComputeChecksum(local)
+			if err != nil {
+				release()
+				return nil, nil, fmt.Errorf("failed to read %s: %w", local, err)
+			}
+			if sum != f.Checksum {
+				release()
+				return nil, nil, fmt.Errorf("checksum mismatch for %s: the file is corrupt or was modified", storage.Store.Location(key))
+			}
+		}
+		files = append(files, local)
+	}
+	if len(files) == 0 {
+		release()
+		return nil, nil, fmt.Errorf("backup %s has no files that can be restored", meta.ID)
+	}
+	return files, release, nil
+}
+
+// restorePlan is a restore of local backup files and the databases it
+// creates or overwrites on the target.
+type restorePlan struct {
+	// backup names the backup in the result
+	backup string
+	target config.ServerConfig
+	eng    engine.Engine
+	files  []string
+	// wholeServer is set if the files replace every database on the target
+	wholeServer bool
+	databases   []restoreTarget
+}
+
+// planRestore works out which databases restoring files changes on target.
+func planRestore(out io.Writer, backup string, target config.ServerConfig, eng engine.Engine, files []string) (*restorePlan, error) {
+	p := &restorePlan{backup: backup, target: target, eng: eng, files: files, databases: []restoreTarget{}}
+	seen := make(map[string]bool)
+	for _, file := range files {
+		dbs, all, err := plannedDatabases(out, eng, target, file)
+		if err != nil {
+			return nil, err
+		}
+		p.wholeServer = p.wholeServer || all
+		for _, t := range dbs {
+			if !seen[t.Name] {
+				seen[t.Name] = true
+				p.databases = append(p.databases, t)
+			}
+		}
+	}
+	return p, nil
+}
+
+// fileNames returns the base names of the files of the plan.
+func (p *restorePlan) fileNames() []string {
+	names := make([]string, len(p.files))
+	for i, f := range p.files {
+		names[i] = filepath.Base(f)
+	}
+	return names
+}
+
+// restoreFiles shows what the plan changes on its target, asks for
+// confirmation, optionally takes a safety backup, and restores the files
+// in order, stopping at the first failure.
+func restoreFiles(out io.Writer, p *restorePlan, opts RestoreOptions) error {
+	target, eng, files := p.target, p.eng, p.files
+	defer util.LogScope(logrus.Fields{"backup_id": p.backup, "target": target.ID})()
+	res := RestoreResult{Backup: p.backup, Target: target.ID, WholeServer: p.wholeServer, Databases: p.databases}
+
+	names := p.fileNames()
+	fmt.Fprintf(out, "Restoring %s to %s (%s on %s)\n", strings.Join(names, ", "), target.ID, target.Engine, target.Host)
+	for _, line := range p.summary() {
+		fmt.Fprintln(out, line)
+	}
+
+	if !opts.Yes {
+		answer := readLine(out, fmt.Sprintf("Type the target ID (%s) to continue: ", target.ID))
+		if answer != target.ID {
+			return fmt.Errorf("restore cancelled")
+		}
+	}
+
+	if opts.SafetyBackup {
+		var dbNames []string
+		for _, t := range res.Databases {
+			if t.Exists {
+				dbNames = append(dbNames, t.Name)
+			}
+		}
+		if len(dbNames) == 0 && !res.WholeServer {
+			fmt.Fprintln(out, "No existing databases are affected, skipping the safety backup.")
+		} else {
+			if res.WholeServer {
+				dbNames = nil
+			}
+			note := fmt.Sprintf("before restoring %s", strings.Join(names, ", "))
+			dir, meta, err := backupServer(out, target, dbNames, "safety", note, nil)
+			if err != nil {
+				return fmt.Errorf("safety backup failed, restore aborted: %w", err)
+			}
+			if meta.Status != "success" {
+				return fmt.Errorf("safety backup %s finished with status %s, restore aborted", meta.ID, meta.Status)
+			}
+			res.SafetyBackup = meta.ID
+			fmt.Fprintf(out, "Safety backup %s stored in %s\n", meta.ID, storage.Store.Location(dir))
+		}
+	}
+
+	restored := 0
+	var restoreErr error
+	for _, file := range files {
+		f := RestoredFile{File: filepath.Base(file), Status: "skipped"}
+		if restoreErr == nil {
+			fmt.Fprintf(out, "Restoring %s to %s (%s)...\n", file, target.ID, target.Host)
+			db := storage.DatabaseFromFilename(file)
+			endScope := util.LogScope(logrus.Fields{"database": db})
+			log := util.Log()
+			var size int64
+			if info, err := os.Stat(file); err == nil {
+				size = info.Size()
+			}
+			progress := util.StartRestoreProgress(db, size)
+			restoreErr = eng.RestoreBackup(target, file, "")
+			progress.Done()
+			endScope()
+			if restoreErr != nil {
+				f.Status, f.Error = "failed", restoreErr.Error()
+				log.WithError(restoreErr).Error("restore failed")
+			} else {
+				f.Status = "success"
+				restored++
+				log.Info("database restored")
+			}
+		}
+		res.Files = append(res.Files, f)
+	}
+
+	switch {
+	case restoreErr == nil:
+		res.Status = "success"
+	case restored > 0:
+		res.Status = "partial"
+		restoreErr = fmt.Errorf("%d of %d files restored: %w: %w", restored, len(files), restoreErr, ErrPartial)
+	default:
+		res.Status = "failed"
+	}
+	util.Log().WithField("status", res.Status).Info("restore finished")
+	if err := render(out, res); err != nil {
+		return err
+	}
+	return restoreErr
+}
+
+// plannedDatabases works out which target databases a backup file touches,
+// and whether they exist there. If the backup covers the whole server, the
+// databases currently on the target are returned instead.
+func plannedDatabases(out io.Writer, eng engine.Engine, target config.ServerConfig, backupPath string) ([]restoreTarget, bool, error) {
+	var names []string
+	if inspector, ok := eng.(engine.BackupInspector); ok {
+		dbs, err := inspector.BackupDatabases(target, backupPath)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read backup: %w", err)
+		}
+		names = dbs
+	}
+	wholeServer := false
+	if len(names) == 0 {
+		// Fall back to the file name
+		base := storage.DatabaseFromFilename(backupPath)
+		if base == "all-databases" {
+			wholeServer = true
+		} else {
+			names = []string{base}
+		}
+	}
+
+	existing, err := eng.ListDatabases(target)
+	if err != nil {
+		fmt.Fprintf(out, "Warning: could not list databases on %s: %v\n", target.ID, err)
+	}
+	if wholeServer {
+		names = existing
+	}
+
+	lister, canList := eng.(engine.TableLister)
+	var plan []restoreTarget
+	for _, name := range names {
+		t := restoreTarget{Name: name, Tables: -1}
+		for _, e := range existing {
+			if e == name {
+				t.Exists = true
+			}
+		}
+		if t.Exists && canList {
+			if tables, err := lister.ListTables(target, name); err == nil {
+				t.Tables = len(tables)
+			}
+		}
+		plan = append(plan, t)
+	}
+	return plan, wholeServer, nil
+}
+
+// summary describes the databases the plan creates or overwrites.
+func (p *restorePlan) summary() []string {
+	var lines []string
+	if p.wholeServer {
+		lines = append(lines, "The backup covers the whole server; every database in it is overwritten.", "Databases currently on the target:")
+	} else {
+		lines = append(lines, "Databases affected on the target:")
+	}
+	if len(p.databases) == 0 {
+		return append(lines, "  (none)")
+	}
+	lines = append(lines, fmt.Sprintf("  % -30s | % -10s | % -8s", "DATABASE", "STATUS", "TABLES"))
+	for _, t := range p.databases {
+		status, tables := "new", "-"
+		if t.Exists {
+			status = "OVERWRITE"
+			if t.Tables >= 0 {
+				tables = fmt.Sprintf("%d", t.Tables)
+			} else {
+				tables = "?"
+			}
+		}
+		lines = append(lines, fmt.Sprintf("  % -30s | % -10s | % -8s", t.Name, status, tables))
+	}
+	return lines
+}
+
+func printRollback(out io.Writer, backupID string, targetID string) {
+	fmt.Fprintf(out, "  dbmigrate restore --backup-id %q --target %s\n", backupID, targetID)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,230 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/storage"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// ShowResult describes one backup and what its files hold.
+type ShowResult struct {
+	Location   string           `json:"location"`
+	DurationMS int64            `json:"duration_ms"`
+	Backup     storage.Metadata `json:"backup"`
+	Contents   []FileContents   `json:"contents,omitempty"`
+
+	// ddl prints the table definitions in the table view
+	ddl bool
+}
+
+// FileContents lists the tables (or collections) of a backup file, as
+// recorded in the metadata or, when From is "dump", found by reading the
+// file. Note explains why a file has no list.
+type FileContents struct {
+	File    string                `json:"file"`
+	From    string                `json:"from,omitempty"`
+	Objects []engine.BackupObject `json:"objects,omitempty"`
+	Note    string                `json:"note,omitempty"`
+	Error   string                `json:"error,omitempty"`
+}
+
+// ShowOptions selects what show reports besides the metadata.
+type ShowOptions struct {
+	// NoContents leaves out the tables of the files
+	NoContents bool
+	// Scan reads the dumps to list their tables instead of taking them from
+	// the metadata
+	Scan bool
+	// DDL prints the table definitions, which only the dumps hold, so it
+	// implies Scan
+	DDL bool
+}
+
+// RunShow describes one backup and lists the tables (or collections) of its
+// files, from the metadata recorded at backup time unless opts asks for the
+// dumps to be read.
+func RunShow(out io.Writer, backupID string, opts ShowOptions) error {
+	meta, err := storage.FindBackup(backupID)
+	if err != nil {
+		return err
+	}
+	res := ShowResult{
+		Location:   storage.Store.Location(meta.Dir),
+		DurationMS: meta.Duration().Milliseconds(),
+		Backup:     meta,
+		ddl:        opts.DDL,
+	}
+	switch {
+	case opts.NoContents:
+	case opts.Scan || opts.DDL:
+		res.Contents = inspectBackup(meta)
+	default:
+		res.Contents = recordedContents(meta)
+	}
+	return render(out, res)
+}
+
+// recordedContents lists the tables recorded for each successful file of
+// meta, falling back to its schema snapshot.
+func recordedContents(meta storage.Metadata) []FileContents {
+	var contents []FileContents
+	for _, f := range meta.Files {
+		c := FileContents{File: f.Name, From: "metadata"}
+		switch {
+		case f.Status != "success":
+			c.Note = "not inspected, the dump did not complete"
+		case len(f.Tables) > 0:
+			for _, t := range f.Tables {
+				c.Objects = append(c.Objects, engine.BackupObject{Name: t.Name})
+			}
+		case f.SchemaFile != "":
+			s, err := storage.LoadSchemaSnapshot(meta, f.Database())
+			if err != nil {
+				c.Error = err.Error()
+				break
+			}
+			for _, t := range s.Tables {
+				c.Objects = append(c.Objects, engine.BackupObject{Name: t.Name})
+			}
+		default:
+			c.Note = "no tables recorded; pass --scan to read the dump"
+		}
+		contents = append(contents, c)
+	}
+	return contents
+}
+
+// inspectBackup reads each successful file of meta to list its contents.
+func inspectBackup(meta storage.Metadata) []FileContents {
+	eng, err := engine.Get(meta.Engine)
+	if err != nil {
+		return []FileContents{{File: "*", Error: err.Error()}}
+	}
+	lister, ok := eng.(engine.ContentLister)
+
+	var contents []FileContents
+	for _, f := range meta.Files {
+		c := FileContents{File: f.Name, From: "dump"}
+		switch {
+		case f.Status != "success":
+			c.Note = "not inspected, the dump did not complete"
+		case !ok:
+			c.Note = fmt.Sprintf("%s backups cannot be inspected", meta.Engine)
+		default:
+			c.Objects, err = fileContents(lister, meta, f)
+			if errors.Is(err, util.ErrNoIdentity) {
+				c.Note = "encrypted; pass --identity to inspect it"
+			} else if err != nil {
+				c.Error = err.Error()
+			}
+		}
+		contents = append(contents, c)
+	}
+	return contents
+}
+
+// fileContents fetches a backup file and lists its tables.
+func fileContents(lister engine.ContentLister, meta storage.Metadata, f storage.BackupFile) ([]engine.BackupObject, error) {
+	local, release, err := storage.FileCopy(meta, f)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return lister.BackupContents(local)
+}
+
+func (r ShowResult) printTable(out io.Writer) {
+	b := r.Backup
+	fmt.Fprintf(out, "Backup:      %s\n", b.ID)
+	fmt.Fprintf(out, "Location:    %s\n", r.Location)
+	fmt.Fprintf(out, "Engine:      %s (%s:%d)\n", b.Engine, b.Host, b.Port)
+	if b.Source != "" {
+		fmt.Fprintf(out, "Source:      %s\n", b.Source)
+	}
+	fmt.Fprintf(out, "Status:      %s\n", b.Status)
+	if b.Kind != "" {
+		fmt.Fprintf(out, "Kind:        %s\n", b.Kind)
+	}
+	if b.Note != "" {
+		fmt.Fprintf(out, "Note:        %s\n", b.Note)
+	}
+	if len(b.Tags) > 0 {
+		fmt.Fprintf(out, "Tags:        %s\n", strings.Join(b.Tags, ", "))
+	}
+	fmt.Fprintf(out, "Started:     %s\n", b.StartedAt)
+	if b.FinishedAt != "" {
+		fmt.Fprintf(out, "Finished:    %s\n", b.FinishedAt)
+	}
+	if r.DurationMS > 0 || b.FinishedAt != "" {
+		fmt.Fprintf(out, "Duration:    %s\n", time.Duration(r.DurationMS)*time.Millisecond)
+	}
+	fmt.Fprintf(out, "Size:        %s\n", backupSize(b))
+	if b.Encryption != nil {
+		fmt.Fprintf(out, "Encryption:  %s (%s)\n", b.Encryption.Format, strings.Join(b.Encryption.KeyIDs, ", "))
+	}
+	if p := b.Provenance; p != nil {
+		fmt.Fprintf(out, "Taken by:    dbmigrate %s on %s, %s mode\n", p.ToolVersion, p.Hostname, p.Mode)
+		if len(p.CommandLine) > 0 {
+			fmt.Fprintf(out, "Command:     %s\n", strings.Join(p.CommandLine, " "))
+		}
+		if len(p.Options) > 0 {
+			var opts []string
+			for k, v := range p.Options {
+				opts = append(opts, k+"="+v)
+			}
+			sort.Strings(opts)
+			fmt.Fprintf(out, "Options:     %s\n", strings.Join(opts, ", "))
+		}
+	}
+	if d := b.LastDrill; d != nil {
+		fmt.Fprintf(out, "Last drill:  %s on %s at %s\n", d.Status, d.Target, d.Time)
+	}
+
+	fmt.Fprintf(out, "\n% -45s | % -10s | % -8s | % -12s | % -10s | %s\n", "FILE", "SIZE", "CODEC", "CHECKSUM", "DURATION", "STATUS")
+	fmt.Fprintln(out, strings.Repeat("-", 110))
+	for _, f := range b.Files {
+		codec := f.Compression
+		if codec == "" {
+			codec = "gzip"
+		}
+		duration := "-"
+		if f.DurationMS > 0 {
+			duration = (time.Duration(f.DurationMS) * time.Millisecond).String()
+		}
+		fmt.Fprintf(out, "% -45s | % -10s | % -8s | % -12s | % -10s | %s\n", f.Name, formatSize(f.Size), codec, shortHash(f.Checksum), duration, f.Status)
+		if f.Error != "" {
+			fmt.Fprintf(out, "    error: %s\n", f.Error)
+		}
+	}
+
+	for _, c := range r.Contents {
+		fmt.Fprintf(out, "\n%s:\n", c.File)
+		switch {
+		case c.Error != "":
+			fmt.Fprintf(out, "    error: %s\n", c.Error)
+		case c.Note != "":
+			fmt.Fprintf(out, "    %s\n", c.Note)
+		case len(c.Objects) == 0:
+			fmt.Fprintln(out, "    no tables found")
+		}
+		// Whole-server dumps hold several databases
+		db := storage.DatabaseFromFilename(c.File)
+		for _, o := range c.Objects {
+			if o.Database != "" && o.Database != db {
+				db = o.Database
+				fmt.Fprintf(out, "  database %s:\n", db)
+			}
+			fmt.Fprintf(out, "    %s\n", o.Name)
+			if r.ddl && o.Definition != "" {
+				fmt.Fprintf(out, "        %s\n", strings.ReplaceAll(o.Definition, "\n", "\n        "))
+			}
+		}
+	}
+}
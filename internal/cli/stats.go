@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/schema"
+	"mydbportal.com/dbmigrate/internal/storage"
+)
+
+// Row counting modes, set per server with the "row_counts" option
+const (
+	rowCountsExact    = "exact"
+	rowCountsEstimate = "estimate"
+	rowCountsNone     = "none"
+)
+
+// tableStats describes the tables of dbName for the catalog: their row
+// counts and the fingerprints of their definitions, as far as the engine can
+// provide them. It also returns the inspected schema. If only the schema
+// cannot be read, the row counts are returned with the error.
+func tableStats(eng engine.Engine, server config.ServerConfig, dbName string) ([]storage.TableStats, *schema.Schema, error) {
+	var counts map[string]int64
+	estimated := false
+	var err error
+	switch mode := strings.ToLower(server.Options["row_counts"]); mode {
+	case "", rowCountsExact:
+		if counter, ok := eng.(engine.RowCounter); ok {
+			counts, err = counter.CountRows(server, dbName)
+		}
+	case rowCountsEstimate:
+		if estimator, ok := eng.(engine.RowEstimator); ok {
+			counts, err = estimator.EstimateRows(server, dbName)
+			estimated = true
+		} else if counter, ok := eng.(engine.RowCounter); ok {
+			counts, err = counter.CountRows(server, dbName)
+		}
+	case rowCountsNone:
+	default:
+		return nil, nil, fmt.Errorf("unknown row_counts option %q (want %s, %s or %s)", mode, rowCountsExact, rowCountsEstimate, rowCountsNone)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not count rows: %w", err)
+	}
+
+	// Without a schema the counts are still worth keeping
+	var s *schema.Schema
+	var schemaErr error
+	if inspector, ok := eng.(engine.SchemaInspector); ok {
+		if s, err = inspector.InspectSchema(server, dbName); err != nil {
+			schemaErr = fmt.Errorf("could not read schema: %w", err)
+		}
+	}
+	if counts == nil && s == nil {
+		return nil, nil, schemaErr
+	}
+
+	names := make(map[string]bool)
+	for name := range counts {
+		names[name] = true
+	}
+	if s != nil {
+		for _, t := range s.Tables {
+			names[t.Name] = true
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	stats := make([]storage.TableStats, len(sorted))
+	for i, name := range sorted {
+		stats[i].Name = name
+		if n, ok := counts[name]; ok {
+			stats[i].Rows = &n
+			stats[i].Estimated = estimated
+		}
+		if s != nil {
+			if t := s.Table(name); t != nil {
+				stats[i].DDLHash = t.Fingerprint()
+			}
+		}
+	}
+	return stats, s, schemaErr
+}
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"mydbportal.com/dbmigrate/internal/storage"
+)
+
+// RunVerify checks the integrity of one backup, or of all backups, and
+// optionally writes the results as JSON to reportPath. It returns an error
+// if any check failed.
+func RunVerify(out io.Writer, backupID string, all bool, reportPath string) error {
+	var results []storage.VerifyResult
+	if all {
+		var err error
+		results, err = storage.VerifyAll()
+		if err != nil {
+			return err
+		}
+	} else {
+		meta, err := storage.FindBackup(backupID)
+		if err != nil {
+			return err
+		}
+		results = append(results, storage.VerifyBackup(meta))
+	}
+
+	report := VerifyReport{Backups: results}
+	for _, r := range results {
+		if !r.OK {
+			report.Failed++
+		}
+	}
+	if err := render(out, report); err != nil {
+		return err
+	}
+	if reportPath != "" {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(reportPath, data, 0644); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+		fmt.Fprintf(out, "Report written to %s\n", reportPath)
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d backups failed verification", report.Failed, len(results))
+	}
+	return nil
+}
+
+// VerifyReport is the outcome of verify.
+type VerifyReport struct {
+	Backups []storage.VerifyResult `json:"backups"`
+	Failed  int                    `json:"failed"`
+}
+
+func (r VerifyReport) printTable(out io.Writer) {
+	for _, res := range r.Backups {
+		id := res.BackupID
+		if id == "" {
+			id = res.Dir
+		}
+		if res.OK {
+			unchecked := 0
+			for _, f := range res.Files {
+				if f.Note != "" {
+					unchecked++
+				}
+			}
+			if unchecked > 0 {
+				fmt.Fprintf(out, "[OK] %s (%d encrypted files not decrypted; pass --identity to check their contents)\n", id, unchecked)
+			} else {
+				fmt.Fprintf(out, "[OK] %s\n", id)
+			}
+			continue
+		}
+		fmt.Fprintf(out, "[FAILED] %s\n", id)
+		if res.Error != "" {
+			fmt.Fprintf(out, "  %s\n", res.Error)
+		}
+		for _, f := range res.Files {
+			switch f.Status {
+			case storage.CheckOK, storage.CheckSkipped:
+				continue
+			case storage.CheckMismatch:
+				fmt.Fprintf(out, "  %s: checksum mismatch (expected %s, got %s)\n", f.Name, f.Expected, f.Actual)
+				if f.Error != "" {
+					fmt.Fprintf(out, "  %s: %s\n", f.Name, f.Error)
+				}
+			case storage.CheckCorrupt:
+				fmt.Fprintf(out, "  %s: %s\n", f.Name, f.Error)
+			case storage.CheckMissing:
+				if f.Error != "" {
+					fmt.Fprintf(out, "  %s: missing (%s)\n", f.Name, f.Error)
+				} else {
+					fmt.Fprintf(out, "  %s: missing\n", f.Name)
+				}
+			default:
+				fmt.Fprintf(out, "  %s: %s\n", f.Name, f.Status)
+			}
+		}
+	}
+	if r.Failed == 0 {
+		fmt.Fprintf(out, "%d backups verified\n", len(r.Backups))
+	}
+}
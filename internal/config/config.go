@@ -22,11 +22,24 @@ type ServerConfig struct {
 	Port     int    `json:"port"`
 	User     string `json:"user"`
 	Password string `json:"password"` // Encrypted
+	// Options holds engine specific settings (e.g. "format": "sql" for sqlite)
+	Options map[string]string `json:"options,omitempty"`
+	// Storage names the storage profile for backups of this source
+	Storage string `json:"storage,omitempty"`
+	// Retention overrides the retention of the storage profile
+	Retention *Retention `json:"retention,omitempty"`
 }
 
 type Config struct {
 	Sources []ServerConfig `json:"sources"`
 	Targets []ServerConfig `json:"targets"`
+	// Storage is the location of the default storage when no profiles are
+	// configured: a directory or a URL such as s3://bucket/prefix
+	Storage string `json:"storage,omitempty"`
+	// Storages are the named storage profiles
+	Storages []StorageProfile `json:"storages,omitempty"`
+	// DefaultStorage names the profile used when none is chosen
+	DefaultStorage string `json:"default_storage,omitempty"`
 }
 
 type Manager struct {
@@ -93,21 +106,59 @@ func (m *Manager) AddSource(s ServerConfig) error {
 	return m.Save()
 }
 
+func (m *Manager) AddTarget(t ServerConfig) error {
+	encryptedPass, err := util.Encrypt(t.Password, encryptionKey)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt password: %w", err)
+	}
+	t.Password = encryptedPass
+
+	m.Config.Targets = append(m.Config.Targets, t)
+	return m.Save()
+}
+
 func (m *Manager) GetSource(id string) (ServerConfig, error) {
 	for _, s := range m.Config.Sources {
 		if s.ID == id {
-			// Decrypt password before returning
-			decryptedPass, err := util.Decrypt(s.Password, encryptionKey)
-			if err != nil {
-				return s, fmt.Errorf("failed to decrypt password: %w", err)
-			}
-			s.Password = decryptedPass
-			return s, nil
+			return decrypted(s)
 		}
 	}
 	return ServerConfig{}, fmt.Errorf("source not found: %s", id)
 }
 
+// GetTarget looks up a target server. Sources are also accepted as targets,
+// so configs without a "targets" section keep working.
+func (m *Manager) GetTarget(id string) (ServerConfig, error) {
+	for _, t := range m.Config.Targets {
+		if t.ID == id {
+			return decrypted(t)
+		}
+	}
+	for _, s := range m.Config.Sources {
+		if s.ID == id {
+			return decrypted(s)
+		}
+	}
+	return ServerConfig{}, fmt.Errorf("target not found: %s", id)
+}
+
+func decrypted(s ServerConfig) (ServerConfig, error) {
+	// Decrypt password before returning
+	decryptedPass, err := util.Decrypt(s.Password, encryptionKey)
+	if err != nil {
+		return s, fmt.Errorf("failed to decrypt password: %w", err)
+	}
+	s.Password = decryptedPass
+	return s, nil
+}
+
 func (m *Manager) ListSources() []ServerConfig {
 	return m.Config.Sources
 }
+
+// ListTargets returns the configured targets followed by the sources,
+// matching the lookup order of GetTarget.
+func (m *Manager) ListTargets() []ServerConfig {
+	targets := append([]ServerConfig{}, m.Config.Targets...)
+	return append(targets, m.Config.Sources...)
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// defaultBackupDir is where backups are kept when no storage is configured,
+// relative to the home directory
+const defaultBackupDir = ".dbmigrate/backups"
+
+// StorageProfile is a named place to keep backups, with the settings for
+// the backups stored there.
+type StorageProfile struct {
+	Name string `json:"name"`
+	// Location is a directory or a storage URL such as s3://bucket/prefix.
+	// Relative directories are taken from the home directory.
+	Location string `json:"location"`
+	// Compression of the dump files: gzip (the default), zstd, lz4 or none
+	Compression string `json:"compression,omitempty"`
+	// CompressionLevel is passed to the codec; 0 uses its default
+	CompressionLevel int `json:"compression_level,omitempty"`
+	// Dedup splits dumps into chunks stored once by content
+	Dedup bool `json:"dedup,omitempty"`
+	// Encryption of the dump files at rest: "age", or empty for none
+	Encryption string `json:"encryption,omitempty"`
+	// Recipients are the public keys backups are encrypted for: age keys
+	// (age1...), SSH public keys, or files listing them
+	Recipients []string `json:"recipients,omitempty"`
+	// Retention decides which backups are pruned
+	Retention *Retention `json:"retention,omitempty"`
+}
+
+// Retention describes which backups of a source to keep.
+type Retention struct {
+	KeepLast    int `json:"keep_last,omitempty"`
+	KeepDaily   int `json:"keep_daily,omitempty"`
+	KeepWeekly  int `json:"keep_weekly,omitempty"`
+	KeepMonthly int `json:"keep_monthly,omitempty"`
+	KeepYearly  int `json:"keep_yearly,omitempty"`
+	// MaxAge is a duration such as "90d", "12w" or "36h"
+	MaxAge string `json:"max_age,omitempty"`
+	// MaxSize is the total size of a source's backups, such as "500GB"
+	MaxSize string `json:"max_size,omitempty"`
+}
+
+// Validate rejects settings the profile cannot be used with.
+func (p StorageProfile) Validate() error {
+	if p.Location == "" {
+		return fmt.Errorf("storage profile %s has no location", p.Name)
+	}
+	if _, err := p.Codec(); err != nil {
+		return fmt.Errorf("storage profile %s: %w", p.Name, err)
+	}
+	switch strings.ToLower(p.Encryption) {
+	case "":
+		if len(p.Recipients) > 0 {
+			return fmt.Errorf("storage profile %s has recipients but no encryption", p.Name)
+		}
+	case "age":
+		if len(p.Recipients) == 0 {
+			return fmt.Errorf("storage profile %s: age encryption needs recipients", p.Name)
+		}
+	default:
+		return fmt.Errorf("storage profile %s: unsupported encryption %q", p.Name, p.Encryption)
+	}
+	return nil
+}
+
+// Codec returns the compression codec of the profile, checking its level.
+func (p StorageProfile) Codec() (util.Codec, error) {
+	name := p.Compression
+	if name == "" {
+		name = "gzip"
+	}
+	codec, err := util.GetCodec(name)
+	if err != nil {
+		return nil, err
+	}
+	if min, max := codec.Levels(); p.CompressionLevel != 0 && (p.CompressionLevel < min || p.CompressionLevel > max) {
+		if max == 0 {
+			return nil, fmt.Errorf("%s compression takes no level", codec.Name())
+		}
+		return nil, fmt.Errorf("%s compression level must be between %d and %d", codec.Name(), min, max)
+	}
+	return codec, nil
+}
+
+// StorageProfile returns the profile called name, or the default profile
+// when name is empty: the one named by default_storage, else the "storage"
+// location, else a profile called "default", else ~/.dbmigrate/backups. A
+// name that is not a profile but a directory or URL is used as a location.
+func (m *Manager) StorageProfile(name string) (StorageProfile, error) {
+	if name == "" {
+		name = m.Config.DefaultStorage
+	}
+	if name == "" && m.Config.Storage != "" {
+		return m.resolve(StorageProfile{Name: "default", Location: m.Config.Storage}), nil
+	}
+	if name == "" {
+		name = "default"
+	}
+	for _, p := range m.Config.Storages {
+		if p.Name == name {
+			return m.resolve(p), nil
+		}
+	}
+	switch {
+	case name == "default":
+		return m.resolve(StorageProfile{Name: name, Location: defaultBackupDir}), nil
+	case strings.ContainsAny(name, "/\\"):
+		return m.resolve(StorageProfile{Name: name, Location: name}), nil
+	}
+	return StorageProfile{}, fmt.Errorf("storage profile not found: %s", name)
+}
+
+// StorageArg returns the profile a --storage argument names. Unlike the
+// locations in the config file, a relative directory is taken from the
+// working directory.
+func (m *Manager) StorageArg(name string) (StorageProfile, error) {
+	for _, p := range m.Config.Storages {
+		if p.Name == name {
+			return m.resolve(p), nil
+		}
+	}
+	if strings.ContainsAny(name, "/\\") && !strings.Contains(name, "://") && !filepath.IsAbs(name) {
+		abs, err := filepath.Abs(name)
+		if err != nil {
+			return StorageProfile{}, err
+		}
+		return StorageProfile{Name: name, Location: abs}, nil
+	}
+	return m.StorageProfile(name)
+}
+
+// catalogDir holds the local backup catalogs, relative to the home directory
+const catalogDir = ".dbmigrate/catalog"
+
+// CatalogDir returns the directory of the local backup catalogs.
+func (m *Manager) CatalogDir() string {
+	return filepath.Join(filepath.Dir(m.configPath), catalogDir)
+}
+
+// resolve makes a relative directory location of the config file absolute,
+// taking it from the directory of the config file.
+func (m *Manager) resolve(p StorageProfile) StorageProfile {
+	if !strings.Contains(p.Location, "://") && !filepath.IsAbs(p.Location) {
+		p.Location = filepath.Join(filepath.Dir(m.configPath), strings.TrimPrefix(p.Location, "~/"))
+	}
+	return p
+}
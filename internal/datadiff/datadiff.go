@@ -0,0 +1,357 @@
+// Package datadiff compares the rows of two databases of the same engine
+// through checksums of primary key ranges, so that only the keys of rows that
+// differ have to leave the servers.
+package datadiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/schema"
+)
+
+const (
+	defaultChunkSize = 1000
+	defaultMaxRows   = 1000
+	// leafRows is the range size below which rows are hashed one by one
+	leafRows = 100
+)
+
+// Options controls a data comparison.
+type Options struct {
+	// Tables limits the comparison to these tables (all if empty)
+	Tables []string
+	// ChunkSize is the number of rows per checksummed range
+	ChunkSize int64
+	// Pause is slept before every query, to limit the load on both servers
+	Pause time.Duration
+	// MaxRows caps the differing rows reported per table
+	MaxRows int
+}
+
+// TableResult is the outcome of comparing one table. Keys are the primary
+// key values of the rows, as text.
+type TableResult struct {
+	Name             string     `json:"name"`
+	LeftRows         int64      `json:"left_rows"`
+	RightRows        int64      `json:"right_rows"`
+	Chunks           int        `json:"chunks"`
+	MismatchedChunks int        `json:"mismatched_chunks"`
+	Missing          [][]string `json:"missing,omitempty"` // only on the left
+	Extra            [][]string `json:"extra,omitempty"`   // only on the right
+	Changed          [][]string `json:"changed,omitempty"`
+	// Truncated is set when more than MaxRows rows differ
+	Truncated bool   `json:"truncated,omitempty"`
+	Skipped   string `json:"skipped,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Differences returns the number of differing rows found.
+func (t TableResult) Differences() int {
+	return len(t.Missing) + len(t.Extra) + len(t.Changed)
+}
+
+// Report summarizes a data comparison.
+type Report struct {
+	Left   string        `json:"left"`
+	Right  string        `json:"right"`
+	Tables []TableResult `json:"tables"`
+}
+
+// Differences returns the number of differing rows over all tables, and
+// whether the comparison was incomplete.
+func (r *Report) Differences() (int, bool) {
+	n, incomplete := 0, false
+	for _, t := range r.Tables {
+		n += t.Differences()
+		if t.Truncated || t.Error != "" {
+			incomplete = true
+		}
+	}
+	return n, incomplete
+}
+
+// WriteJSON saves the report to path.
+func (r *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// side is one of the compared databases.
+type side struct {
+	creds  config.ServerConfig
+	db     string
+	hasher engine.RangeHasher
+}
+
+// comparer walks the ranges of one table on both sides.
+type comparer struct {
+	left, right side
+	opts        Options
+	result      *TableResult
+}
+
+// Run compares the tables of leftDB on left with those of rightDB on right.
+// Both servers must use the same engine, which must support range checksums.
+// Tables are matched by name and keyed by the primary key of the left side;
+// only the columns present on both sides are compared. The returned report
+// lists the differing rows; an error is returned with it if any table could
+// not be compared.
+func Run(left, right config.ServerConfig, leftDB, rightDB string, opts Options) (*Report, error) {
+	if left.Engine != right.Engine {
+		return nil, fmt.Errorf("cannot compare data across engines (%s and %s)", left.Engine, right.Engine)
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultChunkSize
+	}
+	if opts.MaxRows <= 0 {
+		opts.MaxRows = defaultMaxRows
+	}
+	eng, err := engine.Get(left.Engine)
+	if err != nil {
+		return nil, err
+	}
+	hasher, ok1 := eng.(engine.RangeHasher)
+	inspector, ok2 := eng.(engine.SchemaInspector)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("engine %s does not support data comparison", left.Engine)
+	}
+
+	ls, err := inspector.InspectSchema(left, leftDB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read left schema: %w", err)
+	}
+	rs, err := inspector.InspectSchema(right, rightDB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read right schema: %w", err)
+	}
+
+	report := &Report{Left: left.ID + ":" + leftDB, Right: right.ID + ":" + rightDB}
+	wanted := make(map[string]bool)
+	for _, t := range opts.Tables {
+		wanted[t] = true
+	}
+	failed := 0
+	for i := range ls.Tables {
+		lt := &ls.Tables[i]
+		if len(wanted) > 0 && !wanted[lt.Name] {
+			continue
+		}
+		result := TableResult{Name: lt.Name}
+		r, skip := keyRange(lt, rs.Table(lt.Name))
+		if skip != "" {
+			result.Skipped = skip
+		} else {
+			c := &comparer{
+				left:   side{creds: left, db: leftDB, hasher: hasher},
+				right:  side{creds: right, db: rightDB, hasher: hasher},
+				opts:   opts,
+				result: &result,
+			}
+			if err := c.compareTable(r); err != nil {
+				result.Error = err.Error()
+				failed++
+			}
+		}
+		report.Tables = append(report.Tables, result)
+	}
+	for _, name := range opts.Tables {
+		if ls.Table(name) == nil {
+			report.Tables = append(report.Tables, TableResult{Name: name, Error: "table not found on the left"})
+			failed++
+		}
+	}
+	if failed > 0 {
+		return report, fmt.Errorf("%d tables could not be compared", failed)
+	}
+	return report, nil
+}
+
+// keyRange builds the full range of a table, or explains why it cannot be
+// compared by key.
+func keyRange(lt, rt *schema.Table) (engine.KeyRange, string) {
+	r := engine.KeyRange{Table: lt.Name, Key: lt.PrimaryKey}
+	switch {
+	case rt == nil:
+		return r, "table missing on the right"
+	case len(lt.PrimaryKey) == 0:
+		return r, "no primary key"
+	case strings.Join(lt.PrimaryKey, ",") != strings.Join(rt.PrimaryKey, ","):
+		return r, "primary keys differ"
+	}
+	for _, name := range lt.PrimaryKey {
+		var lType, rType string
+		if c := lt.Column(name); c != nil {
+			lType = c.DataType
+		}
+		if c := rt.Column(name); c != nil {
+			rType = c.DataType
+		}
+		if lType != rType {
+			return r, "primary key types differ"
+		}
+		r.KeyTypes = append(r.KeyTypes, lType)
+	}
+	// Document collections have no columns and are hashed whole
+	for _, c := range lt.Columns {
+		if rt.Column(c.Name) != nil {
+			r.Columns = append(r.Columns, c.Name)
+		}
+	}
+	if len(lt.Columns) > 0 && len(r.Columns) == 0 {
+		return r, "no common columns"
+	}
+	return r, ""
+}
+
+// compareTable checksums the table in chunks of ChunkSize rows, split at
+// the keys of the left side, and bisects the chunks that differ. The last
+// chunk is open ended, so rows beyond the last left key are covered too.
+func (c *comparer) compareTable(full engine.KeyRange) error {
+	var after []string
+	for {
+		r := full
+		r.After = after
+		c.pause()
+		upto, err := c.left.hasher.KeyAt(c.left.creds, c.left.db, r, c.opts.ChunkSize-1)
+		if err != nil {
+			return fmt.Errorf("failed to find chunk bound: %w", err)
+		}
+		r.Upto = upto
+
+		ln, lh, rn, rh, err := c.hashBoth(r)
+		if err != nil {
+			return err
+		}
+		c.result.Chunks++
+		c.result.LeftRows += ln
+		c.result.RightRows += rn
+		if ln != rn || lh != rh {
+			c.result.MismatchedChunks++
+			if err := c.bisect(r, ln, rn); err != nil {
+				return err
+			}
+		}
+		if upto == nil {
+			return nil
+		}
+		after = upto
+	}
+}
+
+// bisect narrows a differing range down to single rows by splitting it at
+// the middle key of the side with more rows.
+func (c *comparer) bisect(r engine.KeyRange, ln, rn int64) error {
+	if c.result.Truncated {
+		return nil
+	}
+	larger, n := c.left, ln
+	if rn > ln {
+		larger, n = c.right, rn
+	}
+	if n <= leafRows {
+		return c.compareRows(r)
+	}
+
+	c.pause()
+	mid, err := larger.hasher.KeyAt(larger.creds, larger.db, r, n/2-1)
+	if err != nil {
+		return fmt.Errorf("failed to split range: %w", err)
+	}
+	if mid == nil {
+		return c.compareRows(r)
+	}
+	lower, upper := r, r
+	lower.Upto, upper.After = mid, mid
+	for _, half := range []engine.KeyRange{lower, upper} {
+		ln, lh, rn, rh, err := c.hashBoth(half)
+		if err != nil {
+			return err
+		}
+		if ln != rn || lh != rh {
+			if err := c.bisect(half, ln, rn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compareRows hashes every row of r on both sides and records the keys of
+// the rows that differ.
+func (c *comparer) compareRows(r engine.KeyRange) error {
+	c.pause()
+	leftRows, err := c.left.hasher.HashRows(c.left.creds, c.left.db, r)
+	if err != nil {
+		return fmt.Errorf("failed to hash left rows: %w", err)
+	}
+	c.pause()
+	rightRows, err := c.right.hasher.HashRows(c.right.creds, c.right.db, r)
+	if err != nil {
+		return fmt.Errorf("failed to hash right rows: %w", err)
+	}
+
+	rightHashes := make(map[string]string, len(rightRows))
+	for _, row := range rightRows {
+		rightHashes[keyString(row.Key)] = row.Hash
+	}
+	for _, row := range leftRows {
+		k := keyString(row.Key)
+		h, ok := rightHashes[k]
+		switch {
+		case !ok:
+			c.record(&c.result.Missing, row.Key)
+		case h != row.Hash:
+			c.record(&c.result.Changed, row.Key)
+		}
+		delete(rightHashes, k)
+	}
+	for _, row := range rightRows {
+		if _, ok := rightHashes[keyString(row.Key)]; ok {
+			c.record(&c.result.Extra, row.Key)
+		}
+	}
+	return nil
+}
+
+func (c *comparer) record(list *[][]string, key []string) {
+	if c.result.Differences() >= c.opts.MaxRows {
+		c.result.Truncated = true
+		return
+	}
+	*list = append(*list, key)
+}
+
+// hashBoth checksums r on the left and on the right.
+func (c *comparer) hashBoth(r engine.KeyRange) (int64, string, int64, string, error) {
+	c.pause()
+	ln, lh, err := c.left.hasher.HashRange(c.left.creds, c.left.db, r)
+	if err != nil {
+		return 0, "", 0, "", fmt.Errorf("failed to checksum left range: %w", err)
+	}
+	c.pause()
+	rn, rh, err := c.right.hasher.HashRange(c.right.creds, c.right.db, r)
+	if err != nil {
+		return 0, "", 0, "", fmt.Errorf("failed to checksum right range: %w", err)
+	}
+	return ln, lh, rn, rh, nil
+}
+
+func (c *comparer) pause() {
+	if c.opts.Pause > 0 {
+		time.Sleep(c.opts.Pause)
+	}
+}
+
+// keyString joins key values with a separator that does not occur in text.
+func keyString(key []string) string {
+	return strings.Join(key, "\x00")
+}
@@ -0,0 +1,240 @@
+package datadiff
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/schema"
+)
+
+// fakeHasher holds the rows of one table in memory, sorted by key.
+type fakeHasher struct {
+	rows []engine.RowHash
+	// largest is the most rows returned by one HashRows call
+	largest int
+}
+
+// newFake returns a table with the keys from to upto - 1, each hashed to
+// "h".
+func newFake(from, upto int) *fakeHasher {
+	f := &fakeHasher{}
+	for i := from; i < upto; i++ {
+		f.rows = append(f.rows, engine.RowHash{Key: key(i), Hash: "h"})
+	}
+	return f
+}
+
+func key(i int) []string {
+	return []string{fmt.Sprintf("%05d", i)}
+}
+
+// set adds row i or changes its hash.
+func (f *fakeHasher) set(i int, hash string) {
+	k := key(i)
+	for j := range f.rows {
+		if reflect.DeepEqual(f.rows[j].Key, k) {
+			f.rows[j].Hash = hash
+			return
+		}
+	}
+	f.rows = append(f.rows, engine.RowHash{Key: k, Hash: hash})
+	sort.Slice(f.rows, func(a, b int) bool { return keyString(f.rows[a].Key) < keyString(f.rows[b].Key) })
+}
+
+func (f *fakeHasher) remove(i int) {
+	k := keyString(key(i))
+	for j := range f.rows {
+		if keyString(f.rows[j].Key) == k {
+			f.rows = append(f.rows[:j], f.rows[j+1:]...)
+			return
+		}
+	}
+}
+
+func (f *fakeHasher) inRange(r engine.KeyRange) []engine.RowHash {
+	var rows []engine.RowHash
+	for _, row := range f.rows {
+		k := keyString(row.Key)
+		if len(r.After) > 0 && k <= keyString(r.After) {
+			continue
+		}
+		if len(r.Upto) > 0 && k > keyString(r.Upto) {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func (f *fakeHasher) HashRange(creds config.ServerConfig, dbName string, r engine.KeyRange) (int64, string, error) {
+	rows := f.inRange(r)
+	var sum strings.Builder
+	for _, row := range rows {
+		sum.WriteString(keyString(row.Key) + "=" + row.Hash + ";")
+	}
+	return int64(len(rows)), sum.String(), nil
+}
+
+func (f *fakeHasher) KeyAt(creds config.ServerConfig, dbName string, r engine.KeyRange, n int64) ([]string, error) {
+	rows := f.inRange(r)
+	if n >= int64(len(rows)) {
+		return nil, nil
+	}
+	return rows[n].Key, nil
+}
+
+func (f *fakeHasher) HashRows(creds config.ServerConfig, dbName string, r engine.KeyRange) ([]engine.RowHash, error) {
+	rows := f.inRange(r)
+	f.largest = max(f.largest, len(rows))
+	return rows, nil
+}
+
+func keys(ids ...int) [][]string {
+	var out [][]string
+	for _, i := range ids {
+		out = append(out, key(i))
+	}
+	return out
+}
+
+func TestCompareTable(t *testing.T) {
+	tests := []struct {
+		name        string
+		left, right *fakeHasher
+		chunkSize   int64
+		maxRows     int
+		want        TableResult
+	}{
+		{
+			name: "equal", left: newFake(0, 250), right: newFake(0, 250), chunkSize: 100,
+			want: TableResult{LeftRows: 250, RightRows: 250, Chunks: 3},
+		},
+		{
+			name: "missing, extra and changed",
+			left: func() *fakeHasher { f := newFake(0, 250); f.set(42, "changed"); f.remove(7); return f }(),
+			right: func() *fakeHasher {
+				f := newFake(0, 250)
+				f.remove(120)
+				f.set(1000, "h")
+				return f
+			}(),
+			chunkSize: 100,
+			want: TableResult{LeftRows: 249, RightRows: 250, Chunks: 3, MismatchedChunks: 3,
+				Missing: keys(120), Extra: keys(7, 1000), Changed: keys(42)},
+		},
+		{
+			name: "rows beyond the last left key", left: newFake(0, 150), right: newFake(0, 155), chunkSize: 100,
+			want: TableResult{LeftRows: 150, RightRows: 155, Chunks: 2, MismatchedChunks: 1, Extra: keys(150, 151, 152, 153, 154)},
+		},
+		{
+			name: "empty left", left: newFake(0, 0), right: newFake(0, 3), chunkSize: 100,
+			want: TableResult{LeftRows: 0, RightRows: 3, Chunks: 1, MismatchedChunks: 1, Extra: keys(0, 1, 2)},
+		},
+		{
+			name: "exact chunks", left: newFake(0, 200), right: newFake(0, 201), chunkSize: 100,
+			want: TableResult{LeftRows: 200, RightRows: 201, Chunks: 3, MismatchedChunks: 1, Extra: keys(200)},
+		},
+		{
+			name: "truncated", left: newFake(0, 50),
+			right: func() *fakeHasher {
+				f := newFake(0, 50)
+				for i := 0; i < 50; i += 2 {
+					f.set(i, "changed")
+				}
+				return f
+			}(),
+			chunkSize: 100, maxRows: 10,
+			want: TableResult{LeftRows: 50, RightRows: 50, Chunks: 1, MismatchedChunks: 1,
+				Changed: keys(0, 2, 4, 6, 8, 10, 12, 14, 16, 18), Truncated: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxRows := tt.maxRows
+			if maxRows == 0 {
+				maxRows = defaultMaxRows
+			}
+			result := TableResult{Name: "t"}
+			c := &comparer{
+				left:   side{hasher: tt.left},
+				right:  side{hasher: tt.right},
+				opts:   Options{ChunkSize: tt.chunkSize, MaxRows: maxRows},
+				result: &result,
+			}
+			if err := c.compareTable(engine.KeyRange{Table: "t", Key: []string{"id"}}); err != nil {
+				t.Fatal(err)
+			}
+			tt.want.Name = "t"
+			if !reflect.DeepEqual(result, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", result, tt.want)
+			}
+		})
+	}
+}
+
+func TestBisect(t *testing.T) {
+	left, right := newFake(0, 5000), newFake(0, 5000)
+	right.set(1234, "changed")
+	right.remove(4321)
+	result := TableResult{Name: "t"}
+	c := &comparer{
+		left:   side{hasher: left},
+		right:  side{hasher: right},
+		opts:   Options{ChunkSize: 5000, MaxRows: defaultMaxRows},
+		result: &result,
+	}
+	if err := c.compareTable(engine.KeyRange{Table: "t", Key: []string{"id"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Changed, keys(1234)) || !reflect.DeepEqual(result.Missing, keys(4321)) || result.Extra != nil {
+		t.Errorf("got %+v", result)
+	}
+	// Only narrowed ranges are hashed row by row
+	if left.largest > leafRows || right.largest > leafRows {
+		t.Errorf("hashed %d and %d rows at once, want at most %d", left.largest, right.largest, leafRows)
+	}
+}
+
+func TestKeyRange(t *testing.T) {
+	table := func(pk []string, columns ...schema.Column) *schema.Table {
+		return &schema.Table{Name: "t", PrimaryKey: pk, Columns: columns}
+	}
+	id := schema.Column{Name: "id", DataType: "bigint"}
+	name := schema.Column{Name: "name", DataType: "varchar"}
+	note := schema.Column{Name: "note", DataType: "text"}
+	tests := []struct {
+		name        string
+		left, right *schema.Table
+		want        engine.KeyRange
+		skip        string
+	}{
+		{"common columns", table([]string{"id"}, id, name, note), table([]string{"id"}, id, name),
+			engine.KeyRange{Table: "t", Key: []string{"id"}, KeyTypes: []string{"bigint"}, Columns: []string{"id", "name"}}, ""},
+		{"document collection", table([]string{"_id"}), table([]string{"_id"}),
+			engine.KeyRange{Table: "t", Key: []string{"_id"}, KeyTypes: []string{""}}, ""},
+		{"missing table", table([]string{"id"}, id), nil, engine.KeyRange{Table: "t", Key: []string{"id"}}, "table missing on the right"},
+		{"no primary key", table(nil, id), table(nil, id), engine.KeyRange{Table: "t"}, "no primary key"},
+		{"different keys", table([]string{"id"}, id, name), table([]string{"name"}, id, name),
+			engine.KeyRange{Table: "t", Key: []string{"id"}}, "primary keys differ"},
+		{"different key types", table([]string{"id"}, id), table([]string{"id"}, schema.Column{Name: "id", DataType: "varchar"}),
+			engine.KeyRange{Table: "t", Key: []string{"id"}}, "primary key types differ"},
+		{"key column missing on the right", table([]string{"id"}, id, name), table([]string{"id"}, name),
+			engine.KeyRange{Table: "t", Key: []string{"id"}}, "primary key types differ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, skip := keyRange(tt.left, tt.right)
+			if skip != tt.skip {
+				t.Fatalf("skip %q, want %q", skip, tt.skip)
+			}
+			if skip == "" && !reflect.DeepEqual(r, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", r, tt.want)
+			}
+		})
+	}
+}
@@ -1,9 +1,14 @@
 package engine
 
 import (
+	"database/sql"
 	"fmt"
+	"io"
+	"time"
 
 	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/schema"
+	"mydbportal.com/dbmigrate/internal/util"
 )
 
 // BackupResult holds result for a single database backup
@@ -11,6 +16,8 @@ type BackupResult struct {
 	Database string
 	Filename string
 	Error    error
+	// Duration of the dump, if the engine measured it
+	Duration time.Duration
 }
 
 type Engine interface {
@@ -27,6 +34,193 @@ type Engine interface {
 	RestoreBackup(creds config.ServerConfig, filePath string, dbName string) error
 }
 
+// Extensioner is optionally implemented by engines whose dumps are not
+// compressed SQL text, so callers can name single-database backups correctly.
+type Extensioner interface {
+	Extension(creds config.ServerConfig) string
+}
+
+// Extension returns the backup file extension for eng, including the
+// compression extension (e.g. ".sql.gz").
+func Extension(eng Engine, creds config.ServerConfig) string {
+	if e, ok := eng.(Extensioner); ok {
+		return e.Extension(creds)
+	}
+	return util.CompressedExt(".sql")
+}
+
+// StreamBackuper is implemented by engines that can write their dumps to
+// streams instead of local files, so backups can go straight to remote
+// storage. Calling create again with the same file name discards what was
+// written to the previous writer, so failed dumps can be retried.
+type StreamBackuper interface {
+	// BackupDatabaseTo writes the compressed dump of dbName to w
+	BackupDatabaseTo(creds config.ServerConfig, dbName string, w io.Writer) error
+	// BackupAllTo is BackupAll, writing each file to the writer create returns
+	BackupAllTo(creds config.ServerConfig, create func(filename string) (io.Writer, error)) ([]BackupResult, error)
+}
+
+// DumpRetrier is implemented by engines whose dumps are retried after
+// transient failures.
+type DumpRetrier interface {
+	// DumpAttempts is how often a dump is tried before it fails
+	DumpAttempts() int
+}
+
+// DumpAttempts returns how often eng tries a dump.
+func DumpAttempts(eng Engine) int {
+	if r, ok := eng.(DumpRetrier); ok {
+		return r.DumpAttempts()
+	}
+	return 1
+}
+
+// RetryStream runs dump up to attempts times, each time with a new writer
+// for filename from create, and waits a little longer after each failure.
+func RetryStream(attempts int, filename string, create func(filename string) (io.Writer, error), dump func(w io.Writer) error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(time.Second * time.Duration(i))
+		}
+		// create opens the log scope of the file, so it runs outside the
+		// scope of the attempt
+		var w io.Writer
+		if w, err = create(filename); err != nil {
+			return err
+		}
+		if err = util.LogAttempt(i+1, func() error { return dump(w) }); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// SchemaInspector is implemented by engines that can describe the tables of a database.
+type SchemaInspector interface {
+	InspectSchema(creds config.ServerConfig, dbName string) (*schema.Schema, error)
+}
+
+// ReadOptions narrows the rows returned by TableReader.ReadTable.
+type ReadOptions struct {
+	// OrderBy sorts the rows ascending by these columns
+	OrderBy []string
+	// After keeps rows whose OrderBy values sort after these (keyset paging)
+	After []string
+	// KeyColumn and KeyValues keep rows whose KeyColumn is one of KeyValues
+	KeyColumn string
+	KeyValues []string
+	// Limit caps the number of rows (0 = no limit)
+	Limit int
+}
+
+// TableReader is implemented by engines that can stream the rows of a table.
+// Values are returned as text in the engine's canonical output format; binary
+// columns carry their raw bytes. Entries with Valid unset are SQL NULLs.
+type TableReader interface {
+	ReadTable(creds config.ServerConfig, dbName string, table schema.Table, opts ReadOptions, fn func(row []sql.NullString) error) error
+}
+
+// SQLExecutor is implemented by engines that can run SQL statements.
+// The result rows are returned as text, one row per line.
+type SQLExecutor interface {
+	ExecSQL(creds config.ServerConfig, dbName string, query string) (string, error)
+}
+
+// DatabaseCreator is implemented by engines that can create an empty database.
+type DatabaseCreator interface {
+	CreateDatabase(creds config.ServerConfig, dbName string) error
+}
+
+// BackupInspector is implemented by engines that can tell which databases a
+// backup file creates or overwrites when it is restored to creds. An empty
+// result means the file does not name its databases.
+type BackupInspector interface {
+	BackupDatabases(creds config.ServerConfig, filePath string) ([]string, error)
+}
+
+// ContentLister is implemented by engines that can list the tables (or
+// collections) a backup file holds without restoring it.
+type ContentLister interface {
+	BackupContents(filePath string) ([]BackupObject, error)
+}
+
+// BackupObject is a table (or collection) found in a backup file.
+type BackupObject struct {
+	Database string `json:"database,omitempty"`
+	Name     string `json:"name"`
+	// Definition is the CREATE TABLE statement, or the collection metadata
+	Definition string `json:"definition,omitempty"`
+}
+
+// TableLister is implemented by engines that can list the tables (or
+// collections) of a database.
+type TableLister interface {
+	ListTables(creds config.ServerConfig, dbName string) ([]string, error)
+}
+
+// RenamingRestorer is implemented by engines that can restore a
+// single-database backup into a database of a different name.
+type RenamingRestorer interface {
+	RestoreBackupAs(creds config.ServerConfig, filePath string, dbName string) error
+}
+
+// DatabaseDropper is implemented by engines that can drop a database.
+type DatabaseDropper interface {
+	DropDatabase(creds config.ServerConfig, dbName string) error
+}
+
+// RowCounter is implemented by engines that can count the rows (or
+// documents) of every table (or collection) of a database exactly.
+type RowCounter interface {
+	CountRows(creds config.ServerConfig, dbName string) (map[string]int64, error)
+}
+
+// RowEstimator is implemented by engines that can estimate the row counts of
+// every table of a database from statistics, without scanning them.
+type RowEstimator interface {
+	EstimateRows(creds config.ServerConfig, dbName string) (map[string]int64, error)
+}
+
+// SizeEstimator is implemented by engines that can estimate the size of a
+// database, so the progress of its dump can be shown.
+type SizeEstimator interface {
+	EstimateSize(creds config.ServerConfig, dbName string) (int64, error)
+}
+
+// KeyRange selects the rows of a table whose primary key lies in
+// (After, Upto]. A nil bound is open.
+type KeyRange struct {
+	Table string
+	// Key holds the primary key columns
+	Key []string
+	// KeyTypes holds the data types of the Key columns, as inspected
+	KeyTypes []string
+	// Columns are the columns whose values are checksummed
+	Columns []string
+	After   []string
+	Upto    []string
+}
+
+// RowHash is the checksum of one row, identified by its primary key.
+type RowHash struct {
+	Key  []string
+	Hash string
+}
+
+// RangeHasher is implemented by engines that can checksum the rows of a
+// table in primary key ranges, so data can be compared between servers
+// without transferring it.
+type RangeHasher interface {
+	// HashRange counts the rows in r and returns a checksum of their contents.
+	HashRange(creds config.ServerConfig, dbName string, r KeyRange) (int64, string, error)
+	// KeyAt returns the key of the row at offset n of r in key order, or nil
+	// if r has fewer rows.
+	KeyAt(creds config.ServerConfig, dbName string, r KeyRange, n int64) ([]string, error)
+	// HashRows returns the key and checksum of every row of r in key order.
+	HashRows(creds config.ServerConfig, dbName string, r KeyRange) ([]RowHash, error)
+}
+
 // Factory function type
 type Factory func() Engine
 
@@ -0,0 +1,134 @@
+package mongo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// archiveMagic starts every mongodump --archive stream.
+const archiveMagic = 0x8199e26d
+
+// BackupContents lists the collections of an archive. Its header holds a
+// metadata document per collection, up to a terminator, before any data.
+func (e *MongoEngine) BackupContents(filePath string) ([]engine.BackupObject, error) {
+	var objects []engine.BackupObject
+	err := util.ReadCompressed(filePath, func(r io.Reader) error {
+		br := bufio.NewReader(r)
+		var magic uint32
+		if err := binary.Read(br, binary.LittleEndian, &magic); err != nil || magic != archiveMagic {
+			return fmt.Errorf("not a mongodump archive")
+		}
+		// The prelude describes the dump itself
+		if _, err := readDocument(br); err != nil {
+			return fmt.Errorf("failed to read archive prelude: %w", err)
+		}
+		for {
+			doc, err := readDocument(br)
+			if err != nil {
+				return fmt.Errorf("failed to read archive header: %w", err)
+			}
+			if doc == nil {
+				return nil
+			}
+			fields := stringFields(doc)
+			if fields["collection"] == "" {
+				continue
+			}
+			objects = append(objects, engine.BackupObject{
+				Database:   fields["db"],
+				Name:       fields["collection"],
+				Definition: fields["metadata"],
+			})
+		}
+	})
+	return objects, err
+}
+
+// readDocument reads one BSON document, or returns nil at the terminator
+// that ends the archive header.
+func readDocument(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := int32(binary.LittleEndian.Uint32(size[:]))
+	if n == -1 {
+		return nil, nil
+	}
+	if n < 5 || n > 16<<20 {
+		return nil, fmt.Errorf("invalid document size %d", n)
+	}
+	doc := make([]byte, n)
+	copy(doc, size[:])
+	if _, err := io.ReadFull(r, doc[4:]); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// stringFields returns the top-level string fields of a BSON document. It
+// stops at the first field type it cannot skip.
+func stringFields(doc []byte) map[string]string {
+	fields := make(map[string]string)
+	b := doc[4 : len(doc)-1]
+	for len(b) > 0 {
+		kind := b[0]
+		end := bytes.IndexByte(b[1:], 0)
+		if end < 0 {
+			break
+		}
+		name := string(b[1 : 1+end])
+		b = b[2+end:]
+		size, err := valueSize(kind, b)
+		if err != nil || size > len(b) {
+			break
+		}
+		if kind == 0x02 {
+			// int32 length, then the string and its NUL
+			fields[name] = string(b[4 : size-1])
+		}
+		b = b[size:]
+	}
+	return fields
+}
+
+// valueSize returns the encoded size of a BSON value of kind at the start of b.
+func valueSize(kind byte, b []byte) (int, error) {
+	switch kind {
+	case 0x01, 0x09, 0x11, 0x12: // double, datetime, timestamp, int64
+		return 8, nil
+	case 0x08: // bool
+		return 1, nil
+	case 0x0A: // null
+		return 0, nil
+	case 0x10: // int32
+		return 4, nil
+	case 0x07: // ObjectId
+		return 12, nil
+	case 0x13: // decimal128
+		return 16, nil
+	}
+	if len(b) < 4 {
+		return 0, errors.New("truncated document")
+	}
+	n := int(binary.LittleEndian.Uint32(b))
+	switch kind {
+	case 0x02: // string
+		if n < 1 {
+			return 0, errors.New("invalid string")
+		}
+		return 4 + n, nil
+	case 0x03, 0x04: // document, array
+		return n, nil
+	case 0x05: // binary
+		return 5 + n, nil
+	}
+	return 0, fmt.Errorf("unsupported BSON type 0x%02x", kind)
+}
@@ -0,0 +1,81 @@
+package mongo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+)
+
+// Documents are keyed by _id, written as canonical Extended JSON so every
+// BSON type survives the round trip through the range bounds.
+
+// rangeScript declares c, the collection, and q, the filter selecting r.
+func rangeScript(dbName string, r engine.KeyRange) string {
+	var conds []string
+	if len(r.After) > 0 {
+		conds = append(conds, fmt.Sprintf("$gt: EJSON.parse(%q)", r.After[0]))
+	}
+	if len(r.Upto) > 0 {
+		conds = append(conds, fmt.Sprintf("$lte: EJSON.parse(%q)", r.Upto[0]))
+	}
+	q := "{}"
+	if len(conds) > 0 {
+		q = "{_id: {" + strings.Join(conds, ", ") + "}}"
+	}
+	return fmt.Sprintf(`const c = db.getSiblingDB(%q).getCollection(%q);
+		const q = %s;
+		const crypto = require("crypto");
+		const docHash = d => crypto.createHash("md5").update(EJSON.stringify(d, {relaxed: false})).digest("hex");
+		const key = d => EJSON.stringify(d._id, {relaxed: false});`, dbName, r.Table, q)
+}
+
+// HashRange counts the documents of r and XORs the first 64 bits of their
+// hashes. Documents are hashed by mongosh, so they are read by the client.
+func (e *MongoEngine) HashRange(creds config.ServerConfig, dbName string, r engine.KeyRange) (int64, string, error) {
+	output, err := e.eval(creds, rangeScript(dbName, r)+`
+		let n = 0, h = 0n;
+		c.find(q).forEach(d => { n++; h ^= BigInt("0x" + docHash(d).slice(0, 16)); });
+		print(n + "\t" + h.toString(16));`)
+	if err != nil {
+		return 0, "", err
+	}
+	count, sum, ok := strings.Cut(strings.TrimSpace(output), "\t")
+	n, convErr := strconv.ParseInt(count, 10, 64)
+	if !ok || convErr != nil {
+		return 0, "", fmt.Errorf("unexpected mongosh output: %q", output)
+	}
+	return n, sum, nil
+}
+
+// KeyAt returns the _id of the document at offset n of r.
+func (e *MongoEngine) KeyAt(creds config.ServerConfig, dbName string, r engine.KeyRange, n int64) ([]string, error) {
+	output, err := e.eval(creds, rangeScript(dbName, r)+fmt.Sprintf(`
+		c.find(q, {_id: 1}).sort({_id: 1}).skip(%d).limit(1).forEach(d => print(key(d)));`, n))
+	if err != nil {
+		return nil, err
+	}
+	if key := strings.TrimSpace(output); key != "" {
+		return []string{key}, nil
+	}
+	return nil, nil
+}
+
+// HashRows returns the _id and hash of every document of r.
+func (e *MongoEngine) HashRows(creds config.ServerConfig, dbName string, r engine.KeyRange) ([]engine.RowHash, error) {
+	output, err := e.eval(creds, rangeScript(dbName, r)+`
+		c.find(q).sort({_id: 1}).forEach(d => print(key(d) + "\t" + docHash(d)));`)
+	if err != nil {
+		return nil, err
+	}
+	var rows []engine.RowHash
+	for _, line := range strings.Split(output, "\n") {
+		key, hash, ok := strings.Cut(strings.TrimSpace(line), "\t")
+		if ok {
+			rows = append(rows, engine.RowHash{Key: []string{key}, Hash: hash})
+		}
+	}
+	return rows, nil
+}
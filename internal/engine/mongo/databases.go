@@ -0,0 +1,125 @@
+package mongo
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// ListTables lists the collections of dbName.
+func (e *MongoEngine) ListTables(creds config.ServerConfig, dbName string) ([]string, error) {
+	output, err := e.eval(creds, fmt.Sprintf("db.getSiblingDB(%q).getCollectionNames().forEach(c => print(c))", dbName))
+	if err != nil {
+		return nil, err
+	}
+
+	var collections []string
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		if c := strings.TrimSpace(scanner.Text()); c != "" {
+			collections = append(collections, c)
+		}
+	}
+	return collections, nil
+}
+
+// nsEscape escapes the characters mongorestore treats as wildcards in namespaces.
+var nsEscape = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `$`, `\$`)
+
+// RestoreBackupAs restores a single-database archive into dbName. The
+// original name is taken from the backup file name (<db>_<timestamp>).
+func (e *MongoEngine) RestoreBackupAs(creds config.ServerConfig, filePath string, dbName string) error {
+	base := filepath.Base(filePath)
+	i := strings.LastIndex(base, "_")
+	if i <= 0 || base[:i] == "all-databases" {
+		return fmt.Errorf("%s is not a single-database archive", base)
+	}
+	original := base[:i]
+
+	uri := fmt.Sprintf("mongodb://%s:%s@%s:%d/?authSource=admin",
+		creds.User, creds.Password, creds.Host, creds.Port)
+	args := []string{
+		"--uri", uri,
+		"--archive",
+		"--nsInclude", nsEscape.Replace(original) + ".*",
+		"--nsFrom", nsEscape.Replace(original) + ".*",
+		"--nsTo", nsEscape.Replace(dbName) + ".*",
+	}
+
+	cmd := util.Command("mongorestore", args...)
+	return util.RestoreFromFile(cmd, filePath)
+}
+
+// DropDatabase drops dbName.
+func (e *MongoEngine) DropDatabase(creds config.ServerConfig, dbName string) error {
+	_, err := e.eval(creds, fmt.Sprintf("db.getSiblingDB(%q).dropDatabase()", dbName))
+	return err
+}
+
+// CountRows counts the documents of every collection of dbName.
+func (e *MongoEngine) CountRows(creds config.ServerConfig, dbName string) (map[string]int64, error) {
+	return e.collectionCounts(creds, dbName, "countDocuments({})")
+}
+
+// EstimateRows returns the document counts recorded in collection metadata.
+func (e *MongoEngine) EstimateRows(creds config.ServerConfig, dbName string) (map[string]int64, error) {
+	return e.collectionCounts(creds, dbName, "estimatedDocumentCount()")
+}
+
+// EstimateSize returns the uncompressed data size dbStats reports for dbName.
+func (e *MongoEngine) EstimateSize(creds config.ServerConfig, dbName string) (int64, error) {
+	output, err := e.eval(creds, fmt.Sprintf("print(db.getSiblingDB(%q).stats().dataSize)", dbName))
+	if err != nil {
+		return 0, err
+	}
+	size, err := strconv.ParseFloat(strings.TrimSpace(output), 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected dbStats output: %q", output)
+	}
+	return int64(size), nil
+}
+
+// collectionCounts evaluates count, a collection method, on every collection of dbName.
+func (e *MongoEngine) collectionCounts(creds config.ServerConfig, dbName string, count string) (map[string]int64, error) {
+	output, err := e.eval(creds, fmt.Sprintf(`const d = db.getSiblingDB(%q);
+		d.getCollectionInfos({type: "collection"}).forEach(c => print(c.name + "\t" + d.getCollection(c.name).%s))`, dbName, count))
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64)
+	for _, line := range strings.Split(output, "\n") {
+		name, count, ok := strings.Cut(strings.TrimSpace(line), "\t")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(count, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected count output: %q", line)
+		}
+		counts[name] = n
+	}
+	return counts, nil
+}
+
+// eval runs a mongosh script and returns its output.
+func (e *MongoEngine) eval(creds config.ServerConfig, script string) (string, error) {
+	args := []string{
+		"--host", creds.Host,
+		"--port", fmt.Sprintf("%d", creds.Port),
+		"--username", creds.User,
+		"--password", creds.Password,
+		"--authenticationDatabase", "admin",
+		"--eval", script,
+		"--quiet",
+	}
+	output, err := util.Command("mongosh", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("mongosh failed: %s, output: %s", err, string(output))
+	}
+	return string(output), nil
+}
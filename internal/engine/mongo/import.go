@@ -0,0 +1,46 @@
+package mongo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// ImportDocuments loads newline-delimited Extended JSON documents from r into
+// dbName.collection with mongoimport, which sends them as bulk inserts. With
+// upsert, documents replace existing ones with the same _id; with drop, the
+// collection is dropped first.
+func (e *MongoEngine) ImportDocuments(creds config.ServerConfig, dbName string, collection string, r io.Reader, upsert bool, drop bool) error {
+	mode := "insert"
+	if upsert {
+		mode = "upsert"
+	}
+	args := []string{
+		"--host", creds.Host,
+		"--port", fmt.Sprintf("%d", creds.Port),
+		"--username", creds.User,
+		"--password", creds.Password,
+		"--authenticationDatabase", "admin",
+		"--db", dbName,
+		"--collection", collection,
+		"--mode", mode,
+		"--stopOnError",
+		"--quiet",
+	}
+	if drop {
+		args = append(args, "--drop")
+	}
+
+	cmd := util.Command("mongoimport", args...)
+	cmd.Stdin = r
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("mongoimport failed: %s, output: %s", err, output.String())
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package mongo
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/schema"
+)
+
+// collectionInfo is the shape printed by the InspectSchema script.
+type collectionInfo struct {
+	Name    string `json:"name"`
+	Indexes []struct {
+		Name    string      `json:"name"`
+		Unique  bool        `json:"unique"`
+		Partial bool        `json:"partial"`
+		Keys    [][2]string `json:"keys"`
+	} `json:"indexes"`
+}
+
+// InspectSchema describes the collections of dbName and their indexes.
+// Collections have no fixed columns, so only _id is reported as the key.
+func (e *MongoEngine) InspectSchema(creds config.ServerConfig, dbName string) (*schema.Schema, error) {
+	output, err := e.eval(creds, fmt.Sprintf(`const d = db.getSiblingDB(%q);
+		print(JSON.stringify(d.getCollectionInfos({type: "collection"}).map(c => ({
+			name: c.name,
+			indexes: d.getCollection(c.name).getIndexes().map(i => ({
+				name: i.name, unique: !!i.unique, partial: !!i.partialFilterExpression,
+				keys: Object.entries(i.key).map(([k, v]) => [k, String(v)])
+			}))
+		}))))`, dbName))
+	if err != nil {
+		return nil, err
+	}
+	var infos []collectionInfo
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), &infos); err != nil {
+		return nil, fmt.Errorf("unexpected mongosh output: %w", err)
+	}
+
+	s := &schema.Schema{Engine: e.ID(), Database: dbName}
+	for _, info := range infos {
+		t := schema.Table{Name: info.Name, PrimaryKey: []string{"_id"}}
+		for _, i := range info.Indexes {
+			if i.Name == "_id_" {
+				continue
+			}
+			idx := schema.Index{Name: i.Name, Unique: i.Unique}
+			for _, key := range i.Keys {
+				switch key[1] {
+				case "1":
+					idx.Columns = append(idx.Columns, key[0])
+				case "-1":
+					idx.Columns = append(idx.Columns, key[0]+" DESC")
+				default:
+					// text, hashed, 2dsphere, ...
+					idx.Columns = append(idx.Columns, key[0])
+					idx.Kind = strings.ToUpper(key[1])
+				}
+			}
+			if i.Partial {
+				idx.Kind = "PARTIAL"
+			}
+			t.Indexes = append(t.Indexes, idx)
+		}
+		s.Tables = append(s.Tables, t)
+	}
+	return s, nil
+}
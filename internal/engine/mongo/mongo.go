@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"os/exec"
 	"path/filepath"
 	"strings"
@@ -38,7 +39,7 @@ func (e *MongoEngine) ListDatabases(creds config.ServerConfig) ([]string, error)
 		"--quiet",
 	}
 
-	cmd := exec.Command("mongosh", args...)
+	cmd := util.Command("mongosh", args...)
 	// Stdin not needed for password anymore
 
 	output, err := cmd.CombinedOutput()
@@ -63,26 +64,21 @@ func (e *MongoEngine) ListDatabases(creds config.ServerConfig) ([]string, error)
 	return dbs, nil
 }
 
+// DumpAttempts retries dumps after transient failures, such as server
+// selection timeouts.
+func (e *MongoEngine) DumpAttempts() int {
+	return 3
+}
+
 func (e *MongoEngine) BackupDatabase(creds config.ServerConfig, dbName string, destPath string) error {
 	// Retry logic
-	maxRetries := 3
+	maxRetries := e.DumpAttempts()
 	var lastErr error
 
 	for i := 0; i < maxRetries; i++ {
-		// Use explicit password flag
-		args := []string{
-			"--host", creds.Host,
-			"--port", fmt.Sprintf("%d", creds.Port),
-			"--username", creds.User,
-			"--password", creds.Password,
-			"--authenticationDatabase", "admin",
-			"--archive",
-			"--db", dbName,
-		}
-
-		cmd := exec.Command("mongodump", args...)
-		
-		lastErr = util.RunDumpToFile(cmd, destPath)
+		lastErr = util.LogAttempt(i+1, func() error {
+			return util.RunDumpToFile(e.dumpCmd(creds, dbName), destPath)
+		})
 		if lastErr == nil {
 			return nil
 		}
@@ -93,31 +89,43 @@ func (e *MongoEngine) BackupDatabase(creds config.ServerConfig, dbName string, d
 	return lastErr
 }
 
+// BackupDatabaseTo streams the compressed archive of dbName to w.
+func (e *MongoEngine) BackupDatabaseTo(creds config.ServerConfig, dbName string, w io.Writer) error {
+	return util.RunDump(e.dumpCmd(creds, dbName), w)
+}
+
+// dumpCmd builds a mongodump of dbName, or of every database if it is empty.
+func (e *MongoEngine) dumpCmd(creds config.ServerConfig, dbName string) *exec.Cmd {
+	// Use explicit password flag
+	args := []string{
+		"--host", creds.Host,
+		"--port", fmt.Sprintf("%d", creds.Port),
+		"--username", creds.User,
+		"--password", creds.Password,
+		"--authenticationDatabase", "admin",
+		"--archive",
+	}
+	if dbName != "" {
+		args = append(args, "--db", dbName)
+	}
+	return util.Command("mongodump", args...)
+}
+
 func (e *MongoEngine) BackupAll(creds config.ServerConfig, destDir string) ([]engine.BackupResult, error) {
 	// mongodump --archive ... (dumps all)
 	
 	timestamp := time.Now().Format("2006-01-02T15:04:05Z")
-	filename := fmt.Sprintf("all-databases_%s.archive.gz", timestamp)
+	filename := fmt.Sprintf("all-databases_%s%s", timestamp, util.CompressedExt(".archive"))
 	destPath := filepath.Join(destDir, filename)
 	
 	// Retry logic
-	maxRetries := 3
+	maxRetries := e.DumpAttempts()
 	var lastErr error
 
 	for i := 0; i < maxRetries; i++ {
-		args := []string{
-			"--host", creds.Host,
-			"--port", fmt.Sprintf("%d", creds.Port),
-			"--username", creds.User,
-			"--password", creds.Password,
-			"--authenticationDatabase", "admin",
-			"--archive",
-		}
-		
-		cmd := exec.Command("mongodump", args...)
-		// Stdin not needed for password
-		
-		lastErr = util.RunDumpToFile(cmd, destPath)
+		lastErr = util.LogAttempt(i+1, func() error {
+			return util.RunDumpToFile(e.dumpCmd(creds, ""), destPath)
+		})
 		if lastErr == nil {
 			break
 		}
@@ -134,6 +142,21 @@ func (e *MongoEngine) BackupAll(creds config.ServerConfig, destDir string) ([]en
 	return []engine.BackupResult{res}, nil
 }
 
+// BackupAllTo streams a single archive of every database.
+func (e *MongoEngine) BackupAllTo(creds config.ServerConfig, create func(filename string) (io.Writer, error)) ([]engine.BackupResult, error) {
+	timestamp := time.Now().Format("2006-01-02T15:04:05Z")
+	filename := fmt.Sprintf("all-databases_%s%s", timestamp, util.CompressedExt(".archive"))
+
+	err := engine.RetryStream(e.DumpAttempts(), filename, create, func(w io.Writer) error {
+		return util.RunDump(e.dumpCmd(creds, ""), w)
+	})
+	return []engine.BackupResult{{
+		Database: "all",
+		Filename: filename,
+		Error:    err,
+	}}, nil
+}
+
 func (e *MongoEngine) RestoreBackup(creds config.ServerConfig, filePath string, dbName string) error {
 	// Use URI for restore as before (standard for restore + archive piping)
 	uri := fmt.Sprintf("mongodb://%s:%s@%s:%d/?authSource=admin", 
@@ -145,7 +168,7 @@ func (e *MongoEngine) RestoreBackup(creds config.ServerConfig, filePath string,
 		"--nsInclude=*",
 	}
 	
-	cmd := exec.Command("mongorestore", args...)
+	cmd := util.Command("mongorestore", args...)
 	// Stdin will be set by util.RestoreFromFile
 	
 	return util.RestoreFromFile(cmd, filePath)
@@ -0,0 +1,97 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+)
+
+// rangeClause renders the WHERE clause selecting the keys of r.
+func rangeClause(r engine.KeyRange) string {
+	var where []string
+	if len(r.After) > 0 {
+		where = append(where, fmt.Sprintf("(%s) > (%s)", quoteAll(r.Key, quoteIdent), keyLiterals(r.KeyTypes, r.After)))
+	}
+	if len(r.Upto) > 0 {
+		where = append(where, fmt.Sprintf("(%s) <= (%s)", quoteAll(r.Key, quoteIdent), keyLiterals(r.KeyTypes, r.Upto)))
+	}
+	if len(where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(where, " AND ")
+}
+
+// rowHash hashes the compared columns of a row. CONCAT_WS skips NULLs, so
+// a mask of NULL flags is hashed with the values.
+func rowHash(columns []string) string {
+	nulls := make([]string, len(columns))
+	for i, c := range columns {
+		nulls[i] = "ISNULL(" + quoteIdent(c) + ")"
+	}
+	return fmt.Sprintf("MD5(CONCAT_WS('#', %s, CONCAT(%s)))", quoteAll(columns, quoteIdent), strings.Join(nulls, ", "))
+}
+
+func tableRef(dbName string, table string) string {
+	return quoteIdent(dbName) + "." + quoteIdent(table)
+}
+
+// HashRange counts the rows of r and XORs the first 64 bits of their hashes.
+func (e *MySQLEngine) HashRange(creds config.ServerConfig, dbName string, r engine.KeyRange) (int64, string, error) {
+	var count int64
+	var sum string
+	err := e.query(creds, dbName,
+		[]string{"COUNT(*)", "COALESCE(BIT_XOR(CAST(CONV(SUBSTRING(" + rowHash(r.Columns) + ", 1, 16), 16, 10) AS UNSIGNED)), 0)"},
+		"FROM "+tableRef(dbName, r.Table)+rangeClause(r),
+		func(row []sql.NullString) error {
+			if _, err := fmt.Sscan(row[0].String, &count); err != nil {
+				return fmt.Errorf("unexpected count %q", row[0].String)
+			}
+			sum = row[1].String
+			return nil
+		})
+	return count, sum, err
+}
+
+// KeyAt returns the key of the row at offset n of r.
+func (e *MySQLEngine) KeyAt(creds config.ServerConfig, dbName string, r engine.KeyRange, n int64) ([]string, error) {
+	var key []string
+	err := e.query(creds, dbName, quoteKey(r.Key),
+		fmt.Sprintf("FROM %s%s ORDER BY %s LIMIT 1 OFFSET %d", tableRef(dbName, r.Table), rangeClause(r), quoteAll(r.Key, quoteIdent), n),
+		func(row []sql.NullString) error {
+			key = rowStrings(row)
+			return nil
+		})
+	return key, err
+}
+
+// HashRows returns the key and hash of every row of r.
+func (e *MySQLEngine) HashRows(creds config.ServerConfig, dbName string, r engine.KeyRange) ([]engine.RowHash, error) {
+	var rows []engine.RowHash
+	err := e.query(creds, dbName, append(quoteKey(r.Key), rowHash(r.Columns)),
+		fmt.Sprintf("FROM %s%s ORDER BY %s", tableRef(dbName, r.Table), rangeClause(r), quoteAll(r.Key, quoteIdent)),
+		func(row []sql.NullString) error {
+			values := rowStrings(row)
+			rows = append(rows, engine.RowHash{Key: values[:len(r.Key)], Hash: values[len(r.Key)]})
+			return nil
+		})
+	return rows, err
+}
+
+func quoteKey(key []string) []string {
+	quoted := make([]string, len(key))
+	for i, k := range key {
+		quoted[i] = quoteIdent(k)
+	}
+	return quoted
+}
+
+func rowStrings(row []sql.NullString) []string {
+	values := make([]string, len(row))
+	for i, v := range row {
+		values[i] = v.String
+	}
+	return values
+}
@@ -0,0 +1,181 @@
+package mysql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// createDatabase matches the lines of mysqldump --databases that name a
+// database: CREATE DATABASE, USE, and the ALTER DATABASE statements that
+// switch the collation around routines.
+var createDatabase = regexp.MustCompile("^(?:CREATE DATABASE (?:/\\*[^*]*\\*/ )?(?:IF NOT EXISTS )?|USE |ALTER DATABASE )`((?:[^`]|``)+)`")
+
+// dataStatement matches the first statements of a table's data in a dump.
+var dataStatement = regexp.MustCompile("^(?:INSERT INTO|LOCK TABLES|REPLACE INTO) `")
+
+// BackupDatabases lists the databases a dump creates or switches to. Only
+// the header is read: it stops at the first table data, as dumps made by
+// dbmigrate hold one database.
+func (e *MySQLEngine) BackupDatabases(creds config.ServerConfig, filePath string) ([]string, error) {
+	var dbs []string
+	seen := make(map[string]bool)
+	err := util.ScanCompressedLines(filePath, func(line string) bool {
+		if dataStatement.MatchString(line) {
+			return false
+		}
+		if m := createDatabase.FindStringSubmatch(line); m != nil {
+			name := strings.ReplaceAll(m[1], "``", "`")
+			if !seen[name] {
+				seen[name] = true
+				dbs = append(dbs, name)
+			}
+		}
+		return true
+	})
+	return dbs, err
+}
+
+// createTable matches the start of a CREATE TABLE statement of mysqldump.
+var createTable = regexp.MustCompile("^CREATE TABLE (?:IF NOT EXISTS )?`((?:[^`]|``)+)`")
+
+// BackupContents lists the CREATE TABLE statements of a dump, with the
+// database they follow the USE statement of.
+func (e *MySQLEngine) BackupContents(filePath string) ([]engine.BackupObject, error) {
+	var objects []engine.BackupObject
+	current := ""
+	err := util.ScanCreateTables(filePath, func(line string) {
+		if m := createDatabase.FindStringSubmatch(line); m != nil && strings.HasPrefix(line, "USE ") {
+			current = strings.ReplaceAll(m[1], "``", "`")
+		}
+	}, func(stmt string) {
+		if m := createTable.FindStringSubmatch(stmt); m != nil {
+			objects = append(objects, engine.BackupObject{Database: current, Name: strings.ReplaceAll(m[1], "``", "`"), Definition: stmt})
+		}
+	})
+	return objects, err
+}
+
+// ListTables lists the tables and views of dbName.
+func (e *MySQLEngine) ListTables(creds config.ServerConfig, dbName string) ([]string, error) {
+	out, err := e.ExecSQL(creds, dbName, "SHOW TABLES;")
+	if err != nil {
+		return nil, err
+	}
+	var tables []string
+	for _, line := range strings.Split(out, "\n") {
+		if line != "" {
+			tables = append(tables, unescape(line))
+		}
+	}
+	return tables, nil
+}
+
+// renameDatabase returns a rewrite for the lines of a single-database dump
+// that makes the statements naming its database name dbName instead. Table
+// data cannot match, as mysqldump escapes the newlines inside values.
+func renameDatabase(dbName string) func(line string) string {
+	escaped := strings.ReplaceAll(dbName, "`", "``")
+	return func(line string) string {
+		m := createDatabase.FindStringSubmatchIndex(line)
+		if m == nil {
+			return line
+		}
+		return line[:m[2]] + escaped + line[m[3]:]
+	}
+}
+
+// RestoreBackupAs restores a single-database dump into dbName by rewriting
+// its CREATE DATABASE, USE and ALTER DATABASE statements.
+func (e *MySQLEngine) RestoreBackupAs(creds config.ServerConfig, filePath string, dbName string) error {
+	if err := e.CreateDatabase(creds, dbName); err != nil {
+		return err
+	}
+	rewrite := renameDatabase(dbName)
+
+	args := []string{
+		"-h", creds.Host,
+		"-P", fmt.Sprintf("%d", creds.Port),
+		"-u", creds.User,
+		dbName,
+	}
+	cmd := util.Command("mysql", args...)
+	cmd.Env = e.getEnv(creds)
+	return util.RestoreFromFileRewriting(cmd, filePath, rewrite)
+}
+
+// DropDatabase drops dbName if it exists.
+func (e *MySQLEngine) DropDatabase(creds config.ServerConfig, dbName string) error {
+	_, err := e.ExecSQL(creds, "", "DROP DATABASE IF EXISTS "+quoteIdent(dbName)+";")
+	return err
+}
+
+// CountRows counts the rows of every base table of dbName.
+func (e *MySQLEngine) CountRows(creds config.ServerConfig, dbName string) (map[string]int64, error) {
+	out, err := e.ExecSQL(creds, "", "SELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = "+
+		quoteString(dbName)+" AND TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME;")
+	if err != nil {
+		return nil, err
+	}
+	var selects []string
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" {
+			continue
+		}
+		table := unescape(line)
+		selects = append(selects, fmt.Sprintf("SELECT %s, COUNT(*) FROM %s.%s", quoteString(table), quoteIdent(dbName), quoteIdent(table)))
+	}
+
+	counts := make(map[string]int64)
+	if len(selects) == 0 {
+		return counts, nil
+	}
+	out, err = e.ExecSQL(creds, "", strings.Join(selects, "\nUNION ALL\n")+";")
+	if err != nil {
+		return nil, err
+	}
+	return parseCounts(out)
+}
+
+// EstimateRows reads the row estimates InnoDB keeps in information_schema.
+func (e *MySQLEngine) EstimateRows(creds config.ServerConfig, dbName string) (map[string]int64, error) {
+	out, err := e.ExecSQL(creds, "", "SELECT TABLE_NAME, IFNULL(TABLE_ROWS, 0) FROM information_schema.TABLES WHERE TABLE_SCHEMA = "+
+		quoteString(dbName)+" AND TABLE_TYPE = 'BASE TABLE';")
+	if err != nil {
+		return nil, err
+	}
+	return parseCounts(out)
+}
+
+// EstimateSize sums the data length of the tables of dbName from
+// information_schema. Indexes are left out, as dumps do not contain them.
+func (e *MySQLEngine) EstimateSize(creds config.ServerConfig, dbName string) (int64, error) {
+	out, err := e.ExecSQL(creds, "", "SELECT IFNULL(SUM(DATA_LENGTH), 0) FROM information_schema.TABLES WHERE TABLE_SCHEMA = "+
+		quoteString(dbName)+";")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+}
+
+// parseCounts reads "table<TAB>count" rows.
+func parseCounts(out string) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	for _, line := range strings.Split(out, "\n") {
+		name, count, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(count, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected count output: %q", line)
+		}
+		counts[unescape(name)] = n
+	}
+	return counts, nil
+}
@@ -0,0 +1,73 @@
+package mysql
+
+import (
+	"strings"
+	"testing"
+)
+
+// mysqldump returns the parts of a mysqldump --databases --routines file that
+// name the database, created as ident.
+func mysqldump(ident string) string {
+	return "-- MySQL dump 10.13  Distrib 8.0.36, for Linux (x86_64)\n" +
+		"--\n" +
+		"-- Host: localhost    Database: " + ident + "\n" +
+		"-- ------------------------------------------------------\n" +
+		"/*!40101 SET NAMES utf8mb4 */;\n" +
+		"\n" +
+		"--\n" +
+		"-- Current Database: `" + ident + "`\n" +
+		"--\n" +
+		"\n" +
+		"CREATE DATABASE /*!32312 IF NOT EXISTS*/ `" + ident + "` /*!40100 DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci */ /*!80016 DEFAULT ENCRYPTION='N' */;\n" +
+		"\n" +
+		"USE `" + ident + "`;\n" +
+		"\n" +
+		"CREATE TABLE `notes` (\n" +
+		"  `id` int NOT NULL,\n" +
+		"  `body` text,\n" +
+		"  PRIMARY KEY (`id`)\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n" +
+		"\n" +
+		"LOCK TABLES `notes` WRITE;\n" +
+		"INSERT INTO `notes` VALUES (1,'CREATE DATABASE `other`;\\nUSE `" + ident + "`;');\n" +
+		"UNLOCK TABLES;\n" +
+		"\n" +
+		"/*!50003 SET @saved_cs_client      = @@character_set_client */ ;\n" +
+		"ALTER DATABASE `" + ident + "` CHARACTER SET latin1 COLLATE latin1_swedish_ci ;\n" +
+		"DELIMITER ;;\n" +
+		"CREATE DEFINER=`root`@`localhost` PROCEDURE `note_count`()\n" +
+		"BEGIN SELECT COUNT(*) FROM notes; END ;;\n" +
+		"DELIMITER ;\n" +
+		"ALTER DATABASE `" + ident + "` CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci ;\n"
+}
+
+func TestRenameDatabase(t *testing.T) {
+	tests := []struct {
+		name   string
+		ident  string
+		dbName string
+		want   string
+	}{
+		{"plain names", "shop", "shop_copy", "shop_copy"},
+		{"quoted names", "my-shop", "My Shop", "My Shop"},
+		{"backticks in names", "it``s", "a`b", "a``b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dump := mysqldump(tt.ident)
+			var b strings.Builder
+			rewrite := renameDatabase(tt.dbName)
+			for _, line := range strings.SplitAfter(dump, "\n") {
+				b.WriteString(rewrite(line))
+			}
+			want := dump
+			for _, stmt := range []string{"CREATE DATABASE /*!32312 IF NOT EXISTS*/ `%s`", "USE `%s`;\n\n", "ALTER DATABASE `%s` CHARACTER SET latin1",
+				"ALTER DATABASE `%s` CHARACTER SET utf8mb4"} {
+				want = strings.Replace(want, strings.Replace(stmt, "%s", tt.ident, 1), strings.Replace(stmt, "%s", tt.want, 1), 1)
+			}
+			if got := b.String(); got != want {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
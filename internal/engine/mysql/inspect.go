@@ -0,0 +1,457 @@
+package mysql
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/schema"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// quoteIdent quotes a MySQL identifier.
+func quoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// quoteString quotes a MySQL string literal.
+func quoteString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// unescape reverses the escaping mysql --batch applies to each field.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 == len(s) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '0':
+			b.WriteByte(0)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// query runs "SELECT <exprs> <rest>" and streams the decoded rows to fn.
+// mysql --batch prints NULL and the string 'NULL' identically, so a leading
+// null-mask column records which values are NULL.
+func (e *MySQLEngine) query(creds config.ServerConfig, dbName string, exprs []string, rest string, fn func(row []sql.NullString) error) error {
+	mask := make([]string, len(exprs))
+	for i, x := range exprs {
+		mask[i] = fmt.Sprintf("ISNULL(%s)", x)
+	}
+	stmt := fmt.Sprintf("SELECT CONCAT(%s), %s %s", strings.Join(mask, ", "), strings.Join(exprs, ", "), rest)
+
+	args := []string{
+		"-h", creds.Host,
+		"-P", fmt.Sprintf("%d", creds.Port),
+		"-u", creds.User,
+		"--batch",
+		"--quick",
+		"--skip-column-names",
+		"--init-command=SET time_zone = '+00:00'",
+		"-e", stmt,
+	}
+	if dbName != "" {
+		args = append(args, dbName)
+	}
+
+	cmd := util.Command("mysql", args...)
+	cmd.Env = e.getEnv(creds)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start mysql: %w", err)
+	}
+
+	readErr := readRows(bufio.NewReaderSize(stdout, 1<<20), len(exprs), fn)
+	if readErr != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return readErr
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("query failed: %s, output: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func readRows(r *bufio.Reader, width int, fn func(row []sql.NullString) error) error {
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != width+1 || len(fields[0]) != width {
+			return fmt.Errorf("unexpected mysql output row with %d fields", len(fields))
+		}
+		row := make([]sql.NullString, width)
+		for i := range row {
+			if fields[0][i] == '1' {
+				continue
+			}
+			row[i] = sql.NullString{String: unescape(fields[i+1]), Valid: true}
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
+
+var bitWidth = regexp.MustCompile(`^bit\((\d+)\)`)
+
+// columnExpr selects a column so its text form round-trips: binary values are
+// hex encoded in transit, BIT as a string of 0/1 and spatial types as WKT.
+func columnExpr(c schema.Column) (expr string, isHex bool) {
+	q := quoteIdent(c.Name)
+	switch c.DataType {
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		return "HEX(" + q + ")", true
+	case "bit":
+		width := "1"
+		if m := bitWidth.FindStringSubmatch(c.Type); m != nil {
+			width = m[1]
+		}
+		return fmt.Sprintf("LPAD(BIN(%s + 0), %s, '0')", q, width), false
+	case "geometry", "point", "linestring", "polygon", "multipoint",
+		"multilinestring", "multipolygon", "geometrycollection", "geomcollection":
+		return "ST_AsText(" + q + ")", false
+	}
+	return q, false
+}
+
+// ReadTable streams the rows of table. TIMESTAMP values are read in UTC.
+func (e *MySQLEngine) ReadTable(creds config.ServerConfig, dbName string, table schema.Table, opts engine.ReadOptions, fn func(row []sql.NullString) error) error {
+	exprs := make([]string, len(table.Columns))
+	hexCols := make([]bool, len(table.Columns))
+	for i, c := range table.Columns {
+		exprs[i], hexCols[i] = columnExpr(c)
+	}
+	rest := "FROM " + quoteIdent(dbName) + "." + quoteIdent(table.Name) + readClauses(table, opts)
+
+	return e.query(creds, dbName, exprs, rest, func(row []sql.NullString) error {
+		for i, isHex := range hexCols {
+			if !isHex || !row[i].Valid {
+				continue
+			}
+			raw, err := hex.DecodeString(row[i].String)
+			if err != nil {
+				return fmt.Errorf("column %s: %w", table.Columns[i].Name, err)
+			}
+			row[i].String = string(raw)
+		}
+		return fn(row)
+	})
+}
+
+// readClauses renders the WHERE, ORDER BY and LIMIT clauses for opts on
+// the columns of table.
+func readClauses(table schema.Table, opts engine.ReadOptions) string {
+	var where []string
+	if len(opts.After) > 0 {
+		where = append(where, fmt.Sprintf("(%s) > (%s)", quoteAll(opts.OrderBy, quoteIdent), keyLiterals(dataTypes(table, opts.OrderBy), opts.After)))
+	}
+	if opts.KeyColumn != "" {
+		if len(opts.KeyValues) == 0 {
+			where = append(where, "FALSE")
+		} else {
+			keyType := dataTypes(table, []string{opts.KeyColumn})[0]
+			values := quoteAll(opts.KeyValues, func(v string) string { return keyLiteral(keyType, v) })
+			where = append(where, fmt.Sprintf("%s IN (%s)", quoteIdent(opts.KeyColumn), values))
+		}
+	}
+
+	clauses := ""
+	if len(where) > 0 {
+		clauses += " WHERE " + strings.Join(where, " AND ")
+	}
+	if len(opts.OrderBy) > 0 {
+		clauses += " ORDER BY " + quoteAll(opts.OrderBy, quoteIdent)
+	}
+	if opts.Limit > 0 {
+		clauses += fmt.Sprintf(" LIMIT %d", opts.Limit)
+	}
+	return clauses
+}
+
+// dataTypes returns the data types of the named columns of table, or ""
+// for columns it does not have.
+func dataTypes(table schema.Table, names []string) []string {
+	types := make([]string, len(names))
+	for i, name := range names {
+		if c := table.Column(name); c != nil {
+			types[i] = c.DataType
+		}
+	}
+	return types
+}
+
+var numericLiteral = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// keyLiteral renders v as a literal to compare with a column of dataType.
+// Numbers stay unquoted: compared with a string, MySQL converts both sides
+// to DOUBLE, which loses the precision of large BIGINT and DECIMAL keys and
+// keeps the index from being used.
+func keyLiteral(dataType, v string) string {
+	switch dataType {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "decimal", "numeric":
+		if numericLiteral.MatchString(v) {
+			return v
+		}
+	}
+	return quoteString(v)
+}
+
+// keyLiterals renders values as literals for columns of types, in order.
+func keyLiterals(types []string, values []string) string {
+	literals := make([]string, len(values))
+	for i, v := range values {
+		dataType := ""
+		if i < len(types) {
+			dataType = types[i]
+		}
+		literals[i] = keyLiteral(dataType, v)
+	}
+	return strings.Join(literals, ", ")
+}
+
+func quoteAll(values []string, quote func(string) string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = quote(v)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// InspectSchema reads tables, columns, indexes, foreign keys, checks, views
+// and routines from information_schema.
+func (e *MySQLEngine) InspectSchema(creds config.ServerConfig, dbName string) (*schema.Schema, error) {
+	s := &schema.Schema{Engine: e.ID(), Database: dbName}
+	db := quoteString(dbName)
+
+	// Columns of base tables, in table and ordinal order
+	err := e.query(creds, "",
+		[]string{"c.TABLE_NAME", "c.COLUMN_NAME", "c.COLUMN_TYPE", "c.DATA_TYPE", "c.IS_NULLABLE", "c.COLUMN_DEFAULT", "c.EXTRA"},
+		"FROM information_schema.COLUMNS c JOIN information_schema.TABLES t"+
+			" ON t.TABLE_SCHEMA = c.TABLE_SCHEMA AND t.TABLE_NAME = c.TABLE_NAME"+
+			" WHERE c.TABLE_SCHEMA = "+db+" AND t.TABLE_TYPE = 'BASE TABLE'"+
+			" ORDER BY c.TABLE_NAME, c.ORDINAL_POSITION",
+		func(row []sql.NullString) error {
+			name := row[0].String
+			if len(s.Tables) == 0 || s.Tables[len(s.Tables)-1].Name != name {
+				s.Tables = append(s.Tables, schema.Table{Name: name})
+			}
+			col := schema.Column{
+				Name:     row[1].String,
+				Type:     strings.ToLower(row[2].String),
+				DataType: strings.ToLower(row[3].String),
+				Nullable: row[4].String == "YES",
+				Extra:    row[6].String,
+			}
+			if row[5].Valid {
+				def := row[5].String
+				col.Default = &def
+			}
+			if strings.Contains(strings.ToLower(col.Extra), "auto_increment") {
+				col.AutoIncrement = true
+			}
+			t := &s.Tables[len(s.Tables)-1]
+			t.Columns = append(t.Columns, col)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns: %w", err)
+	}
+
+	// Indexes, one row per indexed column
+	err = e.query(creds, "",
+		[]string{"TABLE_NAME", "INDEX_NAME", "NON_UNIQUE", "COLUMN_NAME", "SUB_PART", "INDEX_TYPE"},
+		"FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = "+db+
+			" ORDER BY TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX",
+		func(row []sql.NullString) error {
+			t := s.Table(row[0].String)
+			if t == nil {
+				return nil
+			}
+			if row[1].String == "PRIMARY" {
+				t.PrimaryKey = append(t.PrimaryKey, row[3].String)
+				return nil
+			}
+			if len(t.Indexes) == 0 || t.Indexes[len(t.Indexes)-1].Name != row[1].String {
+				idx := schema.Index{Name: row[1].String, Unique: row[2].String == "0"}
+				if kind := row[5].String; kind != "BTREE" && kind != "HASH" {
+					idx.Kind = kind
+				}
+				t.Indexes = append(t.Indexes, idx)
+			}
+			idx := &t.Indexes[len(t.Indexes)-1]
+			// Functional indexes (MySQL 8) have no column name
+			if !row[3].Valid {
+				idx.Kind = "FUNCTIONAL"
+				return nil
+			}
+			idx.Columns = append(idx.Columns, row[3].String)
+			if row[4].Valid {
+				idx.Partial = true
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read indexes: %w", err)
+	}
+
+	// Foreign keys, one row per referencing column
+	err = e.query(creds, "",
+		[]string{"k.TABLE_NAME", "k.CONSTRAINT_NAME", "k.COLUMN_NAME", "k.REFERENCED_TABLE_SCHEMA", "k.REFERENCED_TABLE_NAME", "k.REFERENCED_COLUMN_NAME", "r.UPDATE_RULE", "r.DELETE_RULE"},
+		"FROM information_schema.KEY_COLUMN_USAGE k JOIN information_schema.REFERENTIAL_CONSTRAINTS r"+
+			" ON r.CONSTRAINT_SCHEMA = k.CONSTRAINT_SCHEMA AND r.CONSTRAINT_NAME = k.CONSTRAINT_NAME AND r.TABLE_NAME = k.TABLE_NAME"+
+			" WHERE k.TABLE_SCHEMA = "+db+" AND k.REFERENCED_TABLE_NAME IS NOT NULL"+
+			" ORDER BY k.TABLE_NAME, k.CONSTRAINT_NAME, k.ORDINAL_POSITION",
+		func(row []sql.NullString) error {
+			t := s.Table(row[0].String)
+			if t == nil {
+				return nil
+			}
+			if len(t.ForeignKeys) == 0 || t.ForeignKeys[len(t.ForeignKeys)-1].Name != row[1].String {
+				refTable := row[4].String
+				if row[3].String != dbName {
+					refTable = row[3].String + "." + refTable
+				}
+				t.ForeignKeys = append(t.ForeignKeys, schema.ForeignKey{
+					Name:     row[1].String,
+					RefTable: refTable,
+					OnUpdate: row[6].String,
+					OnDelete: row[7].String,
+				})
+			}
+			fk := &t.ForeignKeys[len(t.ForeignKeys)-1]
+			fk.Columns = append(fk.Columns, row[2].String)
+			fk.RefColumns = append(fk.RefColumns, row[5].String)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read foreign keys: %w", err)
+	}
+
+	// CHECK constraints; MySQL before 8.0.16 has no CHECK_CONSTRAINTS table
+	// and never enforced them, so there is nothing to miss
+	e.query(creds, "",
+		[]string{"t.TABLE_NAME", "t.CONSTRAINT_NAME", "c.CHECK_CLAUSE"},
+		"FROM information_schema.TABLE_CONSTRAINTS t JOIN information_schema.CHECK_CONSTRAINTS c"+
+			" ON c.CONSTRAINT_SCHEMA = t.CONSTRAINT_SCHEMA AND c.CONSTRAINT_NAME = t.CONSTRAINT_NAME"+
+			" WHERE t.TABLE_SCHEMA = "+db+" AND t.CONSTRAINT_TYPE = 'CHECK'"+
+			" ORDER BY t.TABLE_NAME, t.CONSTRAINT_NAME",
+		func(row []sql.NullString) error {
+			if t := s.Table(row[0].String); t != nil {
+				t.Checks = append(t.Checks, schema.Check{Name: row[1].String, Expression: row[2].String})
+			}
+			return nil
+		})
+
+	// Views; references into the same database are unqualified so databases
+	// of different names compare equal
+	err = e.query(creds, "",
+		[]string{"TABLE_NAME", "VIEW_DEFINITION"},
+		"FROM information_schema.VIEWS WHERE TABLE_SCHEMA = "+db+" ORDER BY TABLE_NAME",
+		func(row []sql.NullString) error {
+			s.Views = append(s.Views, schema.View{
+				Name:       row[0].String,
+				Definition: strings.ReplaceAll(row[1].String, quoteIdent(dbName)+".", ""),
+			})
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read views: %w", err)
+	}
+
+	// Routines with their parameters and return type
+	err = e.query(creds, "",
+		[]string{"r.ROUTINE_NAME", "r.ROUTINE_TYPE", "r.DTD_IDENTIFIER", "r.ROUTINE_DEFINITION",
+			"(SELECT GROUP_CONCAT(CONCAT_WS(' ', p.PARAMETER_MODE, p.PARAMETER_NAME, p.DTD_IDENTIFIER) ORDER BY p.ORDINAL_POSITION SEPARATOR ', ')" +
+				" FROM information_schema.PARAMETERS p WHERE p.SPECIFIC_SCHEMA = r.ROUTINE_SCHEMA" +
+				" AND p.SPECIFIC_NAME = r.SPECIFIC_NAME AND p.ROUTINE_TYPE = r.ROUTINE_TYPE AND p.ORDINAL_POSITION > 0)"},
+		"FROM information_schema.ROUTINES r WHERE r.ROUTINE_SCHEMA = "+db+" ORDER BY r.ROUTINE_TYPE, r.ROUTINE_NAME",
+		func(row []sql.NullString) error {
+			def := "(" + row[4].String + ")"
+			if row[2].Valid {
+				def += " RETURNS " + row[2].String
+			}
+			s.Routines = append(s.Routines, schema.Routine{
+				Name:       row[0].String,
+				Kind:       row[1].String,
+				Definition: def + "\n" + row[3].String,
+			})
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routines: %w", err)
+	}
+
+	return s, nil
+}
+
+// ExecSQL runs query in dbName and returns the result rows tab separated.
+func (e *MySQLEngine) ExecSQL(creds config.ServerConfig, dbName string, query string) (string, error) {
+	args := []string{
+		"-h", creds.Host,
+		"-P", fmt.Sprintf("%d", creds.Port),
+		"-u", creds.User,
+		"--batch",
+		"--skip-column-names",
+	}
+	if dbName != "" {
+		args = append(args, dbName)
+	}
+
+	cmd := util.Command("mysql", args...)
+	cmd.Env = e.getEnv(creds)
+	cmd.Stdin = strings.NewReader(query)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("sql failed: %s, output: %s", err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// CreateDatabase creates dbName if it does not exist yet.
+func (e *MySQLEngine) CreateDatabase(creds config.ServerConfig, dbName string) error {
+	_, err := e.ExecSQL(creds, "", "CREATE DATABASE IF NOT EXISTS "+quoteIdent(dbName)+";")
+	return err
+}
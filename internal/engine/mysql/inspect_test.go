@@ -0,0 +1,43 @@
+package mysql
+
+import (
+	"testing"
+
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/schema"
+)
+
+func TestKeyBounds(t *testing.T) {
+	table := schema.Table{Name: "orders", Columns: []schema.Column{
+		{Name: "id", DataType: "bigint"},
+		{Name: "code", DataType: "varchar"},
+		{Name: "amount", DataType: "decimal"},
+	}}
+	for _, tt := range []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"bigint after", readClauses(table, engine.ReadOptions{OrderBy: []string{"id"}, After: []string{"9007199254740993"}, Limit: 10}),
+			" WHERE (`id`) > (9007199254740993) ORDER BY `id` LIMIT 10"},
+		{"composite after", readClauses(table, engine.ReadOptions{OrderBy: []string{"code", "id"}, After: []string{"0123", "-5"}}),
+			" WHERE (`code`, `id`) > ('0123', -5) ORDER BY `code`, `id`"},
+		{"key values", readClauses(table, engine.ReadOptions{KeyColumn: "id", KeyValues: []string{"1", "2"}}),
+			" WHERE `id` IN (1, 2)"},
+		{"string key values", readClauses(table, engine.ReadOptions{KeyColumn: "code", KeyValues: []string{"1", "it's"}}),
+			" WHERE `code` IN ('1', 'it''s')"},
+		{"unknown column", readClauses(table, engine.ReadOptions{OrderBy: []string{"other"}, After: []string{"1"}}),
+			" WHERE (`other`) > ('1') ORDER BY `other`"},
+		{"not a number", readClauses(table, engine.ReadOptions{OrderBy: []string{"id"}, After: []string{"1 OR 1=1"}}),
+			" WHERE (`id`) > ('1 OR 1=1') ORDER BY `id`"},
+		{"range", rangeClause(engine.KeyRange{Key: []string{"amount", "code"}, KeyTypes: []string{"decimal", "varchar"}, After: []string{"10.50", "a"}, Upto: []string{"20", "b"}}),
+			" WHERE (`amount`, `code`) > (10.50, 'a') AND (`amount`, `code`) <= (20, 'b')"},
+		{"range without types", rangeClause(engine.KeyRange{Key: []string{"id"}, Upto: []string{"7"}}),
+			" WHERE (`id`) <= ('7')"},
+		{"full range", rangeClause(engine.KeyRange{Key: []string{"id"}, KeyTypes: []string{"int"}}), ""},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, tt.got, tt.want)
+		}
+	}
+}
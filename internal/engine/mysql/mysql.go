@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -43,7 +44,7 @@ func (e *MySQLEngine) ListDatabases(creds config.ServerConfig) ([]string, error)
 		"--skip-column-names",
 	}
 
-	cmd := exec.Command("mysql", args...)
+	cmd := util.Command("mysql", args...)
 	cmd.Env = e.getEnv(creds)
 
 	output, err := cmd.CombinedOutput()
@@ -69,6 +70,15 @@ func (e *MySQLEngine) ListDatabases(creds config.ServerConfig) ([]string, error)
 }
 
 func (e *MySQLEngine) BackupDatabase(creds config.ServerConfig, dbName string, destPath string) error {
+	return util.RunDumpToFile(e.dumpCmd(creds, dbName), destPath)
+}
+
+// BackupDatabaseTo streams the compressed dump of dbName to w.
+func (e *MySQLEngine) BackupDatabaseTo(creds config.ServerConfig, dbName string, w io.Writer) error {
+	return util.RunDump(e.dumpCmd(creds, dbName), w)
+}
+
+func (e *MySQLEngine) dumpCmd(creds config.ServerConfig, dbName string) *exec.Cmd {
 	// mysqldump ...
 	args := []string{
 		"-h", creds.Host,
@@ -80,10 +90,9 @@ func (e *MySQLEngine) BackupDatabase(creds config.ServerConfig, dbName string, d
 		"--databases", dbName,
 	}
 
-	cmd := exec.Command("mysqldump", args...)
+	cmd := util.Command("mysqldump", args...)
 	cmd.Env = e.getEnv(creds)
-
-	return util.RunDumpToFile(cmd, destPath)
+	return cmd
 }
 
 func (e *MySQLEngine) BackupAll(creds config.ServerConfig, destDir string) ([]engine.BackupResult, error) {
@@ -96,7 +105,7 @@ func (e *MySQLEngine) BackupAll(creds config.ServerConfig, destDir string) ([]en
 	timestamp := time.Now().Format("2006-01-02T15:04:05Z")
 
 	for _, db := range dbs {
-		filename := fmt.Sprintf("%s_%s.sql.gz", db, timestamp)
+		filename := fmt.Sprintf("%s_%s%s", db, timestamp, util.CompressedExt(".sql"))
 		destPath := filepath.Join(destDir, filename)
 		
 		err := e.BackupDatabase(creds, db, destPath)
@@ -111,6 +120,31 @@ func (e *MySQLEngine) BackupAll(creds config.ServerConfig, destDir string) ([]en
 	return results, nil
 }
 
+// BackupAllTo streams one dump per database to the writers create returns.
+func (e *MySQLEngine) BackupAllTo(creds config.ServerConfig, create func(filename string) (io.Writer, error)) ([]engine.BackupResult, error) {
+	dbs, err := e.ListDatabases(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []engine.BackupResult
+	timestamp := time.Now().Format("2006-01-02T15:04:05Z")
+
+	for _, db := range dbs {
+		filename := fmt.Sprintf("%s_%s%s", db, timestamp, util.CompressedExt(".sql"))
+		w, err := create(filename)
+		if err == nil {
+			err = e.BackupDatabaseTo(creds, db, w)
+		}
+		results = append(results, engine.BackupResult{
+			Database: db,
+			Filename: filename,
+			Error:    err,
+		})
+	}
+	return results, nil
+}
+
 func (e *MySQLEngine) RestoreBackup(creds config.ServerConfig, filePath string, dbName string) error {
 	// mysql ...
 	args := []string{
@@ -125,7 +159,7 @@ func (e *MySQLEngine) RestoreBackup(creds config.ServerConfig, filePath string,
 	// For now, assume we restore what's in the file.
     // The caller might pass dbName as context, but often with mysqldump it's embedded.
 	
-	cmd := exec.Command("mysql", args...)
+	cmd := util.Command("mysql", args...)
 	cmd.Env = e.getEnv(creds)
 
 	return util.RestoreFromFile(cmd, filePath)
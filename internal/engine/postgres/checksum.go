@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+)
+
+// rangeClause renders the WHERE clause selecting the keys of r.
+func rangeClause(r engine.KeyRange) string {
+	var where []string
+	if len(r.After) > 0 {
+		where = append(where, fmt.Sprintf("(%s) > (%s)", quoteAll(r.Key, QuoteIdent), quoteAll(r.After, QuoteString)))
+	}
+	if len(r.Upto) > 0 {
+		where = append(where, fmt.Sprintf("(%s) <= (%s)", quoteAll(r.Key, QuoteIdent), quoteAll(r.Upto, QuoteString)))
+	}
+	if len(where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(where, " AND ")
+}
+
+// rowHash hashes the text form of a row of the compared columns, which
+// keeps NULL distinct from empty values.
+func rowHash(columns []string) string {
+	return "md5(ROW(" + quoteAll(columns, QuoteIdent) + ")::text)"
+}
+
+// HashRange counts the rows of r and sums the first 60 bits of their hashes.
+func (e *PostgresEngine) HashRange(creds config.ServerConfig, dbName string, r engine.KeyRange) (int64, string, error) {
+	var count int64
+	var sum string
+	err := e.copyOut(creds, dbName,
+		fmt.Sprintf("SELECT count(*), coalesce(sum(('x' || substr(%s, 1, 15))::bit(60)::bigint), 0) FROM %s%s",
+			rowHash(r.Columns), QuoteIdent(r.Table), rangeClause(r)),
+		func(row []sql.NullString) error {
+			if _, err := fmt.Sscan(row[0].String, &count); err != nil {
+				return fmt.Errorf("unexpected count %q", row[0].String)
+			}
+			sum = row[1].String
+			return nil
+		})
+	return count, sum, err
+}
+
+// KeyAt returns the key of the row at offset n of r.
+func (e *PostgresEngine) KeyAt(creds config.ServerConfig, dbName string, r engine.KeyRange, n int64) ([]string, error) {
+	var key []string
+	err := e.copyOut(creds, dbName,
+		fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s LIMIT 1 OFFSET %d",
+			quoteAll(r.Key, QuoteIdent), QuoteIdent(r.Table), rangeClause(r), quoteAll(r.Key, QuoteIdent), n),
+		func(row []sql.NullString) error {
+			key = rowStrings(row)
+			return nil
+		})
+	return key, err
+}
+
+// HashRows returns the key and hash of every row of r.
+func (e *PostgresEngine) HashRows(creds config.ServerConfig, dbName string, r engine.KeyRange) ([]engine.RowHash, error) {
+	var rows []engine.RowHash
+	err := e.copyOut(creds, dbName,
+		fmt.Sprintf("SELECT %s, %s FROM %s%s ORDER BY %s",
+			quoteAll(r.Key, QuoteIdent), rowHash(r.Columns), QuoteIdent(r.Table), rangeClause(r), quoteAll(r.Key, QuoteIdent)),
+		func(row []sql.NullString) error {
+			values := rowStrings(row)
+			rows = append(rows, engine.RowHash{Key: values[:len(r.Key)], Hash: values[len(r.Key)]})
+			return nil
+		})
+	return rows, err
+}
+
+func rowStrings(row []sql.NullString) []string {
+	values := make([]string, len(row))
+	for i, v := range row {
+		values[i] = v.String
+	}
+	return values
+}
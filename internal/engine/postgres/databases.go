@@ -0,0 +1,271 @@
+package postgres
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// createDatabase matches the CREATE DATABASE and \connect lines of pg_dump -C and pg_dumpall.
+var createDatabase = regexp.MustCompile(`^(?:CREATE DATABASE|\\connect(?: -reuse-previous=on)?) ("(?:[^"]|"")+"|[^\s;]+)`)
+
+// BackupDatabases lists the databases a dump creates or connects to. Only
+// the header is read: it stops at the first COPY of table data, as dumps
+// made by dbmigrate hold one database.
+func (e *PostgresEngine) BackupDatabases(creds config.ServerConfig, filePath string) ([]string, error) {
+	var dbs []string
+	seen := make(map[string]bool)
+	err := util.ScanCompressedLines(filePath, func(line string) bool {
+		if strings.HasPrefix(line, "COPY ") || strings.HasPrefix(line, "INSERT INTO ") {
+			return false
+		}
+		m := createDatabase.FindStringSubmatch(line)
+		if m == nil {
+			return true
+		}
+		name := unquoteIdent(m[1])
+		// pg_dumpall connects to template1 to set up roles, it is not restored into
+		if name != "template1" && !seen[name] {
+			seen[name] = true
+			dbs = append(dbs, name)
+		}
+		return true
+	})
+	return dbs, err
+}
+
+// createTable matches the start of a CREATE TABLE statement of pg_dump,
+// whose table names are qualified with their schema.
+var createTable = regexp.MustCompile(`^CREATE (?:UNLOGGED )?TABLE (?:IF NOT EXISTS )?((?:"(?:[^"]|"")+"|[^\s(."]+)(?:\.(?:"(?:[^"]|"")+"|[^\s(."]+))?)`)
+
+// BackupContents lists the CREATE TABLE statements of a dump, with the
+// database they follow the \connect line of.
+func (e *PostgresEngine) BackupContents(filePath string) ([]engine.BackupObject, error) {
+	var objects []engine.BackupObject
+	current := ""
+	err := util.ScanCreateTables(filePath, func(line string) {
+		if m := createDatabase.FindStringSubmatch(line); m != nil && strings.HasPrefix(line, `\connect`) {
+			current = unquoteIdent(m[1])
+		}
+	}, func(stmt string) {
+		m := createTable.FindStringSubmatch(stmt)
+		if m == nil {
+			return
+		}
+		var parts []string
+		for _, part := range splitQualified(m[1]) {
+			parts = append(parts, unquoteIdent(part))
+		}
+		objects = append(objects, engine.BackupObject{Database: current, Name: strings.Join(parts, "."), Definition: stmt})
+	})
+	return objects, err
+}
+
+// splitQualified splits schema.table at the dot outside quotes.
+func splitQualified(name string) []string {
+	quoted := false
+	for i, c := range name {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '.' && !quoted:
+			return []string{name[:i], name[i+1:]}
+		}
+	}
+	return []string{name}
+}
+
+// unquoteIdent removes the double quotes around an identifier.
+func unquoteIdent(name string) string {
+	if len(name) >= 2 && strings.HasPrefix(name, `"`) && strings.HasSuffix(name, `"`) {
+		return strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+	}
+	return name
+}
+
+// ListTables lists the tables of dbName outside the system schemas.
+func (e *PostgresEngine) ListTables(creds config.ServerConfig, dbName string) ([]string, error) {
+	out, err := e.ExecSQL(creds, dbName, `SELECT schemaname || '.' || tablename FROM pg_tables
+		WHERE schemaname NOT IN ('pg_catalog', 'information_schema') ORDER BY 1;`)
+	if err != nil {
+		return nil, err
+	}
+	var tables []string
+	for _, line := range strings.Split(out, "\n") {
+		if line != "" {
+			tables = append(tables, line)
+		}
+	}
+	return tables, nil
+}
+
+// databaseStatement matches the statements of pg_dump -C that name the
+// dumped database.
+var databaseStatement = regexp.MustCompile(`^(CREATE DATABASE|ALTER DATABASE|COMMENT ON DATABASE|(?:GRANT|REVOKE) [^;]*? ON DATABASE|\\connect(?: -reuse-previous=on)?) ("(?:[^"]|"")+"|[^\s;]+)`)
+
+// connectTarget returns the database a \connect of pg_dump names: an
+// identifier, or for names beyond [A-Za-z0-9_.] a connection string
+// "dbname='...'" quoted as an identifier.
+func connectTarget(arg string) string {
+	name := unquoteIdent(arg)
+	value, ok := strings.CutPrefix(name, "dbname=")
+	if !ok || arg == name {
+		return name
+	}
+	if len(value) >= 2 && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") {
+		var b strings.Builder
+		escaped := false
+		for _, c := range value[1 : len(value)-1] {
+			if c == '\\' && !escaped {
+				escaped = true
+				continue
+			}
+			escaped = false
+			b.WriteRune(c)
+		}
+		value = b.String()
+	}
+	return value
+}
+
+// connectLine renders a \connect to dbName the way pg_dump does for names
+// that need quoting.
+func connectLine(dbName string) string {
+	value := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(dbName)
+	return `\connect -reuse-previous=on ` + QuoteIdent("dbname='"+value+"'") + "\n"
+}
+
+// renameDatabase returns a rewrite for the lines of a pg_dump -C file that
+// makes the statements naming the dumped database name dbName instead.
+// COPY data is passed through.
+func renameDatabase(dbName string) func(line string) string {
+	original := ""
+	inCopy := false
+	return func(line string) string {
+		if inCopy {
+			inCopy = line != "\\.\n"
+			return line
+		}
+		if strings.HasPrefix(line, "COPY ") && strings.HasSuffix(line, "FROM stdin;\n") {
+			inCopy = true
+			return line
+		}
+		m := databaseStatement.FindStringSubmatchIndex(line)
+		if m == nil {
+			return line
+		}
+		if strings.HasPrefix(line, `\connect`) {
+			if original == "" || connectTarget(line[m[4]:m[5]]) != original {
+				return line
+			}
+			return connectLine(dbName)
+		}
+		name := unquoteIdent(line[m[4]:m[5]])
+		if original == "" && strings.HasPrefix(line, "CREATE DATABASE") {
+			original = name
+		}
+		if name != original {
+			return line
+		}
+		return line[:m[4]] + QuoteIdent(dbName) + line[m[5]:]
+	}
+}
+
+// RestoreBackupAs restores a pg_dump -C file into dbName by rewriting the
+// statements that name the original database.
+func (e *PostgresEngine) RestoreBackupAs(creds config.ServerConfig, filePath string, dbName string) error {
+	rewrite := renameDatabase(dbName)
+
+	// Like RestoreBackup, errors (e.g. missing roles) do not stop the restore
+	args := []string{
+		"-h", creds.Host,
+		"-p", fmt.Sprintf("%d", creds.Port),
+		"-U", creds.User,
+		"-d", "postgres",
+	}
+	cmd := util.Command("psql", args...)
+	cmd.Env = e.getEnv(creds)
+	return util.RestoreFromFileRewriting(cmd, filePath, rewrite)
+}
+
+// DropDatabase drops dbName if it exists.
+func (e *PostgresEngine) DropDatabase(creds config.ServerConfig, dbName string) error {
+	_, err := e.ExecSQL(creds, "postgres", "DROP DATABASE IF EXISTS "+QuoteIdent(dbName)+";")
+	return err
+}
+
+// userTable names a table relative to the current schema, matching the
+// table names of InspectSchema; tables elsewhere are schema qualified.
+const userTable = `CASE WHEN n.nspname = current_schema() THEN c.relname ELSE n.nspname || '.' || c.relname END`
+
+// userTables selects the tables of dbName outside the system schemas.
+const userTables = `FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relkind IN ('r', 'p') AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+	AND n.nspname NOT LIKE 'pg_toast%'`
+
+// CountRows counts the rows of every table of dbName outside the system schemas.
+func (e *PostgresEngine) CountRows(creds config.ServerConfig, dbName string) (map[string]int64, error) {
+	out, err := e.ExecSQL(creds, dbName, "SELECT "+userTable+", n.nspname, c.relname "+userTables+" ORDER BY 1;")
+	if err != nil {
+		return nil, err
+	}
+	var selects []string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		selects = append(selects, fmt.Sprintf("SELECT %s, count(*) FROM %s.%s",
+			QuoteString(fields[0]), QuoteIdent(fields[1]), QuoteIdent(fields[2])))
+	}
+
+	if len(selects) == 0 {
+		return make(map[string]int64), nil
+	}
+	out, err = e.ExecSQL(creds, dbName, strings.Join(selects, "\nUNION ALL\n")+";")
+	if err != nil {
+		return nil, err
+	}
+	return parseCounts(out)
+}
+
+// EstimateRows reads the planner's row estimates from pg_class. Tables that
+// were never analyzed count as empty.
+func (e *PostgresEngine) EstimateRows(creds config.ServerConfig, dbName string) (map[string]int64, error) {
+	out, err := e.ExecSQL(creds, dbName, "SELECT "+userTable+", GREATEST(c.reltuples, 0)::bigint "+userTables+";")
+	if err != nil {
+		return nil, err
+	}
+	return parseCounts(out)
+}
+
+// EstimateSize returns pg_database_size of dbName.
+func (e *PostgresEngine) EstimateSize(creds config.ServerConfig, dbName string) (int64, error) {
+	out, err := e.ExecSQL(creds, "postgres", "SELECT pg_database_size("+QuoteString(dbName)+");")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+}
+
+// parseCounts reads "table<TAB>count" rows.
+func parseCounts(out string) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	for _, line := range strings.Split(out, "\n") {
+		name, count, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(count, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected count output: %q", line)
+		}
+		counts[name] = n
+	}
+	return counts, nil
+}
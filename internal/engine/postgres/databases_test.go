@@ -0,0 +1,131 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+)
+
+// rewriteLines passes dump line by line through rewrite, as
+// util.RestoreFromFileRewriting does.
+func rewriteLines(dump string, rewrite func(line string) string) string {
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(dump, "\n") {
+		if line != "" {
+			b.WriteString(rewrite(line))
+		}
+	}
+	return b.String()
+}
+
+// pgDump returns the header pg_dump -C writes for a database created as
+// ident, with name being ident unquoted, and connect the \connect argument.
+func pgDump(ident, connect string) string {
+	return `--
+-- PostgreSQL database dump
+--
+
+SET statement_timeout = 0;
+SET client_encoding = 'UTF8';
+
+--
+-- Name: ` + ident + `; Type: DATABASE; Schema: -; Owner: postgres
+--
+
+CREATE DATABASE ` + ident + ` WITH TEMPLATE = template0 ENCODING = 'UTF8' LOCALE_PROVIDER = libc LOCALE = 'en_US.UTF-8';
+
+
+ALTER DATABASE ` + ident + ` OWNER TO postgres;
+
+\connect ` + connect + `
+
+SET statement_timeout = 0;
+SELECT pg_catalog.set_config('search_path', '', false);
+
+--
+-- Name: DATABASE ` + ident + `; Type: COMMENT; Schema: -; Owner: postgres
+--
+
+COMMENT ON DATABASE ` + ident + ` IS 'CREATE DATABASE other';
+
+CREATE TABLE public.notes (
+    id integer NOT NULL,
+    body text
+);
+
+COPY public.notes (id, body) FROM stdin;
+1	CREATE DATABASE ` + ident + `
+2	\connect ` + connect + `
+\.
+
+
+--
+-- Name: DATABASE ` + ident + `; Type: ACL; Schema: -; Owner: postgres
+--
+
+REVOKE CONNECT,TEMPORARY ON DATABASE ` + ident + ` FROM PUBLIC;
+GRANT CONNECT ON DATABASE ` + ident + ` TO app;
+GRANT ALL ON DATABASE other TO app;
+
+--
+-- PostgreSQL database dump complete
+--
+`
+}
+
+func TestRenameDatabase(t *testing.T) {
+	tests := []struct {
+		name           string
+		ident, connect string
+		dbName         string
+		want           string
+	}{
+		{"plain names", "shop", "shop", "shop_copy", `"shop_copy"`},
+		{"quoted name", `"My Shop"`, `-reuse-previous=on "dbname='My Shop'"`, "My Shop 2", `"My Shop 2"`},
+		{"quotes in names", `"it's ""big"""`, `-reuse-previous=on "dbname='it\'s ""big""'"`, `a\b's "copy"`, `"a\b's ""copy"""`},
+		{"quoted to plain", `"my-shop"`, `-reuse-previous=on "dbname='my-shop'"`, "shop", `"shop"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dump := pgDump(tt.ident, tt.connect)
+			got := rewriteLines(dump, renameDatabase(tt.dbName))
+			want := strings.ReplaceAll(dump, "\\connect "+tt.connect+"\n\n", `\connect -reuse-previous=on `+QuoteIdent("dbname='"+
+				strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(tt.dbName)+"'")+"\n\n")
+			for _, stmt := range []string{"CREATE DATABASE %s WITH", "ALTER DATABASE %s OWNER", "COMMENT ON DATABASE %s IS",
+				"ON DATABASE %s FROM", "ON DATABASE %s TO"} {
+				want = strings.Replace(want, strings.Replace(stmt, "%s", tt.ident, 1), strings.Replace(stmt, "%s", tt.want, 1), 1)
+			}
+			if got != want {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+			// Rows of COPY data are left alone
+			if !strings.Contains(got, "1\tCREATE DATABASE "+tt.ident+"\n2\t\\connect "+tt.connect+"\n") {
+				t.Errorf("COPY data was rewritten:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestConnectTarget(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"shop", "shop"},
+		{`"Shop"`, "Shop"},
+		{`"dbname='my-shop'"`, "my-shop"},
+		{`"dbname='it\'s a \\ ""test""'"`, `it's a \ "test"`},
+		{"dbname=shop", "dbname=shop"},
+	}
+	for _, tt := range tests {
+		if got := connectTarget(tt.arg); got != tt.want {
+			t.Errorf("connectTarget(%s) = %q, want %q", tt.arg, got, tt.want)
+		}
+		if tt.want != "dbname=shop" {
+			line := connectLine(tt.want)
+			m := databaseStatement.FindStringSubmatch(line)
+			if m == nil || connectTarget(m[2]) != tt.want {
+				t.Errorf("connectLine(%q) = %q does not name it", tt.want, line)
+			}
+		}
+	}
+}
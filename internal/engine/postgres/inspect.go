@@ -0,0 +1,352 @@
+package postgres
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/schema"
+)
+
+// copyOut runs COPY (<query>) TO STDOUT and streams the decoded rows to fn.
+// COPY text format keeps NULL (\N) distinct from empty strings.
+func (e *PostgresEngine) copyOut(creds config.ServerConfig, dbName string, query string, fn func(row []sql.NullString) error) error {
+	cmd := e.psql(creds, dbName, "-c", "COPY ("+query+") TO STDOUT")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start psql: %w", err)
+	}
+
+	readErr := readCopyRows(bufio.NewReaderSize(stdout, 1<<20), fn)
+	if readErr != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return readErr
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("query failed: %s, output: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func readCopyRows(r *bufio.Reader, fn func(row []sql.NullString) error) error {
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		fields := strings.Split(line, "\t")
+		row := make([]sql.NullString, len(fields))
+		for i, f := range fields {
+			if f == `\N` {
+				continue
+			}
+			row[i] = sql.NullString{String: unescapeCopy(f), Valid: true}
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
+
+// unescapeCopy reverses the backslash escapes of COPY text format.
+func unescapeCopy(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 == len(s) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'v':
+			b.WriteByte('\v')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// ReadTable streams the rows of table from the current schema.
+// Timestamps are read in UTC and bytea values are returned as raw bytes.
+func (e *PostgresEngine) ReadTable(creds config.ServerConfig, dbName string, table schema.Table, opts engine.ReadOptions, fn func(row []sql.NullString) error) error {
+	cols := make([]string, len(table.Columns))
+	for i, c := range table.Columns {
+		cols[i] = QuoteIdent(c.Name)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s%s", strings.Join(cols, ", "), QuoteIdent(table.Name), readClauses(opts))
+
+	return e.copyOut(creds, dbName, query, func(row []sql.NullString) error {
+		if len(row) != len(table.Columns) {
+			return fmt.Errorf("unexpected copy output row with %d fields", len(row))
+		}
+		for i, c := range table.Columns {
+			if c.DataType != "bytea" || !row[i].Valid {
+				continue
+			}
+			raw, err := hex.DecodeString(strings.TrimPrefix(row[i].String, `\x`))
+			if err != nil {
+				return fmt.Errorf("column %s: %w", c.Name, err)
+			}
+			row[i].String = string(raw)
+		}
+		return fn(row)
+	})
+}
+
+// readClauses renders the WHERE, ORDER BY and LIMIT clauses for opts.
+func readClauses(opts engine.ReadOptions) string {
+	var where []string
+	if len(opts.After) > 0 {
+		where = append(where, fmt.Sprintf("(%s) > (%s)", quoteAll(opts.OrderBy, QuoteIdent), quoteAll(opts.After, QuoteString)))
+	}
+	if opts.KeyColumn != "" {
+		if len(opts.KeyValues) == 0 {
+			where = append(where, "FALSE")
+		} else {
+			where = append(where, fmt.Sprintf("%s IN (%s)", QuoteIdent(opts.KeyColumn), quoteAll(opts.KeyValues, QuoteString)))
+		}
+	}
+
+	clauses := ""
+	if len(where) > 0 {
+		clauses += " WHERE " + strings.Join(where, " AND ")
+	}
+	if len(opts.OrderBy) > 0 {
+		clauses += " ORDER BY " + quoteAll(opts.OrderBy, QuoteIdent)
+	}
+	if opts.Limit > 0 {
+		clauses += fmt.Sprintf(" LIMIT %d", opts.Limit)
+	}
+	return clauses
+}
+
+func quoteAll(values []string, quote func(string) string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = quote(v)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// fkRules maps pg_constraint action codes to SQL.
+var fkRules = map[string]string{
+	"a": "NO ACTION",
+	"r": "RESTRICT",
+	"c": "CASCADE",
+	"n": "SET NULL",
+	"d": "SET DEFAULT",
+}
+
+// InspectSchema reads the tables, views and routines of the current schema
+// (usually public) from pg_catalog.
+func (e *PostgresEngine) InspectSchema(creds config.ServerConfig, dbName string) (*schema.Schema, error) {
+	s := &schema.Schema{Engine: e.ID(), Database: dbName}
+
+	// Columns of ordinary and partitioned tables
+	err := e.copyOut(creds, dbName, `
+		SELECT cl.relname, a.attname, format_type(a.atttypid, a.atttypmod), t.typname,
+		       NOT a.attnotnull, pg_get_expr(d.adbin, d.adrelid), a.attidentity, a.attgenerated
+		FROM pg_attribute a
+		JOIN pg_class cl ON cl.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = cl.relnamespace
+		JOIN pg_type t ON t.oid = a.atttypid
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE n.nspname = current_schema() AND cl.relkind IN ('r', 'p')
+		  AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY cl.relname, a.attnum`,
+		func(row []sql.NullString) error {
+			name := row[0].String
+			if len(s.Tables) == 0 || s.Tables[len(s.Tables)-1].Name != name {
+				s.Tables = append(s.Tables, schema.Table{Name: name})
+			}
+			col := schema.Column{
+				Name:     row[1].String,
+				Type:     row[2].String,
+				DataType: row[3].String,
+				Nullable: row[4].String == "t",
+			}
+			if row[5].Valid {
+				def := row[5].String
+				if strings.HasPrefix(def, "nextval(") {
+					col.AutoIncrement = true
+				} else if row[7].String == "s" {
+					col.Extra = "STORED GENERATED " + def
+				} else {
+					col.Default = &def
+				}
+			}
+			if row[6].String == "a" || row[6].String == "d" {
+				col.AutoIncrement = true
+			}
+			t := &s.Tables[len(s.Tables)-1]
+			t.Columns = append(t.Columns, col)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns: %w", err)
+	}
+
+	// Indexes, one row per key column; expression columns have no name
+	err = e.copyOut(creds, dbName, `
+		SELECT t.relname, i.relname, ix.indisprimary, ix.indisunique, a.attname, am.amname, ix.indpred IS NOT NULL,
+		       pg_get_indexdef(ix.indexrelid)
+		FROM pg_index ix
+		JOIN pg_class t ON t.oid = ix.indrelid
+		JOIN pg_class i ON i.oid = ix.indexrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		JOIN pg_am am ON am.oid = i.relam
+		CROSS JOIN LATERAL unnest(ix.indkey::int2[]) WITH ORDINALITY AS k(attnum, ord)
+		LEFT JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+		WHERE n.nspname = current_schema() AND k.ord <= ix.indnkeyatts
+		ORDER BY t.relname, i.relname, k.ord`,
+		func(row []sql.NullString) error {
+			t := s.Table(row[0].String)
+			if t == nil {
+				return nil
+			}
+			if row[2].String == "t" {
+				t.PrimaryKey = append(t.PrimaryKey, row[4].String)
+				return nil
+			}
+			if len(t.Indexes) == 0 || t.Indexes[len(t.Indexes)-1].Name != row[1].String {
+				idx := schema.Index{Name: row[1].String, Unique: row[3].String == "t"}
+				if kind := row[5].String; kind != "btree" {
+					idx.Kind = strings.ToUpper(kind)
+				}
+				if row[6].String == "t" {
+					idx.Partial = true
+				}
+				idx.Definition = row[7].String
+				t.Indexes = append(t.Indexes, idx)
+			}
+			idx := &t.Indexes[len(t.Indexes)-1]
+			if !row[4].Valid {
+				idx.Kind = "FUNCTIONAL"
+				return nil
+			}
+			idx.Columns = append(idx.Columns, row[4].String)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read indexes: %w", err)
+	}
+
+	// Foreign keys, one row per referencing column
+	err = e.copyOut(creds, dbName, `
+		SELECT cl.relname, con.conname, a.attname, rcl.relname, ra.attname, con.confupdtype, con.confdeltype
+		FROM pg_constraint con
+		JOIN pg_class cl ON cl.oid = con.conrelid
+		JOIN pg_namespace n ON n.oid = cl.relnamespace
+		JOIN pg_class rcl ON rcl.oid = con.confrelid
+		CROSS JOIN LATERAL unnest(con.conkey, con.confkey) WITH ORDINALITY AS k(attnum, refnum, ord)
+		JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum
+		JOIN pg_attribute ra ON ra.attrelid = con.confrelid AND ra.attnum = k.refnum
+		WHERE con.contype = 'f' AND n.nspname = current_schema()
+		ORDER BY cl.relname, con.conname, k.ord`,
+		func(row []sql.NullString) error {
+			t := s.Table(row[0].String)
+			if t == nil {
+				return nil
+			}
+			if len(t.ForeignKeys) == 0 || t.ForeignKeys[len(t.ForeignKeys)-1].Name != row[1].String {
+				t.ForeignKeys = append(t.ForeignKeys, schema.ForeignKey{
+					Name:     row[1].String,
+					RefTable: row[3].String,
+					OnUpdate: fkRules[row[5].String],
+					OnDelete: fkRules[row[6].String],
+				})
+			}
+			fk := &t.ForeignKeys[len(t.ForeignKeys)-1]
+			fk.Columns = append(fk.Columns, row[2].String)
+			fk.RefColumns = append(fk.RefColumns, row[4].String)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read foreign keys: %w", err)
+	}
+
+	err = e.copyOut(creds, dbName, `
+		SELECT cl.relname, con.conname, pg_get_constraintdef(con.oid)
+		FROM pg_constraint con
+		JOIN pg_class cl ON cl.oid = con.conrelid
+		JOIN pg_namespace n ON n.oid = cl.relnamespace
+		WHERE con.contype = 'c' AND n.nspname = current_schema()
+		ORDER BY cl.relname, con.conname`,
+		func(row []sql.NullString) error {
+			if t := s.Table(row[0].String); t != nil {
+				t.Checks = append(t.Checks, schema.Check{
+					Name:       row[1].String,
+					Expression: strings.TrimPrefix(row[2].String, "CHECK "),
+				})
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checks: %w", err)
+	}
+
+	err = e.copyOut(creds, dbName, `
+		SELECT c.relname, pg_get_viewdef(c.oid, true)
+		FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind = 'v' AND n.nspname = current_schema()
+		ORDER BY c.relname`,
+		func(row []sql.NullString) error {
+			s.Views = append(s.Views, schema.View{Name: row[0].String, Definition: row[1].String})
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read views: %w", err)
+	}
+
+	// Functions and procedures, without those installed by extensions
+	err = e.copyOut(creds, dbName, `
+		SELECT p.proname || '(' || pg_get_function_identity_arguments(p.oid) || ')',
+		       CASE p.prokind WHEN 'p' THEN 'PROCEDURE' ELSE 'FUNCTION' END,
+		       pg_get_functiondef(p.oid)
+		FROM pg_proc p JOIN pg_namespace n ON n.oid = p.pronamespace
+		WHERE n.nspname = current_schema() AND p.prokind IN ('f', 'p')
+		  AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_proc'::regclass
+		                  AND d.objid = p.oid AND d.deptype = 'e')
+		ORDER BY 1`,
+		func(row []sql.NullString) error {
+			s.Routines = append(s.Routines, schema.Routine{Name: row[0].String, Kind: row[1].String, Definition: row[2].String})
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routines: %w", err)
+	}
+
+	return s, nil
+}
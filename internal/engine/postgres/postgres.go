@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -45,7 +46,7 @@ func (e *PostgresEngine) ListDatabases(creds config.ServerConfig) ([]string, err
 		"-c", "SELECT datname FROM pg_database WHERE datistemplate = false;",
 	}
 
-	cmd := exec.Command("psql", args...)
+	cmd := util.Command("psql", args...)
 	cmd.Env = e.getEnv(creds)
 
 	output, err := cmd.CombinedOutput()
@@ -65,29 +66,21 @@ func (e *PostgresEngine) ListDatabases(creds config.ServerConfig) ([]string, err
 	return dbs, nil
 }
 
+// DumpAttempts retries dumps after transient failures, such as SSL SYSCALL
+// errors.
+func (e *PostgresEngine) DumpAttempts() int {
+	return 3
+}
+
 func (e *PostgresEngine) BackupDatabase(creds config.ServerConfig, dbName string, destPath string) error {
-	// pg_dump -C -F p ...
-	// -C: Include commands to create the database
-	// -F p: Output plain-text SQL script
-	
 	// Retries for transient failures
-	maxRetries := 3
+	maxRetries := e.DumpAttempts()
 	var lastErr error
 	
 	for i := 0; i < maxRetries; i++ {
-		args := []string{
-			"-h", creds.Host,
-			"-p", fmt.Sprintf("%d", creds.Port),
-			"-U", creds.User,
-			"-F", "p",
-			"-C",
-			dbName,
-		}
-
-		cmd := exec.Command("pg_dump", args...)
-		cmd.Env = e.getEnv(creds)
-
-		lastErr = util.RunDumpToFile(cmd, destPath)
+		lastErr = util.LogAttempt(i+1, func() error {
+			return util.RunDumpToFile(e.dumpCmd(creds, dbName), destPath)
+		})
 		if lastErr == nil {
 			return nil
 		}
@@ -99,6 +92,29 @@ func (e *PostgresEngine) BackupDatabase(creds config.ServerConfig, dbName string
 	return lastErr
 }
 
+// BackupDatabaseTo streams the compressed dump of dbName to w.
+func (e *PostgresEngine) BackupDatabaseTo(creds config.ServerConfig, dbName string, w io.Writer) error {
+	return util.RunDump(e.dumpCmd(creds, dbName), w)
+}
+
+func (e *PostgresEngine) dumpCmd(creds config.ServerConfig, dbName string) *exec.Cmd {
+	// pg_dump -C -F p ...
+	// -C: Include commands to create the database
+	// -F p: Output plain-text SQL script
+	args := []string{
+		"-h", creds.Host,
+		"-p", fmt.Sprintf("%d", creds.Port),
+		"-U", creds.User,
+		"-F", "p",
+		"-C",
+		dbName,
+	}
+
+	cmd := util.Command("pg_dump", args...)
+	cmd.Env = e.getEnv(creds)
+	return cmd
+}
+
 func (e *PostgresEngine) BackupAll(creds config.ServerConfig, destDir string) ([]engine.BackupResult, error) {
 	dbs, err := e.ListDatabases(creds)
 	if err != nil {
@@ -109,7 +125,7 @@ func (e *PostgresEngine) BackupAll(creds config.ServerConfig, destDir string) ([
 	timestamp := time.Now().Format("2006-01-02T15:04:05Z")
 
 	for _, db := range dbs {
-		filename := fmt.Sprintf("%s_%s.sql.gz", db, timestamp)
+		filename := fmt.Sprintf("%s_%s%s", db, timestamp, util.CompressedExt(".sql"))
 		destPath := filepath.Join(destDir, filename)
 		
 		err := e.BackupDatabase(creds, db, destPath)
@@ -124,6 +140,30 @@ func (e *PostgresEngine) BackupAll(creds config.ServerConfig, destDir string) ([
 	return results, nil
 }
 
+// BackupAllTo streams one dump per database to the writers create returns.
+func (e *PostgresEngine) BackupAllTo(creds config.ServerConfig, create func(filename string) (io.Writer, error)) ([]engine.BackupResult, error) {
+	dbs, err := e.ListDatabases(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []engine.BackupResult
+	timestamp := time.Now().Format("2006-01-02T15:04:05Z")
+
+	for _, db := range dbs {
+		filename := fmt.Sprintf("%s_%s%s", db, timestamp, util.CompressedExt(".sql"))
+		err := engine.RetryStream(e.DumpAttempts(), filename, create, func(w io.Writer) error {
+			return e.BackupDatabaseTo(creds, db, w)
+		})
+		results = append(results, engine.BackupResult{
+			Database: db,
+			Filename: filename,
+			Error:    err,
+		})
+	}
+	return results, nil
+}
+
 func (e *PostgresEngine) RestoreBackup(creds config.ServerConfig, filePath string, dbName string) error {
 	// psql -h target -U user -d postgres (since the file contains CREATE DATABASE, we connect to postgres)
 	args := []string{
@@ -133,7 +173,7 @@ func (e *PostgresEngine) RestoreBackup(creds config.ServerConfig, filePath strin
 		"-d", "postgres",
 	}
 	
-	cmd := exec.Command("psql", args...)
+	cmd := util.Command("psql", args...)
 	cmd.Env = e.getEnv(creds)
 
 	return util.RestoreFromFile(cmd, filePath)
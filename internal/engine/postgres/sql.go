@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// QuoteIdent quotes a PostgreSQL identifier.
+func QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// QuoteString quotes a PostgreSQL string literal.
+func QuoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func (e *PostgresEngine) psql(creds config.ServerConfig, dbName string, extra ...string) *exec.Cmd {
+	if dbName == "" {
+		dbName = "postgres"
+	}
+	args := []string{
+		"-h", creds.Host,
+		"-p", fmt.Sprintf("%d", creds.Port),
+		"-U", creds.User,
+		"-d", dbName,
+		"-X",
+		"-q",
+		"-v", "ON_ERROR_STOP=1",
+	}
+	cmd := util.Command("psql", append(args, extra...)...)
+	cmd.Env = append(e.getEnv(creds), "PGTZ=UTC")
+	return cmd
+}
+
+// ExecSQL runs query in dbName and returns the result rows tab separated.
+func (e *PostgresEngine) ExecSQL(creds config.ServerConfig, dbName string, query string) (string, error) {
+	cmd := e.psql(creds, dbName, "-A", "-t", "-F", "\t")
+	cmd.Stdin = strings.NewReader(query)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("sql failed: %s, output: %s", err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// CreateDatabase creates dbName if it does not exist yet.
+func (e *PostgresEngine) CreateDatabase(creds config.ServerConfig, dbName string) error {
+	out, err := e.ExecSQL(creds, "postgres", "SELECT 1 FROM pg_database WHERE datname = "+QuoteString(dbName)+";")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(out) != "" {
+		return nil
+	}
+	_, err = e.ExecSQL(creds, "postgres", "CREATE DATABASE "+QuoteIdent(dbName)+";")
+	return err
+}
+
+// CopyIn bulk loads r, in COPY text format, into table using COPY FROM STDIN.
+// Timestamps without a zone are interpreted as UTC.
+func (e *PostgresEngine) CopyIn(creds config.ServerConfig, dbName string, table string, columns []string, r io.Reader) error {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = QuoteIdent(c)
+	}
+	stmt := fmt.Sprintf("COPY %s (%s) FROM STDIN", QuoteIdent(table), strings.Join(quoted, ", "))
+
+	cmd := e.psql(creds, dbName, "-c", stmt)
+	cmd.Stdin = r
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("copy into %s failed: %s, output: %s", table, err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Key-level archive layout (before compression):
+//
+//	"DBMREDIS1\n"
+//	'S' uvarint(db)                                      select database
+//	'K' uvarint(len) key varint(expireAtMs) uvarint(len) payload
+//	'E'                                                  end of archive
+//
+// expireAtMs is an absolute unix time in milliseconds, 0 for persistent keys,
+// so TTLs keep counting down between backup and restore.
+const archiveMagic = "DBMREDIS1\n"
+
+// rdbMagic starts every RDB snapshot.
+const rdbMagic = "REDIS"
+
+const (
+	recSelect = 'S'
+	recKey    = 'K'
+	recEnd    = 'E'
+)
+
+// batchSize is the number of commands pipelined per round trip.
+const batchSize = 100
+
+type archiveWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func newArchiveWriter(w io.Writer) *archiveWriter {
+	aw := &archiveWriter{w: bufio.NewWriter(w)}
+	aw.w.WriteString(archiveMagic)
+	return aw
+}
+
+func (aw *archiveWriter) uvarint(v uint64) {
+	n := binary.PutUvarint(aw.buf[:], v)
+	aw.w.Write(aw.buf[:n])
+}
+
+func (aw *archiveWriter) bytes(b []byte) {
+	aw.uvarint(uint64(len(b)))
+	aw.w.Write(b)
+}
+
+func (aw *archiveWriter) selectDB(db int) {
+	aw.w.WriteByte(recSelect)
+	aw.uvarint(uint64(db))
+}
+
+func (aw *archiveWriter) key(key []byte, expireAt int64, payload []byte) {
+	aw.w.WriteByte(recKey)
+	aw.bytes(key)
+	n := binary.PutVarint(aw.buf[:], expireAt)
+	aw.w.Write(aw.buf[:n])
+	aw.bytes(payload)
+}
+
+func (aw *archiveWriter) Close() error {
+	aw.w.WriteByte(recEnd)
+	return aw.w.Flush()
+}
+
+// exportDatabase walks db with SCAN and writes every key's DUMP payload and TTL.
+func exportDatabase(c *conn, aw *archiveWriter, db int) error {
+	if _, err := c.do("SELECT", strconv.Itoa(db)); err != nil {
+		return fmt.Errorf("failed to select database %d: %w", db, err)
+	}
+	aw.selectDB(db)
+
+	cursor := "0"
+	for {
+		reply, err := c.do("SCAN", cursor, "COUNT", "1000")
+		if err != nil {
+			return fmt.Errorf("scan failed: %w", err)
+		}
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 2 {
+			return fmt.Errorf("scan: unexpected reply")
+		}
+		cursor = replyString(items[0])
+		keys, _ := items[1].([]interface{})
+
+		for start := 0; start < len(keys); start += batchSize {
+			end := start + batchSize
+			if end > len(keys) {
+				end = len(keys)
+			}
+			if err := exportKeys(c, aw, keys[start:end]); err != nil {
+				return err
+			}
+		}
+
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+func exportKeys(c *conn, aw *archiveWriter, keys []interface{}) error {
+	for _, k := range keys {
+		key := replyString(k)
+		c.send("DUMP", key)
+		c.send("PTTL", key)
+	}
+	if err := c.flush(); err != nil {
+		return err
+	}
+
+	now := time.Now().UnixMilli()
+	for _, k := range keys {
+		payload, err := c.receive()
+		if err != nil {
+			return fmt.Errorf("dump %q failed: %w", replyString(k), err)
+		}
+		ttlReply, err := c.receive()
+		if err != nil {
+			return fmt.Errorf("pttl %q failed: %w", replyString(k), err)
+		}
+		// The key expired or was deleted since SCAN returned it.
+		if payload == nil {
+			continue
+		}
+		ttl, _ := ttlReply.(int64)
+		if ttl == -2 {
+			continue
+		}
+		var expireAt int64
+		if ttl >= 0 {
+			expireAt = now + ttl
+		}
+		aw.key(k.([]byte), expireAt, payload.([]byte))
+	}
+	return nil
+}
+
+// importArchive replays a key-level archive with RESTORE ... REPLACE ABSTTL.
+// targetDB >= 0 overrides the databases recorded in the archive.
+func importArchive(c *conn, r *bufio.Reader, targetDB int, flush bool) error {
+	head := make([]byte, len(archiveMagic))
+	if _, err := io.ReadFull(r, head); err != nil || string(head) != archiveMagic {
+		return fmt.Errorf("not a redis key-level archive")
+	}
+
+	flushed := make(map[int]bool)
+	pending := 0
+	drain := func() error {
+		if err := c.flush(); err != nil {
+			return err
+		}
+		for ; pending > 0; pending-- {
+			if _, err := c.receive(); err != nil {
+				return fmt.Errorf("restore failed: %w", err)
+			}
+		}
+		return nil
+	}
+
+	now := time.Now().UnixMilli()
+	for {
+		tag, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("archive is truncated: %w", err)
+		}
+
+		switch tag {
+		case recSelect:
+			v, err := binary.ReadUvarint(r)
+			if err != nil {
+				return fmt.Errorf("archive is corrupt: %w", err)
+			}
+			db := int(v)
+			if targetDB >= 0 {
+				db = targetDB
+			}
+			if err := drain(); err != nil {
+				return err
+			}
+			if _, err := c.do("SELECT", strconv.Itoa(db)); err != nil {
+				return fmt.Errorf("failed to select database %d: %w", db, err)
+			}
+			if flush && !flushed[db] {
+				if _, err := c.do("FLUSHDB"); err != nil {
+					return fmt.Errorf("failed to flush database %d: %w", db, err)
+				}
+				flushed[db] = true
+			}
+
+		case recKey:
+			key, err := readBytes(r)
+			if err != nil {
+				return err
+			}
+			expireAt, err := binary.ReadVarint(r)
+			if err != nil {
+				return fmt.Errorf("archive is corrupt: %w", err)
+			}
+			payload, err := readBytes(r)
+			if err != nil {
+				return err
+			}
+			if expireAt > 0 && expireAt <= now {
+				continue
+			}
+
+			c.send("RESTORE", string(key), strconv.FormatInt(expireAt, 10), string(payload), "REPLACE", "ABSTTL")
+			pending++
+			if pending >= batchSize {
+				if err := drain(); err != nil {
+					return err
+				}
+			}
+
+		case recEnd:
+			return drain()
+
+		default:
+			return fmt.Errorf("archive is corrupt: unknown record %q", tag)
+		}
+	}
+}
+
+func readBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("archive is corrupt: %w", err)
+	}
+	if n > math.MaxInt64 {
+		return nil, fmt.Errorf("archive is corrupt: length %d", n)
+	}
+	// Grow with the data read, so a corrupt length cannot allocate more
+	// than the archive holds
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		return nil, fmt.Errorf("archive is truncated: %w", err)
+	}
+	return buf.Bytes(), nil
+}
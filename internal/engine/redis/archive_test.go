@@ -0,0 +1,319 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mydbportal.com/dbmigrate/internal/config"
+)
+
+type fakeKey struct {
+	payload  string
+	expireAt int64 // unix milliseconds, 0 for persistent keys
+}
+
+// fakeRedis answers the commands used by key-level export and restore, so
+// archives can be tested without a live server.
+type fakeRedis struct {
+	mu  sync.Mutex
+	dbs map[int]map[string]fakeKey
+}
+
+func startFakeRedis(t *testing.T, dbs map[int]map[string]fakeKey) (*fakeRedis, config.ServerConfig) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	f := &fakeRedis{dbs: make(map[int]map[string]fakeKey)}
+	for db, keys := range dbs {
+		f.dbs[db] = make(map[string]fakeKey)
+		for k, v := range keys {
+			f.dbs[db][k] = v
+		}
+	}
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(nc)
+		}
+	}()
+	addr := l.Addr().(*net.TCPAddr)
+	return f, config.ServerConfig{Engine: "redis", Host: "127.0.0.1", Port: addr.Port}
+}
+
+func (f *fakeRedis) serve(nc net.Conn) {
+	defer nc.Close()
+	c := &conn{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	db := 0
+	for {
+		v, err := c.receive()
+		if err != nil {
+			return
+		}
+		items, _ := v.([]interface{})
+		var args []string
+		for _, item := range items {
+			args = append(args, replyString(item))
+		}
+		c.w.WriteString(f.handle(&db, args))
+		if c.r.Buffered() == 0 {
+			c.flush()
+		}
+	}
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func (f *fakeRedis) handle(db *int, args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := f.dbs[*db]
+	if keys == nil {
+		keys = make(map[string]fakeKey)
+		f.dbs[*db] = keys
+	}
+	now := time.Now().UnixMilli()
+
+	switch strings.ToUpper(args[0]) {
+	case "SELECT":
+		*db, _ = strconv.Atoi(args[1])
+		return "+OK\r\n"
+	case "DBSIZE":
+		return fmt.Sprintf(":%d\r\n", len(keys))
+	case "FLUSHDB":
+		f.dbs[*db] = make(map[string]fakeKey)
+		return "+OK\r\n"
+	case "SCAN":
+		// One page with every key
+		var names []string
+		for k := range keys {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		reply := fmt.Sprintf("*2\r\n%s*%d\r\n", bulk("0"), len(names))
+		for _, k := range names {
+			reply += bulk(k)
+		}
+		return reply
+	case "DUMP":
+		k, ok := keys[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(k.payload)
+	case "PTTL":
+		k, ok := keys[args[1]]
+		switch {
+		case !ok:
+			return ":-2\r\n"
+		case k.expireAt == 0:
+			return ":-1\r\n"
+		}
+		return fmt.Sprintf(":%d\r\n", k.expireAt-now)
+	case "RESTORE":
+		if len(args) != 6 || args[4] != "REPLACE" || args[5] != "ABSTTL" {
+			return "-ERR syntax error\r\n"
+		}
+		expireAt, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return "-ERR invalid TTL\r\n"
+		}
+		keys[args[1]] = fakeKey{payload: args[3], expireAt: expireAt}
+		return "+OK\r\n"
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func (f *fakeRedis) snapshot() map[int]map[string]fakeKey {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[int]map[string]fakeKey)
+	for db, keys := range f.dbs {
+		if len(keys) == 0 {
+			continue
+		}
+		out[db] = make(map[string]fakeKey)
+		for k, v := range keys {
+			out[db][k] = v
+		}
+	}
+	return out
+}
+
+// sameKeys compares databases, allowing expiry times to differ by the time
+// a round trip takes.
+func sameKeys(got, want map[int]map[string]fakeKey) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for db, keys := range want {
+		if len(got[db]) != len(keys) {
+			return false
+		}
+		for k, w := range keys {
+			g, ok := got[db][k]
+			if !ok || g.payload != w.payload {
+				return false
+			}
+			if d := g.expireAt - w.expireAt; d < -1000 || d > 1000 || (g.expireAt == 0) != (w.expireAt == 0) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func exportArchive(t *testing.T, creds config.ServerConfig, dbs ...int) []byte {
+	t.Helper()
+	c, err := dial(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var buf bytes.Buffer
+	aw := newArchiveWriter(&buf)
+	for _, db := range dbs {
+		if err := exportDatabase(c, aw, db); err != nil {
+			t.Fatalf("export db %d: %v", db, err)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func importInto(creds config.ServerConfig, archive []byte, targetDB int, flush bool) error {
+	c, err := dial(creds)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return importArchive(c, bufio.NewReader(bytes.NewReader(archive)), targetDB, flush)
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	inOneHour := time.Now().Add(time.Hour).UnixMilli()
+	source := map[int]map[string]fakeKey{
+		0: {
+			"persistent": {payload: "\x00\x05hello\x09\x00"},
+			"expiring":   {payload: "\x00\x03ttl", expireAt: inOneHour},
+		},
+		3: {
+			"bin\x00key\r\n": {payload: "\xff\x00\r\n\xfe"},
+		},
+	}
+	stale := fakeKey{payload: "old"}
+
+	tests := []struct {
+		name     string
+		targetDB int
+		flush    bool
+		want     map[int]map[string]fakeKey
+	}{
+		{
+			name:     "flush first",
+			targetDB: -1,
+			flush:    true,
+			want:     source,
+		},
+		{
+			name:     "merge",
+			targetDB: -1,
+			want: map[int]map[string]fakeKey{
+				0: {"persistent": source[0]["persistent"], "expiring": source[0]["expiring"], "stale": stale},
+				3: source[3],
+			},
+		},
+		{
+			name:     "target database",
+			targetDB: 5,
+			flush:    true,
+			want: map[int]map[string]fakeKey{
+				0: {"stale": stale},
+				5: {"persistent": source[0]["persistent"], "expiring": source[0]["expiring"], "bin\x00key\r\n": source[3]["bin\x00key\r\n"]},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, src := startFakeRedis(t, source)
+			archive := exportArchive(t, src, 0, 3)
+
+			target, dst := startFakeRedis(t, map[int]map[string]fakeKey{0: {"stale": stale}})
+			if err := importInto(dst, archive, tt.targetDB, tt.flush); err != nil {
+				t.Fatalf("import: %v", err)
+			}
+			if got := target.snapshot(); !sameKeys(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArchiveSkipsExpiredKeys(t *testing.T) {
+	var buf bytes.Buffer
+	aw := newArchiveWriter(&buf)
+	aw.selectDB(0)
+	aw.key([]byte("gone"), time.Now().Add(-time.Minute).UnixMilli(), []byte("x"))
+	aw.key([]byte("kept"), 0, []byte("y"))
+	aw.Close()
+
+	target, dst := startFakeRedis(t, nil)
+	if err := importInto(dst, buf.Bytes(), -1, false); err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]map[string]fakeKey{0: {"kept": {payload: "y"}}}
+	if got := target.snapshot(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestArchiveMalformed(t *testing.T) {
+	var valid bytes.Buffer
+	aw := newArchiveWriter(&valid)
+	aw.selectDB(0)
+	aw.key([]byte("key"), 0, []byte("payload"))
+	aw.Close()
+	full := valid.String()
+	keyStart := strings.IndexByte(full, recKey)
+
+	tests := []struct {
+		name    string
+		archive string
+	}{
+		{"empty", ""},
+		{"wrong magic", "DBMREDIS2\n" + full[len(archiveMagic):]},
+		{"rdb snapshot", "REDIS0011" + full[9:]},
+		{"no end record", full[:len(full)-1]},
+		{"truncated key", full[:keyStart+3]},
+		{"truncated payload", full[:len(full)-4]},
+		{"unknown record", archiveMagic + "X"},
+		{"huge length", archiveMagic + "K\xff\xff\xff\xff\xff\xff\xff\xff\x7f"},
+		{"overflowing varint", archiveMagic + "S\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\x01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, dst := startFakeRedis(t, nil)
+			if err := importInto(dst, []byte(tt.archive), -1, false); err == nil {
+				t.Fatal("import succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+func init() {
+	engine.Register("redis", func() engine.Engine {
+		return &RedisEngine{}
+	})
+}
+
+// RedisEngine backs up Redis instances.
+//
+// Source options:
+//   - "mode": "rdb" (default) fetches a full RDB snapshot with redis-cli for
+//     BackupAll; "keys" exports every key with DUMP/PTTL per logical database.
+//
+// Target options:
+//   - "restore": "merge" (default) overwrites keys present in the backup and
+//     keeps the rest; "flush" runs FLUSHDB on each restored database first.
+//   - "rdb_path": where to place an RDB snapshot on restore. RDB files cannot be
+//     loaded into a running server, so the server must be restarted afterwards.
+//
+// Single database backups always use the key-level format.
+type RedisEngine struct{}
+
+func (e *RedisEngine) ID() string {
+	return "redis"
+}
+
+func (e *RedisEngine) rdbMode(creds config.ServerConfig) bool {
+	mode := strings.ToLower(creds.Options["mode"])
+	return mode == "" || mode == "rdb"
+}
+
+// Extension returns the extension of single database (key-level) backups.
+func (e *RedisEngine) Extension(creds config.ServerConfig) string {
+	return util.CompressedExt(".redis")
+}
+
+// ListDatabases reports the logical databases 0..N-1 (16 unless configured otherwise).
+func (e *RedisEngine) ListDatabases(creds config.ServerConfig) ([]string, error) {
+	c, err := dial(creds)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	count := 16
+	// CONFIG is often disabled on managed services; keep the default then.
+	if reply, err := c.do("CONFIG", "GET", "databases"); err == nil {
+		if items, ok := reply.([]interface{}); ok && len(items) == 2 {
+			if n, err := strconv.Atoi(replyString(items[1])); err == nil && n > 0 {
+				count = n
+			}
+		}
+	}
+
+	var dbs []string
+	for i := 0; i < count; i++ {
+		dbs = append(dbs, strconv.Itoa(i))
+	}
+	return dbs, nil
+}
+
+// nonEmptyDatabases returns the databases that hold at least one key.
+func (e *RedisEngine) nonEmptyDatabases(creds config.ServerConfig) ([]string, error) {
+	all, err := e.ListDatabases(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := dial(creds)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var dbs []string
+	for _, db := range all {
+		if _, err := c.do("SELECT", db); err != nil {
+			// Databases above the server's limit are rejected; stop there.
+			break
+		}
+		size, err := c.do("DBSIZE")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list databases: %w", err)
+		}
+		if n, _ := size.(int64); n > 0 {
+			dbs = append(dbs, db)
+		}
+	}
+	return dbs, nil
+}
+
+func (e *RedisEngine) BackupDatabase(creds config.ServerConfig, dbName string, destPath string) error {
+	return util.CreateFile(destPath, func(w io.Writer) error {
+		return e.BackupDatabaseTo(creds, dbName, w)
+	})
+}
+
+// BackupDatabaseTo writes the compressed key-level archive of dbName to w.
+func (e *RedisEngine) BackupDatabaseTo(creds config.ServerConfig, dbName string, w io.Writer) error {
+	db, err := strconv.Atoi(dbName)
+	if err != nil {
+		return fmt.Errorf("invalid redis database %q: must be a number", dbName)
+	}
+
+	c, err := dial(creds)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return util.Compress(w, func(w io.Writer) error {
+		aw := newArchiveWriter(w)
+		if err := exportDatabase(c, aw, db); err != nil {
+			return err
+		}
+		return aw.Close()
+	})
+}
+
+// BackupAll writes the files of BackupAllTo to destDir. Each file is closed
+// when the next one is created; a failure to close it fails its backup.
+func (e *RedisEngine) BackupAll(creds config.ServerConfig, destDir string) ([]engine.BackupResult, error) {
+	var current *os.File
+	closeErrs := make(map[string]error)
+	closeCurrent := func() {
+		if current != nil {
+			if err := current.Close(); err != nil {
+				closeErrs[filepath.Base(current.Name())] = fmt.Errorf("failed to close output file: %w", err)
+			}
+			current = nil
+		}
+	}
+	results, err := e.BackupAllTo(creds, func(filename string) (io.Writer, error) {
+		closeCurrent()
+		f, err := os.Create(filepath.Join(destDir, filename))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create output file: %w", err)
+		}
+		current = f
+		return f, nil
+	})
+	closeCurrent()
+	for i, res := range results {
+		if res.Error == nil {
+			results[i].Error = closeErrs[res.Filename]
+		}
+	}
+	return results, err
+}
+
+// BackupAllTo streams an RDB snapshot, or one archive per non-empty
+// database, to the writers create returns.
+func (e *RedisEngine) BackupAllTo(creds config.ServerConfig, create func(filename string) (io.Writer, error)) ([]engine.BackupResult, error) {
+	timestamp := time.Now().Format("2006-01-02T15:04:05Z")
+
+	if e.rdbMode(creds) {
+		filename := fmt.Sprintf("all-databases_%s%s", timestamp, util.CompressedExt(".rdb"))
+		w, err := create(filename)
+		if err == nil {
+			err = e.backupRDBTo(creds, w)
+		}
+		return []engine.BackupResult{{
+			Database: "all",
+			Filename: filename,
+			Error:    err,
+		}}, nil
+	}
+
+	dbs, err := e.nonEmptyDatabases(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []engine.BackupResult
+	for _, db := range dbs {
+		filename := fmt.Sprintf("%s_%s%s", db, timestamp, e.Extension(creds))
+		w, err := create(filename)
+		if err == nil {
+			err = e.BackupDatabaseTo(creds, db, w)
+		}
+		results = append(results, engine.BackupResult{
+			Database: db,
+			Filename: filename,
+			Error:    err,
+		})
+	}
+	return results, nil
+}
+
+// backupRDBTo lets redis-cli fetch a snapshot over the replication protocol,
+// which makes the server run a BGSAVE and stream the result. redis-cli needs
+// a file to write to, so the snapshot is compressed into w from a temp file.
+func (e *RedisEngine) backupRDBTo(creds config.ServerConfig, w io.Writer) error {
+	tmp, err := os.CreateTemp("", "dbmigrate-*.rdb")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+
+	port := creds.Port
+	if port == 0 {
+		port = 6379
+	}
+	args := []string{
+		"-h", creds.Host,
+		"-p", fmt.Sprintf("%d", port),
+	}
+	if creds.User != "" {
+		args = append(args, "--user", creds.User)
+	}
+	args = append(args, "--rdb", tmpPath)
+
+	cmd := util.Command("redis-cli", args...)
+	// REDISCLI_AUTH keeps the password off the process list
+	cmd.Env = append(os.Environ(), fmt.Sprintf("REDISCLI_AUTH=%s", creds.Password))
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to fetch rdb snapshot: %s, output: %s", err, string(output))
+	}
+
+	return util.CompressFileTo(tmpPath, w)
+}
+
+// RestoreBackup restores a key-level archive over the network, or places an
+// RDB snapshot at the target's "rdb_path". If dbName is set, every key of a
+// key-level archive is restored into that database index instead.
+func (e *RedisEngine) RestoreBackup(creds config.ServerConfig, filePath string, dbName string) error {
+	targetDB := -1
+	if dbName != "" {
+		n, err := strconv.Atoi(dbName)
+		if err != nil {
+			return fmt.Errorf("invalid redis database %q: must be a number", dbName)
+		}
+		targetDB = n
+	}
+
+	flush := false
+	switch mode := strings.ToLower(creds.Options["restore"]); mode {
+	case "", "merge":
+	case "flush":
+		flush = true
+	default:
+		return fmt.Errorf("unknown redis restore mode %q (want merge or flush)", mode)
+	}
+
+	return util.ReadCompressed(filePath, func(r io.Reader) error {
+		br := bufio.NewReader(r)
+		head, err := br.Peek(len(rdbMagic))
+		if err == nil && string(head) == rdbMagic {
+			return e.restoreRDB(creds, br)
+		}
+
+		c, err := dial(creds)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+
+		return importArchive(c, br, targetDB, flush)
+	})
+}
+
+func (e *RedisEngine) restoreRDB(creds config.ServerConfig, r io.Reader) error {
+	destPath := creds.Options["rdb_path"]
+	if destPath == "" {
+		return fmt.Errorf("backup is an RDB snapshot, which cannot be loaded into a running server; " +
+			"set the target's rdb_path option to the server's dump file and restart it, or back up with mode=keys")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(destPath), "."+filepath.Base(destPath)+".restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write rdb file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), destPath); err != nil {
+		return fmt.Errorf("failed to move rdb file into place: %w", err)
+	}
+
+	util.Log().WithField("path", destPath).Warn("RDB snapshot written; restart redis-server to load it")
+	return nil
+}
@@ -0,0 +1,65 @@
+package redis
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackupAll(t *testing.T) {
+	source := map[int]map[string]fakeKey{
+		0: {"a": {payload: "\x00\x01a"}},
+		3: {"b": {payload: "\x00\x01b"}, "c": {payload: "\x00\x01c"}},
+	}
+	_, src := startFakeRedis(t, source)
+	src.Options = map[string]string{"mode": "keys"}
+	e := &RedisEngine{}
+
+	dir := t.TempDir()
+	results, err := e.BackupAll(src, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Database != "0" || results[1].Database != "3" {
+		t.Fatalf("backed up %+v, want databases 0 and 3", results)
+	}
+	for _, res := range results {
+		if res.Error != nil {
+			t.Fatalf("backup of %s: %v", res.Database, res.Error)
+		}
+		target, dst := startFakeRedis(t, nil)
+		if err := e.RestoreBackup(dst, filepath.Join(dir, res.Filename), ""); err != nil {
+			t.Fatalf("restore of %s: %v", res.Filename, err)
+		}
+		db := 0
+		if res.Database == "3" {
+			db = 3
+		}
+		want := map[int]map[string]fakeKey{db: source[db]}
+		if got := target.snapshot(); !sameKeys(got, want) {
+			t.Errorf("%s restored %q, want %q", res.Filename, got, want)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("backup wrote %d files, want 2", len(entries))
+	}
+
+	// Every file fails on its own when it cannot be created
+	results, err = e.BackupAll(src, filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("backed up %+v", results)
+	}
+	for _, res := range results {
+		if res.Error == nil || !strings.Contains(res.Error.Error(), "failed to create output file") {
+			t.Errorf("backup of %s into a missing directory: %v", res.Database, res.Error)
+		}
+	}
+}
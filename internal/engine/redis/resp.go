@@ -0,0 +1,170 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"mydbportal.com/dbmigrate/internal/config"
+)
+
+// conn is a minimal RESP2 client. redis-cli cannot round-trip binary DUMP
+// payloads, so key-level export and restore talk to the server directly.
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+	w  *bufio.Writer
+}
+
+// redisError is an error reply sent by the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+func address(creds config.ServerConfig) string {
+	port := creds.Port
+	if port == 0 {
+		port = 6379
+	}
+	return net.JoinHostPort(creds.Host, strconv.Itoa(port))
+}
+
+func dial(creds config.ServerConfig) (*conn, error) {
+	nc, err := net.DialTimeout("tcp", address(creds), 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	c := &conn{
+		nc: nc,
+		r:  bufio.NewReader(nc),
+		w:  bufio.NewWriter(nc),
+	}
+
+	if creds.Password != "" {
+		var err error
+		if creds.User != "" {
+			_, err = c.do("AUTH", creds.User, creds.Password)
+		} else {
+			_, err = c.do("AUTH", creds.Password)
+		}
+		if err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("redis authentication failed: %w", err)
+		}
+	}
+	return c, nil
+}
+
+func (c *conn) Close() error {
+	return c.nc.Close()
+}
+
+// send buffers a command without waiting for its reply (pipelining).
+func (c *conn) send(args ...string) error {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(c.w, "$%d\r\n", len(a))
+		c.w.WriteString(a)
+		c.w.WriteString("\r\n")
+	}
+	return nil
+}
+
+func (c *conn) flush() error {
+	return c.w.Flush()
+}
+
+// do sends a single command and returns its reply.
+func (c *conn) do(args ...string) (interface{}, error) {
+	c.send(args...)
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+	return c.receive()
+}
+
+// receive reads one reply. Error replies are returned as redisError.
+// Replies are string (status), int64, []byte (bulk), []interface{} or nil.
+func (c *conn) receive() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		if buf[n] != '\r' || buf[n+1] != '\n' {
+			return nil, fmt.Errorf("redis: bulk reply longer than %d bytes", n)
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			item, err := c.receive()
+			if err != nil {
+				if _, ok := err.(redisError); !ok {
+					return nil, err
+				}
+				item = err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func (c *conn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: malformed reply line")
+	}
+	return line[:len(line)-2], nil
+}
+
+func replyString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	case int64:
+		return strconv.FormatInt(t, 10)
+	}
+	return ""
+}
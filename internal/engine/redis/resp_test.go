@@ -0,0 +1,101 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readerConn returns a conn that reads replies from input.
+func readerConn(input string) *conn {
+	return &conn{r: bufio.NewReader(strings.NewReader(input))}
+}
+
+func TestReceive(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  interface{}
+	}{
+		{"status", "+OK\r\n", "OK"},
+		{"integer", ":42\r\n", int64(42)},
+		{"negative integer", ":-2\r\n", int64(-2)},
+		{"bulk", "$5\r\nhello\r\n", []byte("hello")},
+		{"binary bulk", "$4\r\n\x00\r\n\xff\r\n", []byte("\x00\r\n\xff")},
+		{"empty bulk", "$0\r\n\r\n", []byte{}},
+		{"nil bulk", "$-1\r\n", nil},
+		{"nil array", "*-1\r\n", nil},
+		{"array", "*2\r\n$1\r\na\r\n:1\r\n", []interface{}{[]byte("a"), int64(1)}},
+		{"nested array", "*2\r\n$1\r\n0\r\n*1\r\n$3\r\nkey\r\n\r\n", []interface{}{[]byte("0"), []interface{}{[]byte("key")}}},
+		{"error in array", "*2\r\n+OK\r\n-ERR no\r\n", []interface{}{"OK", redisError("ERR no")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readerConn(tt.input).receive()
+			if err != nil {
+				t.Fatalf("receive: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReceiveErrorReply(t *testing.T) {
+	_, err := readerConn("-WRONGTYPE bad key\r\n").receive()
+	if err != redisError("WRONGTYPE bad key") {
+		t.Fatalf("got %v, want the error reply", err)
+	}
+}
+
+func TestReceiveMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"no input", ""},
+		{"empty line", "\r\n"},
+		{"missing CR", "+OK\n"},
+		{"unterminated line", "+OK"},
+		{"unknown type", "!x\r\n"},
+		{"bad integer", ":4x\r\n"},
+		{"bad bulk length", "$x\r\n"},
+		{"truncated bulk", "$10\r\nhello\r\n"},
+		{"bulk longer than its length", "$3\r\nhello\r\n"},
+		{"bad array length", "*x\r\n"},
+		{"truncated array", "*3\r\n+a\r\n+b\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := readerConn(tt.input).receive(); err == nil {
+				t.Fatalf("got %#v, want an error", got)
+			}
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	var buf bytes.Buffer
+	c := &conn{w: bufio.NewWriter(&buf)}
+	c.send("SET", "k", "a\r\nb")
+	c.send("PING")
+	if err := c.flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$4\r\na\r\nb\r\n*1\r\n$4\r\nPING\r\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+
+	// The server side parses commands as arrays of bulk strings
+	got, err := readerConn(buf.String()).receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []interface{}{[]byte("SET"), []byte("k"), []byte("a\r\nb")}) {
+		t.Fatalf("got %#v", got)
+	}
+}
@@ -0,0 +1,117 @@
+package sqlite
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+)
+
+// Row hashes use the sha3() function of the sqlite3 shell, which SQLite
+// itself does not provide.
+
+// rangeClause renders the WHERE clause selecting the keys of r.
+func rangeClause(r engine.KeyRange) string {
+	var where []string
+	if len(r.After) > 0 {
+		where = append(where, fmt.Sprintf("(%s) > (%s)", quoteAll(r.Key, quoteIdent), quoteAll(r.After, quoteLiteral)))
+	}
+	if len(r.Upto) > 0 {
+		where = append(where, fmt.Sprintf("(%s) <= (%s)", quoteAll(r.Key, quoteIdent), quoteAll(r.Upto, quoteLiteral)))
+	}
+	if len(where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(where, " AND ")
+}
+
+// rowHash hashes the SQL literals of the compared columns, which keeps NULL
+// and the storage class of each value apart.
+func rowHash(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = "quote(" + quoteIdent(c) + ")"
+	}
+	return "hex(sha3(" + strings.Join(quoted, " || ',' || ") + "))"
+}
+
+// keyText selects the key columns as text, aliased k0, k1, ...
+func keyText(key []string) string {
+	cols := make([]string, len(key))
+	for i, k := range key {
+		cols[i] = fmt.Sprintf("CAST(%s AS TEXT) AS k%d", quoteIdent(k), i)
+	}
+	return strings.Join(cols, ", ")
+}
+
+func quoteAll(values []string, quote func(string) string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = quote(v)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// HashRange counts the rows of r and hashes their hashes in key order.
+func (e *SQLiteEngine) HashRange(creds config.ServerConfig, dbName string, r engine.KeyRange) (int64, string, error) {
+	path, err := e.existingPath(creds, dbName)
+	if err != nil {
+		return 0, "", err
+	}
+	rows, err := query(path, fmt.Sprintf(
+		"SELECT CAST(count(*) AS TEXT) AS n, hex(sha3(coalesce(group_concat(h, ''), ''))) AS h FROM (SELECT %s AS h FROM %s%s ORDER BY %s);",
+		rowHash(r.Columns), quoteIdent(r.Table), rangeClause(r), quoteAll(r.Key, quoteIdent)))
+	if err != nil {
+		return 0, "", err
+	}
+	if len(rows) != 1 {
+		return 0, "", fmt.Errorf("unexpected checksum result for %s", r.Table)
+	}
+	n, err := strconv.ParseInt(str(rows[0], "n"), 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("unexpected count %q", str(rows[0], "n"))
+	}
+	return n, str(rows[0], "h"), nil
+}
+
+// KeyAt returns the key of the row at offset n of r.
+func (e *SQLiteEngine) KeyAt(creds config.ServerConfig, dbName string, r engine.KeyRange, n int64) ([]string, error) {
+	path, err := e.existingPath(creds, dbName)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := query(path, fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s LIMIT 1 OFFSET %d;",
+		keyText(r.Key), quoteIdent(r.Table), rangeClause(r), quoteAll(r.Key, quoteIdent), n))
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	return keyValues(rows[0], len(r.Key)), nil
+}
+
+// HashRows returns the key and hash of every row of r.
+func (e *SQLiteEngine) HashRows(creds config.ServerConfig, dbName string, r engine.KeyRange) ([]engine.RowHash, error) {
+	path, err := e.existingPath(creds, dbName)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := query(path, fmt.Sprintf("SELECT %s, %s AS h FROM %s%s ORDER BY %s;",
+		keyText(r.Key), rowHash(r.Columns), quoteIdent(r.Table), rangeClause(r), quoteAll(r.Key, quoteIdent)))
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]engine.RowHash, len(rows))
+	for i, row := range rows {
+		hashes[i] = engine.RowHash{Key: keyValues(row, len(r.Key)), Hash: str(row, "h")}
+	}
+	return hashes, nil
+}
+
+func keyValues(row map[string]*string, n int) []string {
+	key := make([]string, n)
+	for i := range key {
+		key[i] = str(row, fmt.Sprintf("k%d", i))
+	}
+	return key
+}
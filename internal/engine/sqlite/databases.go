@@ -0,0 +1,229 @@
+package sqlite
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// BackupDatabases returns the database file a backup is restored into.
+func (e *SQLiteEngine) BackupDatabases(creds config.ServerConfig, filePath string) ([]string, error) {
+	destPath, err := e.targetPath(creds, dbNameFromFile(filePath))
+	if err != nil {
+		return nil, err
+	}
+	return []string{filepath.Base(destPath)}, nil
+}
+
+// createTable matches the start of a CREATE TABLE statement of sqlite3 .dump.
+var createTable = regexp.MustCompile(`^CREATE TABLE (?:IF NOT EXISTS )?("(?:[^"]|"")+"|\[[^\]]+\]|[^\s(]+)`)
+
+// BackupContents lists the tables of a backup: read from a copy of a binary
+// backup, or from the CREATE TABLE statements of an SQL export.
+func (e *SQLiteEngine) BackupContents(filePath string) ([]engine.BackupObject, error) {
+	dbName := dbNameFromFile(filePath)
+	isBinary := false
+	err := util.ReadCompressed(filePath, func(r io.Reader) error {
+		head := make([]byte, len(headerMagic))
+		if _, err := io.ReadFull(r, head); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		isBinary = bytes.Equal(head, headerMagic)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []engine.BackupObject
+	if !isBinary {
+		err := util.ScanCreateTables(filePath, func(string) {}, func(stmt string) {
+			m := createTable.FindStringSubmatch(stmt)
+			if m == nil {
+				return
+			}
+			name := m[1]
+			switch {
+			case strings.HasPrefix(name, `"`):
+				name = strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+			case strings.HasPrefix(name, "["):
+				name = name[1 : len(name)-1]
+			}
+			if !strings.HasPrefix(name, "sqlite_") {
+				objects = append(objects, engine.BackupObject{Database: dbName, Name: name, Definition: stmt})
+			}
+		})
+		return objects, err
+	}
+
+	tmp, err := os.CreateTemp("", "dbmigrate-*.sqlite")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+	if err := util.DecompressFile(filePath, tmpPath); err != nil {
+		return nil, err
+	}
+
+	// Statements span lines, so rows and columns are split on control characters
+	cmd := util.Command("sqlite3", "-readonly", "-separator", "\x1f", "-newline", "\x1e", tmpPath,
+		"SELECT name, sql FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name;")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %s, output: %s", err, string(output))
+	}
+	for _, row := range strings.Split(string(output), "\x1e") {
+		name, sql, ok := strings.Cut(row, "\x1f")
+		if !ok {
+			continue
+		}
+		objects = append(objects, engine.BackupObject{Database: dbName, Name: name, Definition: sql + ";"})
+	}
+	return objects, nil
+}
+
+// ListTables lists the tables of a database file.
+func (e *SQLiteEngine) ListTables(creds config.ServerConfig, dbName string) ([]string, error) {
+	path, err := e.existingPath(creds, dbName)
+	if err != nil {
+		return nil, err
+	}
+	cmd := util.Command("sqlite3", "-readonly", path,
+		"SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name;")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %s, output: %s", err, string(output))
+	}
+	var tables []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			tables = append(tables, line)
+		}
+	}
+	return tables, nil
+}
+
+// existingPath finds dbName among the files matched by the host, or where a
+// restore would have written it (scratch names need not match a glob host).
+func (e *SQLiteEngine) existingPath(creds config.ServerConfig, dbName string) (string, error) {
+	if path, err := e.dbPath(creds, dbName); err == nil {
+		return path, nil
+	}
+	path, err := e.targetPath(creds, dbName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("database not found: %s", dbName)
+	}
+	return path, nil
+}
+
+// RestoreBackupAs restores a backup into the database file dbName. The host
+// must be a directory or glob; a plain file host would be replaced.
+func (e *SQLiteEngine) RestoreBackupAs(creds config.ServerConfig, filePath string, dbName string) error {
+	path, err := e.targetPath(creds, dbName)
+	if err != nil {
+		return err
+	}
+	if filepath.Base(path) != dbName {
+		return fmt.Errorf("sqlite target %s is a single file; use a directory or glob host", creds.Host)
+	}
+	return e.RestoreBackup(creds, filePath, dbName)
+}
+
+// DropDatabase removes the database file dbName and its journal files.
+func (e *SQLiteEngine) DropDatabase(creds config.ServerConfig, dbName string) error {
+	path, err := e.targetPath(creds, dbName)
+	if err != nil {
+		return err
+	}
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		os.Remove(path + suffix)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// EstimateSize returns the size of the database file.
+func (e *SQLiteEngine) EstimateSize(creds config.ServerConfig, dbName string) (int64, error) {
+	path, err := e.dbPath(creds, dbName)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// CountRows counts the rows of every table of a database file.
+func (e *SQLiteEngine) CountRows(creds config.ServerConfig, dbName string) (map[string]int64, error) {
+	tables, err := e.ListTables(creds, dbName)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64)
+	if len(tables) == 0 {
+		return counts, nil
+	}
+	path, err := e.existingPath(creds, dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	var selects []string
+	for _, t := range tables {
+		selects = append(selects, fmt.Sprintf("SELECT %s, COUNT(*) FROM %s", quoteLiteral(t), quoteIdent(t)))
+	}
+	cmd := util.Command("sqlite3", "-readonly", "-separator", "\t", path, strings.Join(selects, " UNION ALL ")+";")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count rows: %s, output: %s", err, string(output))
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		name, count, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(count), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected count output: %q", line)
+		}
+		counts[name] = n
+	}
+	return counts, nil
+}
+
+// ExecSQL runs query in a database file and returns the result rows tab
+// separated.
+func (e *SQLiteEngine) ExecSQL(creds config.ServerConfig, dbName string, query string) (string, error) {
+	path, err := e.existingPath(creds, dbName)
+	if err != nil {
+		return "", err
+	}
+	cmd := util.Command("sqlite3", "-bail", "-separator", "\t", path, query)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("sqlite3 failed: %s, output: %s", err, string(output))
+	}
+	return string(output), nil
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,163 @@
+package sqlite
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/schema"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// query runs a read-only query against a database file and returns its rows
+// as column name to value maps. Every selected value must be TEXT or NULL.
+func query(path string, sql string) ([]map[string]*string, error) {
+	cmd := util.Command("sqlite3", "-readonly", "-json", path, sql)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("sqlite3 failed: %s, output: %s", err, string(output))
+	}
+	// sqlite3 prints nothing for an empty result
+	var rows []map[string]*string
+	if len(strings.TrimSpace(string(output))) == 0 {
+		return rows, nil
+	}
+	if err := json.Unmarshal(output, &rows); err != nil {
+		return nil, fmt.Errorf("unexpected sqlite3 output: %w", err)
+	}
+	return rows, nil
+}
+
+func str(row map[string]*string, key string) string {
+	if v := row[key]; v != nil {
+		return *v
+	}
+	return ""
+}
+
+// userTable selects the user tables of sqlite_master m
+const userTable = "m.type = 'table' AND m.name NOT LIKE 'sqlite_%'"
+
+// InspectSchema reads tables, columns, indexes and foreign keys through the
+// table_info, index_list and foreign_key_list pragmas, and the views.
+func (e *SQLiteEngine) InspectSchema(creds config.ServerConfig, dbName string) (*schema.Schema, error) {
+	path, err := e.existingPath(creds, dbName)
+	if err != nil {
+		return nil, err
+	}
+	s := &schema.Schema{Engine: e.ID(), Database: dbName}
+
+	rows, err := query(path, `SELECT m.name AS tbl, m.sql AS ddl, p.name AS col, p.type AS type,
+		CAST(p."notnull" AS TEXT) AS not_null, p.dflt_value AS dflt, CAST(p.pk AS TEXT) AS pk
+		FROM sqlite_master m JOIN pragma_table_info(m.name) p WHERE `+userTable+` ORDER BY m.name, p.cid;`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns: %w", err)
+	}
+	autoIncrement := make(map[string]bool)
+	// pk holds the 1-based position of a column in the primary key
+	pkColumns := make(map[string]map[int]string)
+	for _, row := range rows {
+		name := str(row, "tbl")
+		if len(s.Tables) == 0 || s.Tables[len(s.Tables)-1].Name != name {
+			s.Tables = append(s.Tables, schema.Table{Name: name})
+			autoIncrement[name] = strings.Contains(strings.ToUpper(str(row, "ddl")), "AUTOINCREMENT")
+			pkColumns[name] = make(map[int]string)
+		}
+		typ := strings.ToLower(str(row, "type"))
+		col := schema.Column{
+			Name:     str(row, "col"),
+			Type:     typ,
+			DataType: strings.TrimSpace(strings.SplitN(typ, "(", 2)[0]),
+			Nullable: str(row, "not_null") == "0",
+			Default:  row["dflt"],
+		}
+		t := &s.Tables[len(s.Tables)-1]
+		t.Columns = append(t.Columns, col)
+		if pos, _ := strconv.Atoi(str(row, "pk")); pos > 0 {
+			pkColumns[name][pos] = col.Name
+		}
+	}
+	for i := range s.Tables {
+		t := &s.Tables[i]
+		for pos := 1; pos <= len(pkColumns[t.Name]); pos++ {
+			t.PrimaryKey = append(t.PrimaryKey, pkColumns[t.Name][pos])
+		}
+		if autoIncrement[t.Name] && len(t.PrimaryKey) == 1 {
+			t.Column(t.PrimaryKey[0]).AutoIncrement = true
+		}
+	}
+
+	// Indexes, one row per indexed column; the primary key is already known
+	rows, err = query(path, `SELECT m.name AS tbl, il.name AS idx, CAST(il."unique" AS TEXT) AS uniq,
+		CAST(il.partial AS TEXT) AS partial, ii.name AS col,
+		(SELECT sql FROM sqlite_master WHERE type = 'index' AND name = il.name) AS def
+		FROM sqlite_master m JOIN pragma_index_list(m.name) il JOIN pragma_index_xinfo(il.name) ii
+		WHERE `+userTable+` AND il.origin <> 'pk' AND ii.key = 1 ORDER BY m.name, il.name, ii.seqno;`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read indexes: %w", err)
+	}
+	for _, row := range rows {
+		t := s.Table(str(row, "tbl"))
+		if t == nil {
+			continue
+		}
+		if len(t.Indexes) == 0 || t.Indexes[len(t.Indexes)-1].Name != str(row, "idx") {
+			idx := schema.Index{Name: str(row, "idx"), Unique: str(row, "uniq") == "1"}
+			if str(row, "partial") == "1" {
+				idx.Kind = "PARTIAL"
+			}
+			// Automatic indexes of UNIQUE constraints have no statement
+			idx.Definition = str(row, "def")
+			t.Indexes = append(t.Indexes, idx)
+		}
+		idx := &t.Indexes[len(t.Indexes)-1]
+		// Expression columns have no name
+		if row["col"] == nil {
+			idx.Kind = "FUNCTIONAL"
+			continue
+		}
+		idx.Columns = append(idx.Columns, str(row, "col"))
+	}
+
+	// Foreign keys, one row per referencing column
+	rows, err = query(path, `SELECT m.name AS tbl, CAST(fk.id AS TEXT) AS id, fk."from" AS col,
+		fk."table" AS ref_table, fk."to" AS ref_col, fk.on_update AS on_update, fk.on_delete AS on_delete
+		FROM sqlite_master m JOIN pragma_foreign_key_list(m.name) fk WHERE `+userTable+` ORDER BY m.name, fk.id, fk.seq;`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read foreign keys: %w", err)
+	}
+	for _, row := range rows {
+		t := s.Table(str(row, "tbl"))
+		if t == nil {
+			continue
+		}
+		// SQLite foreign keys are unnamed
+		name := fmt.Sprintf("fk_%s_%s", t.Name, str(row, "id"))
+		if len(t.ForeignKeys) == 0 || t.ForeignKeys[len(t.ForeignKeys)-1].Name != name {
+			t.ForeignKeys = append(t.ForeignKeys, schema.ForeignKey{
+				Name:     name,
+				RefTable: str(row, "ref_table"),
+				OnUpdate: str(row, "on_update"),
+				OnDelete: str(row, "on_delete"),
+			})
+		}
+		fk := &t.ForeignKeys[len(t.ForeignKeys)-1]
+		fk.Columns = append(fk.Columns, str(row, "col"))
+		// A reference to the parent's primary key leaves "to" empty
+		fk.RefColumns = append(fk.RefColumns, str(row, "ref_col"))
+	}
+
+	// CHECK constraints live only in the CREATE TABLE text, so views are the
+	// only other objects read here; SQLite has no stored routines
+	rows, err = query(path, "SELECT name, sql FROM sqlite_master WHERE type = 'view' ORDER BY name;")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read views: %w", err)
+	}
+	for _, row := range rows {
+		s.Views = append(s.Views, schema.View{Name: str(row, "name"), Definition: str(row, "sql")})
+	}
+
+	return s, nil
+}
@@ -0,0 +1,360 @@
+package sqlite
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+func init() {
+	engine.Register("sqlite", func() engine.Engine {
+		return &SQLiteEngine{}
+	})
+}
+
+// headerMagic is the first 16 bytes of every SQLite database file.
+var headerMagic = []byte("SQLite format 3\x00")
+
+// SQLiteEngine backs up SQLite database files.
+// ServerConfig.Host is a file path or a glob (e.g. /srv/app/*.db); Port, User
+// and Password are ignored. Set Options["format"] = "sql" to export SQL text
+// (sqlite3 .dump) instead of a binary database copy.
+type SQLiteEngine struct{}
+
+func (e *SQLiteEngine) ID() string {
+	return "sqlite"
+}
+
+func (e *SQLiteEngine) sqlFormat(creds config.ServerConfig) bool {
+	return strings.EqualFold(creds.Options["format"], "sql")
+}
+
+// Extension returns ".sql" for SQL exports and ".sqlite" for binary copies,
+// followed by the compression extension.
+func (e *SQLiteEngine) Extension(creds config.ServerConfig) string {
+	if e.sqlFormat(creds) {
+		return util.CompressedExt(".sql")
+	}
+	return util.CompressedExt(".sqlite")
+}
+
+// resolvePaths expands creds.Host into the list of database files it matches.
+// A directory host matches the files inside it.
+func (e *SQLiteEngine) resolvePaths(creds config.ServerConfig) ([]string, error) {
+	if creds.Host == "" {
+		return nil, fmt.Errorf("sqlite source requires a file path or glob as host")
+	}
+	pattern := creds.Host
+	isDir := false
+	if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+		pattern = filepath.Join(pattern, "*")
+		isDir = true
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sqlite path pattern %q: %w", creds.Host, err)
+	}
+
+	var paths []string
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		paths = append(paths, m)
+	}
+	if len(paths) == 0 && !isDir {
+		return nil, fmt.Errorf("no sqlite database files match %s", creds.Host)
+	}
+	return paths, nil
+}
+
+// ListDatabases returns the names of the database files matched by the host:
+// their base names, or their paths below the glob root if base names collide.
+func (e *SQLiteEngine) ListDatabases(creds config.ServerConfig) ([]string, error) {
+	paths, names, err := e.namedPaths(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	var dbs []string
+	for _, p := range paths {
+		dbs = append(dbs, names[p])
+	}
+	return dbs, nil
+}
+
+func (e *SQLiteEngine) dbPath(creds config.ServerConfig, dbName string) (string, error) {
+	paths, names, err := e.namedPaths(creds)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range paths {
+		if names[p] == dbName {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("database not found: %s", dbName)
+}
+
+// namedPaths resolves the host and names each file. Files whose base names
+// collide (e.g. /data/*/app.db) are named after their path below the glob
+// root with separators replaced by "_", such as site1_app.db.
+func (e *SQLiteEngine) namedPaths(creds config.ServerConfig) ([]string, map[string]string, error) {
+	paths, err := e.resolvePaths(creds)
+	if err != nil {
+		return nil, nil, err
+	}
+	count := make(map[string]int)
+	for _, p := range paths {
+		count[filepath.Base(p)]++
+	}
+
+	root := globRoot(creds.Host)
+	names := make(map[string]string)
+	owner := make(map[string]string)
+	for _, p := range paths {
+		name := filepath.Base(p)
+		if count[name] > 1 {
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				rel = p
+			}
+			name = strings.ReplaceAll(filepath.ToSlash(rel), "/", "_")
+		}
+		if other, ok := owner[name]; ok {
+			return nil, nil, fmt.Errorf("sqlite files %s and %s both map to database name %s", other, p, name)
+		}
+		owner[name] = p
+		names[p] = name
+	}
+	return paths, names, nil
+}
+
+// globRoot returns the directory of host above its first glob element, or
+// host itself if it is a directory.
+func globRoot(host string) string {
+	if info, err := os.Stat(host); err == nil && info.IsDir() {
+		return host
+	}
+	dir := filepath.Dir(host)
+	for strings.ContainsAny(dir, "*?[") {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+func (e *SQLiteEngine) BackupDatabase(creds config.ServerConfig, dbName string, destPath string) error {
+	return util.CreateFile(destPath, func(w io.Writer) error {
+		return e.BackupDatabaseTo(creds, dbName, w)
+	})
+}
+
+// BackupDatabaseTo writes the compressed copy (or SQL export) of dbName to w.
+func (e *SQLiteEngine) BackupDatabaseTo(creds config.ServerConfig, dbName string, w io.Writer) error {
+	srcPath, err := e.dbPath(creds, dbName)
+	if err != nil {
+		return err
+	}
+
+	if e.sqlFormat(creds) {
+		// sqlite3 -readonly file .dump
+		cmd := util.Command("sqlite3", "-readonly", srcPath, ".dump")
+		return util.RunDump(cmd, w)
+	}
+
+	// VACUUM INTO produces a consistent, compacted copy of a live database
+	// without blocking writers. It refuses to overwrite, so reserve a name and
+	// remove the placeholder first.
+	tmp, err := os.CreateTemp("", "dbmigrate-*.sqlite")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	os.Remove(tmpPath)
+	defer os.Remove(tmpPath)
+
+	stmt := fmt.Sprintf("VACUUM INTO %s;", quoteLiteral(tmpPath))
+	cmd := util.Command("sqlite3", srcPath, stmt)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("vacuum into failed: %s, output: %s", err, string(output))
+	}
+
+	return util.CompressFileTo(tmpPath, w)
+}
+
+func (e *SQLiteEngine) BackupAll(creds config.ServerConfig, destDir string) ([]engine.BackupResult, error) {
+	dbs, err := e.ListDatabases(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []engine.BackupResult
+	timestamp := time.Now().Format("2006-01-02T15:04:05Z")
+
+	for _, db := range dbs {
+		filename := fmt.Sprintf("%s_%s%s", db, timestamp, e.Extension(creds))
+		destPath := filepath.Join(destDir, filename)
+
+		err := e.BackupDatabase(creds, db, destPath)
+
+		res := engine.BackupResult{
+			Database: db,
+			Filename: filename,
+			Error:    err,
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// BackupAllTo streams a copy of every matched database to the writers create returns.
+func (e *SQLiteEngine) BackupAllTo(creds config.ServerConfig, create func(filename string) (io.Writer, error)) ([]engine.BackupResult, error) {
+	dbs, err := e.ListDatabases(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []engine.BackupResult
+	timestamp := time.Now().Format("2006-01-02T15:04:05Z")
+
+	for _, db := range dbs {
+		filename := fmt.Sprintf("%s_%s%s", db, timestamp, e.Extension(creds))
+		w, err := create(filename)
+		if err == nil {
+			err = e.BackupDatabaseTo(creds, db, w)
+		}
+		results = append(results, engine.BackupResult{
+			Database: db,
+			Filename: filename,
+			Error:    err,
+		})
+	}
+	return results, nil
+}
+
+// targetPath decides where a restored database is written. A directory or
+// glob host receives dbName inside it; a plain file host is replaced.
+func (e *SQLiteEngine) targetPath(creds config.ServerConfig, dbName string) (string, error) {
+	if creds.Host == "" {
+		return "", fmt.Errorf("sqlite target requires a file path or directory as host")
+	}
+	if info, err := os.Stat(creds.Host); err == nil && info.IsDir() {
+		return filepath.Join(creds.Host, dbName), nil
+	}
+	if strings.ContainsAny(creds.Host, "*?[") {
+		return filepath.Join(filepath.Dir(creds.Host), dbName), nil
+	}
+	return creds.Host, nil
+}
+
+// dbNameFromFile recovers the database name from a backup file name of the
+// form <db>_<timestamp>.sqlite.gz (or .sql.gz, or another codec's extension).
+func dbNameFromFile(filePath string) string {
+	name := filepath.Base(filePath)
+	name = util.TrimCompressedExt(name)
+	name = strings.TrimSuffix(name, ".sqlite")
+	name = strings.TrimSuffix(name, ".sql")
+	if i := strings.LastIndex(name, "_"); i > 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// RestoreBackup writes the restored database next to its destination and
+// renames it into place, so readers never observe a half-written file.
+// Binary copies are decompressed as-is; SQL exports are replayed through sqlite3.
+// The target database must not be in use while it is replaced.
+func (e *SQLiteEngine) RestoreBackup(creds config.ServerConfig, filePath string, dbName string) error {
+	if dbName == "" {
+		dbName = dbNameFromFile(filePath)
+	}
+	destPath, err := e.targetPath(creds, dbName)
+	if err != nil {
+		return err
+	}
+	destDir := filepath.Dir(destPath)
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+
+	// Temp file lives in the destination directory so the final rename is atomic.
+	tmp, err := os.CreateTemp(destDir, "."+filepath.Base(destPath)+".restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+
+	if err := util.DecompressFile(filePath, tmpPath); err != nil {
+		return err
+	}
+
+	isBinary, err := hasSQLiteHeader(tmpPath)
+	if err != nil {
+		return err
+	}
+	if !isBinary {
+		// SQL text export: replay it into a fresh database file.
+		os.Remove(tmpPath)
+		cmd := util.Command("sqlite3", "-bail", tmpPath)
+		if err := util.RestoreFromFile(cmd, filePath); err != nil {
+			return err
+		}
+	}
+
+	cmd := util.Command("sqlite3", tmpPath, "PRAGMA quick_check;")
+	output, err := cmd.CombinedOutput()
+	if err != nil || strings.TrimSpace(string(output)) != "ok" {
+		return fmt.Errorf("restored database failed integrity check: %v, output: %s", err, string(output))
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(destPath); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		return fmt.Errorf("failed to move restored database into place: %w", err)
+	}
+	// A stale WAL from the previous file would be replayed into the new one.
+	// It is only removed once the old file is gone: until then it may hold
+	// committed transactions.
+	os.Remove(destPath + "-wal")
+	os.Remove(destPath + "-shm")
+	return nil
+}
+
+func hasSQLiteHeader(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, len(headerMagic))
+	if _, err := io.ReadFull(f, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return bytes.Equal(buf, headerMagic), nil
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
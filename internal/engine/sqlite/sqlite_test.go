@@ -0,0 +1,210 @@
+package sqlite
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+func requireSQLite(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+}
+
+// sqlite runs statements on the database at path and returns the output.
+func sqlite(t *testing.T, path string, statements string) string {
+	t.Helper()
+	out, err := exec.Command("sqlite3", path, statements).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3 %s: %v: %s", path, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// writeFile writes data to path, creating its directory.
+func writeFile(t *testing.T, path string, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const testRows = "CREATE TABLE t(id INTEGER PRIMARY KEY, name TEXT); INSERT INTO t(name) VALUES ('a'), ('it''s'), (NULL);"
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	requireSQLite(t)
+	e := &SQLiteEngine{}
+	for _, format := range []string{"", "sql"} {
+		t.Run("format "+format, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src", "app.db")
+			os.MkdirAll(filepath.Dir(src), 0755)
+			sqlite(t, src, testRows)
+			want := sqlite(t, src, "SELECT id, quote(name) FROM t ORDER BY id;")
+
+			source := config.ServerConfig{Engine: "sqlite", Host: filepath.Join(dir, "src", "*.db"), Options: map[string]string{"format": format}}
+			backup := filepath.Join(dir, "app.db_2025-01-02T03:04:05Z"+e.Extension(source))
+			if err := e.BackupDatabase(source, "app.db", backup); err != nil {
+				t.Fatal(err)
+			}
+
+			// Restoring over an existing database replaces it and drops its
+			// WAL, which belongs to the old file
+			targetDir := filepath.Join(dir, "target")
+			dest := filepath.Join(targetDir, "app.db")
+			os.MkdirAll(targetDir, 0755)
+			sqlite(t, dest, "CREATE TABLE old(x);")
+			writeFile(t, dest+"-wal", "stale")
+			writeFile(t, dest+"-shm", "stale")
+
+			target := config.ServerConfig{Engine: "sqlite", Host: targetDir}
+			if err := e.RestoreBackup(target, backup, ""); err != nil {
+				t.Fatal(err)
+			}
+			if got := sqlite(t, dest, "SELECT id, quote(name) FROM t ORDER BY id;"); got != want {
+				t.Errorf("restored rows %q, want %q", got, want)
+			}
+			if got := sqlite(t, dest, "SELECT name FROM sqlite_master WHERE name = 'old';"); got != "" {
+				t.Errorf("the old database survived the restore")
+			}
+			for _, suffix := range []string{"-wal", "-shm"} {
+				if _, err := os.Stat(dest + suffix); !os.IsNotExist(err) {
+					t.Errorf("%s of the old database left: %v", suffix, err)
+				}
+			}
+			assertNoTempFiles(t, targetDir)
+		})
+	}
+}
+
+func TestCollidingNames(t *testing.T) {
+	requireSQLite(t)
+	e := &SQLiteEngine{}
+	dir := t.TempDir()
+	for _, site := range []string{"s1", "s2"} {
+		path := filepath.Join(dir, site, "app.db")
+		os.MkdirAll(filepath.Dir(path), 0755)
+		sqlite(t, path, "CREATE TABLE site(name TEXT); INSERT INTO site VALUES ('"+site+"');")
+	}
+	sqlite(t, filepath.Join(dir, "s2", "other.db"), "CREATE TABLE x(y);")
+	source := config.ServerConfig{Engine: "sqlite", Host: filepath.Join(dir, "*", "*.db")}
+
+	dbs, err := e.ListDatabases(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"s1_app.db", "s2_app.db", "other.db"}; !reflect.DeepEqual(dbs, want) {
+		t.Fatalf("databases %q, want %q", dbs, want)
+	}
+
+	backup := filepath.Join(dir, "s2_app.db_2025-01-02T03:04:05Z.sqlite.gz")
+	if err := e.BackupDatabase(source, "s2_app.db", backup); err != nil {
+		t.Fatal(err)
+	}
+	targetDir := filepath.Join(dir, "target")
+	os.MkdirAll(targetDir, 0755)
+	if err := e.RestoreBackup(config.ServerConfig{Engine: "sqlite", Host: targetDir}, backup, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := sqlite(t, filepath.Join(targetDir, "s2_app.db"), "SELECT name FROM site;"); got != "s2" {
+		t.Fatalf("restored the database of %q", got)
+	}
+}
+
+func TestRestoreFailures(t *testing.T) {
+	requireSQLite(t)
+	e := &SQLiteEngine{}
+
+	// compressed writes data to a compressed backup file named after dbName
+	compressed := func(t *testing.T, dir string, data string) string {
+		raw := filepath.Join(dir, "raw")
+		writeFile(t, raw, data)
+		backup := filepath.Join(dir, "app.db_2025-01-02T03:04:05Z.sqlite.gz")
+		if err := util.CreateFile(backup, func(w io.Writer) error { return util.CompressFileTo(raw, w) }); err != nil {
+			t.Fatal(err)
+		}
+		return backup
+	}
+
+	t.Run("corrupt database", func(t *testing.T) {
+		dir := t.TempDir()
+		backup := compressed(t, dir, string(headerMagic)+strings.Repeat("\xff", 4096))
+		targetDir := filepath.Join(dir, "target")
+		dest := filepath.Join(targetDir, "app.db")
+		os.MkdirAll(targetDir, 0755)
+		sqlite(t, dest, testRows)
+		writeFile(t, dest+"-wal", "")
+
+		err := e.RestoreBackup(config.ServerConfig{Engine: "sqlite", Host: targetDir}, backup, "")
+		if err == nil || !strings.Contains(err.Error(), "integrity check") {
+			t.Fatalf("restoring a corrupt database: %v", err)
+		}
+		if got := sqlite(t, dest, "SELECT count(*) FROM t;"); got != "3" {
+			t.Errorf("the target database changed: %s rows", got)
+		}
+		if _, err := os.Stat(dest + "-wal"); err != nil {
+			t.Errorf("the WAL of the target was removed: %v", err)
+		}
+		assertNoTempFiles(t, targetDir)
+	})
+
+	t.Run("invalid SQL export", func(t *testing.T) {
+		dir := t.TempDir()
+		backup := compressed(t, dir, "CREATE TABLE t(x);\nNOT SQL AT ALL;\n")
+		targetDir := filepath.Join(dir, "target")
+		os.MkdirAll(targetDir, 0755)
+		if err := e.RestoreBackup(config.ServerConfig{Engine: "sqlite", Host: targetDir}, backup, ""); err == nil {
+			t.Fatal("restoring an invalid SQL export succeeded")
+		}
+		if _, err := os.Stat(filepath.Join(targetDir, "app.db")); !os.IsNotExist(err) {
+			t.Errorf("a database was created: %v", err)
+		}
+		assertNoTempFiles(t, targetDir)
+	})
+
+	t.Run("failed rename keeps the WAL", func(t *testing.T) {
+		dir := t.TempDir()
+		src := filepath.Join(dir, "app.db")
+		sqlite(t, src, testRows)
+		backup := filepath.Join(dir, "app.db_2025-01-02T03:04:05Z.sqlite.gz")
+		if err := e.BackupDatabase(config.ServerConfig{Engine: "sqlite", Host: src}, "app.db", backup); err != nil {
+			t.Fatal(err)
+		}
+		// A directory in place of the database cannot be replaced
+		targetDir := filepath.Join(dir, "target")
+		dest := filepath.Join(targetDir, "app.db")
+		writeFile(t, filepath.Join(dest, "keep"), "")
+		writeFile(t, dest+"-wal", "committed")
+
+		if err := e.RestoreBackup(config.ServerConfig{Engine: "sqlite", Host: targetDir}, backup, ""); err == nil {
+			t.Fatal("restore succeeded")
+		}
+		if data, err := os.ReadFile(dest + "-wal"); err != nil || string(data) != "committed" {
+			t.Errorf("the WAL was lost: %q %v", data, err)
+		}
+		assertNoTempFiles(t, targetDir)
+	})
+}
+
+// assertNoTempFiles fails if a restore left temporary files in dir.
+func assertNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".restore-") {
+			t.Errorf("temporary file %s left", entry.Name())
+		}
+	}
+}
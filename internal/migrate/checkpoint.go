@@ -0,0 +1,86 @@
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// checkpoint records the progress of a migration into MongoDB so a failed
+// run can resume after the last primary key that was written.
+type checkpoint struct {
+	path     string
+	Source   string                      `json:"source"`
+	Target   string                      `json:"target"`
+	Database string                      `json:"database"`
+	Tables   map[string]*tableCheckpoint `json:"tables"`
+}
+
+type tableCheckpoint struct {
+	// Started is set once the first batch has been sent
+	Started bool `json:"started"`
+	// Done is set when every row has been written
+	Done bool `json:"done"`
+	// After holds the primary key of the last row written
+	After []string `json:"after,omitempty"`
+	Rows  int64    `json:"rows"`
+}
+
+// loadCheckpoint reads path, or starts a new checkpoint if it does not exist.
+// An empty path disables checkpointing.
+func loadCheckpoint(path string, report *Report) (*checkpoint, error) {
+	cp := &checkpoint{
+		path:     path,
+		Source:   report.Source,
+		Target:   report.Target,
+		Database: report.Database,
+		Tables:   make(map[string]*tableCheckpoint),
+	}
+	if path == "" {
+		return cp, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved checkpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file %s: %w", path, err)
+	}
+	if saved.Source != cp.Source || saved.Target != cp.Target || saved.Database != cp.Database {
+		return nil, fmt.Errorf("checkpoint %s belongs to %s/%s -> %s, remove it to start over", path, saved.Source, saved.Database, saved.Target)
+	}
+	if saved.Tables != nil {
+		cp.Tables = saved.Tables
+	}
+	return cp, nil
+}
+
+func (cp *checkpoint) table(name string) *tableCheckpoint {
+	t, ok := cp.Tables[name]
+	if !ok {
+		t = &tableCheckpoint{}
+		cp.Tables[name] = t
+	}
+	return t
+}
+
+// save writes the checkpoint atomically.
+func (cp *checkpoint) save() error {
+	if cp.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := cp.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
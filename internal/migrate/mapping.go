@@ -0,0 +1,137 @@
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Mapping controls how relational tables are turned into MongoDB documents.
+// Tables that are not listed are copied into a collection of the same name
+// with default conversions.
+type Mapping struct {
+	// BatchSize is the number of documents per insert batch (default 1000)
+	BatchSize int `json:"batch_size,omitempty"`
+	// Tables holds per-table rules
+	Tables []TableMapping `json:"tables"`
+	// Skip lists tables that are not copied at all
+	Skip []string `json:"skip,omitempty"`
+}
+
+// ColumnRules renames, converts and drops columns.
+type ColumnRules struct {
+	// Rename maps a column to a field name; dots create nested documents
+	Rename map[string]string `json:"rename,omitempty"`
+	// Types forces the BSON type of a column, see ValueTypes
+	Types map[string]string `json:"types,omitempty"`
+	// Exclude lists columns that are left out
+	Exclude []string `json:"exclude,omitempty"`
+	// OmitNulls leaves out NULL fields instead of storing null
+	OmitNulls bool `json:"omit_nulls,omitempty"`
+}
+
+// TableMapping maps one table to a collection.
+type TableMapping struct {
+	Table string `json:"table"`
+	// Collection defaults to the table name
+	Collection string `json:"collection,omitempty"`
+	ColumnRules
+	// Embed stores rows of child tables as arrays inside each document
+	Embed []EmbedMapping `json:"embed,omitempty"`
+}
+
+// EmbedMapping embeds the rows of a child table that reference the parent.
+type EmbedMapping struct {
+	Table string `json:"table"`
+	// As is the array field name (default: the child table name)
+	As string `json:"as,omitempty"`
+	// ForeignKey is the child column pointing at the parent; it is taken from
+	// the schema when the child has a single-column foreign key to the parent
+	ForeignKey string `json:"foreign_key,omitempty"`
+	// References is the referenced parent column (default: from the foreign key)
+	References string `json:"references,omitempty"`
+	ColumnRules
+}
+
+// ValueTypes are the conversions accepted in ColumnRules.Types.
+var ValueTypes = []string{"string", "int", "long", "double", "decimal", "bool", "date", "json", "binary", "objectid", "uuid"}
+
+// LoadMapping reads a mapping file.
+func LoadMapping(path string) (*Mapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Mapping
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid mapping file %s: %w", path, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("invalid mapping file %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+func (m *Mapping) validate() error {
+	check := func(where string, rules ColumnRules) error {
+		for col, typ := range rules.Types {
+			if !contains(ValueTypes, typ) {
+				return fmt.Errorf("%s.%s: unknown type %q", where, col, typ)
+			}
+		}
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, t := range m.Tables {
+		if t.Table == "" {
+			return fmt.Errorf("table mapping without a table name")
+		}
+		if seen[t.Table] {
+			return fmt.Errorf("table %s is mapped twice", t.Table)
+		}
+		seen[t.Table] = true
+		if err := check(t.Table, t.ColumnRules); err != nil {
+			return err
+		}
+		for _, e := range t.Embed {
+			if e.Table == "" {
+				return fmt.Errorf("%s: embed without a table name", t.Table)
+			}
+			if err := check(e.Table, e.ColumnRules); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// table returns the rules for name, or nil if it is not listed.
+func (m *Mapping) table(name string) *TableMapping {
+	for i := range m.Tables {
+		if m.Tables[i].Table == name {
+			return &m.Tables[i]
+		}
+	}
+	return nil
+}
+
+// embedded reports whether name is embedded into another table.
+func (m *Mapping) embedded(name string) bool {
+	for _, t := range m.Tables {
+		for _, e := range t.Embed {
+			if e.Table == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,523 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"mydbportal.com/dbmigrate/internal/config"
+	"mydbportal.com/dbmigrate/internal/engine"
+	"mydbportal.com/dbmigrate/internal/engine/postgres"
+	"mydbportal.com/dbmigrate/internal/schema"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// Options controls a migration.
+type Options struct {
+	// Database is the source database to migrate
+	Database string
+	// TargetDatabase defaults to Database
+	TargetDatabase string
+	// Tables limits the migration to these tables (all if empty)
+	Tables []string
+	// EnumMode is EnumCheck (default) or EnumType
+	EnumMode string
+	// DropExisting drops tables (or collections) that already exist on the target
+	DropExisting bool
+	// Mapping holds the table to collection rules for MongoDB targets
+	Mapping *Mapping
+	// Checkpoint is the progress file used to resume a MongoDB migration
+	Checkpoint string
+	// BatchSize overrides the number of documents per insert batch
+	BatchSize int
+	// Out receives the progress messages for people (discarded if nil)
+	Out io.Writer
+}
+
+// output returns where progress messages go.
+func (opts Options) output() io.Writer {
+	if opts.Out == nil {
+		return io.Discard
+	}
+	return opts.Out
+}
+
+// copyLoader is implemented by engines that accept COPY text format.
+type copyLoader interface {
+	CopyIn(creds config.ServerConfig, dbName string, table string, columns []string, r io.Reader) error
+}
+
+// documentLoader is implemented by engines that accept Extended JSON documents.
+type documentLoader interface {
+	ImportDocuments(creds config.ServerConfig, dbName string, collection string, r io.Reader, upsert bool, drop bool) error
+}
+
+// Run migrates between the engines of source and target.
+func Run(source, target config.ServerConfig, opts Options) (*Report, error) {
+	if target.Engine == "mongo" {
+		return SQLToMongo(source, target, opts)
+	}
+	return MySQLToPostgres(source, target, opts)
+}
+
+// MySQLToPostgres copies the schema and data of a MySQL database into
+// PostgreSQL: tables are created without indexes, bulk loaded with COPY, and
+// primary keys, indexes and foreign keys are built afterwards. The returned
+// report lists everything that could not be mapped exactly; an error is
+// returned with it if any step failed.
+func MySQLToPostgres(source, target config.ServerConfig, opts Options) (*Report, error) {
+	if source.Engine != "mysql" || target.Engine != "postgres" {
+		return nil, fmt.Errorf("unsupported migration path %s -> %s (only mysql -> postgres)", source.Engine, target.Engine)
+	}
+	if opts.TargetDatabase == "" {
+		opts.TargetDatabase = opts.Database
+	}
+	if opts.EnumMode == "" {
+		opts.EnumMode = EnumCheck
+	}
+	if opts.EnumMode != EnumCheck && opts.EnumMode != EnumType {
+		return nil, fmt.Errorf("unknown enum mode %q (want %s or %s)", opts.EnumMode, EnumCheck, EnumType)
+	}
+
+	srcEng, err := engine.Get(source.Engine)
+	if err != nil {
+		return nil, err
+	}
+	dstEng, err := engine.Get(target.Engine)
+	if err != nil {
+		return nil, err
+	}
+	inspector, ok1 := srcEng.(engine.SchemaInspector)
+	reader, ok2 := srcEng.(engine.TableReader)
+	executor, ok3 := dstEng.(engine.SQLExecutor)
+	creator, ok4 := dstEng.(engine.DatabaseCreator)
+	loader, ok5 := dstEng.(copyLoader)
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 {
+		return nil, fmt.Errorf("engines %s/%s do not support migration", source.Engine, target.Engine)
+	}
+
+	report := &Report{
+		Source:         source.ID,
+		Target:         target.ID,
+		Database:       opts.Database,
+		TargetDatabase: opts.TargetDatabase,
+	}
+
+	fmt.Fprintf(opts.output(), "Inspecting %s on %s...\n", opts.Database, source.ID)
+	sch, err := inspector.InspectSchema(source, opts.Database)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := selectTables(sch, opts.Tables)
+	if err != nil {
+		return nil, err
+	}
+
+	var plans []pgTable
+	for _, t := range tables {
+		plans = append(plans, planMySQLTable(t, opts.EnumMode, report))
+	}
+	foreignKeys := planMySQLForeignKeys(tables, report)
+
+	if err := creator.CreateDatabase(target, opts.TargetDatabase); err != nil {
+		return report, fmt.Errorf("failed to create target database: %w", err)
+	}
+
+	// Schema: one transaction, so a failure leaves nothing half created
+	var ddl []string
+	ddl = append(ddl, "BEGIN;")
+	for _, p := range plans {
+		if opts.DropExisting {
+			ddl = append(ddl, fmt.Sprintf("DROP TABLE IF EXISTS %s CASCADE;", postgres.QuoteIdent(p.Name)))
+		}
+	}
+	for _, p := range plans {
+		for _, pre := range p.PreSQL {
+			if opts.DropExisting {
+				// CREATE TYPE "x" ... -> DROP TYPE IF EXISTS "x"
+				name := strings.SplitN(strings.TrimPrefix(pre, "CREATE TYPE "), " AS ", 2)[0]
+				ddl = append(ddl, fmt.Sprintf("DROP TYPE IF EXISTS %s CASCADE;", name))
+			}
+			ddl = append(ddl, pre)
+		}
+		ddl = append(ddl, p.Create)
+	}
+	ddl = append(ddl, "COMMIT;")
+
+	fmt.Fprintf(opts.output(), "Creating %d tables in %s on %s...\n", len(plans), opts.TargetDatabase, target.ID)
+	if _, err := executor.ExecSQL(target, opts.TargetDatabase, strings.Join(ddl, "\n")); err != nil {
+		return report, fmt.Errorf("failed to create schema: %w", err)
+	}
+
+	// Data
+	for i := range plans {
+		p := &plans[i]
+		rows, zeroDates, err := copyTable(reader, loader, source, target, opts, p)
+		res := TableResult{Name: p.Name, Rows: rows}
+		if zeroDates > 0 {
+			report.issue(p.Name, "", "%d zero dates loaded as NULL (or -infinity in NOT NULL columns)", zeroDates)
+		}
+		if err != nil {
+			res.Error = err.Error()
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", p.Name, err))
+			util.Log().WithField("table", p.Name).WithError(err).Error("table copy failed")
+			fmt.Fprintf(opts.output(), " [FAILED] %s: %v\n", p.Name, err)
+		} else {
+			util.Log().WithFields(logrus.Fields{"table": p.Name, "rows": rows}).Info("table copied")
+			fmt.Fprintf(opts.output(), " [OK] %s (%d rows)\n", p.Name, rows)
+		}
+		report.Tables = append(report.Tables, res)
+	}
+
+	// Keys, indexes and identity sequences. Each statement runs on its own so
+	// one failure (e.g. duplicate values MySQL tolerated) does not block the rest.
+	fmt.Fprintln(opts.output(), "Building indexes and constraints...")
+	var post []string
+	for _, p := range plans {
+		post = append(post, p.PostSQL...)
+		post = append(post, p.Sequence...)
+	}
+	post = append(post, foreignKeys...)
+	for _, stmt := range post {
+		if _, err := executor.ExecSQL(target, opts.TargetDatabase, stmt); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", stmt, err))
+			util.Log().WithField("statement", stmt).WithError(err).Error("statement failed")
+			fmt.Fprintf(opts.output(), " [FAILED] %s\n", stmt)
+		}
+	}
+
+	if len(report.Errors) > 0 {
+		return report, fmt.Errorf("migration finished with %d errors", len(report.Errors))
+	}
+	return report, nil
+}
+
+// selectTables returns the tables to migrate, in schema order.
+func selectTables(sch *schema.Schema, names []string) ([]schema.Table, error) {
+	if len(names) == 0 {
+		return sch.Tables, nil
+	}
+	var tables []schema.Table
+	for _, n := range names {
+		t := sch.Table(n)
+		if t == nil {
+			return nil, fmt.Errorf("table not found: %s", n)
+		}
+		tables = append(tables, *t)
+	}
+	return tables, nil
+}
+
+// copyTable streams a table from the source into COPY on the target.
+func copyTable(reader engine.TableReader, loader copyLoader, source, target config.ServerConfig, opts Options, p *pgTable) (rows int64, zeroDates int64, err error) {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		bw := bufio.NewWriterSize(pw, 1<<20)
+		err := reader.ReadTable(source, opts.Database, p.readTable(), engine.ReadOptions{}, func(row []sql.NullString) error {
+			for i, v := range row {
+				if i > 0 {
+					bw.WriteByte('\t')
+				}
+				field, zero := copyValue(v, p.Columns[i])
+				if zero {
+					zeroDates++
+				}
+				bw.WriteString(field)
+			}
+			rows++
+			return bw.WriteByte('\n')
+		})
+		if err == nil {
+			err = bw.Flush()
+		}
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	loadErr := loader.CopyIn(target, opts.TargetDatabase, p.Name, p.columnNames(), pr)
+	// Unblock the reader if COPY stopped consuming early
+	pr.CloseWithError(io.ErrClosedPipe)
+	readErr := <-done
+
+	if readErr != nil && readErr != io.ErrClosedPipe {
+		return rows, zeroDates, readErr
+	}
+	return rows, zeroDates, loadErr
+}
+
+// SQLToMongo copies the tables of a MySQL or PostgreSQL database into MongoDB
+// collections following opts.Mapping. Rows are read in primary key order and
+// inserted in batches; with opts.Checkpoint set, progress is saved after each
+// batch and a later run with the same checkpoint resumes where it stopped.
+func SQLToMongo(source, target config.ServerConfig, opts Options) (*Report, error) {
+	if target.Engine != "mongo" {
+		return nil, fmt.Errorf("unsupported migration path %s -> %s", source.Engine, target.Engine)
+	}
+	if opts.TargetDatabase == "" {
+		opts.TargetDatabase = opts.Database
+	}
+	mapping := opts.Mapping
+	if mapping == nil {
+		mapping = &Mapping{}
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = mapping.BatchSize
+	}
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	srcEng, err := engine.Get(source.Engine)
+	if err != nil {
+		return nil, err
+	}
+	dstEng, err := engine.Get(target.Engine)
+	if err != nil {
+		return nil, err
+	}
+	inspector, ok1 := srcEng.(engine.SchemaInspector)
+	reader, ok2 := srcEng.(engine.TableReader)
+	loader, ok3 := dstEng.(documentLoader)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, fmt.Errorf("engines %s/%s do not support migration", source.Engine, target.Engine)
+	}
+
+	report := &Report{
+		Source:         source.ID,
+		Target:         target.ID,
+		Database:       opts.Database,
+		TargetDatabase: opts.TargetDatabase,
+	}
+
+	fmt.Fprintf(opts.output(), "Inspecting %s on %s...\n", opts.Database, source.ID)
+	sch, err := inspector.InspectSchema(source, opts.Database)
+	if err != nil {
+		return nil, err
+	}
+	var tables []schema.Table
+	if len(opts.Tables) > 0 {
+		if tables, err = selectTables(sch, opts.Tables); err != nil {
+			return nil, err
+		}
+	} else {
+		// Embedded tables are only copied on their own if the mapping lists them
+		for _, t := range sch.Tables {
+			if contains(mapping.Skip, t.Name) || (mapping.embedded(t.Name) && mapping.table(t.Name) == nil) {
+				continue
+			}
+			tables = append(tables, t)
+		}
+	}
+
+	type collectionPlan struct {
+		plan       *docPlan
+		collection string
+	}
+	var plans []collectionPlan
+	for _, t := range tables {
+		rules := mapping.table(t.Name)
+		if rules == nil {
+			rules = &TableMapping{Table: t.Name}
+		}
+		p := planDocument(t, rules.ColumnRules, true, report)
+		for _, e := range rules.Embed {
+			child := sch.Table(e.Table)
+			if child == nil {
+				return nil, fmt.Errorf("table not found: %s", e.Table)
+			}
+			ep, err := planEmbed(p, t, *child, e, report)
+			if err != nil {
+				return nil, err
+			}
+			p.embeds = append(p.embeds, ep)
+		}
+		if len(t.PrimaryKey) == 0 {
+			report.issue(t.Name, "", "no primary key: documents get generated _id values and the table is copied again from the start when resuming")
+		}
+		collection := rules.Collection
+		if collection == "" {
+			collection = t.Name
+		}
+		plans = append(plans, collectionPlan{plan: p, collection: collection})
+	}
+
+	cp, err := loadCheckpoint(opts.Checkpoint, report)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(opts.output(), "Copying %d tables into %s on %s...\n", len(plans), opts.TargetDatabase, target.ID)
+	for _, cplan := range plans {
+		p := cplan.plan
+		tcp := cp.table(p.table)
+		rows, err := copyDocuments(reader, loader, source, target, opts, p, cplan.collection, batchSize, cp, tcp)
+		p.reportFailures(report)
+		res := TableResult{Name: p.table, Rows: rows}
+		if err != nil {
+			res.Error = err.Error()
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", p.table, err))
+			util.Log().WithField("table", p.table).WithError(err).Error("table copy failed")
+			fmt.Fprintf(opts.output(), " [FAILED] %s: %v\n", p.table, err)
+		} else {
+			util.Log().WithFields(logrus.Fields{"table": p.table, "collection": cplan.collection, "documents": rows}).Info("table copied")
+			fmt.Fprintf(opts.output(), " [OK] %s -> %s (%d documents)\n", p.table, cplan.collection, rows)
+		}
+		report.Tables = append(report.Tables, res)
+	}
+
+	if len(report.Errors) > 0 {
+		if opts.Checkpoint != "" {
+			return report, fmt.Errorf("migration finished with %d errors, run again with --checkpoint %s to resume", len(report.Errors), opts.Checkpoint)
+		}
+		return report, fmt.Errorf("migration finished with %d errors", len(report.Errors))
+	}
+	return report, nil
+}
+
+// copyDocuments copies one table into a collection, batchSize documents at a
+// time, recording each batch in the checkpoint.
+func copyDocuments(reader engine.TableReader, loader documentLoader, source, target config.ServerConfig, opts Options, p *docPlan, collection string, batchSize int, cp *checkpoint, tcp *tableCheckpoint) (int64, error) {
+	if tcp.Done {
+		fmt.Fprintf(opts.output(), " [SKIP] %s (completed in a previous run)\n", p.table)
+		return tcp.Rows, nil
+	}
+	resumed := tcp.Started
+	if resumed && len(p.key) == 0 {
+		tcp.Rows = 0
+	}
+	// Collections without a resumable key are rebuilt from scratch
+	dropFirst := (opts.DropExisting && !resumed) || (resumed && len(p.key) == 0)
+	first := true
+
+	flush := func(rows [][]sql.NullString) error {
+		docs := make([]*document, len(rows))
+		for i, row := range rows {
+			docs[i] = p.buildDocument(row)
+		}
+		if err := attachEmbeds(reader, source, opts.Database, p, rows, docs); err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, doc := range docs {
+			if err := enc.Encode(doc); err != nil {
+				return err
+			}
+		}
+		// A batch may have been partly written before the failure we resume from
+		upsert := first && resumed && len(p.key) > 0
+		if err := loader.ImportDocuments(target, opts.TargetDatabase, collection, &buf, upsert, first && dropFirst); err != nil {
+			return err
+		}
+
+		first = false
+		tcp.Started = true
+		tcp.Rows += int64(len(rows))
+		if len(p.key) > 0 && len(rows) > 0 {
+			tcp.After = p.keyValues(rows[len(rows)-1])
+		}
+		return cp.save()
+	}
+
+	if len(p.key) > 0 {
+		for {
+			var rows [][]sql.NullString
+			err := reader.ReadTable(source, opts.Database, p.readTable(), engine.ReadOptions{
+				OrderBy: p.keyColumns(),
+				After:   tcp.After,
+				Limit:   batchSize,
+			}, func(row []sql.NullString) error {
+				rows = append(rows, row)
+				return nil
+			})
+			if err != nil {
+				return tcp.Rows, err
+			}
+			if len(rows) > 0 {
+				if err := flush(rows); err != nil {
+					return tcp.Rows, err
+				}
+			}
+			if len(rows) < batchSize {
+				break
+			}
+		}
+	} else {
+		var rows [][]sql.NullString
+		err := reader.ReadTable(source, opts.Database, p.readTable(), engine.ReadOptions{}, func(row []sql.NullString) error {
+			rows = append(rows, row)
+			if len(rows) < batchSize {
+				return nil
+			}
+			err := flush(rows)
+			rows = nil
+			return err
+		})
+		if err == nil && len(rows) > 0 {
+			err = flush(rows)
+		}
+		if err != nil {
+			return tcp.Rows, err
+		}
+	}
+
+	// Nothing was written, but an existing collection must still be dropped
+	if first && dropFirst {
+		if err := flush(nil); err != nil {
+			return tcp.Rows, err
+		}
+	}
+
+	tcp.Done = true
+	return tcp.Rows, cp.save()
+}
+
+// attachEmbeds reads the child rows of each embed for a batch of parent rows
+// and stores them as arrays in docs.
+func attachEmbeds(reader engine.TableReader, source config.ServerConfig, dbName string, p *docPlan, rows [][]sql.NullString, docs []*document) error {
+	for _, e := range p.embeds {
+		seen := make(map[string]bool)
+		var refs []string
+		for _, row := range rows {
+			v := row[e.refIndex]
+			if v.Valid && !seen[v.String] {
+				seen[v.String] = true
+				refs = append(refs, v.String)
+			}
+		}
+
+		children := make(map[string][]*document)
+		if len(refs) > 0 {
+			err := reader.ReadTable(source, dbName, e.child.readTable(), engine.ReadOptions{
+				OrderBy:   e.child.keyColumns(),
+				KeyColumn: e.fkColumn,
+				KeyValues: refs,
+			}, func(row []sql.NullString) error {
+				fk := row[e.fkIndex].String
+				children[fk] = append(children[fk], e.child.buildDocument(row))
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", e.child.table, err)
+			}
+		}
+
+		for i, row := range rows {
+			list := children[row[e.refIndex].String]
+			if list == nil {
+				list = []*document{}
+			}
+			docs[i].set(e.as, list)
+		}
+	}
+	return nil
+}
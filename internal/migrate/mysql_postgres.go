@@ -0,0 +1,427 @@
+package migrate
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"mydbportal.com/dbmigrate/internal/engine/postgres"
+	"mydbportal.com/dbmigrate/internal/schema"
+)
+
+// Enum handling for MySQL ENUM columns.
+const (
+	EnumCheck = "check" // varchar with a CHECK (col IN (...)) constraint
+	EnumType  = "type"  // a dedicated CREATE TYPE ... AS ENUM
+)
+
+// valueKind selects how a MySQL value is rewritten for COPY.
+type valueKind int
+
+const (
+	valueText valueKind = iota
+	valueBool
+	valueBytes
+	valueDate // zero dates ('0000-00-00') become NULL, or -infinity if NOT NULL
+)
+
+// pgColumn is the PostgreSQL translation of a MySQL column.
+type pgColumn struct {
+	source   schema.Column
+	Name     string
+	Type     string
+	NotNull  bool
+	Default  string
+	Identity bool
+	Check    string
+	kind     valueKind
+}
+
+// pgTable is the PostgreSQL translation of a MySQL table.
+type pgTable struct {
+	source   schema.Table
+	Name     string
+	Columns  []pgColumn
+	PreSQL   []string // e.g. CREATE TYPE for enums
+	Create   string
+	PostSQL  []string // primary key and indexes, built after the data is loaded
+	Sequence []string // identity resyncs
+}
+
+// readTable is the source table restricted to the columns that are copied.
+func (t *pgTable) readTable() schema.Table {
+	rt := schema.Table{Name: t.source.Name}
+	for _, c := range t.Columns {
+		rt.Columns = append(rt.Columns, c.source)
+	}
+	return rt
+}
+
+func (t *pgTable) columnNames() []string {
+	names := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+var (
+	typeArgs   = regexp.MustCompile(`\(([^)]*)\)`)
+	defaultNow = regexp.MustCompile(`(?i)^(current_timestamp|now|localtimestamp|localtime)(\(\d*\))?$`)
+	numeric    = regexp.MustCompile(`^-?\d+(\.\d+)?([eE][-+]?\d+)?$`)
+)
+
+// translateMySQLType maps a MySQL column type to PostgreSQL.
+func translateMySQLType(c schema.Column, table string, enumMode string, r *Report) (pgType string, kind valueKind, check string, pre string) {
+	unsigned := strings.Contains(c.Type, "unsigned")
+	args := ""
+	if m := typeArgs.FindStringSubmatch(c.Type); m != nil {
+		args = m[1]
+	}
+
+	switch c.DataType {
+	case "tinyint":
+		if strings.HasPrefix(c.Type, "tinyint(1)") {
+			return "boolean", valueBool, "", ""
+		}
+		return "smallint", valueText, "", ""
+	case "smallint":
+		if unsigned {
+			return "integer", valueText, "", ""
+		}
+		return "smallint", valueText, "", ""
+	case "mediumint":
+		return "integer", valueText, "", ""
+	case "int", "integer":
+		if unsigned {
+			return "bigint", valueText, "", ""
+		}
+		return "integer", valueText, "", ""
+	case "bigint":
+		if unsigned {
+			if c.AutoIncrement {
+				r.issue(table, c.Name, "BIGINT UNSIGNED AUTO_INCREMENT mapped to bigint identity; values above 9223372036854775807 will fail")
+				return "bigint", valueText, "", ""
+			}
+			r.issue(table, c.Name, "BIGINT UNSIGNED mapped to numeric(20,0)")
+			return "numeric(20,0)", valueText, "", ""
+		}
+		return "bigint", valueText, "", ""
+	case "decimal", "numeric":
+		if args != "" {
+			return "numeric(" + args + ")", valueText, "", ""
+		}
+		return "numeric", valueText, "", ""
+	case "float":
+		return "real", valueText, "", ""
+	case "double", "real":
+		return "double precision", valueText, "", ""
+	case "bit":
+		if args == "" || args == "1" {
+			return "boolean", valueBool, "", ""
+		}
+		return "bit varying(" + args + ")", valueText, "", ""
+	case "char":
+		return "char(" + orDefault(args, "1") + ")", valueText, "", ""
+	case "varchar":
+		return "varchar(" + args + ")", valueText, "", ""
+	case "tinytext", "text", "mediumtext", "longtext":
+		return "text", valueText, "", ""
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		return "bytea", valueBytes, "", ""
+	case "date":
+		return "date", valueDate, "", ""
+	case "datetime":
+		return precision("timestamp", args), valueDate, "", ""
+	case "timestamp":
+		return precision("timestamptz", args), valueDate, "", ""
+	case "time":
+		r.issue(table, c.Name, "TIME mapped to time; MySQL durations outside 00:00:00-24:00:00 will fail to load")
+		return precision("time", args), valueText, "", ""
+	case "year":
+		return "smallint", valueText, "", ""
+	case "json":
+		return "jsonb", valueText, "", ""
+	case "enum":
+		values := parseEnumValues(c.Type)
+		quoted := make([]string, len(values))
+		for i, v := range values {
+			quoted[i] = postgres.QuoteString(v)
+		}
+		if enumMode == EnumType {
+			typeName := truncateIdent(table + "_" + c.Name)
+			pre := fmt.Sprintf("CREATE TYPE %s AS ENUM (%s);", postgres.QuoteIdent(typeName), strings.Join(quoted, ", "))
+			return postgres.QuoteIdent(typeName), valueText, "", pre
+		}
+		width := 1
+		for _, v := range values {
+			if len(v) > width {
+				width = len(v)
+			}
+		}
+		check := fmt.Sprintf("CHECK (%s IN (%s))", postgres.QuoteIdent(c.Name), strings.Join(quoted, ", "))
+		return fmt.Sprintf("varchar(%d)", width), valueText, check, ""
+	case "set":
+		r.issue(table, c.Name, "SET has no PostgreSQL equivalent; mapped to text holding the comma separated members")
+		return "text", valueText, "", ""
+	case "geometry", "point", "linestring", "polygon", "multipoint",
+		"multilinestring", "multipolygon", "geometrycollection", "geomcollection":
+		r.issue(table, c.Name, "spatial type %s mapped to text holding WKT", c.DataType)
+		return "text", valueText, "", ""
+	}
+
+	r.issue(table, c.Name, "unknown type %s mapped to text", c.Type)
+	return "text", valueText, "", ""
+}
+
+// translateMySQLDefault maps a column default, or returns "" if it is dropped.
+func translateMySQLDefault(c schema.Column, pgType string, kind valueKind, table string, r *Report) string {
+	if c.Default == nil || c.AutoIncrement {
+		return ""
+	}
+	def := *c.Default
+
+	if defaultNow.MatchString(def) {
+		return "CURRENT_TIMESTAMP"
+	}
+	if strings.Contains(c.Extra, "DEFAULT_GENERATED") {
+		r.issue(table, c.Name, "expression default %s was not translated", def)
+		return ""
+	}
+
+	switch kind {
+	case valueBool:
+		switch def {
+		case "0", "b'0'":
+			return "false"
+		case "1", "b'1'":
+			return "true"
+		}
+		r.issue(table, c.Name, "boolean default %s was not translated", def)
+		return ""
+	case valueBytes:
+		r.issue(table, c.Name, "binary default was not translated")
+		return ""
+	case valueDate:
+		if strings.HasPrefix(def, "0000-00-00") {
+			r.issue(table, c.Name, "zero date default %s was dropped", def)
+			return ""
+		}
+		return postgres.QuoteString(def)
+	}
+
+	if numeric.MatchString(def) && !strings.HasPrefix(pgType, "varchar") && !strings.HasPrefix(pgType, "char") && pgType != "text" {
+		return def
+	}
+	if strings.HasPrefix(pgType, "bit varying") {
+		return "B" + postgres.QuoteString(strings.TrimSuffix(strings.TrimPrefix(def, "b'"), "'"))
+	}
+	return postgres.QuoteString(def)
+}
+
+// planMySQLTable builds the PostgreSQL DDL for t. Constraints other than NOT
+// NULL and CHECK are deferred to PostSQL so the bulk load runs without indexes.
+func planMySQLTable(t schema.Table, enumMode string, r *Report) pgTable {
+	pt := pgTable{source: t, Name: t.Name}
+	var defs []string
+	var checks []string
+
+	for _, c := range t.Columns {
+		if strings.Contains(c.Extra, "GENERATED") && !strings.Contains(c.Extra, "DEFAULT_GENERATED") {
+			r.issue(t.Name, c.Name, "generated column skipped (%s)", c.Extra)
+			continue
+		}
+		if strings.Contains(strings.ToLower(c.Extra), "on update") {
+			r.issue(t.Name, c.Name, "ON UPDATE CURRENT_TIMESTAMP has no PostgreSQL equivalent; add a trigger if it is needed")
+		}
+
+		pgType, kind, check, pre := translateMySQLType(c, t.Name, enumMode, r)
+		col := pgColumn{
+			source:  c,
+			Name:    c.Name,
+			Type:    pgType,
+			NotNull: !c.Nullable,
+			kind:    kind,
+			Check:   check,
+		}
+		if pre != "" {
+			pt.PreSQL = append(pt.PreSQL, pre)
+		}
+		if c.AutoIncrement {
+			switch pgType {
+			case "smallint", "integer", "bigint":
+				col.Identity = true
+				pt.Sequence = append(pt.Sequence, fmt.Sprintf(
+					"SELECT setval(pg_get_serial_sequence(%s, %s), COALESCE(MAX(%s), 0) + 1, false) FROM %s;",
+					postgres.QuoteString(postgres.QuoteIdent(t.Name)), postgres.QuoteString(c.Name),
+					postgres.QuoteIdent(c.Name), postgres.QuoteIdent(t.Name)))
+			default:
+				r.issue(t.Name, c.Name, "AUTO_INCREMENT on %s cannot become an identity column", pgType)
+			}
+		}
+		col.Default = translateMySQLDefault(c, pgType, kind, t.Name, r)
+
+		def := postgres.QuoteIdent(col.Name) + " " + col.Type
+		if col.Identity {
+			def += " GENERATED BY DEFAULT AS IDENTITY"
+		}
+		if col.NotNull {
+			def += " NOT NULL"
+		}
+		if col.Default != "" {
+			def += " DEFAULT " + col.Default
+		}
+		defs = append(defs, def)
+		if col.Check != "" {
+			checks = append(checks, col.Check)
+		}
+		pt.Columns = append(pt.Columns, col)
+	}
+
+	pt.Create = fmt.Sprintf("CREATE TABLE %s (\n  %s\n);", postgres.QuoteIdent(t.Name), strings.Join(append(defs, checks...), ",\n  "))
+
+	if len(t.PrimaryKey) > 0 {
+		pt.PostSQL = append(pt.PostSQL, fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (%s);",
+			postgres.QuoteIdent(t.Name), quoteIdents(t.PrimaryKey)))
+	}
+	for _, idx := range t.Indexes {
+		switch idx.Kind {
+		case "FULLTEXT", "SPATIAL", "FUNCTIONAL":
+			r.objectIssue(t.Name, idx.Name, "%s index skipped; recreate it manually", idx.Kind)
+			continue
+		}
+		if idx.Partial {
+			r.objectIssue(t.Name, idx.Name, "prefix index rebuilt over the full column values")
+		}
+		unique := ""
+		if idx.Unique {
+			unique = "UNIQUE "
+		}
+		pt.PostSQL = append(pt.PostSQL, fmt.Sprintf("CREATE %sINDEX %s ON %s (%s);", unique,
+			postgres.QuoteIdent(truncateIdent(t.Name+"_"+idx.Name)), postgres.QuoteIdent(t.Name), quoteIdents(idx.Columns)))
+	}
+
+	return pt
+}
+
+// planMySQLForeignKeys builds the foreign keys among the migrated tables.
+func planMySQLForeignKeys(tables []schema.Table, r *Report) []string {
+	migrated := make(map[string]bool)
+	for _, t := range tables {
+		migrated[t.Name] = true
+	}
+
+	var stmts []string
+	for _, t := range tables {
+		for _, fk := range t.ForeignKeys {
+			if !migrated[fk.RefTable] {
+				r.objectIssue(t.Name, fk.Name, "foreign key to %s skipped because that table is not migrated", fk.RefTable)
+				continue
+			}
+			stmt := fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)",
+				postgres.QuoteIdent(t.Name), postgres.QuoteIdent(truncateIdent(fk.Name)), quoteIdents(fk.Columns),
+				postgres.QuoteIdent(fk.RefTable), quoteIdents(fk.RefColumns))
+			if fk.OnUpdate != "" {
+				stmt += " ON UPDATE " + fk.OnUpdate
+			}
+			if fk.OnDelete != "" {
+				stmt += " ON DELETE " + fk.OnDelete
+			}
+			stmts = append(stmts, stmt+";")
+		}
+	}
+	return stmts
+}
+
+// copyValue renders a MySQL value of column c as a COPY text field.
+// zeroDate reports that a MySQL zero date had to be replaced.
+func copyValue(v sql.NullString, c pgColumn) (field string, zeroDate bool) {
+	if !v.Valid {
+		return `\N`, false
+	}
+	switch c.kind {
+	case valueBool:
+		if v.String == "0" || v.String == "" {
+			return "f", false
+		}
+		return "t", false
+	case valueBytes:
+		// \x hex bytea input, with the backslash escaped for COPY
+		return `\\x` + hex.EncodeToString([]byte(v.String)), false
+	case valueDate:
+		if strings.HasPrefix(v.String, "0000-00-00") {
+			if c.NotNull {
+				return "-infinity", true
+			}
+			return `\N`, true
+		}
+	}
+	return copyEscaper.Replace(v.String), false
+}
+
+var copyEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// parseEnumValues extracts the members of "enum('a','b')", undoubling
+// quotes escaped inside a member.
+func parseEnumValues(colType string) []string {
+	start := strings.Index(colType, "(")
+	end := strings.LastIndex(colType, ")")
+	if start < 0 || end <= start {
+		return nil
+	}
+	body := colType[start+1 : end]
+
+	var values []string
+	var cur strings.Builder
+	inQuote := false
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case c == '\'' && inQuote && i+1 < len(body) && body[i+1] == '\'':
+			cur.WriteByte('\'')
+			i++
+		case c == '\'':
+			inQuote = !inQuote
+			if !inQuote {
+				values = append(values, cur.String())
+				cur.Reset()
+			}
+		case inQuote:
+			cur.WriteByte(c)
+		}
+	}
+	return values
+}
+
+func quoteIdents(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = postgres.QuoteIdent(n)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// truncateIdent keeps identifiers within PostgreSQL's 63 byte limit.
+func truncateIdent(name string) string {
+	if len(name) <= 63 {
+		return name
+	}
+	return name[:63]
+}
+
+func precision(pgType, args string) string {
+	if _, err := strconv.Atoi(args); err == nil {
+		return pgType + "(" + args + ")"
+	}
+	return pgType
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
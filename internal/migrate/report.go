@@ -0,0 +1,66 @@
+// Package migrate moves data between different database engines.
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Issue is an entry of the translation report: something that could not be
+// mapped exactly and was approximated, skipped or failed.
+type Issue struct {
+	Table   string `json:"table,omitempty"`
+	Column  string `json:"column,omitempty"`
+	Object  string `json:"object,omitempty"` // index or constraint name
+	Message string `json:"message"`
+}
+
+func (i Issue) String() string {
+	where := i.Table
+	if i.Column != "" {
+		where += "." + i.Column
+	}
+	if i.Object != "" {
+		where += " (" + i.Object + ")"
+	}
+	if where == "" {
+		return i.Message
+	}
+	return where + ": " + i.Message
+}
+
+// TableResult records how many rows were copied for a table.
+type TableResult struct {
+	Name  string `json:"name"`
+	Rows  int64  `json:"rows"`
+	Error string `json:"error,omitempty"`
+}
+
+// Report summarizes a migration.
+type Report struct {
+	Source         string        `json:"source"`
+	Target         string        `json:"target"`
+	Database       string        `json:"database"`
+	TargetDatabase string        `json:"target_database"`
+	Tables         []TableResult `json:"tables"`
+	Issues         []Issue       `json:"issues"`
+	Errors         []string      `json:"errors,omitempty"`
+}
+
+func (r *Report) issue(table, column, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, Issue{Table: table, Column: column, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *Report) objectIssue(table, object, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, Issue{Table: table, Object: object, Message: fmt.Sprintf(format, args...)})
+}
+
+// WriteJSON saves the report to path.
+func (r *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,365 @@
+package migrate
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"mydbportal.com/dbmigrate/internal/schema"
+)
+
+// document is a MongoDB document that keeps its fields in insertion order.
+type document struct {
+	keys   []string
+	values []interface{}
+}
+
+// set stores v under path; dots in path create nested documents.
+func (d *document) set(path string, v interface{}) {
+	key, rest, nested := strings.Cut(path, ".")
+	for i, k := range d.keys {
+		if k != key {
+			continue
+		}
+		if !nested {
+			d.values[i] = v
+			return
+		}
+		sub, ok := d.values[i].(*document)
+		if !ok {
+			sub = &document{}
+			d.values[i] = sub
+		}
+		sub.set(rest, v)
+		return
+	}
+	if nested {
+		sub := &document{}
+		sub.set(rest, v)
+		v = sub
+	}
+	d.keys = append(d.keys, key)
+	d.values = append(d.values, v)
+}
+
+func (d *document) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range d.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		val, err := json.Marshal(d.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// docField is where a source column ends up in a document.
+type docField struct {
+	column schema.Column
+	path   string // empty if the column is only read for keys or embedding
+	typ    string
+}
+
+// docPlan describes how rows of one table become documents.
+type docPlan struct {
+	table     string
+	fields    []docField
+	key       []int // positions of the primary key columns
+	omitNulls bool
+	embeds    []embedPlan
+	failures  map[string]int64 // per column, values kept as strings
+}
+
+// embedPlan describes a child table stored as an array in each parent document.
+type embedPlan struct {
+	as       string
+	child    *docPlan
+	fkIndex  int // position of the foreign key among the child fields
+	refIndex int // position of the referenced column among the parent fields
+	fkColumn string
+}
+
+// readTable is the source table restricted to the columns that are read.
+func (p *docPlan) readTable() schema.Table {
+	t := schema.Table{Name: p.table}
+	for _, f := range p.fields {
+		t.Columns = append(t.Columns, f.column)
+	}
+	return t
+}
+
+func (p *docPlan) keyColumns() []string {
+	cols := make([]string, len(p.key))
+	for i, k := range p.key {
+		cols[i] = p.fields[k].column.Name
+	}
+	return cols
+}
+
+func (p *docPlan) keyValues(row []sql.NullString) []string {
+	vals := make([]string, len(p.key))
+	for i, k := range p.key {
+		vals[i] = row[k].String
+	}
+	return vals
+}
+
+// fieldIndex returns the position of column, adding it as a hidden field if
+// it is not read yet.
+func (p *docPlan) fieldIndex(t schema.Table, column string) int {
+	for i, f := range p.fields {
+		if f.column.Name == column {
+			return i
+		}
+	}
+	c := t.Column(column)
+	if c == nil {
+		return -1
+	}
+	p.fields = append(p.fields, docField{column: *c})
+	return len(p.fields) - 1
+}
+
+// planDocument maps the columns of t using rules. With withID, the primary
+// key becomes _id (a subdocument for composite keys) unless it is renamed.
+func planDocument(t schema.Table, rules ColumnRules, withID bool, r *Report) *docPlan {
+	p := &docPlan{table: t.Name, omitNulls: rules.OmitNulls, failures: make(map[string]int64)}
+
+	for _, c := range t.Columns {
+		f := docField{column: c, path: c.Name, typ: rules.Types[c.Name]}
+		if f.typ == "" {
+			f.typ = inferValueType(c)
+			if strings.HasPrefix(c.DataType, "_") {
+				r.issue(t.Name, c.Name, "array type %s stored as its text form", c.Type)
+			}
+		}
+		if contains(rules.Exclude, c.Name) {
+			f.path = ""
+		} else if name, ok := rules.Rename[c.Name]; ok {
+			f.path = name
+		} else if withID && contains(t.PrimaryKey, c.Name) {
+			f.path = "_id"
+			if len(t.PrimaryKey) > 1 {
+				f.path = "_id." + c.Name
+			}
+		}
+		p.fields = append(p.fields, f)
+	}
+
+	for _, k := range t.PrimaryKey {
+		p.key = append(p.key, p.fieldIndex(t, k))
+	}
+	for col := range rules.Rename {
+		if t.Column(col) == nil {
+			r.issue(t.Name, col, "rename rule for unknown column ignored")
+		}
+	}
+	return p
+}
+
+// planEmbed resolves the foreign key between parent and the child table of e.
+func planEmbed(parent *docPlan, parentTable schema.Table, child schema.Table, e EmbedMapping, r *Report) (embedPlan, error) {
+	fk, ref := e.ForeignKey, e.References
+	if fk == "" || ref == "" {
+		for _, k := range child.ForeignKeys {
+			if k.RefTable != parentTable.Name || len(k.Columns) != 1 || (fk != "" && k.Columns[0] != fk) {
+				continue
+			}
+			fk = k.Columns[0]
+			if ref == "" {
+				ref = k.RefColumns[0]
+			}
+			break
+		}
+	}
+	if fk == "" {
+		return embedPlan{}, fmt.Errorf("cannot embed %s into %s: no single-column foreign key, set foreign_key in the mapping", child.Name, parentTable.Name)
+	}
+	if ref == "" {
+		if len(parentTable.PrimaryKey) != 1 {
+			return embedPlan{}, fmt.Errorf("cannot embed %s into %s: set references in the mapping", child.Name, parentTable.Name)
+		}
+		ref = parentTable.PrimaryKey[0]
+	}
+
+	ep := embedPlan{as: e.As, fkColumn: fk, child: planDocument(child, e.ColumnRules, false, r)}
+	if ep.as == "" {
+		ep.as = child.Name
+	}
+	ep.fkIndex = ep.child.fieldIndex(child, fk)
+	ep.refIndex = parent.fieldIndex(parentTable, ref)
+	if ep.fkIndex < 0 {
+		return embedPlan{}, fmt.Errorf("column not found: %s.%s", child.Name, fk)
+	}
+	if ep.refIndex < 0 {
+		return embedPlan{}, fmt.Errorf("column not found: %s.%s", parentTable.Name, ref)
+	}
+	return ep, nil
+}
+
+// inferValueType picks the BSON type for a MySQL or PostgreSQL column.
+func inferValueType(c schema.Column) string {
+	switch c.DataType {
+	case "tinyint":
+		if strings.HasPrefix(c.Type, "tinyint(1)") {
+			return "bool"
+		}
+		return "int"
+	case "bool", "boolean":
+		return "bool"
+	case "smallint", "mediumint", "int2", "int4":
+		return "int"
+	case "int", "integer":
+		if strings.Contains(c.Type, "unsigned") {
+			return "long"
+		}
+		return "int"
+	case "bigint", "int8":
+		if strings.Contains(c.Type, "unsigned") {
+			return "decimal"
+		}
+		return "long"
+	case "decimal", "numeric":
+		return "decimal"
+	case "float", "double", "real", "float4", "float8":
+		return "double"
+	case "date", "datetime", "timestamp", "timestamptz":
+		return "date"
+	case "json", "jsonb":
+		return "json"
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob", "bytea":
+		return "binary"
+	}
+	return "string"
+}
+
+// dateLayouts are the text forms of dates and timestamps read from MySQL and
+// PostgreSQL (ISO DateStyle); values without a zone are UTC.
+var dateLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// convertValue returns v as an Extended JSON value of type typ. If v cannot
+// be converted, it is returned as a plain string and ok is false.
+func convertValue(v sql.NullString, typ string) (value interface{}, ok bool) {
+	if !v.Valid {
+		return nil, true
+	}
+	s := v.String
+
+	switch typ {
+	case "int":
+		if n, err := strconv.ParseInt(s, 10, 32); err == nil {
+			return map[string]string{"$numberInt": strconv.FormatInt(n, 10)}, true
+		}
+	case "long":
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return map[string]string{"$numberLong": strconv.FormatInt(n, 10)}, true
+		}
+	case "double":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			text := strconv.FormatFloat(f, 'g', -1, 64)
+			switch {
+			case math.IsInf(f, 1):
+				text = "Infinity"
+			case math.IsInf(f, -1):
+				text = "-Infinity"
+			case math.IsNaN(f):
+				text = "NaN"
+			}
+			return map[string]string{"$numberDouble": text}, true
+		}
+	case "decimal":
+		if numeric.MatchString(s) {
+			return map[string]string{"$numberDecimal": s}, true
+		}
+	case "bool":
+		switch strings.ToLower(s) {
+		case "1", "t", "true":
+			return true, true
+		case "0", "f", "false":
+			return false, true
+		}
+	case "date":
+		for _, layout := range dateLayouts {
+			if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+				ms := strconv.FormatInt(t.UnixMilli(), 10)
+				return map[string]interface{}{"$date": map[string]string{"$numberLong": ms}}, true
+			}
+		}
+	case "json":
+		if json.Valid([]byte(s)) {
+			return json.RawMessage(s), true
+		}
+	case "binary":
+		return binaryValue([]byte(s), "00"), true
+	case "uuid":
+		if raw, err := hex.DecodeString(strings.ReplaceAll(s, "-", "")); err == nil && len(raw) == 16 {
+			return binaryValue(raw, "04"), true
+		}
+	case "objectid":
+		if raw, err := hex.DecodeString(s); err == nil && len(raw) == 12 {
+			return map[string]string{"$oid": strings.ToLower(s)}, true
+		}
+	default:
+		return s, true
+	}
+	return s, false
+}
+
+func binaryValue(raw []byte, subType string) interface{} {
+	return map[string]interface{}{"$binary": map[string]string{
+		"base64":  base64.StdEncoding.EncodeToString(raw),
+		"subType": subType,
+	}}
+}
+
+// buildDocument converts one row.
+func (p *docPlan) buildDocument(row []sql.NullString) *document {
+	doc := &document{}
+	for i, f := range p.fields {
+		if f.path == "" || (p.omitNulls && !row[i].Valid) {
+			continue
+		}
+		v, ok := convertValue(row[i], f.typ)
+		if !ok {
+			p.failures[f.column.Name]++
+		}
+		doc.set(f.path, v)
+	}
+	return doc
+}
+
+// reportFailures adds the conversion failures of p (and its embeds) to r.
+func (p *docPlan) reportFailures(r *Report) {
+	for _, f := range p.fields {
+		if n := p.failures[f.column.Name]; n > 0 {
+			r.issue(p.table, f.column.Name, "%d values could not be converted to %s and were stored as strings", n, f.typ)
+		}
+	}
+	for _, e := range p.embeds {
+		e.child.reportFailures(r)
+	}
+}
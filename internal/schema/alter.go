@@ -0,0 +1,350 @@
+package schema
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// dialect renders the engine specific parts of an ALTER script.
+type dialect struct {
+	engine string
+}
+
+func (d dialect) ident(name string) string {
+	if d.engine == "mysql" {
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (d dialect) literal(s string) string {
+	if d.engine == "mysql" {
+		s = strings.ReplaceAll(s, `\`, `\\`)
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+var (
+	mysqlNumeric  = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+	mysqlNow      = regexp.MustCompile(`(?i)^(current_timestamp|now)(\(\d*\))?$`)
+	mysqlOnUpdate = regexp.MustCompile(`(?i)on update (\S+)`)
+	mysqlTextType = regexp.MustCompile(`char|text|enum|set|json`)
+)
+
+// defaultExpr renders a column default as SQL. PostgreSQL already reports
+// defaults as expressions; MySQL reports literals unquoted.
+func (d dialect) defaultExpr(c Column) string {
+	def := *c.Default
+	if d.engine != "mysql" {
+		return def
+	}
+	switch {
+	case strings.Contains(c.Extra, "DEFAULT_GENERATED") && !mysqlNow.MatchString(def):
+		return "(" + def + ")"
+	case mysqlNow.MatchString(def), def == "NULL":
+		return def
+	case strings.HasPrefix(def, "'"), strings.HasPrefix(def, "b'"):
+		// MariaDB quotes literals itself
+		return def
+	case mysqlNumeric.MatchString(def) && !mysqlTextType.MatchString(c.Type):
+		return def
+	}
+	return d.literal(def)
+}
+
+// columnDef renders the definition of c, or returns false if it cannot be
+// reproduced from the inspected schema.
+func (d dialect) columnDef(c Column) (string, bool) {
+	def := d.ident(c.Name) + " " + c.Type
+	if d.engine == "mysql" {
+		if strings.Contains(c.Extra, "GENERATED") && !strings.Contains(c.Extra, "DEFAULT_GENERATED") {
+			return "", false
+		}
+		if c.Nullable {
+			def += " NULL"
+		} else {
+			def += " NOT NULL"
+		}
+		if c.Default != nil {
+			def += " DEFAULT " + d.defaultExpr(c)
+		}
+		if c.AutoIncrement {
+			def += " AUTO_INCREMENT"
+		}
+		if m := mysqlOnUpdate.FindStringSubmatch(c.Extra); m != nil {
+			def += " ON UPDATE " + m[1]
+		}
+		return def, true
+	}
+
+	if !c.Nullable {
+		def += " NOT NULL"
+	}
+	if c.Default != nil {
+		def += " DEFAULT " + d.defaultExpr(c)
+	}
+	if c.AutoIncrement {
+		def += " GENERATED BY DEFAULT AS IDENTITY"
+	}
+	if expr, ok := strings.CutPrefix(c.Extra, "STORED GENERATED "); ok {
+		def += " GENERATED ALWAYS AS (" + expr + ") STORED"
+	}
+	return def, true
+}
+
+func (d dialect) columns(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = d.ident(n)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// createIndex renders a CREATE INDEX statement, or a comment if the index
+// cannot be reproduced.
+func (d dialect) createIndex(table string, i Index) string {
+	if d.engine != "mysql" && i.Definition != "" {
+		return i.Definition + ";"
+	}
+	if i.Kind == "FUNCTIONAL" || i.Partial || len(i.Columns) == 0 {
+		return fmt.Sprintf("-- index %s on %s uses expressions or prefix lengths; recreate it by hand", i.Name, table)
+	}
+	prefix := "CREATE "
+	if i.Unique {
+		prefix += "UNIQUE "
+	}
+	using := ""
+	switch {
+	case d.engine == "mysql" && (i.Kind == "FULLTEXT" || i.Kind == "SPATIAL"):
+		prefix += i.Kind + " "
+	case d.engine != "mysql" && i.Kind != "":
+		using = " USING " + strings.ToLower(i.Kind)
+	}
+	return fmt.Sprintf("%sINDEX %s ON %s%s (%s);", prefix, d.ident(i.Name), d.ident(table), using, d.columns(i.Columns))
+}
+
+func (d dialect) dropIndex(table string, name string) string {
+	if d.engine == "mysql" {
+		return fmt.Sprintf("DROP INDEX %s ON %s;", d.ident(name), d.ident(table))
+	}
+	return fmt.Sprintf("DROP INDEX %s;", d.ident(name))
+}
+
+func (d dialect) addForeignKey(table string, fk ForeignKey) string {
+	s := fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)",
+		d.ident(table), d.ident(fk.Name), d.columns(fk.Columns), d.ident(fk.RefTable), d.columns(fk.RefColumns))
+	if fk.OnDelete != "" {
+		s += " ON DELETE " + fk.OnDelete
+	}
+	if fk.OnUpdate != "" {
+		s += " ON UPDATE " + fk.OnUpdate
+	}
+	return s + ";"
+}
+
+func (d dialect) dropForeignKey(table string, name string) string {
+	if d.engine == "mysql" {
+		return fmt.Sprintf("ALTER TABLE %s DROP FOREIGN KEY %s;", d.ident(table), d.ident(name))
+	}
+	return fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s;", d.ident(table), d.ident(name))
+}
+
+func checkClause(c Check) string {
+	if strings.HasPrefix(c.Expression, "(") {
+		return "CHECK " + c.Expression
+	}
+	return "CHECK (" + c.Expression + ")"
+}
+
+func (d dialect) dropCheck(table string, name string) string {
+	if d.engine == "mysql" {
+		return fmt.Sprintf("ALTER TABLE %s DROP CHECK %s;", d.ident(table), d.ident(name))
+	}
+	return fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s;", d.ident(table), d.ident(name))
+}
+
+// alterColumn renders the statements that change column r into l.
+func (d dialect) alterColumn(table string, l, r Column) []string {
+	if d.engine == "mysql" {
+		def, ok := d.columnDef(l)
+		if !ok {
+			return []string{fmt.Sprintf("-- column %s.%s is generated; change its expression by hand", table, l.Name)}
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s;", d.ident(table), def)}
+	}
+
+	prefix := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s ", d.ident(table), d.ident(l.Name))
+	var stmts []string
+	if l.AutoIncrement != r.AutoIncrement || l.Extra != r.Extra {
+		stmts = append(stmts, fmt.Sprintf("-- column %s.%s: identity or generation differs; change it by hand", table, l.Name))
+	}
+	if l.Type != r.Type {
+		stmts = append(stmts, fmt.Sprintf("%sTYPE %s USING %s::%s;", prefix, l.Type, d.ident(l.Name), l.Type))
+	}
+	if l.Nullable != r.Nullable {
+		if l.Nullable {
+			stmts = append(stmts, prefix+"DROP NOT NULL;")
+		} else {
+			stmts = append(stmts, prefix+"SET NOT NULL;")
+		}
+	}
+	switch {
+	case l.Default == nil && r.Default != nil:
+		stmts = append(stmts, prefix+"DROP DEFAULT;")
+	case l.Default != nil && (r.Default == nil || *l.Default != *r.Default):
+		stmts = append(stmts, prefix+"SET DEFAULT "+d.defaultExpr(l)+";")
+	}
+	return stmts
+}
+
+func (d dialect) createTable(t *Table) []string {
+	var stmts, defs []string
+	for _, c := range t.Columns {
+		def, ok := d.columnDef(c)
+		if !ok {
+			stmts = append(stmts, fmt.Sprintf("-- column %s.%s is generated and was left out; add it by hand", t.Name, c.Name))
+			continue
+		}
+		defs = append(defs, "  "+def)
+	}
+	if len(t.PrimaryKey) > 0 {
+		defs = append(defs, "  PRIMARY KEY ("+d.columns(t.PrimaryKey)+")")
+	}
+	for _, c := range t.Checks {
+		defs = append(defs, "  CONSTRAINT "+d.ident(c.Name)+" "+checkClause(c))
+	}
+	stmts = append(stmts, fmt.Sprintf("CREATE TABLE %s (\n%s\n);", d.ident(t.Name), strings.Join(defs, ",\n")))
+	for _, i := range t.Indexes {
+		stmts = append(stmts, d.createIndex(t.Name, i))
+	}
+	return stmts
+}
+
+// AlterScript generates the SQL that brings right in line with left. Both
+// schemas must come from the same engine, MySQL or PostgreSQL. Objects the
+// inspected schema cannot reproduce exactly are listed as comments.
+func AlterScript(left, right *Schema) (string, error) {
+	if left.Engine != right.Engine {
+		return "", fmt.Errorf("cannot generate an ALTER script between %s and %s", left.Engine, right.Engine)
+	}
+	if left.Engine != "mysql" && left.Engine != "postgres" {
+		return "", fmt.Errorf("ALTER scripts are only generated for mysql and postgres, not %s", left.Engine)
+	}
+	d := dialect{engine: left.Engine}
+	changes := Diff(left, right)
+
+	var views, dropFKs, tables, dropTables, addFKs, createViews, routines []string
+	for _, c := range changes {
+		lt, rt := left.Table(c.Table), right.Table(c.Table)
+		switch c.Object {
+		case "table":
+			if c.Side == OnlyLeft {
+				t := left.Table(c.Name)
+				tables = append(tables, d.createTable(t)...)
+				for _, fk := range t.ForeignKeys {
+					addFKs = append(addFKs, d.addForeignKey(t.Name, fk))
+				}
+			} else {
+				dropTables = append(dropTables, fmt.Sprintf("DROP TABLE %s;", d.ident(c.Name)))
+			}
+
+		case "column":
+			switch c.Side {
+			case OnlyLeft:
+				if def, ok := d.columnDef(*lt.Column(c.Name)); ok {
+					tables = append(tables, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", d.ident(c.Table), def))
+				} else {
+					tables = append(tables, fmt.Sprintf("-- column %s.%s is generated; add it by hand", c.Table, c.Name))
+				}
+			case OnlyRight:
+				tables = append(tables, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", d.ident(c.Table), d.ident(c.Name)))
+			default:
+				tables = append(tables, d.alterColumn(c.Table, *lt.Column(c.Name), *rt.Column(c.Name))...)
+			}
+
+		case "primary_key":
+			if len(rt.PrimaryKey) > 0 {
+				if d.engine == "mysql" {
+					tables = append(tables, fmt.Sprintf("ALTER TABLE %s DROP PRIMARY KEY;", d.ident(c.Table)))
+				} else {
+					tables = append(tables, "-- assumes the default primary key constraint name",
+						fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s;", d.ident(c.Table), d.ident(c.Table+"_pkey")))
+				}
+			}
+			if len(lt.PrimaryKey) > 0 {
+				tables = append(tables, fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (%s);", d.ident(c.Table), d.columns(lt.PrimaryKey)))
+			}
+
+		case "index":
+			if c.Side != OnlyLeft {
+				tables = append(tables, d.dropIndex(c.Table, c.Name))
+			}
+			if c.Side != OnlyRight {
+				for _, i := range lt.Indexes {
+					if i.Name == c.Name {
+						tables = append(tables, d.createIndex(c.Table, i))
+					}
+				}
+			}
+
+		case "foreign_key":
+			if c.Side != OnlyLeft {
+				dropFKs = append(dropFKs, d.dropForeignKey(c.Table, c.Name))
+			}
+			if c.Side != OnlyRight {
+				for _, fk := range lt.ForeignKeys {
+					if fk.Name == c.Name {
+						addFKs = append(addFKs, d.addForeignKey(c.Table, fk))
+					}
+				}
+			}
+
+		case "check":
+			if c.Side != OnlyLeft {
+				tables = append(tables, d.dropCheck(c.Table, c.Name))
+			}
+			if c.Side != OnlyRight {
+				for _, k := range lt.Checks {
+					if k.Name == c.Name {
+						tables = append(tables, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s;", d.ident(c.Table), d.ident(k.Name), checkClause(k)))
+					}
+				}
+			}
+
+		case "view":
+			// Views are dropped first and recreated last, as they depend on the tables
+			if c.Side != OnlyLeft {
+				views = append(views, fmt.Sprintf("DROP VIEW IF EXISTS %s;", d.ident(c.Name)))
+			}
+			if c.Side != OnlyRight {
+				createViews = append(createViews, fmt.Sprintf("CREATE VIEW %s AS %s;", d.ident(c.Name), strings.TrimSuffix(strings.TrimSpace(c.Left), ";")))
+			}
+
+		case "routine":
+			kind, name, _ := strings.Cut(c.Name, " ")
+			if d.engine == "mysql" {
+				routines = append(routines, fmt.Sprintf("-- %s %s differs; recreate it from SHOW CREATE %s on the left", strings.ToLower(kind), name, kind))
+				continue
+			}
+			// PostgreSQL names carry their argument types, as DROP needs
+			if c.Side != OnlyLeft {
+				routines = append(routines, fmt.Sprintf("DROP %s IF EXISTS %s;", kind, name))
+			}
+			if c.Side != OnlyRight {
+				routines = append(routines, strings.TrimSpace(c.Left)+";")
+			}
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- Brings %s in line with %s (%d differences).\n", right.Database, left.Database, len(changes))
+	fmt.Fprintln(&b, "-- Review before running: dropped objects lose their data.")
+	for _, stmts := range [][]string{views, dropFKs, tables, dropTables, addFKs, createViews, routines} {
+		for _, s := range stmts {
+			b.WriteString(s)
+			b.WriteString("\n")
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,225 @@
+package schema
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func str(s string) *string { return &s }
+
+// golden compares got with testdata/name, or rewrites it with -update.
+func golden(t *testing.T, name string, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs, rerun with -update to accept:\n%s", path, got)
+	}
+}
+
+func TestDefaultExpr(t *testing.T) {
+	tests := []struct {
+		engine string
+		column Column
+		want   string
+	}{
+		{"mysql", Column{Type: "int", Default: str("0")}, "0"},
+		{"mysql", Column{Type: "decimal(10,2)", Default: str("-1.50")}, "-1.50"},
+		{"mysql", Column{Type: "double", Default: str("1e10")}, "1e10"},
+		{"mysql", Column{Type: "varchar(10)", Default: str("5")}, "'5'"},
+		{"mysql", Column{Type: "enum('1','2')", Default: str("1")}, "'1'"},
+		{"mysql", Column{Type: "varchar(20)", Default: str(`it's a \ test`)}, `'it''s a \\ test'`},
+		{"mysql", Column{Type: "varchar(20)", Default: str("")}, "''"},
+		{"mysql", Column{Type: "varchar(20)", Default: str("NULL")}, "NULL"},
+		{"mysql", Column{Type: "timestamp", Default: str("CURRENT_TIMESTAMP"), Extra: "DEFAULT_GENERATED"}, "CURRENT_TIMESTAMP"},
+		{"mysql", Column{Type: "datetime(3)", Default: str("current_timestamp(3)")}, "current_timestamp(3)"},
+		{"mysql", Column{Type: "date", Default: str("2024-01-01")}, "'2024-01-01'"},
+		{"mysql", Column{Type: "varchar(36)", Default: str("uuid()"), Extra: "DEFAULT_GENERATED"}, "(uuid())"},
+		{"mysql", Column{Type: "varchar(20)", Default: str("'maria'")}, "'maria'"},
+		{"mysql", Column{Type: "bit(1)", Default: str("b'0'")}, "b'0'"},
+		{"postgres", Column{Type: "integer", Default: str("nextval('t_id_seq'::regclass)")}, "nextval('t_id_seq'::regclass)"},
+		{"postgres", Column{Type: "text", Default: str("'it''s'::text")}, "'it''s'::text"},
+		{"postgres", Column{Type: "timestamp with time zone", Default: str("now()")}, "now()"},
+	}
+	for _, tt := range tests {
+		if got := (dialect{engine: tt.engine}).defaultExpr(tt.column); got != tt.want {
+			t.Errorf("%s default %q of %s: got %s, want %s", tt.engine, *tt.column.Default, tt.column.Type, got, tt.want)
+		}
+	}
+}
+
+// alterSchemas returns a pair of schemas of engine that differ in every kind
+// of object.
+func alterSchemas(engine string) (left, right *Schema) {
+	intType, textType := "int", "varchar(100)"
+	if engine == "postgres" {
+		intType, textType = "integer", "text"
+	}
+	id := Column{Name: "id", Type: intType, DataType: intType, AutoIncrement: true}
+	customerID := Column{Name: "customer_id", Type: intType, DataType: intType}
+	name := Column{Name: "name", Type: textType, DataType: textType, Nullable: true}
+
+	orders := func(side string) Table {
+		t := Table{
+			Name:       "orders",
+			Columns:    []Column{id, customerID},
+			PrimaryKey: []string{"id"},
+			Indexes:    []Index{{Name: "orders_customer", Columns: []string{"customer_id"}}},
+			ForeignKeys: []ForeignKey{
+				{Name: "orders_customer_fk", Columns: []string{"customer_id"}, RefTable: "customers", RefColumns: []string{"id"}},
+				{Name: "orders_old_fk", Columns: []string{"customer_id"}, RefTable: "legacy", RefColumns: []string{"id"}},
+			},
+			Checks: []Check{{Name: "orders_id_positive", Expression: "(id > 0)"}},
+		}
+		status := Column{Name: "status", Type: textType, DataType: textType, Default: str("new")}
+		if engine == "postgres" {
+			status.Default = str("'new'::text")
+		}
+		if side == "left" {
+			status.Nullable = false
+			status.Default = str("it's")
+			if engine == "postgres" {
+				status.Default = str("'it''s'::text")
+			}
+			t.Columns = append(t.Columns, status, Column{Name: "note", Type: textType, DataType: textType, Nullable: true},
+				Column{Name: "total", Type: "numeric(10,2)", DataType: "numeric", Nullable: true})
+			t.Indexes[0].Unique = true
+			t.ForeignKeys[0].OnDelete = "CASCADE"
+			t.ForeignKeys = t.ForeignKeys[:1]
+			t.Checks = append(t.Checks, Check{Name: "orders_status_known", Expression: "status in ('new', 'it''s')"})
+		} else {
+			status.Nullable = true
+			t.Columns = append(t.Columns, status, Column{Name: "legacy_code", Type: intType, DataType: intType, Nullable: true},
+				Column{Name: "total", Type: intType, DataType: intType, Nullable: true})
+			t.Checks[0].Expression = "(id >= 0)"
+		}
+		return t
+	}
+
+	customers := Table{
+		Name:       "customers",
+		Columns:    []Column{id, name},
+		PrimaryKey: []string{"id"},
+		Indexes:    []Index{{Name: "customers_name", Columns: []string{"name"}}},
+		Checks:     []Check{{Name: "customers_name_set", Expression: "name <> ''"}},
+	}
+	if engine == "mysql" {
+		customers.Columns = append(customers.Columns,
+			Column{Name: "created", Type: "timestamp", DataType: "timestamp", Default: str("CURRENT_TIMESTAMP"), Extra: "DEFAULT_GENERATED on update CURRENT_TIMESTAMP"},
+			Column{Name: "name_length", Type: intType, DataType: intType, Nullable: true, Extra: "VIRTUAL GENERATED"})
+		customers.Indexes = append(customers.Indexes,
+			Index{Name: "customers_name_text", Columns: []string{"name"}, Kind: "FULLTEXT"},
+			Index{Name: "customers_name_prefix", Columns: []string{"name"}, Partial: true})
+	} else {
+		customers.Columns = append(customers.Columns,
+			Column{Name: "created", Type: "timestamp with time zone", DataType: "timestamp with time zone", Default: str("now()")},
+			Column{Name: "name_length", Type: intType, DataType: intType, Nullable: true, Extra: "STORED GENERATED length(name)"})
+		customers.Indexes[0].Definition = `CREATE INDEX customers_name ON public.customers USING btree (name)`
+		customers.Indexes = append(customers.Indexes,
+			Index{Name: "customers_name_lower", Kind: "FUNCTIONAL", Definition: `CREATE INDEX customers_name_lower ON public.customers USING btree (lower(name))`})
+	}
+
+	legacy := Table{Name: "legacy", Columns: []Column{id}, PrimaryKey: []string{"id"}}
+	keyless := func(pk ...string) Table {
+		return Table{Name: "events", Columns: []Column{id, customerID}, PrimaryKey: pk}
+	}
+
+	routine := Routine{Name: "order_count", Kind: "FUNCTION", Definition: "CREATE FUNCTION order_count() RETURNS int RETURN 1"}
+	if engine == "postgres" {
+		routine = Routine{Name: "order_count()", Kind: "FUNCTION", Definition: "CREATE OR REPLACE FUNCTION public.order_count()\n RETURNS integer\n LANGUAGE sql\nAS $function$select 1$function$"}
+	}
+	oldRoutine := routine
+	oldRoutine.Definition += " -- old"
+
+	left = &Schema{
+		Engine: engine, Database: "app",
+		Tables:   []Table{customers, keyless("id", "customer_id"), orders("left")},
+		Views:    []View{{Name: "open_orders", Definition: "select id from orders where status = 'new'"}},
+		Routines: []Routine{routine},
+	}
+	right = &Schema{
+		Engine: engine, Database: "app_copy",
+		Tables:   []Table{keyless("id"), legacy, orders("right")},
+		Views:    []View{{Name: "open_orders", Definition: "select id from orders"}, {Name: "old_view", Definition: "select 1"}},
+		Routines: []Routine{oldRoutine},
+	}
+	return left, right
+}
+
+func TestAlterScript(t *testing.T) {
+	for _, engine := range []string{"mysql", "postgres"} {
+		t.Run(engine, func(t *testing.T) {
+			left, right := alterSchemas(engine)
+			script, err := AlterScript(left, right)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, "alter_"+engine+".sql", script)
+
+			// A schema needs no changes to match itself
+			script, err = AlterScript(left, left)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "-- Brings app in line with app (0 differences).\n-- Review before running: dropped objects lose their data.\n"
+			if script != want {
+				t.Errorf("script for equal schemas:\n%s", script)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	for _, engine := range []string{"mysql", "postgres"} {
+		t.Run(engine, func(t *testing.T) {
+			left, right := alterSchemas(engine)
+			var b strings.Builder
+			for _, c := range Diff(left, right) {
+				name := c.Name
+				if c.Table != "" {
+					name = c.Table + "." + name
+				}
+				fmt.Fprintf(&b, "%s %s: %s\n", c.Object, name, c.Side)
+				if c.Left != "" {
+					fmt.Fprintf(&b, "  left:  %s\n", strings.ReplaceAll(c.Left, "\n", "\n         "))
+				}
+				if c.Right != "" {
+					fmt.Fprintf(&b, "  right: %s\n", strings.ReplaceAll(c.Right, "\n", "\n         "))
+				}
+			}
+			golden(t, "diff_"+engine+".txt", b.String())
+
+			// Whitespace in stored definitions is not a difference
+			reformatted, _ := alterSchemas(engine)
+			reformatted.Views[0].Definition = "select  id\n  from orders\n where status = 'new'"
+			reformatted.Tables[2].Checks[1].Expression = "status in ('new',  'it''s')"
+			if changes := Diff(left, reformatted); len(changes) != 0 {
+				t.Errorf("reformatted definitions differ: %+v", changes)
+			}
+		})
+	}
+}
+
+func TestAlterScriptEngines(t *testing.T) {
+	if _, err := AlterScript(&Schema{Engine: "mysql"}, &Schema{Engine: "postgres"}); err == nil {
+		t.Error("generated a script between mysql and postgres")
+	}
+	if _, err := AlterScript(&Schema{Engine: "sqlite"}, &Schema{Engine: "sqlite"}); err == nil {
+		t.Error("generated a script for sqlite")
+	}
+}
@@ -0,0 +1,247 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Sides of a Change
+const (
+	OnlyLeft  = "only_left"
+	OnlyRight = "only_right"
+	Changed   = "changed"
+)
+
+// Change is one difference between two schemas.
+type Change struct {
+	// Object is table, column, primary_key, index, foreign_key, check, view or routine
+	Object string `json:"object"`
+	// Table is the table the object belongs to, for objects inside tables
+	Table string `json:"table,omitempty"`
+	Name  string `json:"name"`
+	Side  string `json:"side"`
+	// Left and Right describe the object on each side, if it exists there
+	Left  string `json:"left,omitempty"`
+	Right string `json:"right,omitempty"`
+}
+
+// Diff compares two schemas object by object. Tables, views and routines are
+// matched by name, as are the columns, indexes, foreign keys and checks of
+// tables on both sides. Changes are ordered by table, then object.
+func Diff(left, right *Schema) []Change {
+	var changes []Change
+
+	for _, name := range unionNames(tableNames(left), tableNames(right)) {
+		lt, rt := left.Table(name), right.Table(name)
+		switch {
+		case rt == nil:
+			changes = append(changes, Change{Object: "table", Name: name, Side: OnlyLeft, Left: describeTable(lt)})
+		case lt == nil:
+			changes = append(changes, Change{Object: "table", Name: name, Side: OnlyRight, Right: describeTable(rt)})
+		default:
+			changes = append(changes, diffTable(lt, rt)...)
+		}
+	}
+
+	lv, rv := make(map[string]string), make(map[string]string)
+	for _, v := range left.Views {
+		lv[v.Name] = v.Definition
+	}
+	for _, v := range right.Views {
+		rv[v.Name] = v.Definition
+	}
+	changes = append(changes, diffDescriptions("view", "", lv, rv, normalizeSQL)...)
+
+	lr, rr := make(map[string]string), make(map[string]string)
+	for _, r := range left.Routines {
+		lr[r.Kind+" "+r.Name] = r.Definition
+	}
+	for _, r := range right.Routines {
+		rr[r.Kind+" "+r.Name] = r.Definition
+	}
+	changes = append(changes, diffDescriptions("routine", "", lr, rr, normalizeSQL)...)
+	return changes
+}
+
+func diffTable(l, r *Table) []Change {
+	var changes []Change
+
+	lc, rc := make(map[string]string), make(map[string]string)
+	var order []string
+	for _, c := range l.Columns {
+		lc[c.Name] = DescribeColumn(c)
+		order = append(order, c.Name)
+	}
+	for _, c := range r.Columns {
+		rc[c.Name] = DescribeColumn(c)
+		if _, ok := lc[c.Name]; !ok {
+			order = append(order, c.Name)
+		}
+	}
+	// Columns keep their table order rather than sorting by name
+	for _, name := range order {
+		if c := describedChange("column", l.Name, name, lc, rc, nil); c != nil {
+			changes = append(changes, *c)
+		}
+	}
+
+	lpk, rpk := strings.Join(l.PrimaryKey, ", "), strings.Join(r.PrimaryKey, ", ")
+	if lpk != rpk {
+		c := Change{Object: "primary_key", Table: l.Name, Name: "PRIMARY KEY", Side: Changed, Left: "(" + lpk + ")", Right: "(" + rpk + ")"}
+		switch {
+		case rpk == "":
+			c.Side, c.Right = OnlyLeft, ""
+		case lpk == "":
+			c.Side, c.Left = OnlyRight, ""
+		}
+		changes = append(changes, c)
+	}
+
+	li, ri := make(map[string]string), make(map[string]string)
+	for _, i := range l.Indexes {
+		li[i.Name] = DescribeIndex(i)
+	}
+	for _, i := range r.Indexes {
+		ri[i.Name] = DescribeIndex(i)
+	}
+	changes = append(changes, diffDescriptions("index", l.Name, li, ri, nil)...)
+
+	lf, rf := make(map[string]string), make(map[string]string)
+	for _, fk := range l.ForeignKeys {
+		lf[fk.Name] = DescribeForeignKey(fk)
+	}
+	for _, fk := range r.ForeignKeys {
+		rf[fk.Name] = DescribeForeignKey(fk)
+	}
+	changes = append(changes, diffDescriptions("foreign_key", l.Name, lf, rf, nil)...)
+
+	lk, rk := make(map[string]string), make(map[string]string)
+	for _, c := range l.Checks {
+		lk[c.Name] = c.Expression
+	}
+	for _, c := range r.Checks {
+		rk[c.Name] = c.Expression
+	}
+	changes = append(changes, diffDescriptions("check", l.Name, lk, rk, normalizeSQL)...)
+	return changes
+}
+
+// diffDescriptions compares two sets of described objects by name. normalize,
+// if set, is applied before comparing but not to the reported descriptions.
+func diffDescriptions(object, table string, left, right map[string]string, normalize func(string) string) []Change {
+	var changes []Change
+	for _, name := range unionNames(keys(left), keys(right)) {
+		if c := describedChange(object, table, name, left, right, normalize); c != nil {
+			changes = append(changes, *c)
+		}
+	}
+	return changes
+}
+
+func describedChange(object, table, name string, left, right map[string]string, normalize func(string) string) *Change {
+	l, inLeft := left[name]
+	r, inRight := right[name]
+	c := &Change{Object: object, Table: table, Name: name, Left: l, Right: r}
+	switch {
+	case !inRight:
+		c.Side = OnlyLeft
+	case !inLeft:
+		c.Side = OnlyRight
+	case l == r, normalize != nil && normalize(l) == normalize(r):
+		return nil
+	default:
+		c.Side = Changed
+	}
+	return c
+}
+
+// DescribeColumn renders a column as "type [NOT NULL] [DEFAULT x] [extra]".
+func DescribeColumn(c Column) string {
+	parts := []string{c.Type}
+	if !c.Nullable {
+		parts = append(parts, "NOT NULL")
+	}
+	if c.Default != nil {
+		parts = append(parts, "DEFAULT "+*c.Default)
+	}
+	if c.AutoIncrement {
+		parts = append(parts, "AUTO_INCREMENT")
+	}
+	if c.Extra != "" && !strings.EqualFold(c.Extra, "auto_increment") {
+		parts = append(parts, c.Extra)
+	}
+	return strings.Join(parts, " ")
+}
+
+// DescribeIndex renders an index as "[UNIQUE] [KIND] (columns)", or its
+// definition when the engine provides one.
+func DescribeIndex(i Index) string {
+	if i.Definition != "" {
+		return i.Definition
+	}
+	var parts []string
+	if i.Unique {
+		parts = append(parts, "UNIQUE")
+	}
+	if i.Kind != "" {
+		parts = append(parts, i.Kind)
+	}
+	cols := "(" + strings.Join(i.Columns, ", ") + ")"
+	if i.Partial {
+		cols += " prefix"
+	}
+	return strings.Join(append(parts, cols), " ")
+}
+
+// DescribeForeignKey renders a foreign key as "(cols) REFERENCES t (cols) [rules]".
+func DescribeForeignKey(fk ForeignKey) string {
+	s := fmt.Sprintf("(%s) REFERENCES %s (%s)", strings.Join(fk.Columns, ", "), fk.RefTable, strings.Join(fk.RefColumns, ", "))
+	if fk.OnDelete != "" {
+		s += " ON DELETE " + fk.OnDelete
+	}
+	if fk.OnUpdate != "" {
+		s += " ON UPDATE " + fk.OnUpdate
+	}
+	return s
+}
+
+func describeTable(t *Table) string {
+	return fmt.Sprintf("%d columns", len(t.Columns))
+}
+
+// normalizeSQL collapses whitespace so formatting differences in stored
+// definitions do not count as changes.
+func normalizeSQL(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func tableNames(s *Schema) []string {
+	names := make([]string, len(s.Tables))
+	for i, t := range s.Tables {
+		names[i] = t.Name
+	}
+	return names
+}
+
+func keys(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	return names
+}
+
+// unionNames returns the sorted union of a and b.
+func unionNames(a, b []string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, n := range append(append([]string(nil), a...), b...) {
+		if !seen[n] {
+			seen[n] = true
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
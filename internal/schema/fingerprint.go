@@ -0,0 +1,47 @@
+package schema
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+)
+
+// Fingerprint returns a hash of the table's normalized definition. Indexes,
+// foreign keys and checks are compared by name, so their order does not
+// matter; column order does.
+func (t *Table) Fingerprint() string {
+	n := *t
+	n.Indexes = append([]Index(nil), t.Indexes...)
+	sort.Slice(n.Indexes, func(i, j int) bool { return n.Indexes[i].Name < n.Indexes[j].Name })
+	n.ForeignKeys = append([]ForeignKey(nil), t.ForeignKeys...)
+	sort.Slice(n.ForeignKeys, func(i, j int) bool { return n.ForeignKeys[i].Name < n.ForeignKeys[j].Name })
+	n.Checks = append([]Check(nil), t.Checks...)
+	sort.Slice(n.Checks, func(i, j int) bool { return n.Checks[i].Name < n.Checks[j].Name })
+	return hashJSON(n)
+}
+
+// Fingerprint returns a hash over the fingerprints of all tables and the
+// definitions of views and routines.
+func (s *Schema) Fingerprint() string {
+	tables := make(map[string]string, len(s.Tables))
+	for i := range s.Tables {
+		tables[s.Tables[i].Name] = s.Tables[i].Fingerprint()
+	}
+	views := make(map[string]string, len(s.Views))
+	for _, v := range s.Views {
+		views[v.Name] = v.Definition
+	}
+	routines := make(map[string]string, len(s.Routines))
+	for _, r := range s.Routines {
+		routines[r.Kind+" "+r.Name] = r.Definition
+	}
+	return hashJSON(map[string]map[string]string{"tables": tables, "views": views, "routines": routines})
+}
+
+func hashJSON(v interface{}) string {
+	// Marshal sorts map keys, and the schema types hold nothing that can fail
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
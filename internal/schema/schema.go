@@ -0,0 +1,96 @@
+// Package schema describes the structure of a relational database in an
+// engine neutral way, so it can be translated or compared across engines.
+package schema
+
+// Schema is the structure of a single database.
+type Schema struct {
+	Engine   string    `json:"engine"`
+	Database string    `json:"database"`
+	Tables   []Table   `json:"tables"`
+	Views    []View    `json:"views,omitempty"`
+	Routines []Routine `json:"routines,omitempty"`
+}
+
+type Table struct {
+	Name        string       `json:"name"`
+	Columns     []Column     `json:"columns"`
+	PrimaryKey  []string     `json:"primary_key,omitempty"`
+	Indexes     []Index      `json:"indexes,omitempty"`
+	ForeignKeys []ForeignKey `json:"foreign_keys,omitempty"`
+	Checks      []Check      `json:"checks,omitempty"`
+}
+
+type Column struct {
+	Name string `json:"name"`
+	// Type is the full native type, e.g. "int(10) unsigned" or "enum('a','b')"
+	Type string `json:"type"`
+	// DataType is the base type name, e.g. "int" or "enum"
+	DataType      string  `json:"data_type"`
+	Nullable      bool    `json:"nullable"`
+	Default       *string `json:"default,omitempty"`
+	AutoIncrement bool    `json:"auto_increment,omitempty"`
+	// Extra holds engine specific attributes (e.g. "on update CURRENT_TIMESTAMP")
+	Extra string `json:"extra,omitempty"`
+}
+
+type Index struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique,omitempty"`
+	// Kind is the index method when it is not a plain btree (e.g. "FULLTEXT")
+	Kind string `json:"kind,omitempty"`
+	// Partial is set when an index column only covers a prefix of the value
+	Partial bool `json:"partial,omitempty"`
+	// Definition is the complete CREATE INDEX statement, where the engine provides one
+	Definition string `json:"definition,omitempty"`
+}
+
+type ForeignKey struct {
+	Name       string   `json:"name"`
+	Columns    []string `json:"columns"`
+	RefTable   string   `json:"ref_table"`
+	RefColumns []string `json:"ref_columns"`
+	OnUpdate   string   `json:"on_update,omitempty"`
+	OnDelete   string   `json:"on_delete,omitempty"`
+}
+
+type Check struct {
+	Name string `json:"name"`
+	// Expression is the checked condition, as the engine prints it
+	Expression string `json:"expression"`
+}
+
+// View is a named query. Definition is the query as the engine prints it.
+type View struct {
+	Name       string `json:"name"`
+	Definition string `json:"definition"`
+}
+
+// Routine is a stored function or procedure.
+type Routine struct {
+	// Name includes the argument types on engines that allow overloading
+	Name string `json:"name"`
+	// Kind is FUNCTION or PROCEDURE
+	Kind       string `json:"kind"`
+	Definition string `json:"definition"`
+}
+
+// Table returns the table with the given name, or nil.
+func (s *Schema) Table(name string) *Table {
+	for i := range s.Tables {
+		if s.Tables[i].Name == name {
+			return &s.Tables[i]
+		}
+	}
+	return nil
+}
+
+// Column returns the column with the given name, or nil.
+func (t *Table) Column(name string) *Column {
+	for i := range t.Columns {
+		if t.Columns[i].Name == name {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+-- Brings app_copy in line with app (15 differences).
+-- Review before running: dropped objects lose their data.
+DROP VIEW IF EXISTS `old_view`;
+DROP VIEW IF EXISTS `open_orders`;
+ALTER TABLE `orders` DROP FOREIGN KEY `orders_customer_fk`;
+ALTER TABLE `orders` DROP FOREIGN KEY `orders_old_fk`;
+-- column customers.name_length is generated and was left out; add it by hand
+CREATE TABLE `customers` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `name` varchar(100) NULL,
+  `created` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  CONSTRAINT `customers_name_set` CHECK (name <> '')
+);
+CREATE INDEX `customers_name` ON `customers` (`name`);
+CREATE FULLTEXT INDEX `customers_name_text` ON `customers` (`name`);
+-- index customers_name_prefix on customers uses expressions or prefix lengths; recreate it by hand
+ALTER TABLE `events` DROP PRIMARY KEY;
+ALTER TABLE `events` ADD PRIMARY KEY (`id`, `customer_id`);
+ALTER TABLE `orders` MODIFY COLUMN `status` varchar(100) NOT NULL DEFAULT 'it''s';
+ALTER TABLE `orders` ADD COLUMN `note` varchar(100) NULL;
+ALTER TABLE `orders` MODIFY COLUMN `total` numeric(10,2) NULL;
+ALTER TABLE `orders` DROP COLUMN `legacy_code`;
+DROP INDEX `orders_customer` ON `orders`;
+CREATE UNIQUE INDEX `orders_customer` ON `orders` (`customer_id`);
+ALTER TABLE `orders` DROP CHECK `orders_id_positive`;
+ALTER TABLE `orders` ADD CONSTRAINT `orders_id_positive` CHECK (id > 0);
+ALTER TABLE `orders` ADD CONSTRAINT `orders_status_known` CHECK (status in ('new', 'it''s'));
+DROP TABLE `legacy`;
+ALTER TABLE `orders` ADD CONSTRAINT `orders_customer_fk` FOREIGN KEY (`customer_id`) REFERENCES `customers` (`id`) ON DELETE CASCADE;
+CREATE VIEW `open_orders` AS select id from orders where status = 'new';
+-- function order_count differs; recreate it from SHOW CREATE FUNCTION on the left
@@ -0,0 +1,37 @@
+-- Brings app_copy in line with app (15 differences).
+-- Review before running: dropped objects lose their data.
+DROP VIEW IF EXISTS "old_view";
+DROP VIEW IF EXISTS "open_orders";
+ALTER TABLE "orders" DROP CONSTRAINT "orders_customer_fk";
+ALTER TABLE "orders" DROP CONSTRAINT "orders_old_fk";
+CREATE TABLE "customers" (
+  "id" integer NOT NULL GENERATED BY DEFAULT AS IDENTITY,
+  "name" text,
+  "created" timestamp with time zone NOT NULL DEFAULT now(),
+  "name_length" integer GENERATED ALWAYS AS (length(name)) STORED,
+  PRIMARY KEY ("id"),
+  CONSTRAINT "customers_name_set" CHECK (name <> '')
+);
+CREATE INDEX customers_name ON public.customers USING btree (name);
+CREATE INDEX customers_name_lower ON public.customers USING btree (lower(name));
+-- assumes the default primary key constraint name
+ALTER TABLE "events" DROP CONSTRAINT "events_pkey";
+ALTER TABLE "events" ADD PRIMARY KEY ("id", "customer_id");
+ALTER TABLE "orders" ALTER COLUMN "status" SET NOT NULL;
+ALTER TABLE "orders" ALTER COLUMN "status" SET DEFAULT 'it''s'::text;
+ALTER TABLE "orders" ADD COLUMN "note" text;
+ALTER TABLE "orders" ALTER COLUMN "total" TYPE numeric(10,2) USING "total"::numeric(10,2);
+ALTER TABLE "orders" DROP COLUMN "legacy_code";
+DROP INDEX "orders_customer";
+CREATE UNIQUE INDEX "orders_customer" ON "orders" ("customer_id");
+ALTER TABLE "orders" DROP CONSTRAINT "orders_id_positive";
+ALTER TABLE "orders" ADD CONSTRAINT "orders_id_positive" CHECK (id > 0);
+ALTER TABLE "orders" ADD CONSTRAINT "orders_status_known" CHECK (status in ('new', 'it''s'));
+DROP TABLE "legacy";
+ALTER TABLE "orders" ADD CONSTRAINT "orders_customer_fk" FOREIGN KEY ("customer_id") REFERENCES "customers" ("id") ON DELETE CASCADE;
+CREATE VIEW "open_orders" AS select id from orders where status = 'new';
+DROP FUNCTION IF EXISTS order_count();
+CREATE OR REPLACE FUNCTION public.order_count()
+ RETURNS integer
+ LANGUAGE sql
+AS $function$select 1$function$;
@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	// Key is the slash separated name of the object within the backend
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Backend stores backup files and metadata as objects under slash separated
+// keys, such as "mysql/source-db1_2025-11-29T10:00:00Z/metadata.json".
+// Missing objects are reported with errors matching os.ErrNotExist.
+type Backend interface {
+	// Put stores everything read from r under key. The object must not
+	// become visible if r fails.
+	Put(key string, r io.Reader) error
+	// Get opens the object stored under key.
+	Get(key string) (io.ReadCloser, error)
+	// List returns every object whose key starts with prefix, at any depth.
+	List(prefix string) ([]ObjectInfo, error)
+	Delete(key string) error
+	Stat(key string) (ObjectInfo, error)
+	// Location renders key for messages, e.g. as a path or URL.
+	Location(key string) string
+}
+
+// notObject reports err, or a missing key if key names a directory, which
+// only holds the objects below it.
+func notObject(key string, err error) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%s: %w", key, os.ErrNotExist)
+}
+
+// BackendFactory opens a backend from its storage URL.
+type BackendFactory func(u *url.URL) (Backend, error)
+
+var backends = make(map[string]BackendFactory)
+
+// RegisterBackend makes a backend available for storage URLs with scheme.
+func RegisterBackend(scheme string, factory BackendFactory) {
+	backends[scheme] = factory
+}
+
+// Open returns the backend for a storage location: a local directory, or a
+// URL such as s3://bucket/prefix.
+func Open(location string) (Backend, error) {
+	if !strings.Contains(location, "://") {
+		return NewLocalBackend(location), nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage URL %q: %w", location, err)
+	}
+	factory, ok := backends[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported storage scheme %q", u.Scheme)
+	}
+	return factory(u)
+}
+
+// Store holds the backup catalog. It defaults to the "backups" directory
+// and is replaced by the configured storage location with Use.
+var Store Backend = NewLocalBackend("backups")
+
+// Use makes the backend at location the backup store.
+func Use(location string) error {
+	b, err := Open(location)
+	if err != nil {
+		return err
+	}
+	Store = b
+	chunkSizes = nil
+	return nil
+}
+
+// Writer streams an object into the store while computing its checksum
+// and size. The object is stored by Close, or discarded by Abort. When
+// backups are deduplicated, the stream is split into chunks instead and the
+// object is its manifest.
+type Writer struct {
+	store  Backend
+	key    string
+	pw     *io.PipeWriter
+	done   chan error
+	closed bool
+	err    error
+	hash   hash.Hash
+	size   int64
+	// seal encrypts writes before they reach the store, if set
+	seal io.WriteCloser
+	// chunks splits the stream of deduplicated backups
+	chunks *chunkWriter
+}
+
+// NewWriter starts storing an object under key, or its manifest under
+// key.chunks when backups are deduplicated.
+func NewWriter(key string) *Writer {
+	if chunkCodec != nil {
+		return &Writer{store: Store, key: key + manifestExt, hash: sha256.New(), chunks: newChunkWriter(chunkCodec, chunkLevel)}
+	}
+	pr, pw := io.Pipe()
+	w := &Writer{store: Store, key: key, pw: pw, done: make(chan error, 1), hash: sha256.New()}
+	go func() {
+		err := w.store.Put(key, pr)
+		// Unblock writers if the backend gave up early
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w
+}
+
+// Seal encrypts everything written from now on for recipients, identified
+// by keyIDs. The checksum and size describe the encrypted object. Chunks of
+// deduplicated backups are encrypted one by one, and the checksum describes
+// the plaintext.
+func (w *Writer) Seal(recipients []age.Recipient, keyIDs []string) error {
+	if w.chunks != nil {
+		w.chunks.seal(recipients, keyIDs)
+		return nil
+	}
+	seal, err := util.Seal(storedWriter{w}, recipients)
+	if err != nil {
+		return err
+	}
+	w.seal = seal
+	return nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.seal != nil {
+		return w.seal.Write(p)
+	}
+	return w.writeStored(p)
+}
+
+// writeStored passes p on to the backend.
+func (w *Writer) writeStored(p []byte) (int, error) {
+	var n int
+	var err error
+	if w.chunks != nil {
+		n, err = w.chunks.Write(p)
+	} else {
+		n, err = w.pw.Write(p)
+	}
+	w.hash.Write(p[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+// storedWriter writes to the backend, bypassing the seal.
+type storedWriter struct{ w *Writer }
+
+func (s storedWriter) Write(p []byte) (int, error) {
+	return s.w.writeStored(p)
+}
+
+// Close finishes the object and waits until it is stored. Closing again
+// returns the first result.
+func (w *Writer) Close() error {
+	if !w.closed && w.chunks != nil {
+		w.closed = true
+		w.err = w.storeManifest()
+	}
+	if !w.closed {
+		w.closed = true
+		var err error
+		if w.seal != nil {
+			err = w.seal.Close()
+		}
+		w.pw.CloseWithError(err)
+		if w.err = <-w.done; w.err == nil {
+			w.err = err
+		}
+	}
+	return w.err
+}
+
+// storeManifest stores the last chunk and the manifest.
+func (w *Writer) storeManifest() error {
+	if err := w.chunks.Close(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(w.chunks.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return w.store.Put(w.key, bytes.NewReader(data))
+}
+
+// Abort discards the object, deleting it if it was already stored. Chunks
+// already stored are left to gc.
+func (w *Writer) Abort(err error) {
+	if w.closed {
+		if w.err == nil {
+			w.store.Delete(w.key)
+		}
+		return
+	}
+	w.closed = true
+	if w.chunks != nil {
+		w.err = err
+		return
+	}
+	w.pw.CloseWithError(err)
+	w.err = <-w.done
+}
+
+// Checksum returns the checksum of everything written, as "sha256:<hex>".
+func (w *Writer) Checksum() string {
+	return "sha256:" + hex.EncodeToString(w.hash.Sum(nil))
+}
+
+// Size returns the number of bytes written.
+func (w *Writer) Size() int64 {
+	return w.size
+}
+
+// Manifest returns the base name of the manifest of a deduplicated object,
+// or "".
+func (w *Writer) Manifest() string {
+	if w.chunks == nil {
+		return ""
+	}
+	return path.Base(w.key)
+}
+
+// StoredSize returns the number of bytes the object added to the store:
+// the size of its new chunks when deduplicated.
+func (w *Writer) StoredSize() int64 {
+	if w.chunks != nil {
+		return w.chunks.stored
+	}
+	return w.size
+}
+
+// LocalCopy returns a local file holding the object at key, for tools that
+// need a path: the file itself in local storage, or a download under the
+// same base name otherwise. release removes the download.
+func LocalCopy(key string) (string, func(), error) {
+	if local, ok := Store.(*LocalBackend); ok {
+		p := local.Path(key)
+		if _, err := os.Stat(p); err != nil {
+			return "", nil, err
+		}
+		return p, func() {}, nil
+	}
+
+	r, err := Store.Get(key)
+	if err != nil {
+		return "", nil, err
+	}
+	defer r.Close()
+	return download(r, path.Base(key), Store.Location(key))
+}
+
+// FileCopy returns a local file holding a backup file, like LocalCopy.
+// Deduplicated files are reassembled from their chunks.
+func FileCopy(meta Metadata, f BackupFile) (string, func(), error) {
+	if f.Manifest == "" {
+		return LocalCopy(path.Join(meta.Dir, f.Name))
+	}
+	r, err := OpenFile(meta, f)
+	if err != nil {
+		return "", nil, err
+	}
+	defer r.Close()
+	return download(r, f.Name, Store.Location(path.Join(meta.Dir, f.Manifest)))
+}
+
+// download copies r to a temporary file called name.
+func download(r io.Reader, name, location string) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "dbmigrate-fetch-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	release := func() { os.RemoveAll(dir) }
+	p := filepath.Join(dir, name)
+	f, err := os.Create(p)
+	if err == nil {
+		_, err = io.Copy(f, r)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		release()
+		return "", nil, fmt.Errorf("failed to download %s: %w", location, err)
+	}
+	return p, release, nil
+}
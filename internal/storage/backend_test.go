@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// failingReader returns some data, then fails.
+type failingReader struct{ sent bool }
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if !r.sent {
+		r.sent = true
+		return copy(p, "partial"), nil
+	}
+	return 0, errors.New("source failed")
+}
+
+// testBackend checks that b behaves as the Backend interface documents. b
+// must start out empty.
+func testBackend(t *testing.T, b Backend) {
+	put := func(t *testing.T, key, data string) {
+		t.Helper()
+		if err := b.Put(key, strings.NewReader(data)); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+	}
+	get := func(t *testing.T, key string) string {
+		t.Helper()
+		r, err := b.Get(key)
+		if err != nil {
+			t.Fatalf("get %s: %v", key, err)
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("read %s: %v", key, err)
+		}
+		return string(data)
+	}
+	list := func(t *testing.T, prefix string) []string {
+		t.Helper()
+		objects, err := b.List(prefix)
+		if err != nil {
+			t.Fatalf("list %q: %v", prefix, err)
+		}
+		keys := []string{}
+		for _, obj := range objects {
+			keys = append(keys, obj.Key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	missing := func(t *testing.T, key string) {
+		t.Helper()
+		if r, err := b.Get(key); !errors.Is(err, os.ErrNotExist) {
+			if err == nil {
+				r.Close()
+			}
+			t.Errorf("get %s: %v, want a missing key error", key, err)
+		}
+		if _, err := b.Stat(key); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("stat %s: %v, want a missing key error", key, err)
+		}
+	}
+
+	t.Run("put and get", func(t *testing.T) {
+		put(t, "mysql/backup/db.sql.gz", "first")
+		if got := get(t, "mysql/backup/db.sql.gz"); got != "first" {
+			t.Errorf("got %q", got)
+		}
+		put(t, "mysql/backup/db.sql.gz", "replaced")
+		if got := get(t, "mysql/backup/db.sql.gz"); got != "replaced" {
+			t.Errorf("got %q after overwriting", got)
+		}
+		put(t, "mysql/backup/empty", "")
+		if got := get(t, "mysql/backup/empty"); got != "" {
+			t.Errorf("got %q from an empty object", got)
+		}
+	})
+
+	t.Run("stat", func(t *testing.T) {
+		before := time.Now().Add(-time.Hour)
+		put(t, "stat/object", "12345")
+		info, err := b.Stat("stat/object")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Key != "stat/object" || info.Size != 5 {
+			t.Errorf("stat returned %+v", info)
+		}
+		if info.ModTime.Before(before) {
+			t.Errorf("modification time %v is in the past", info.ModTime)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		for _, key := range []string{"l/a/1", "l/a/2", "l/a/b/3", "l/ab/4", "l/c"} {
+			put(t, key, key)
+		}
+		for _, tt := range []struct {
+			prefix string
+			want   []string
+		}{
+			{"l/", []string{"l/a/1", "l/a/2", "l/a/b/3", "l/ab/4", "l/c"}},
+			{"l/a/", []string{"l/a/1", "l/a/2", "l/a/b/3"}},
+			{"l/a", []string{"l/a/1", "l/a/2", "l/a/b/3", "l/ab/4"}},
+			{"l/a/b/3", []string{"l/a/b/3"}},
+			{"l/missing/", []string{}},
+			{"nothing", []string{}},
+		} {
+			if got := list(t, tt.prefix); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("list %q = %q, want %q", tt.prefix, got, tt.want)
+			}
+		}
+		objects, err := b.List("l/ab/")
+		if err != nil || len(objects) != 1 || objects[0].Size != int64(len("l/ab/4")) {
+			t.Errorf("list returned %+v, %v", objects, err)
+		}
+		all := list(t, "")
+		for _, key := range []string{"l/a/1", "l/c"} {
+			if i := sort.SearchStrings(all, key); i == len(all) || all[i] != key {
+				t.Errorf("list of everything misses %s: %q", key, all)
+			}
+		}
+	})
+
+	t.Run("missing keys", func(t *testing.T) {
+		missing(t, "missing/object")
+		put(t, "missing/sibling", "x")
+		missing(t, "missing/object")
+		missing(t, "missing")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		put(t, "del/a/1", "1")
+		put(t, "del/a/2", "2")
+		if err := b.Delete("del/a/1"); err != nil {
+			t.Fatal(err)
+		}
+		missing(t, "del/a/1")
+		if got := list(t, "del/"); !reflect.DeepEqual(got, []string{"del/a/2"}) {
+			t.Errorf("list after delete = %q", got)
+		}
+		if err := b.Delete("del/a/2"); err != nil {
+			t.Fatal(err)
+		}
+		if got := list(t, "del/"); len(got) != 0 {
+			t.Errorf("list after deleting everything = %q", got)
+		}
+		// A new object can take the place of a deleted one
+		put(t, "del/a/1", "again")
+		if got := get(t, "del/a/1"); got != "again" {
+			t.Errorf("got %q", got)
+		}
+	})
+
+	t.Run("failed put", func(t *testing.T) {
+		if err := b.Put("failed/object", &failingReader{}); err == nil {
+			t.Fatal("put succeeded with a failing reader")
+		}
+		missing(t, "failed/object")
+		if got := list(t, "failed/"); len(got) != 0 {
+			t.Errorf("a failed put left %q", got)
+		}
+
+		// A failed overwrite keeps the old object
+		put(t, "failed/kept", "old")
+		if err := b.Put("failed/kept", &failingReader{}); err == nil {
+			t.Fatal("put succeeded with a failing reader")
+		}
+		if got := get(t, "failed/kept"); got != "old" {
+			t.Errorf("a failed put replaced the object with %q", got)
+		}
+	})
+}
+
+func TestLocalBackend(t *testing.T) {
+	testBackend(t, NewLocalBackend(t.TempDir()))
+}
+
+// TestS3Backend runs against the S3 compatible service in
+// DBMIGRATE_TEST_S3, a storage URL such as
+// s3://test/dbmigrate?endpoint=localhost:9000&insecure=true for a local
+// MinIO started with MINIO_ROOT_USER and MINIO_ROOT_PASSWORD set in the
+// environment. The bucket is created if needed, and each run uses its own
+// prefix within it.
+func TestS3Backend(t *testing.T) {
+	location := os.Getenv("DBMIGRATE_TEST_S3")
+	if location == "" {
+		t.Skip("DBMIGRATE_TEST_S3 is not set")
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + fmt.Sprintf("/test-%d", time.Now().UnixNano())
+	b, err := NewS3Backend(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3 := b.(*S3Backend)
+	ctx := context.Background()
+	exists, err := s3.client.BucketExists(ctx, s3.bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		if err := s3.client.MakeBucket(ctx, s3.bucket, minio.MakeBucketOptions{Region: u.Query().Get("region")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		objects, _ := b.List("")
+		for _, obj := range objects {
+			b.Delete(obj.Key)
+		}
+	})
+	testBackend(t, b)
+}
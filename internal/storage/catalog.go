@@ -0,0 +1,336 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// The catalog is a local index of the backups in a store, so listing them
+// does not read every metadata.json. It is an append-only JSONL file with
+// one entry per metadata write or backup deletion; the last entry for a
+// backup directory wins. Without a catalog directory the store is scanned.
+var catalogDir string
+
+// UseCatalog keeps the catalogs of stores in dir, one file per location.
+func UseCatalog(dir string) {
+	catalogDir = dir
+}
+
+// catalogEntry is one line of a catalog file.
+type catalogEntry struct {
+	Op   string    `json:"op"` // put, delete
+	Time string    `json:"time"`
+	Dir  string    `json:"dir"`
+	Meta *Metadata `json:"meta,omitempty"`
+}
+
+// catalogPath returns the catalog file of the current store, or "" when
+// catalogs are not used.
+func catalogPath() string {
+	if catalogDir == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(Store.Location("")))
+	return filepath.Join(catalogDir, hex.EncodeToString(sum[:8])+".jsonl")
+}
+
+// CatalogLocation returns the catalog file of the current store.
+func CatalogLocation() string {
+	return catalogPath()
+}
+
+// loadCatalog replays the catalog of the current store. A missing catalog
+// is rebuilt from the store, warning about backups left out.
+func loadCatalog() (map[string]Metadata, error) {
+	p := catalogPath()
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		stats, rebuildErr := RebuildCatalog()
+		if rebuildErr != nil {
+			return nil, rebuildErr
+		}
+		warnUnreadable(stats.Unreadable)
+		f, err = os.Open(p)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open catalog: %w", err)
+	}
+	defer f.Close()
+
+	backups := make(map[string]Metadata)
+	entries := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 64<<20)
+	var bad error
+	for scanner.Scan() {
+		if bad != nil {
+			// Only the last line may be cut short by a crash
+			return nil, fmt.Errorf("catalog %s is damaged, run 'catalog rebuild': %w", p, bad)
+		}
+		var e catalogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			bad = err
+			continue
+		}
+		entries++
+		switch e.Op {
+		case "put":
+			if e.Meta != nil {
+				meta := *e.Meta
+				meta.Dir = e.Dir
+				if err := meta.upgrade(); err != nil {
+					return nil, fmt.Errorf("catalog %s: %s: %w", p, e.Dir, err)
+				}
+				backups[e.Dir] = meta
+			}
+		case "delete":
+			delete(backups, e.Dir)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read catalog %s: %w", p, err)
+	}
+
+	// Rewrite catalogs that mostly hold superseded entries
+	if bad != nil || entries > 2*len(backups)+100 {
+		list := make([]Metadata, 0, len(backups))
+		for _, b := range backups {
+			list = append(list, b)
+		}
+		if err := writeCatalog(list); err != nil {
+			return nil, err
+		}
+	}
+	return backups, nil
+}
+
+// writeCatalog replaces the catalog of the current store with backups.
+func writeCatalog(backups []Metadata) error {
+	p := catalogPath()
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return fmt.Errorf("failed to create catalog dir: %w", err)
+	}
+	var buf bytes.Buffer
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, b := range backups {
+		b := b
+		line, err := json.Marshal(catalogEntry{Op: "put", Time: now, Dir: b.Dir, Meta: &b})
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write catalog: %w", err)
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write catalog: %w", err)
+	}
+	return nil
+}
+
+// appendCatalog records a change to the store in its catalog. If that
+// fails, the catalog is removed so it is rebuilt the next time it is used.
+func appendCatalog(op, dir string, meta *Metadata) {
+	p := catalogPath()
+	if p == "" {
+		return
+	}
+	if _, err := os.Stat(p); err != nil {
+		// Built from the store, with this change, when first needed
+		return
+	}
+	line, err := json.Marshal(catalogEntry{Op: op, Time: time.Now().UTC().Format(time.RFC3339), Dir: dir, Meta: meta})
+	if err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0600); err == nil {
+			_, err = f.Write(append(line, '\n'))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if err != nil {
+		os.Remove(p)
+	}
+}
+
+// CatalogStats sums up a catalog rebuild.
+type CatalogStats struct {
+	Backups int
+	// Unreadable lists the backup directories whose metadata could not be read
+	Unreadable []string
+}
+
+// RebuildCatalog scans the store for backups and replaces its catalog.
+func RebuildCatalog() (CatalogStats, error) {
+	var stats CatalogStats
+	backups, unreadable, err := scanBackups()
+	if err != nil {
+		return stats, err
+	}
+	stats.Backups, stats.Unreadable = len(backups), unreadable
+	if catalogPath() == "" {
+		return stats, nil
+	}
+	return stats, writeCatalog(backups)
+}
+
+// warnUnreadable logs the backup directories left out of a listing because
+// their metadata could not be read.
+func warnUnreadable(unreadable []string) {
+	for _, u := range unreadable {
+		util.Log().WithField("metadata", u).Warn("skipping backup with unreadable metadata, see 'catalog rebuild'")
+	}
+}
+
+// scanBackups reads every <engine>/<backup_dir>/metadata.json of the store,
+// and returns the directories whose metadata is unreadable.
+func scanBackups() ([]Metadata, []string, error) {
+	objects, err := Store.List("")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list %s: %w", Store.Location(""), err)
+	}
+	var backups []Metadata
+	var unreadable []string
+	for _, obj := range objects {
+		parts := strings.Split(obj.Key, "/")
+		if len(parts) != 3 || parts[2] != "metadata.json" || isRepositoryKey(obj.Key) {
+			continue
+		}
+		meta, err := loadMetadata(obj.Key)
+		if err != nil {
+			unreadable = append(unreadable, fmt.Sprintf("%s: %v", Store.Location(obj.Key), err))
+			continue
+		}
+		backups = append(backups, meta)
+	}
+	return backups, unreadable, nil
+}
+
+// Query selects backups from the catalog. Zero fields match everything.
+type Query struct {
+	// Source selects the backups of a source by ID. Backups whose metadata
+	// has no source ID match by Engine, Host and Port instead.
+	Source string
+	// Engine, Host and Port select the backups of one server
+	Engine string
+	Host   string
+	Port   int
+	// Database selects backups holding a file of that database
+	Database string
+	Status   string
+	Since    time.Time
+	Until    time.Time
+	// Tags must all be set on a backup
+	Tags []string
+}
+
+// Match reports whether meta satisfies the query.
+func (q Query) Match(meta Metadata) bool {
+	if q.Engine != "" && meta.Engine != q.Engine {
+		return false
+	}
+	switch {
+	case q.Source != "" && meta.Source != "":
+		if meta.Source != q.Source {
+			return false
+		}
+	case q.Host != "":
+		if meta.Host != q.Host || meta.Port != q.Port {
+			return false
+		}
+	case q.Source != "":
+		return false
+	}
+	if q.Status != "" && meta.Status != q.Status {
+		return false
+	}
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		t, err := time.Parse(time.RFC3339, meta.Timestamp)
+		if err != nil || (!q.Since.IsZero() && t.Before(q.Since)) || (!q.Until.IsZero() && t.After(q.Until)) {
+			return false
+		}
+	}
+	if q.Database != "" {
+		found := false
+		for _, f := range meta.Files {
+			if f.Database() == q.Database {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, tag := range q.Tags {
+		if !meta.HasTag(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasTag reports whether the backup carries tag.
+func (m Metadata) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// QueryBackups returns the backups matching q, newest first.
+func QueryBackups(q Query) ([]Metadata, error) {
+	backups, err := ListBackups()
+	if err != nil {
+		return nil, err
+	}
+	var matched []Metadata
+	for _, b := range backups {
+		if q.Match(b) {
+			matched = append(matched, b)
+		}
+	}
+	return matched, nil
+}
+
+// sortBackups orders backups newest first by their timestamps, parsed so
+// that zones and formats do not matter, then by directory.
+func sortBackups(backups []Metadata) {
+	parsed := make(map[string]time.Time, len(backups))
+	for _, b := range backups {
+		if t, err := time.Parse(time.RFC3339, b.Timestamp); err == nil {
+			parsed[b.Dir] = t
+		}
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		ti, iok := parsed[backups[i].Dir]
+		tj, jok := parsed[backups[j].Dir]
+		switch {
+		case iok && jok && !ti.Equal(tj):
+			return ti.After(tj)
+		case iok != jok:
+			// Backups with unknown timestamps go last
+			return iok
+		}
+		return backups[i].Dir > backups[j].Dir
+	})
+}
@@ -0,0 +1,594 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// Deduplicated backups split every dump stream into content-defined chunks,
+// stored once by the SHA-256 of their contents under
+// chunks/<first two hex digits>/<hex>.<variant>. The variant names how the
+// chunk is stored, its codec and the recipients it is encrypted for, so
+// chunks are only shared by backups that store them alike. A backup file is
+// then a manifest, <file>.chunks, listing its chunks in order.
+const (
+	chunkPrefix = "chunks/"
+	manifestExt = ".chunks"
+)
+
+// chunkName matches the base names of stored chunks: manifests of version 1
+// had no variant.
+var chunkName = regexp.MustCompile(`^[0-9a-f]{64}(\.[a-z0-9]+(-age-[0-9a-f]{16})?)?$`)
+
+// Chunk sizes. Cut points are searched between the minimum and maximum
+// size and aim for the average.
+const (
+	minChunkSize = 256 << 10
+	avgChunkSize = 1 << 20
+	maxChunkSize = 4 << 20
+)
+
+// Cut points are found where the top bits of a rolling gear hash are zero.
+// Below the average size more bits must match, above it fewer, which keeps
+// chunk sizes close to the average.
+const (
+	maskSmall uint64 = (1<<22 - 1) << (64 - 22)
+	maskLarge uint64 = (1<<18 - 1) << (64 - 18)
+)
+
+// gear maps each byte to a random 64-bit value. The table must never
+// change, or chunks of new backups would no longer match the stored ones.
+var gear [256]uint64
+
+func init() {
+	// splitmix64 with a fixed seed
+	x := uint64(0x64626d6967726174)
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// Manifest lists the chunks of a deduplicated backup file.
+type Manifest struct {
+	Version int `json:"version"`
+	// Compression is the codec of every chunk
+	Compression string `json:"compression"`
+	// Encrypted is set when the chunks are encrypted with age
+	Encrypted bool `json:"encrypted,omitempty"`
+	// Variant suffixes the keys of the chunks; empty in version 1
+	Variant string     `json:"variant,omitempty"`
+	Chunks  []ChunkRef `json:"chunks"`
+}
+
+// chunkVariant names chunks compressed with codec and, if keyIDs are set,
+// encrypted for those recipients.
+func chunkVariant(codec string, keyIDs []string) string {
+	if len(keyIDs) == 0 {
+		return codec
+	}
+	ids := append([]string(nil), keyIDs...)
+	sort.Strings(ids)
+	sum := sha256.Sum256([]byte(strings.Join(ids, "\n")))
+	return codec + "-age-" + hex.EncodeToString(sum[:8])
+}
+
+// ChunkRef names a chunk by the SHA-256 of its contents.
+type ChunkRef struct {
+	ID   string `json:"id"`
+	Size int64  `json:"size"`
+}
+
+// chunkCodec compresses the chunks of new backups; nil when backups are not
+// deduplicated
+var (
+	chunkCodec util.Codec
+	chunkLevel int
+)
+
+// UseDedup makes new backups in the store deduplicated, with chunks
+// compressed by codec at level. A nil codec turns deduplication off.
+func UseDedup(codec util.Codec, level int) {
+	chunkCodec, chunkLevel = codec, level
+}
+
+// Deduplicating reports whether new backups are deduplicated.
+func Deduplicating() bool {
+	return chunkCodec != nil
+}
+
+// chunkSizes caches the stored size of every chunk in the store, by key
+var chunkSizes map[string]int64
+
+// chunkIndex lists the chunks of the store once.
+func chunkIndex() (map[string]int64, error) {
+	if chunkSizes != nil {
+		return chunkSizes, nil
+	}
+	objects, err := Store.List(chunkPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chunks in %s: %w", Store.Location(""), err)
+	}
+	sizes := make(map[string]int64, len(objects))
+	for _, obj := range objects {
+		// Skips uploads in progress, such as hidden temporary files
+		if name := path.Base(obj.Key); chunkName.MatchString(name) && obj.Key == chunkPrefix+name[:2]+"/"+name {
+			sizes[obj.Key] = obj.Size
+		}
+	}
+	chunkSizes = sizes
+	return sizes, nil
+}
+
+// chunkKey returns the key of the chunk id stored as variant.
+func chunkKey(id string, variant string) string {
+	if variant == "" {
+		return chunkPrefix + id[:2] + "/" + id
+	}
+	return chunkPrefix + id[:2] + "/" + id + "." + variant
+}
+
+// isRepositoryKey reports whether key belongs to the chunk store rather
+// than to a backup.
+func isRepositoryKey(key string) bool {
+	return strings.HasPrefix(key, chunkPrefix) || strings.HasPrefix(key, lockPrefix)
+}
+
+// chunkWriter splits a stream into chunks and stores the new ones.
+type chunkWriter struct {
+	codec      util.Codec
+	level      int
+	recipients []age.Recipient
+	manifest   Manifest
+	// stored counts the bytes of the chunks this stream added to the store
+	stored int64
+
+	buf []byte
+	// pos is how far buf has been searched for a cut point, fp the hash there
+	pos int
+	fp  uint64
+}
+
+func newChunkWriter(codec util.Codec, level int) *chunkWriter {
+	return &chunkWriter{
+		codec:    codec,
+		level:    level,
+		manifest: Manifest{Version: 2, Compression: codec.Name(), Variant: chunkVariant(codec.Name(), nil), Chunks: []ChunkRef{}},
+	}
+}
+
+// seal encrypts the chunks written from now on for recipients, identified
+// by keyIDs.
+func (c *chunkWriter) seal(recipients []age.Recipient, keyIDs []string) {
+	c.recipients = recipients
+	c.manifest.Encrypted = true
+	c.manifest.Variant = chunkVariant(c.codec.Name(), keyIDs)
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	for {
+		n := c.cut()
+		if n == 0 {
+			return len(p), nil
+		}
+		if err := c.emit(c.buf[:n]); err != nil {
+			return 0, err
+		}
+		c.buf = append(c.buf[:0], c.buf[n:]...)
+		c.pos, c.fp = 0, 0
+	}
+}
+
+// cut returns the length of the next chunk in buf, or 0 if more data is
+// needed to find it.
+func (c *chunkWriter) cut() int {
+	if c.pos < minChunkSize-64 {
+		// Only the last 64 bytes influence the hash
+		c.pos = min(minChunkSize-64, len(c.buf))
+	}
+	for c.pos < len(c.buf) {
+		c.fp = c.fp<<1 + gear[c.buf[c.pos]]
+		c.pos++
+		switch {
+		case c.pos < minChunkSize:
+		case c.pos >= maxChunkSize:
+			return c.pos
+		case c.pos < avgChunkSize && c.fp&maskSmall == 0:
+			return c.pos
+		case c.pos >= avgChunkSize && c.fp&maskLarge == 0:
+			return c.pos
+		}
+	}
+	return 0
+}
+
+// emit records a chunk in the manifest and stores it unless the store
+// already holds it.
+func (c *chunkWriter) emit(data []byte) error {
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:])
+	c.manifest.Chunks = append(c.manifest.Chunks, ChunkRef{ID: id, Size: int64(len(data))})
+
+	key := chunkKey(id, c.manifest.Variant)
+	index, err := chunkIndex()
+	if err != nil {
+		return err
+	}
+	if _, ok := index[key]; ok {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	var seal io.WriteCloser
+	if len(c.recipients) > 0 {
+		if seal, err = util.Seal(&buf, c.recipients); err != nil {
+			return err
+		}
+		out = seal
+	}
+	cw, err := c.codec.NewWriter(out, c.level)
+	if err != nil {
+		return fmt.Errorf("failed to start %s compression: %w", c.codec.Name(), err)
+	}
+	if _, err := cw.Write(data); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	if seal != nil {
+		if err := seal.Close(); err != nil {
+			return err
+		}
+	}
+	if err := Store.Put(key, bytes.NewReader(buf.Bytes())); err != nil {
+		return fmt.Errorf("failed to store chunk %s: %w", id, err)
+	}
+	index[key] = int64(buf.Len())
+	c.stored += int64(buf.Len())
+	return nil
+}
+
+// Close stores the rest of the stream as the last chunk.
+func (c *chunkWriter) Close() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	err := c.emit(c.buf)
+	c.buf = nil
+	return err
+}
+
+// loadManifest reads the manifest object at key.
+func loadManifest(key string) (Manifest, error) {
+	var m Manifest
+	r, err := Store.Get(key)
+	if err != nil {
+		return m, err
+	}
+	defer r.Close()
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return m, fmt.Errorf("invalid manifest %s: %w", Store.Location(key), err)
+	}
+	return m, nil
+}
+
+// chunkReader reassembles a stream from its chunks.
+type chunkReader struct {
+	codec   util.Codec
+	variant string
+	refs    []ChunkRef
+	cur     *bytes.Reader
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for r.cur == nil || r.cur.Len() == 0 {
+		if len(r.refs) == 0 {
+			return 0, io.EOF
+		}
+		data, err := readChunk(r.refs[0], r.codec, r.variant)
+		if err != nil {
+			return 0, err
+		}
+		r.refs = r.refs[1:]
+		r.cur = bytes.NewReader(data)
+	}
+	return r.cur.Read(p)
+}
+
+func (r *chunkReader) Close() error {
+	return nil
+}
+
+// readChunk loads a chunk stored as variant and checks it against its ID.
+func readChunk(ref ChunkRef, codec util.Codec, variant string) ([]byte, error) {
+	f, err := Store.Get(chunkKey(ref.ID, variant))
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %w", ref.ID, err)
+	}
+	defer f.Close()
+	plain, err := util.Unseal(f)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := io.ReadAll(plain)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %w", ref.ID, err)
+	}
+	codecs := []util.Codec{codec}
+	if variant == "" {
+		// Version 1 manifests shared chunks whatever codec stored them
+		if c := util.DetectCodec(bufio.NewReader(bytes.NewReader(stored))); c.Name() != codec.Name() {
+			codecs = append(codecs, c)
+		}
+	}
+	for _, c := range codecs {
+		if data, decErr := decodeChunk(stored, c); decErr != nil {
+			err = decErr
+		} else if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != ref.ID || int64(len(data)) != ref.Size {
+			err = fmt.Errorf("contents do not match its ID")
+		} else {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("chunk %s is corrupt: %w", ref.ID, err)
+}
+
+// decodeChunk decompresses a stored chunk with codec.
+func decodeChunk(stored []byte, codec util.Codec) ([]byte, error) {
+	dr, err := codec.NewReader(bytes.NewReader(stored))
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+	return io.ReadAll(dr)
+}
+
+// openManifest returns a reader of the stream a manifest describes. It
+// fails with an error matching os.ErrNotExist if chunks are missing.
+func openManifest(key string) (io.ReadCloser, error) {
+	m, err := loadManifest(key)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := util.GetCodec(m.Compression)
+	if err != nil {
+		return nil, err
+	}
+	index, err := chunkIndex()
+	if err != nil {
+		return nil, err
+	}
+	missing := 0
+	for _, ref := range m.Chunks {
+		if _, ok := index[chunkKey(ref.ID, m.Variant)]; !ok {
+			missing++
+		}
+	}
+	if missing > 0 {
+		return nil, fmt.Errorf("%d of %d chunks missing: %w", missing, len(m.Chunks), os.ErrNotExist)
+	}
+	if m.Encrypted && !util.HasIdentities() {
+		return nil, util.ErrNoIdentity
+	}
+	return &chunkReader{codec: codec, variant: m.Variant, refs: m.Chunks}, nil
+}
+
+// OpenFile opens a backup file for reading: the stored object, or the
+// stream reassembled from its chunks for deduplicated backups.
+func OpenFile(meta Metadata, f BackupFile) (io.ReadCloser, error) {
+	if f.Manifest != "" {
+		return openManifest(path.Join(meta.Dir, f.Manifest))
+	}
+	return Store.Get(path.Join(meta.Dir, f.Name))
+}
+
+// GCResult sums up a garbage collection of the chunk store.
+type GCResult struct {
+	Chunks     int   `json:"chunks"`
+	Referenced int   `json:"referenced"`
+	Removed    int   `json:"removed"`
+	Freed      int64 `json:"freed"`
+	Failed     int   `json:"failed"`
+}
+
+// CollectGarbage removes the chunks no backup references. With dryRun
+// nothing is removed. It refuses to run while backups hold the repository
+// lock, or when a backup's metadata or manifest cannot be read, since its
+// chunks would be lost.
+func CollectGarbage(dryRun bool) (GCResult, error) {
+	var res GCResult
+	if !dryRun {
+		release, err := LockRepository(true)
+		if err != nil {
+			return res, err
+		}
+		defer release()
+	}
+
+	// The catalog may miss backups written from other machines, so the
+	// referenced chunks come from the metadata in the store itself
+	backups, unreadable, err := scanBackups()
+	if err != nil {
+		return res, err
+	}
+	if len(unreadable) > 0 {
+		return res, fmt.Errorf("cannot tell which chunks are referenced, unreadable metadata:\n  %s", strings.Join(unreadable, "\n  "))
+	}
+	referenced := make(map[string]bool)
+	for _, b := range backups {
+		for _, f := range b.Files {
+			if f.Manifest == "" {
+				continue
+			}
+			m, err := loadManifest(path.Join(b.Dir, f.Manifest))
+			if errors.Is(err, os.ErrNotExist) {
+				// A file whose manifest is gone cannot be restored anyway
+				continue
+			}
+			if err != nil {
+				return res, fmt.Errorf("backup %s: %w", b.ID, err)
+			}
+			for _, ref := range m.Chunks {
+				referenced[chunkKey(ref.ID, m.Variant)] = true
+			}
+		}
+	}
+
+	chunkSizes = nil
+	index, err := chunkIndex()
+	if err != nil {
+		return res, err
+	}
+	res.Chunks = len(index)
+	for key, size := range index {
+		if referenced[key] {
+			res.Referenced++
+			continue
+		}
+		if !dryRun {
+			if err := Store.Delete(key); err != nil {
+				res.Failed++
+				continue
+			}
+			delete(index, key)
+		}
+		res.Removed++
+		res.Freed += size
+	}
+	return res, nil
+}
+
+// StoredSize returns what a backup added to the store: the size of its
+// files, or of their new chunks when deduplicated.
+func (m Metadata) StoredSize() int64 {
+	var size int64
+	for _, f := range m.Files {
+		if f.Manifest != "" {
+			size += f.StoredSize
+		} else {
+			size += f.Size
+		}
+	}
+	return size
+}
+
+// RepositorySize returns the number of chunks in the store and their total
+// stored size.
+func RepositorySize() (int, int64, error) {
+	index, err := chunkIndex()
+	if err != nil {
+		return 0, 0, err
+	}
+	var total int64
+	for _, size := range index {
+		total += size
+	}
+	return len(index), total, nil
+}
+
+// Backups and garbage collection take locks under locks/ so gc never
+// removes chunks a running backup has just found in the store. A lock holds
+// the time it was last refreshed, every lockRefresh while its run goes on;
+// locks not refreshed for staleLockAge are left by crashed runs and ignored.
+const (
+	lockPrefix   = "locks/"
+	gcLockPrefix = lockPrefix + "gc"
+	lockRefresh  = 10 * time.Minute
+	staleLockAge = 2 * time.Hour
+)
+
+// LockRepository takes a lock on the chunk store: shared for backups,
+// exclusive for gc. The returned function releases it. The lock is written
+// before the others are checked, so of two runs starting at once at least
+// one sees the other and gives up.
+func LockRepository(exclusive bool) (func(), error) {
+	kind := "backup"
+	if exclusive {
+		kind = "gc"
+	}
+	host, _ := os.Hostname()
+	key := fmt.Sprintf("%s%s-%s-%d-%d", lockPrefix, kind, sanitizeHost(host), os.Getpid(), time.Now().UnixNano())
+	if err := writeLock(key); err != nil {
+		return nil, fmt.Errorf("failed to lock repository: %w", err)
+	}
+
+	objects, err := Store.List(lockPrefix)
+	if err != nil {
+		Store.Delete(key)
+		return nil, fmt.Errorf("failed to list locks in %s: %w", Store.Location(""), err)
+	}
+	for _, obj := range objects {
+		if obj.Key == key || time.Since(lockRefreshed(obj)) > staleLockAge {
+			continue
+		}
+		if exclusive || strings.HasPrefix(obj.Key, gcLockPrefix) {
+			Store.Delete(key)
+			return nil, fmt.Errorf("repository is locked by %s (delete it if no backup or gc is running)", Store.Location(obj.Key))
+		}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(lockRefresh)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				if err := writeLock(key); err != nil {
+					util.Log().WithError(err).Warn("failed to refresh repository lock")
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		Store.Delete(key)
+	}, nil
+}
+
+func writeLock(key string) error {
+	return Store.Put(key, strings.NewReader(time.Now().UTC().Format(time.RFC3339)+"\n"))
+}
+
+// lockRefreshed returns when a lock was last refreshed: the time it holds,
+// or its modification time if that cannot be read. A lock of unknown age
+// counts as fresh.
+func lockRefreshed(obj ObjectInfo) time.Time {
+	if r, err := Store.Get(obj.Key); err == nil {
+		data, _ := io.ReadAll(io.LimitReader(r, 100))
+		r.Close()
+		if t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data))); err == nil {
+			return t
+		}
+	}
+	if obj.ModTime.IsZero() {
+		return time.Now()
+	}
+	return obj.ModTime
+}
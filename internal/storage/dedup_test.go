@@ -0,0 +1,540 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"mydbportal.com/dbmigrate/internal/util"
+)
+
+// testData returns n pseudo-random bytes from splitmix64 with seed, the same
+// on every platform and Go release.
+func testData(seed uint64, n int) []byte {
+	out := make([]byte, 0, n+8)
+	var word [8]byte
+	for len(out) < n {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		binary.LittleEndian.PutUint64(word[:], z^(z>>31))
+		out = append(out, word[:]...)
+	}
+	return out[:n]
+}
+
+// useTestStore makes an empty local directory the deduplicated store for
+// the duration of the test.
+func useTestStore(t *testing.T, codec string) *LocalBackend {
+	t.Helper()
+	c, err := util.GetCodec(codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevStore, prevSizes, prevCodec, prevLevel := Store, chunkSizes, chunkCodec, chunkLevel
+	t.Cleanup(func() {
+		Store, chunkSizes, chunkCodec, chunkLevel = prevStore, prevSizes, prevCodec, prevLevel
+		util.SetIdentities(nil)
+	})
+	store := NewLocalBackend(t.TempDir())
+	Store, chunkSizes = store, nil
+	UseDedup(c, 0)
+	return store
+}
+
+// chunkStream splits data, written in pieces of writeSize bytes, and
+// returns its manifest.
+func chunkStream(t *testing.T, data []byte, writeSize int) Manifest {
+	t.Helper()
+	cw := newChunkWriter(chunkCodec, chunkLevel)
+	for rest := data; len(rest) > 0; {
+		n := min(writeSize, len(rest))
+		if _, err := cw.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return cw.manifest
+}
+
+// TestGearTable pins the gear table: changing it moves every cut point.
+func TestGearTable(t *testing.T) {
+	h := sha256.New()
+	for _, v := range gear {
+		binary.Write(h, binary.LittleEndian, v)
+	}
+	const want = "8a48d3d2703d6065130ef61026dac31eba219d2587feb772eecc9acea05f6666"
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		t.Fatalf("gear table hash is %s, want %s", got, want)
+	}
+}
+
+// goldenChunks are the chunks of testData(1, 12 MiB). Changing the chunker
+// in a way that moves them makes new backups stop sharing chunks with
+// stored ones.
+var goldenChunks = []ChunkRef{
+	{ID: "4697b837a40d48eb39d8222923436aa087df5b37e2a5f710115a1ed6fd8255f9", Size: 1049315},
+	{ID: "01722dfee173dac7bfae20d0354df11ed461ccb43338d53f5d4eeb450b9ba98c", Size: 1388675},
+	{ID: "d6606651c8ec7ce823ae7e076afd6b6d94c6d99f338babe8a5b827c89f6d1358", Size: 1226860},
+	{ID: "a87984509007e15d504225f890944deab821186980446c8df3f32c8dba4441ba", Size: 1206922},
+	{ID: "50b660e99e2f4c4a0c4d54edf7cb5b03d33cb6ed5d428297046da4189a1055a7", Size: 1134788},
+	{ID: "50ff22e5cd53441e322b38492abfc1d4baa6c73d0941d4d4f1ae4a4ee0c02d2a", Size: 1076560},
+	{ID: "44de4eb99973f5e679546b9053a2307bf7ad55bf7ec51ad4803240047c120109", Size: 1074522},
+	{ID: "552d650cb8c4d13a5a54c92fdc0bba2d0affe4b889832b607e33ebd0427ea5dd", Size: 871505},
+	{ID: "ae1dcbde866c0101ece54ebcdd07db15d2142d1732a9d780b84072c1387f93ee", Size: 1534715},
+	{ID: "5f82327198a53b76fbac648682e3d417bc3235238bb217b20894bfc4f1fd7dfe", Size: 1049962},
+	{ID: "24c48ac058938c3f5e47baebe50ba355718b2abbc4eec0c0009015042720c3ce", Size: 969088},
+}
+
+// TestChunkBoundaries checks that cut points depend only on the contents,
+// not on how the stream is written, and have not changed.
+func TestChunkBoundaries(t *testing.T) {
+	useTestStore(t, "none")
+	data := testData(1, 12<<20)
+
+	for _, writeSize := range []int{len(data), 1 << 20, 4093, 1} {
+		if writeSize == 1 && testing.Short() {
+			continue
+		}
+		m := chunkStream(t, data, writeSize)
+		for i, ref := range m.Chunks {
+			if ref.Size < minChunkSize && i < len(m.Chunks)-1 || ref.Size > maxChunkSize {
+				t.Errorf("chunk %d has size %d", i, ref.Size)
+			}
+		}
+		if len(m.Chunks) != len(goldenChunks) {
+			t.Fatalf("writes of %d bytes: %d chunks, want %d", writeSize, len(m.Chunks), len(goldenChunks))
+		}
+		for i, ref := range m.Chunks {
+			if ref != goldenChunks[i] {
+				t.Errorf("writes of %d bytes: chunk %d is %+v, want %+v", writeSize, i, ref, goldenChunks[i])
+			}
+		}
+	}
+}
+
+// TestChunkShift checks that inserting data only changes the chunks around
+// the insertion.
+func TestChunkShift(t *testing.T) {
+	useTestStore(t, "none")
+	data := testData(2, 10<<20)
+	before := chunkStream(t, data, 1<<20)
+
+	shifted := append(testData(3, 1000), data...)
+	after := chunkStream(t, shifted, 1<<20)
+
+	ids := make(map[string]bool)
+	for _, ref := range before.Chunks {
+		ids[ref.ID] = true
+	}
+	shared := 0
+	for _, ref := range after.Chunks {
+		if ids[ref.ID] {
+			shared++
+		}
+	}
+	if shared < len(before.Chunks)-1 {
+		t.Fatalf("%d of %d chunks shared after inserting 1000 bytes", shared, len(before.Chunks))
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		codec   string
+		size    int
+		encrypt bool
+	}{
+		{"empty", "gzip", 0, false},
+		{"below minimum chunk size", "gzip", 1000, false},
+		{"several chunks", "gzip", 9 << 20, false},
+		{"zstd", "zstd", 3 << 20, false},
+		{"uncompressed", "none", 3 << 20, false},
+		{"encrypted", "gzip", 3 << 20, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestStore(t, tt.codec)
+			data := testData(4, tt.size)
+
+			write := func(key string) BackupFile {
+				w := NewWriter(key)
+				if tt.encrypt {
+					if err := w.Seal([]age.Recipient{identity.Recipient()}, []string{identity.Recipient().String()}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				return BackupFile{Name: path.Base(key), Size: w.Size(), Checksum: w.Checksum(), Manifest: w.Manifest(), StoredSize: w.StoredSize()}
+			}
+			meta := Metadata{Dir: "mysql/backup"}
+			first := write("mysql/backup/shop_1.sql.gz")
+			second := write("mysql/backup/shop_2.sql.gz")
+
+			if first.Manifest != "shop_1.sql.gz"+manifestExt {
+				t.Fatalf("manifest is %q", first.Manifest)
+			}
+			sum := sha256.Sum256(data)
+			if first.Checksum != "sha256:"+hex.EncodeToString(sum[:]) || first.Size != int64(len(data)) {
+				t.Errorf("checksum %s and size %d do not describe the plaintext", first.Checksum, first.Size)
+			}
+			if second.StoredSize != 0 {
+				t.Errorf("storing the same data again added %d bytes", second.StoredSize)
+			}
+
+			if tt.encrypt {
+				if _, err := OpenFile(meta, first); !errors.Is(err, util.ErrNoIdentity) {
+					t.Fatalf("opening without an identity: %v", err)
+				}
+				util.SetIdentities([]age.Identity{identity})
+			}
+			for _, f := range []BackupFile{first, second} {
+				r, err := OpenFile(meta, f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := io.ReadAll(r)
+				r.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatalf("%s: read %d bytes that differ from the %d written", f.Name, len(got), len(data))
+				}
+			}
+		})
+	}
+}
+
+func TestChunkReaderDetectsDamage(t *testing.T) {
+	store := useTestStore(t, "gzip")
+	data := testData(5, 2<<20)
+	w := NewWriter("b/db_1.sql.gz")
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	meta := Metadata{Dir: "b"}
+	f := BackupFile{Name: "db_1.sql.gz", Manifest: w.Manifest()}
+	m, err := loadManifest(path.Join(meta.Dir, f.Manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := chunkKey(m.Chunks[len(m.Chunks)-1].ID, m.Variant)
+
+	// A chunk replaced by another's contents no longer matches its ID
+	other, err := os.ReadFile(store.Path(chunkKey(m.Chunks[0].ID, m.Variant)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(store.Path(last), other, 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenFile(meta, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("reading a damaged chunk succeeded")
+	}
+
+	// A missing chunk is reported before reading starts
+	os.Remove(store.Path(last))
+	chunkSizes = nil
+	if _, err := OpenFile(meta, f); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("opening with a missing chunk: %v", err)
+	}
+}
+
+// TestChunkFormats checks that chunks are only shared by backups that
+// compress and encrypt them alike.
+func TestChunkFormats(t *testing.T) {
+	store := useTestStore(t, "gzip")
+	first, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := testData(9, 3<<20)
+	meta := Metadata{Dir: "b"}
+
+	steps := []struct {
+		name   string
+		codec  string
+		sealTo *age.X25519Identity
+	}{
+		{"gzip", "gzip", nil},
+		{"zstd", "zstd", nil},
+		{"encrypted", "zstd", first},
+		{"other recipient", "zstd", second},
+		{"gzip again", "gzip", nil},
+	}
+	var files []BackupFile
+	for i, step := range steps {
+		c, err := util.GetCodec(step.codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		UseDedup(c, 0)
+		w := NewWriter(fmt.Sprintf("b/db_%d.sql", i))
+		if step.sealTo != nil {
+			r := step.sealTo.Recipient()
+			if err := w.Seal([]age.Recipient{r}, []string{r.String()}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		f := BackupFile{Name: path.Base(w.key), Manifest: w.Manifest(), StoredSize: w.StoredSize()}
+		files = append(files, f)
+
+		shared := step.name == "gzip again"
+		if (f.StoredSize == 0) != shared {
+			t.Errorf("%s: stored %d bytes of chunks", step.name, f.StoredSize)
+		}
+		if step.sealTo != nil {
+			m, err := loadManifest(path.Join(meta.Dir, f.Manifest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, ref := range m.Chunks {
+				stored, err := os.ReadFile(store.Path(chunkKey(ref.ID, m.Variant)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.HasPrefix(stored, []byte("age-encryption.org/")) {
+					t.Fatalf("%s: chunk %s is not encrypted", step.name, ref.ID)
+				}
+			}
+		}
+	}
+
+	util.SetIdentities([]age.Identity{first, second})
+	for i, f := range files {
+		r, err := OpenFile(meta, f)
+		if err != nil {
+			t.Fatalf("%s: %v", steps[i].name, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%s: read back %d bytes: %v", steps[i].name, len(got), err)
+		}
+	}
+
+	// Only the recipients of a backup can read it
+	util.SetIdentities([]age.Identity{second})
+	r, err := OpenFile(meta, files[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("read a backup encrypted for another recipient")
+	}
+}
+
+// TestLegacyChunks checks that manifests of version 1, whose chunks were
+// shared whatever codec stored them, can still be read.
+func TestLegacyChunks(t *testing.T) {
+	store := useTestStore(t, "gzip")
+	data := testData(10, 2<<20)
+	w := NewWriter("b/db_1.sql")
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	key := path.Join("b", w.Manifest())
+	m, err := loadManifest(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Move the chunks to version 1 keys and claim another codec for them
+	for _, ref := range m.Chunks {
+		if err := os.Rename(store.Path(chunkKey(ref.ID, m.Variant)), store.Path(chunkKey(ref.ID, ""))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Version, m.Variant, m.Compression = 1, "", "zstd"
+	encoded, _ := json.Marshal(m)
+	if err := store.Put(key, bytes.NewReader(encoded)); err != nil {
+		t.Fatal(err)
+	}
+	chunkSizes = nil
+
+	r, err := OpenFile(Metadata{Dir: "b"}, BackupFile{Name: "db_1.sql", Manifest: w.Manifest()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read back %d bytes: %v", len(got), err)
+	}
+}
+
+func TestLockStaleness(t *testing.T) {
+	store := useTestStore(t, "none")
+	release, err := LockRepository(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LockRepository(true); err == nil {
+		t.Fatal("gc locked the repository while a backup holds it")
+	}
+
+	// A lock last refreshed long ago is left by a crashed run, however
+	// recent its file is
+	locks, err := store.List(lockPrefix)
+	if err != nil || len(locks) != 1 {
+		t.Fatalf("locks: %v %v", locks, err)
+	}
+	old := time.Now().Add(-staleLockAge - time.Minute).UTC().Format(time.RFC3339)
+	if err := store.Put(locks[0].Key, strings.NewReader(old+"\n")); err != nil {
+		t.Fatal(err)
+	}
+	releaseGC, err := LockRepository(true)
+	if err != nil {
+		t.Fatalf("a stale lock blocked gc: %v", err)
+	}
+	releaseGC()
+	release()
+	if locks, _ := store.List(lockPrefix); len(locks) != 0 {
+		t.Fatalf("locks left after release: %v", locks)
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	store := useTestStore(t, "gzip")
+	prevCatalog := catalogDir
+	t.Cleanup(func() { catalogDir = prevCatalog })
+	catalogDir = ""
+
+	write := func(dir string, seed uint64) BackupFile {
+		w := NewWriter(dir + "/db_1.sql.gz")
+		w.Write(testData(seed, 2<<20))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return BackupFile{Name: "db_1.sql.gz", Manifest: w.Manifest()}
+	}
+	kept := write("mysql/kept", 6)
+	if err := WriteMetadata("mysql/kept", Metadata{Version: 2, ID: "kept", Engine: "mysql", Files: []BackupFile{kept}}); err != nil {
+		t.Fatal(err)
+	}
+	write("mysql/orphan", 7)
+
+	// A catalog written elsewhere that misses the kept backup must not
+	// decide which chunks are referenced
+	catalogDir = t.TempDir()
+	if err := writeCatalog(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := CollectGarbage(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Removed == 0 || res.Referenced == 0 || res.Failed != 0 {
+		t.Fatalf("gc result %+v", res)
+	}
+	r, err := OpenFile(Metadata{Dir: "mysql/kept"}, kept)
+	if err != nil {
+		t.Fatalf("chunks of a backup missing from the catalog were removed: %v", err)
+	}
+	r.Close()
+
+	// Unreadable metadata stops gc before anything is removed
+	write("mysql/broken", 8)
+	if err := store.Put("mysql/broken/metadata.json", strings.NewReader("{")); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := store.List(chunkPrefix)
+	if _, err := CollectGarbage(false); err == nil {
+		t.Fatal("gc ran with unreadable metadata")
+	}
+	if after, _ := store.List(chunkPrefix); len(after) != len(before) {
+		t.Fatalf("%d of %d chunks removed", len(before)-len(after), len(before))
+	}
+}
+
+// TestLockRace starts gc and backups at the same time: gc must never hold
+// the repository together with a backup.
+func TestLockRace(t *testing.T) {
+	store := useTestStore(t, "none")
+	// Slow writes widen the window between checking the locks and writing
+	// one, as on remote stores
+	Store = slowPuts{store}
+	for i := 0; i < 50; i++ {
+		var gcOK, backupOK bool
+		var releases []func()
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, exclusive := range []bool{true, false, false} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				release, err := LockRepository(exclusive)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if exclusive {
+					gcOK = true
+				} else {
+					backupOK = true
+				}
+				releases = append(releases, release)
+			}()
+		}
+		wg.Wait()
+		if gcOK && backupOK {
+			t.Fatal("gc and a backup both locked the repository")
+		}
+		for _, release := range releases {
+			release()
+		}
+		if locks, _ := store.List(lockPrefix); len(locks) != 0 {
+			t.Fatalf("locks left after release or failure: %v", locks)
+		}
+	}
+}
+
+type slowPuts struct {
+	*LocalBackend
+}
+
+func (s slowPuts) Put(key string, r io.Reader) error {
+	time.Sleep(5 * time.Millisecond)
+	return s.LocalBackend.Put(key, r)
+}
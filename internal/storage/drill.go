@@ -0,0 +1,24 @@
+package storage
+
+// DrillRecord is the outcome of a restore drill of a backup.
+type DrillRecord struct {
+	Time      string          `json:"time"`
+	Target    string          `json:"target"`
+	Status    string          `json:"status"` // passed, failed
+	Databases []DrillDatabase `json:"databases"`
+}
+
+// DrillDatabase is the drill result of one database of a backup.
+type DrillDatabase struct {
+	Database string   `json:"database"`
+	Scratch  string   `json:"scratch"`
+	Tables   int      `json:"tables"`
+	Status   string   `json:"status"` // passed, failed
+	Problems []string `json:"problems,omitempty"`
+}
+
+// RecordDrill stores rec as the last drill of meta in its metadata.json.
+func RecordDrill(meta Metadata, rec DrillRecord) error {
+	meta.LastDrill = &rec
+	return WriteMetadata(meta.Dir, meta)
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	RegisterBackend("file", func(u *url.URL) (Backend, error) {
+		return NewLocalBackend(u.Path), nil
+	})
+}
+
+// LocalBackend keeps objects as files below a root directory.
+type LocalBackend struct {
+	Root string
+}
+
+// NewLocalBackend returns a backend storing files below root.
+func NewLocalBackend(root string) *LocalBackend {
+	return &LocalBackend{Root: root}
+}
+
+// Path returns the file that holds key.
+func (b *LocalBackend) Path(key string) string {
+	return filepath.Join(b.Root, filepath.FromSlash(key))
+}
+
+// Put writes to a temporary file next to the destination and renames it
+// into place once r is exhausted.
+func (b *LocalBackend) Put(key string, r io.Reader) error {
+	dest := b.Path(key)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (b *LocalBackend) Get(key string) (io.ReadCloser, error) {
+	f, err := os.Open(b.Path(key))
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err != nil || info.IsDir() {
+		f.Close()
+		return nil, notObject(key, err)
+	}
+	return f, nil
+}
+
+func (b *LocalBackend) List(prefix string) ([]ObjectInfo, error) {
+	// Walk the deepest directory the prefix names completely
+	dir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i]
+	}
+	var objects []ObjectInfo
+	err := filepath.WalkDir(b.Path(dir), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(b.Root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return objects, err
+}
+
+// Delete removes the file of key and any directories it leaves empty.
+func (b *LocalBackend) Delete(key string) error {
+	if err := os.Remove(b.Path(key)); err != nil {
+		return err
+	}
+	for dir := path.Dir(key); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if os.Remove(b.Path(dir)) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (b *LocalBackend) Stat(key string) (ObjectInfo, error) {
+	info, err := os.Stat(b.Path(key))
+	if err != nil || info.IsDir() {
+		return ObjectInfo{}, notObject(key, err)
+	}
+	return ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (b *LocalBackend) Location(key string) string {
+	return b.Path(key)
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy decides which backups of one source to keep. Zero fields
+// are not applied.
+type RetentionPolicy struct {
+	KeepLast    int
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+	KeepYearly  int
+	// MaxAge removes backups older than this, whatever the keep rules say
+	MaxAge time.Duration
+	// MaxSize removes the oldest backups once their total size exceeds it
+	MaxSize int64
+}
+
+// hasKeepRules reports whether any keep-N rule is set. Without one, every
+// successful backup is kept unless MaxAge or MaxSize removes it.
+func (p RetentionPolicy) hasKeepRules() bool {
+	return p.KeepLast > 0 || p.KeepDaily > 0 || p.KeepWeekly > 0 || p.KeepMonthly > 0 || p.KeepYearly > 0
+}
+
+// PruneDecision is the verdict of a retention policy on one backup.
+type PruneDecision struct {
+	Backup Metadata
+	Keep   bool
+	// Reason explains the verdict, e.g. "daily 2025-11-29" or "older than max age"
+	Reason string
+}
+
+// Size returns the total size of the files of a backup.
+func (m Metadata) Size() int64 {
+	var size int64
+	for _, f := range m.Files {
+		size += f.Size
+	}
+	return size
+}
+
+// PlanRetention applies policy to the backups of one source and returns a
+// decision for each, newest first. Only successful backups count toward the
+// keep rules. Failed and partial backups are kept while they are newer than
+// the newest successful one, which is never removed.
+func PlanRetention(backups []Metadata, policy RetentionPolicy, now time.Time) []PruneDecision {
+	type entry struct {
+		PruneDecision
+		time    time.Time
+		reasons []string
+	}
+	entries := make([]*entry, 0, len(backups))
+	for _, b := range backups {
+		e := &entry{PruneDecision: PruneDecision{Backup: b}}
+		t, err := time.Parse(time.RFC3339, b.Timestamp)
+		if err != nil {
+			// Without a timestamp no rule can be applied safely
+			e.Keep, e.Reason = true, "unknown timestamp"
+		}
+		e.time = t
+		entries = append(entries, e)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].time.After(entries[j].time) })
+
+	newest := -1
+	for i, e := range entries {
+		if e.Backup.Status == "success" && e.Reason == "" {
+			newest = i
+			break
+		}
+	}
+
+	buckets := []struct {
+		name   string
+		keep   int
+		format func(time.Time) string
+	}{
+		{"daily", policy.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", policy.KeepWeekly, func(t time.Time) string {
+			y, w := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", y, w)
+		}},
+		{"monthly", policy.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", policy.KeepYearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+	seen := make([]map[string]bool, len(buckets))
+	for i := range seen {
+		seen[i] = make(map[string]bool)
+	}
+
+	successful := 0
+	for i, e := range entries {
+		if e.Reason != "" {
+			continue
+		}
+		if e.Backup.Status != "success" {
+			if newest < 0 || i < newest {
+				e.Keep, e.reasons = true, []string{"newer than the newest successful backup"}
+			} else {
+				e.reasons = []string{e.Backup.Status + " backup"}
+			}
+			continue
+		}
+
+		successful++
+		if !policy.hasKeepRules() {
+			e.Keep, e.reasons = true, []string{"no keep rules"}
+		}
+		if successful <= policy.KeepLast {
+			e.Keep = true
+			e.reasons = append(e.reasons, fmt.Sprintf("last %d", policy.KeepLast))
+		}
+		// Each period keeps its newest successful backup
+		for j, bucket := range buckets {
+			period := bucket.format(e.time)
+			if bucket.keep == 0 || seen[j][period] || len(seen[j]) >= bucket.keep {
+				continue
+			}
+			seen[j][period] = true
+			e.Keep = true
+			e.reasons = append(e.reasons, bucket.name+" "+period)
+		}
+		if !e.Keep {
+			e.reasons = []string{"not selected by any keep rule"}
+		}
+	}
+
+	var total int64
+	for i, e := range entries {
+		if i == newest {
+			e.Keep = true
+			e.reasons = append([]string{"newest successful backup"}, e.reasons...)
+		}
+		if !e.Keep || e.Reason != "" {
+			continue
+		}
+		if i != newest && policy.MaxAge > 0 && now.Sub(e.time) > policy.MaxAge {
+			e.Keep, e.reasons = false, []string{"older than max age"}
+			continue
+		}
+		if i != newest && policy.MaxSize > 0 && total+e.Backup.Size() > policy.MaxSize {
+			e.Keep, e.reasons = false, []string{"over max total size"}
+			continue
+		}
+		total += e.Backup.Size()
+	}
+
+	decisions := make([]PruneDecision, len(entries))
+	for i, e := range entries {
+		if e.Reason == "" {
+			e.Reason = strings.Join(e.reasons, ", ")
+		}
+		decisions[i] = e.PruneDecision
+	}
+	return decisions
+}
+
+// DeleteBackup removes every object of a backup from the store, metadata
+// last, so an interrupted delete leaves the backup listed.
+func DeleteBackup(meta Metadata) error {
+	objects, err := Store.List(meta.Dir + "/")
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", Store.Location(meta.Dir), err)
+	}
+	metaKey := path.Join(meta.Dir, "metadata.json")
+	for _, obj := range objects {
+		if obj.Key == metaKey {
+			continue
+		}
+		if err := Store.Delete(obj.Key); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", Store.Location(obj.Key), err)
+		}
+	}
+	if err := Store.Delete(metaKey); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", Store.Location(metaKey), err)
+	}
+	appendCatalog("delete", meta.Dir, nil)
+	return nil
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// backupAt returns a backup taken at t with one file of size bytes.
+func backupAt(id string, t time.Time, status string, size int64) Metadata {
+	return Metadata{ID: id, Timestamp: t.UTC().Format(time.RFC3339), Status: status, Files: []BackupFile{{Name: id, Size: size}}}
+}
+
+func TestPlanRetention(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	hoursAgo := func(h int) time.Time { return now.Add(-time.Duration(h) * time.Hour) }
+
+	// One backup a day at 02:00 from January 1st, and one more on the
+	// last day at 01:00
+	var daily []Metadata
+	for d := time.Date(2025, 1, 1, 2, 0, 0, 0, time.UTC); !d.After(now); d = d.AddDate(0, 0, 1) {
+		daily = append(daily, backupAt(d.Format("01-02"), d, "success", 10))
+	}
+	daily = append(daily, backupAt("03-10 early", time.Date(2025, 3, 10, 1, 0, 0, 0, time.UTC), "success", 10))
+
+	tests := []struct {
+		name    string
+		backups []Metadata
+		policy  RetentionPolicy
+		kept    []string
+	}{
+		{
+			name: "newest successful backup outlives max age",
+			backups: []Metadata{
+				backupAt("failed", hoursAgo(1), "failed", 10),
+				backupAt("newest", hoursAgo(100), "success", 10),
+				backupAt("old", hoursAgo(200), "success", 10),
+			},
+			policy: RetentionPolicy{KeepLast: 5, MaxAge: 24 * time.Hour},
+			kept:   []string{"failed", "newest"},
+		},
+		{
+			name: "newest successful backup outlives max size",
+			backups: []Metadata{
+				backupAt("newest", hoursAgo(1), "success", 500),
+				backupAt("old", hoursAgo(2), "success", 10),
+			},
+			policy: RetentionPolicy{KeepLast: 5, MaxSize: 100},
+			kept:   []string{"newest"},
+		},
+		{
+			name: "newest successful backup outlives max age without keep rules",
+			backups: []Metadata{
+				backupAt("newest", hoursAgo(1), "success", 10),
+			},
+			policy: RetentionPolicy{MaxAge: time.Minute},
+			kept:   []string{"newest"},
+		},
+		{
+			name: "failed and partial backups do not count toward keep last",
+			backups: []Metadata{
+				backupAt("s1", hoursAgo(1), "success", 10),
+				backupAt("failed", hoursAgo(2), "failed", 10),
+				backupAt("partial", hoursAgo(3), "partial", 10),
+				backupAt("s2", hoursAgo(4), "success", 10),
+				backupAt("s3", hoursAgo(5), "success", 10),
+			},
+			policy: RetentionPolicy{KeepLast: 2},
+			kept:   []string{"s1", "s2"},
+		},
+		{
+			name: "failed backups do not fill daily slots",
+			backups: []Metadata{
+				backupAt("today", hoursAgo(1), "success", 10),
+				backupAt("yesterday failed", hoursAgo(24), "failed", 10),
+				backupAt("two days ago", hoursAgo(48), "success", 10),
+				backupAt("three days ago", hoursAgo(72), "success", 10),
+			},
+			policy: RetentionPolicy{KeepDaily: 2},
+			kept:   []string{"today", "two days ago"},
+		},
+		{
+			name: "failed backups newer than the newest successful one are kept",
+			backups: []Metadata{
+				backupAt("failed", hoursAgo(1), "failed", 10),
+				backupAt("partial", hoursAgo(2), "partial", 10),
+				backupAt("success", hoursAgo(3), "success", 10),
+			},
+			policy: RetentionPolicy{KeepLast: 1},
+			kept:   []string{"failed", "partial", "success"},
+		},
+		{
+			name:    "daily weekly and monthly buckets",
+			backups: daily,
+			policy:  RetentionPolicy{KeepDaily: 3, KeepWeekly: 3, KeepMonthly: 2},
+			// Days 03-10, 03-09 and 03-08; ISO weeks 11, 10 and 9 end on
+			// 03-10, 03-09 and 03-02; months on 03-10 and 02-28
+			kept: []string{"03-10", "03-09", "03-08", "03-02", "02-28"},
+		},
+		{
+			name:    "yearly bucket",
+			backups: daily,
+			policy:  RetentionPolicy{KeepYearly: 5},
+			kept:    []string{"03-10"},
+		},
+		{
+			name: "max age",
+			backups: []Metadata{
+				backupAt("1h", hoursAgo(1), "success", 10),
+				backupAt("30h", hoursAgo(30), "success", 10),
+				backupAt("50h", hoursAgo(50), "success", 10),
+				backupAt("100h", hoursAgo(100), "success", 10),
+			},
+			policy: RetentionPolicy{MaxAge: 48 * time.Hour},
+			kept:   []string{"1h", "30h"},
+		},
+		{
+			name: "max size keeps the newest backups that fit",
+			backups: []Metadata{
+				backupAt("a", hoursAgo(1), "success", 100),
+				backupAt("b", hoursAgo(2), "success", 100),
+				backupAt("c", hoursAgo(3), "success", 100),
+				backupAt("d", hoursAgo(4), "success", 100),
+			},
+			policy: RetentionPolicy{KeepLast: 10, MaxSize: 250},
+			kept:   []string{"a", "b"},
+		},
+		{
+			name: "unknown timestamps are kept",
+			backups: []Metadata{
+				backupAt("newest", hoursAgo(1), "success", 10),
+				{ID: "no timestamp", Timestamp: "yesterday", Status: "success"},
+			},
+			policy: RetentionPolicy{KeepLast: 1},
+			kept:   []string{"newest", "no timestamp"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions := PlanRetention(tt.backups, tt.policy, now)
+			if len(decisions) != len(tt.backups) {
+				t.Fatalf("%d decisions for %d backups", len(decisions), len(tt.backups))
+			}
+			kept := []string{}
+			for _, d := range decisions {
+				if d.Keep {
+					kept = append(kept, d.Backup.ID)
+				}
+				if d.Reason == "" {
+					t.Errorf("%s has no reason", d.Backup.ID)
+				}
+			}
+			if !reflect.DeepEqual(kept, tt.kept) {
+				t.Errorf("kept %q, want %q", kept, tt.kept)
+				for _, d := range decisions {
+					t.Logf("%-15s keep=%v %s", d.Backup.ID, d.Keep, d.Reason)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+func init() {
+	RegisterBackend("s3", NewS3Backend)
+}
+
+// s3PartSize bounds the memory a streaming upload buffers per part. With
+// the 10000 part limit of S3 it allows objects of up to about 160 GiB.
+const s3PartSize = 16 << 20
+
+// S3Backend keeps objects in a bucket of Amazon S3 or a compatible service
+// such as MinIO, below an optional key prefix.
+type S3Backend struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Backend opens s3://bucket/prefix. The query may set "endpoint"
+// (host:port, default s3.amazonaws.com), "region" and "insecure=true" for
+// plain HTTP. Credentials are read from the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY (or MINIO_ROOT_USER and MINIO_ROOT_PASSWORD)
+// environment variables, or the shared AWS credentials file.
+func NewS3Backend(u *url.URL) (Backend, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("storage URL %q has no bucket", u.String())
+	}
+	q := u.Query()
+	endpoint := q.Get("endpoint")
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{},
+	})
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: q.Get("insecure") != "true",
+		Region: q.Get("region"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+	return &S3Backend{client: client, bucket: u.Host, prefix: strings.Trim(u.Path, "/")}, nil
+}
+
+func (b *S3Backend) objectName(key string) string {
+	if b.prefix == "" {
+		return key
+	}
+	return b.prefix + "/" + key
+}
+
+// notExist turns S3's missing key errors into os.ErrNotExist.
+func notExist(key string, err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case minio.NoSuchKey, "NotFound":
+		return fmt.Errorf("%s: %w", key, os.ErrNotExist)
+	}
+	return err
+}
+
+// Put uploads r in parts of s3PartSize as it is read. If r fails, the
+// multipart upload is aborted and no object is created.
+func (b *S3Backend) Put(key string, r io.Reader) error {
+	_, err := b.client.PutObject(context.Background(), b.bucket, b.objectName(key), r, -1, minio.PutObjectOptions{
+		PartSize:    s3PartSize,
+		ContentType: "application/octet-stream",
+	})
+	return err
+}
+
+func (b *S3Backend) Get(key string) (io.ReadCloser, error) {
+	obj, err := b.client.GetObject(context.Background(), b.bucket, b.objectName(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, notExist(key, err)
+	}
+	// GetObject is lazy; Stat surfaces a missing object before reading
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, notExist(key, err)
+	}
+	return obj, nil
+}
+
+func (b *S3Backend) List(prefix string) ([]ObjectInfo, error) {
+	base := ""
+	if b.prefix != "" {
+		base = b.prefix + "/"
+	}
+	var objects []ObjectInfo
+	for obj := range b.client.ListObjects(context.Background(), b.bucket, minio.ListObjectsOptions{
+		Prefix:    base + prefix,
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:     strings.TrimPrefix(obj.Key, base),
+			Size:    obj.Size,
+			ModTime: obj.LastModified,
+		})
+	}
+	return objects, nil
+}
+
+func (b *S3Backend) Delete(key string) error {
+	return b.client.RemoveObject(context.Background(), b.bucket, b.objectName(key), minio.RemoveObjectOptions{})
+}
+
+func (b *S3Backend) Stat(key string) (ObjectInfo, error) {
+	info, err := b.client.StatObject(context.Background(), b.bucket, b.objectName(key), minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, notExist(key, err)
+	}
+	return ObjectInfo{Key: key, Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+func (b *S3Backend) Location(key string) string {
+	return "s3://" + path.Join(b.bucket, b.objectName(key))
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"mydbportal.com/dbmigrate/internal/schema"
+)
+
+// WriteSchemaSnapshot stores the schema of a backed up database next to its
+// file as <file>.schema.json and returns the snapshot's file name.
+func WriteSchemaSnapshot(dirPath string, fileName string, s *schema.Schema) (string, error) {
+	name := fileName + ".schema.json"
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := Store.Put(path.Join(dirPath, name), bytes.NewReader(data)); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// LoadSchemaSnapshot reads the schema recorded for a database of a backup.
+func LoadSchemaSnapshot(meta Metadata, dbName string) (*schema.Schema, error) {
+	for _, f := range meta.Files {
+		if f.Database() != dbName {
+			continue
+		}
+		if f.SchemaFile == "" {
+			return nil, fmt.Errorf("backup %s has no schema snapshot of %s", meta.ID, dbName)
+		}
+		r, err := Store.Get(path.Join(meta.Dir, f.SchemaFile))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		var s schema.Schema
+		if err := json.NewDecoder(r).Decode(&s); err != nil {
+			return nil, fmt.Errorf("invalid schema snapshot %s: %w", f.SchemaFile, err)
+		}
+		return &s, nil
+	}
+	return nil, fmt.Errorf("backup %s has no database %s", meta.ID, dbName)
+}
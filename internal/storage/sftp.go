@@ -0,0 +1,365 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func init() {
+	RegisterBackend("sftp", NewSFTPBackend)
+}
+
+const (
+	// sftpChunkSize is the unit in which uploads are written and resumed
+	sftpChunkSize = 4 << 20
+	// sftpRetries bounds the reconnects while writing a single chunk
+	sftpRetries = 3
+)
+
+// sftpBackoff is the pause before the first reconnect; each further attempt
+// waits one more sftpBackoff.
+var sftpBackoff = time.Second
+
+// SFTPBackend keeps objects as files below a directory of an SSH server.
+// Uploads are written to a hidden .part file and renamed into place, so
+// readers never see partial objects.
+type SFTPBackend struct {
+	addr   string
+	root   string
+	config *ssh.ClientConfig
+
+	mu     sync.Mutex
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+// NewSFTPBackend opens sftp://user@host[:port]/path. The path is relative
+// to the login directory unless it starts with "//". Authentication uses
+// the password from the URL or the SFTP_PASSWORD environment variable, the
+// ssh-agent, and ~/.ssh/id_ed25519, id_ecdsa and id_rsa, or the key file
+// set with "key=". Host keys are checked against ~/.ssh/known_hosts, or the
+// file set with "known_hosts=".
+func NewSFTPBackend(u *url.URL) (Backend, error) {
+	if u.Hostname() == "" || u.User == nil {
+		return nil, fmt.Errorf("storage URL %q needs a user and host", u.Redacted())
+	}
+	q := u.Query()
+	home, _ := os.UserHomeDir()
+
+	knownHostsFile := q.Get("known_hosts")
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts: %w", err)
+	}
+
+	var auth []ssh.AuthMethod
+	password, ok := u.User.Password()
+	if !ok {
+		password = os.Getenv("SFTP_PASSWORD")
+	}
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	keyFiles := []string{q.Get("key")}
+	if keyFiles[0] == "" {
+		keyFiles = []string{
+			filepath.Join(home, ".ssh", "id_ed25519"),
+			filepath.Join(home, ".ssh", "id_ecdsa"),
+			filepath.Join(home, ".ssh", "id_rsa"),
+		}
+	}
+	var signers []ssh.Signer
+	for _, f := range keyFiles {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %s: %w", f, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	root := strings.TrimPrefix(u.Path, "/")
+	if strings.HasPrefix(u.Path, "//") {
+		root = "/" + strings.TrimLeft(u.Path, "/")
+	}
+	if root == "" {
+		root = "."
+	}
+	b := &SFTPBackend{
+		addr: net.JoinHostPort(u.Hostname(), port),
+		root: root,
+		config: &ssh.ClientConfig{
+			User:            u.User.Username(),
+			Auth:            auth,
+			HostKeyCallback: hostKeys,
+			Timeout:         30 * time.Second,
+		},
+	}
+	if _, err := b.sftpClient(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// sftpClient returns the current connection, dialing one if needed.
+func (b *SFTPBackend) sftpClient() (*sftp.Client, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.client != nil {
+		return b.client, nil
+	}
+	conn, err := ssh.Dial("tcp", b.addr, b.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", b.addr, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start sftp on %s: %w", b.addr, err)
+	}
+	b.conn, b.client = conn, client
+	return client, nil
+}
+
+// reconnect drops client, unless another caller has replaced it already.
+func (b *SFTPBackend) reconnect(client *sftp.Client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.client == client {
+		// Closing the SSH connection first keeps a dead session from blocking
+		b.conn.Close()
+		b.client.Close()
+		b.client, b.conn = nil, nil
+	}
+}
+
+func (b *SFTPBackend) path(key string) string {
+	return path.Join(b.root, key)
+}
+
+// Put uploads r in chunks to a .part file. If the connection drops, it
+// reconnects and resumes with the chunk that failed, then renames the file
+// into place. The .part file is removed if the upload fails.
+func (b *SFTPBackend) Put(key string, r io.Reader) error {
+	dest := b.path(key)
+	part := path.Join(path.Dir(dest), "."+path.Base(dest)+".part")
+	client, err := b.sftpClient()
+	if err != nil {
+		return err
+	}
+	if err := client.MkdirAll(path.Dir(dest)); err != nil {
+		return fmt.Errorf("failed to create %s: %w", path.Dir(dest), err)
+	}
+	f, err := client.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", part, err)
+	}
+	if err := b.upload(f, part, dest, r); err != nil {
+		// Uploads may have reconnected, so look up the current connection
+		if client, clientErr := b.sftpClient(); clientErr == nil {
+			client.Remove(part)
+		}
+		return err
+	}
+	return nil
+}
+
+// upload writes r to the open part file f, closes it and renames it to
+// dest.
+func (b *SFTPBackend) upload(f *sftp.File, part, dest string, r io.Reader) error {
+	buf := make([]byte, sftpChunkSize)
+	var offset int64
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			var err error
+			// writeChunk closes f if it fails
+			if f, err = b.writeChunk(f, part, buf[:n], offset); err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			f.Close()
+			return readErr
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to finish %s: %w", part, err)
+	}
+
+	client, err := b.sftpClient()
+	if err != nil {
+		return err
+	}
+	// posix-rename replaces the destination atomically; plain SFTP rename
+	// refuses to overwrite, so fall back to removing the old file first
+	if err := client.PosixRename(part, dest); err != nil {
+		client.Remove(dest)
+		if err := client.Rename(part, dest); err != nil {
+			return fmt.Errorf("failed to rename %s: %w", part, err)
+		}
+	}
+	return nil
+}
+
+// writeChunk writes data at offset of part. On failure it reconnects,
+// reopens part and writes the chunk again.
+func (b *SFTPBackend) writeChunk(f *sftp.File, part string, data []byte, offset int64) (*sftp.File, error) {
+	_, err := f.WriteAt(data, offset)
+	for attempt := 1; err != nil; attempt++ {
+		if f != nil {
+			f.Close()
+		}
+		if attempt > sftpRetries {
+			return nil, fmt.Errorf("failed to upload %s: %w", part, err)
+		}
+		time.Sleep(time.Duration(attempt) * sftpBackoff)
+		b.mu.Lock()
+		client := b.client
+		b.mu.Unlock()
+		if client != nil {
+			b.reconnect(client)
+		}
+		var info os.FileInfo
+		if client, err = b.sftpClient(); err != nil {
+			continue
+		}
+		if info, err = client.Stat(part); err != nil {
+			continue
+		}
+		// Earlier chunks are gone from memory, so the server must have them.
+		// The current one is sent again whole: its packets may have arrived
+		// out of order, leaving holes below the reported size.
+		if info.Size() < offset {
+			return nil, fmt.Errorf("cannot resume %s: only %d of %d bytes arrived", part, info.Size(), offset)
+		}
+		if f, err = client.OpenFile(part, os.O_WRONLY); err != nil {
+			continue
+		}
+		_, err = f.WriteAt(data, offset)
+	}
+	return f, nil
+}
+
+func (b *SFTPBackend) Get(key string) (io.ReadCloser, error) {
+	client, err := b.sftpClient()
+	if err != nil {
+		return nil, err
+	}
+	f, err := client.Open(b.path(key))
+	if err == nil {
+		info, statErr := f.Stat()
+		if statErr == nil && !info.IsDir() {
+			return f, nil
+		}
+		f.Close()
+		return nil, notObject(key, statErr)
+	}
+	// Servers differ in how they refuse to open a directory
+	if info, statErr := client.Stat(b.path(key)); statErr == nil && info.IsDir() {
+		return nil, notObject(key, nil)
+	}
+	return nil, err
+}
+
+func (b *SFTPBackend) List(prefix string) ([]ObjectInfo, error) {
+	client, err := b.sftpClient()
+	if err != nil {
+		return nil, err
+	}
+	dir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i]
+	}
+	var objects []ObjectInfo
+	walker := client.Walk(b.path(dir))
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if errors.Is(err, os.ErrNotExist) && walker.Path() == b.path(dir) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		info := walker.Stat()
+		if info.IsDir() {
+			continue
+		}
+		key := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), b.root), "/")
+		if b.root == "." {
+			key = strings.TrimPrefix(walker.Path(), "./")
+		}
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		}
+	}
+	return objects, nil
+}
+
+// Delete removes the file of key and any directories it leaves empty.
+func (b *SFTPBackend) Delete(key string) error {
+	client, err := b.sftpClient()
+	if err != nil {
+		return err
+	}
+	if err := client.Remove(b.path(key)); err != nil {
+		return err
+	}
+	for dir := path.Dir(key); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if client.RemoveDirectory(b.path(dir)) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (b *SFTPBackend) Stat(key string) (ObjectInfo, error) {
+	client, err := b.sftpClient()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := client.Stat(b.path(key))
+	if err != nil || info.IsDir() {
+		return ObjectInfo{}, notObject(key, err)
+	}
+	return ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (b *SFTPBackend) Location(key string) string {
+	return fmt.Sprintf("sftp://%s@%s/%s", b.config.User, b.addr, strings.TrimPrefix(b.path(key), "./"))
+}
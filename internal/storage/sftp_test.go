@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startSFTPServer serves handlers over SSH on a local port and returns the
+// URL of a store below the directory "store", logging in with a password.
+func startSFTPServer(t *testing.T, handlers sftp.Handlers) *url.URL {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == "backup" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, config, handlers)
+		}
+	}()
+
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(ln.Addr().String())}, hostKey.PublicKey())
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Keys of the user running the tests are not offered
+	t.Setenv("SSH_AUTH_SOCK", "")
+	t.Setenv("HOME", t.TempDir())
+	return &url.URL{
+		Scheme:   "sftp",
+		User:     url.UserPassword("backup", "secret"),
+		Host:     ln.Addr().String(),
+		Path:     "/store",
+		RawQuery: url.Values{"known_hosts": {knownHosts}}.Encode(),
+	}
+}
+
+// serveSFTP runs the sftp subsystem on the sessions of one SSH connection.
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig, handlers sftp.Handlers) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "only sessions are served")
+			continue
+		}
+		ch, requests, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					server := sftp.NewRequestServer(ch, handlers)
+					go func() {
+						server.Serve()
+						server.Close()
+					}()
+				}
+			}
+		}()
+	}
+}
+
+func TestSFTPBackend(t *testing.T) {
+	b, err := NewSFTPBackend(startSFTPServer(t, sftp.InMemHandler()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, b)
+}
+
+// failingWrites makes every write fail once armed.
+type failingWrites struct {
+	sftp.FileWriter
+	armed *atomic.Bool
+}
+
+func (f failingWrites) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	w, err := f.FileWriter.Filewrite(r)
+	if err != nil {
+		return nil, err
+	}
+	return failingWriterAt{w, f}, nil
+}
+
+type failingWriterAt struct {
+	io.WriterAt
+	f failingWrites
+}
+
+func (w failingWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if w.f.armed.Load() {
+		return 0, errors.New("disk full")
+	}
+	return w.WriterAt.WriteAt(p, off)
+}
+
+func TestSFTPFailedUpload(t *testing.T) {
+	prev := sftpBackoff
+	sftpBackoff = time.Millisecond
+	t.Cleanup(func() { sftpBackoff = prev })
+
+	handlers := sftp.InMemHandler()
+	var armed atomic.Bool
+	handlers.FilePut = failingWrites{FileWriter: handlers.FilePut, armed: &armed}
+	b, err := NewSFTPBackend(startSFTPServer(t, handlers))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := testData(1, 1000)
+	if err := b.Put("mysql/backup/db.sql.gz", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The chunk fails again after every reconnect
+	armed.Store(true)
+	err = b.Put("mysql/backup/next.sql.gz", bytes.NewReader(data))
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("put with failing writes: %v", err)
+	}
+	objects, err := b.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != "mysql/backup/db.sql.gz" || objects[0].Size != int64(len(data)) {
+		t.Errorf("a failed upload left %+v", objects)
+	}
+}
@@ -1,11 +1,13 @@
 package storage
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
-	"sort"
+	"strings"
 	"time"
 )
 
@@ -15,9 +17,41 @@ type BackupFile struct {
 	Size     int64  `json:"size"`
 	Status   string `json:"status"` // success, failed
 	Error    string `json:"error,omitempty"`
+	// Compression names the codec of the file; empty means gzip
+	Compression string `json:"compression,omitempty"`
+	// Manifest names the chunk list of a deduplicated file, which is not
+	// stored as an object of its own
+	Manifest string `json:"manifest,omitempty"`
+	// StoredSize is what the file added to a deduplicated store: the size
+	// of its chunks that were not stored yet
+	StoredSize int64 `json:"stored_size,omitempty"`
+	// Tables describes the contents, collected right after the dump
+	Tables []TableStats `json:"tables,omitempty"`
+	// SchemaHash fingerprints the definitions of all tables
+	SchemaHash string `json:"schema_hash,omitempty"`
+	// SchemaFile names the schema snapshot stored next to the file
+	SchemaFile string `json:"schema_file,omitempty"`
+	// DurationMS is how long the dump of the file took
+	DurationMS int64 `json:"duration_ms,omitempty"`
 }
 
+// TableStats describes one table (or collection) of a backed up database.
+type TableStats struct {
+	Name string `json:"name"`
+	// Rows is unset when row counting is disabled for the source
+	Rows *int64 `json:"rows,omitempty"`
+	// Estimated is set when Rows comes from statistics rather than a count
+	Estimated bool   `json:"estimated,omitempty"`
+	DDLHash   string `json:"ddl_hash,omitempty"`
+}
+
+// MetadataVersion is the version of the metadata format written by this
+// release. Version 1 files, which have no version field, are upgraded when
+// they are loaded.
+const MetadataVersion = 2
+
 type Metadata struct {
+	Version   int          `json:"version"`
 	ID        string       `json:"id"`
 	Engine    string       `json:"engine"`
 	Host      string       `json:"host"`
@@ -25,85 +59,224 @@ type Metadata struct {
 	User      string       `json:"user"`
 	Timestamp string       `json:"timestamp"` // ISO8601
 	Files     []BackupFile `json:"files"`
-	Status    string       `json:"status"` // success, partial, failed
+	Status    string       `json:"status"`         // success, partial, failed
+	Kind      string       `json:"kind,omitempty"` // empty for regular backups, "safety" before a restore
+	Note      string       `json:"note,omitempty"`
+	LastDrill *DrillRecord `json:"last_drill,omitempty"` // last verified restore
+	// Encryption is set when the backup files are encrypted
+	Encryption *Encryption `json:"encryption,omitempty"`
+	// Tags label a backup for queries, e.g. "pre-upgrade"
+	Tags []string `json:"tags,omitempty"`
+	// Source is the ID of the configured server the backup was taken from
+	Source string `json:"source,omitempty"`
+	// StartedAt and FinishedAt bound the backup run (RFC 3339)
+	StartedAt  string `json:"started_at,omitempty"`
+	FinishedAt string `json:"finished_at,omitempty"`
+	// Provenance records where and how the backup was taken
+	Provenance *Provenance `json:"provenance,omitempty"`
+
+	// Dir is the directory the metadata was loaded from
+	Dir string `json:"-"`
+}
+
+// Provenance records the machine, tool and settings that took a backup.
+type Provenance struct {
+	Hostname    string   `json:"hostname"`
+	ToolVersion string   `json:"tool_version"`
+	CommandLine []string `json:"command_line,omitempty"`
+	// Mode is "stream" when dumps went straight to storage, "staged" when
+	// they were written to local files first
+	Mode string `json:"mode"`
+	// Options are the engine options of the source
+	Options map[string]string `json:"options,omitempty"`
+	// RunID matches the backup to the log entries of the run that took it
+	RunID string `json:"run_id,omitempty"`
+}
+
+// Encryption describes how the files of a backup are encrypted. Metadata
+// and schema snapshots are stored in plain text.
+type Encryption struct {
+	Format string `json:"format"` // age
+	// KeyIDs identify the recipients: age public keys or SSH key fingerprints
+	KeyIDs []string `json:"key_ids"`
+}
+
+// Database returns the database a backup file holds, from its name
+// <db>_<timestamp><ext>. Full server backups are named "all-databases".
+func (f BackupFile) Database() string {
+	return DatabaseFromFilename(f.Name)
+}
+
+// Table returns the stats of the named table, or nil.
+func (f BackupFile) Table(name string) *TableStats {
+	for i := range f.Tables {
+		if f.Tables[i].Name == name {
+			return &f.Tables[i]
+		}
+	}
+	return nil
 }
 
-// Root directory for backups
-var BackupRoot = "backups"
+// DatabaseFromFilename recovers the database name from a backup file name.
+func DatabaseFromFilename(name string) string {
+	name = filepath.Base(name)
+	if i := strings.LastIndex(name, "_"); i > 0 {
+		return name[:i]
+	}
+	return name
+}
 
+// InitBackupDir returns the key prefix of a new backup in the store,
+// <engine>/source-<host>_<timestamp>, and the timestamp used in it.
 func InitBackupDir(engine, host string, ts time.Time) (string, string, error) {
-	// Format: backups/engine/source-host_timestamp/
 	tsStr := ts.Format(time.RFC3339)
-	dirName := fmt.Sprintf("source-%s_%s", host, tsStr)
-	path := filepath.Join(BackupRoot, engine, dirName)
-	
-	if err := os.MkdirAll(path, 0755); err != nil {
-		return "", "", err
+	dirName := fmt.Sprintf("source-%s_%s", sanitizeHost(host), tsStr)
+	return path.Join(engine, dirName), tsStr, nil
+}
+
+// sanitizeHost makes a host usable as a single path element: file based
+// engines (sqlite) use a path or glob as host, which must not create nested
+// dirs. Characters outside [A-Za-z0-9._-] are replaced by "_". Backup keys
+// still contain colons in their timestamps, so stores must accept them.
+func sanitizeHost(host string) string {
+	host = strings.Trim(host, "/\\[]")
+	host = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, host)
+	if host == "" || host == "." || host == ".." {
+		return "unknown"
 	}
-	return path, tsStr, nil
+	return host
 }
 
+// WriteMetadata stores meta as metadata.json below the backup's key prefix.
 func WriteMetadata(dirPath string, meta Metadata) error {
 	data, err := json.MarshalIndent(meta, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filepath.Join(dirPath, "metadata.json"), data, 0644)
+	if err := Store.Put(path.Join(dirPath, "metadata.json"), bytes.NewReader(data)); err != nil {
+		return err
+	}
+	appendCatalog("put", dirPath, &meta)
+	return nil
 }
 
+// LoadMetadata reads a metadata.json file from the local filesystem.
 func LoadMetadata(path string) (Metadata, error) {
 	var meta Metadata
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return meta, err
 	}
-	err = json.Unmarshal(data, &meta)
-	return meta, err
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, err
+	}
+	return meta, meta.upgrade()
 }
 
-// ListBackups returns all backups for a given engine/host (or all if empty)
-// This is a simplified version. In a real app, we might index them.
-func ListBackups() ([]Metadata, error) {
-	var backups []Metadata
-	
-	// Walk through backups/
-	// structure: backups/<engine>/<backup_dir>/metadata.json
-	
-	entries, err := os.ReadDir(BackupRoot)
+// upgrade migrates metadata of an older format to the current version.
+func (m *Metadata) upgrade() error {
+	switch {
+	case m.Version > MetadataVersion:
+		return fmt.Errorf("metadata version %d is newer than this release supports (%d)", m.Version, MetadataVersion)
+	case m.Version == MetadataVersion:
+		return nil
+	}
+	// Version 1 only recorded when a backup started
+	if m.StartedAt == "" {
+		m.StartedAt = m.Timestamp
+	}
+	m.Version = MetadataVersion
+	return nil
+}
+
+// Duration returns how long the backup took: the whole run, or the sum of
+// the file dumps if that is longer (run times are recorded in seconds).
+func (m Metadata) Duration() time.Duration {
+	var d time.Duration
+	for _, f := range m.Files {
+		d += time.Duration(f.DurationMS) * time.Millisecond
+	}
+	start, err1 := time.Parse(time.RFC3339, m.StartedAt)
+	end, err2 := time.Parse(time.RFC3339, m.FinishedAt)
+	if err1 == nil && err2 == nil && end.Sub(start) > d {
+		return end.Sub(start)
+	}
+	return d
+}
+
+// loadMetadata reads the metadata object at key from the store.
+func loadMetadata(key string) (Metadata, error) {
+	var meta Metadata
+	r, err := Store.Get(key)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return backups, nil
-		}
-		return nil, err
+		return meta, err
+	}
+	defer r.Close()
+	if err := json.NewDecoder(r).Decode(&meta); err != nil {
+		return meta, err
 	}
+	meta.Dir = path.Dir(key)
+	return meta, meta.upgrade()
+}
 
-	for _, engineDir := range entries {
-		if !engineDir.IsDir() {
-			continue
+// ListBackups returns all backups in the store, newest first, from the
+// catalog of the store. Without a catalog, the store is scanned for
+// <engine>/<backup_dir>/metadata.json objects.
+func ListBackups() ([]Metadata, error) {
+	var backups []Metadata
+	if catalogPath() == "" {
+		var unreadable []string
+		var err error
+		if backups, unreadable, err = scanBackups(); err != nil {
+			return nil, err
 		}
-		enginePath := filepath.Join(BackupRoot, engineDir.Name())
-		
-		backupDirs, err := os.ReadDir(enginePath)
+		warnUnreadable(unreadable)
+	} else {
+		catalog, err := loadCatalog()
 		if err != nil {
-			continue
+			return nil, err
 		}
-
-		for _, bd := range backupDirs {
-			if !bd.IsDir() {
-				continue
-			}
-			metaPath := filepath.Join(enginePath, bd.Name(), "metadata.json")
-			meta, err := LoadMetadata(metaPath)
-			if err == nil {
-				backups = append(backups, meta)
-			}
+		for _, b := range catalog {
+			backups = append(backups, b)
 		}
 	}
+	sortBackups(backups)
+	return backups, nil
+}
 
-	// Sort by timestamp desc
-	sort.Slice(backups, func(i, j int) bool {
-		return backups[i].Timestamp > backups[j].Timestamp
-	})
+// FindBackup returns the backup with the given ID.
+func FindBackup(id string) (Metadata, error) {
+	backups, err := ListBackups()
+	if err != nil {
+		return Metadata{}, err
+	}
+	for _, b := range backups {
+		if b.ID == id {
+			return b, nil
+		}
+	}
+	return Metadata{}, fmt.Errorf("backup not found: %s (run 'catalog rebuild' if it was taken elsewhere)", id)
+}
 
-	return backups, nil
-}
\ No newline at end of file
+// LatestBackup returns the newest regular backup of a source: one recorded
+// with its ID, or from metadata without a source ID, taken from its server.
+// Safety backups and backups that failed completely are ignored.
+func LatestBackup(sourceID, engine, host string, port int) (Metadata, error) {
+	q := Query{Source: sourceID, Engine: engine, Host: host, Port: port}
+	backups, err := QueryBackups(q)
+	if err != nil {
+		return Metadata{}, err
+	}
+	for _, b := range backups {
+		if b.Kind == "" && b.Status != "failed" {
+			return b, nil
+		}
+	}
+	return Metadata{}, fmt.Errorf("no backups found for %s %s", engine, host)
+}
@@ -0,0 +1,340 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterBackend("webdav", NewWebDAVBackend)
+	RegisterBackend("webdavs", NewWebDAVBackend)
+}
+
+const (
+	// webdavChunkSize is the chunk size of resumable Nextcloud uploads
+	webdavChunkSize = 8 << 20
+	// webdavRetries bounds the attempts to upload a single chunk
+	webdavRetries = 3
+)
+
+// WebDAVBackend keeps objects as files below a collection of a WebDAV
+// server. Uploads go to a hidden .part file that is moved into place.
+type WebDAVBackend struct {
+	client   *http.Client
+	base     *url.URL
+	user     string
+	password string
+	// uploads is the Nextcloud chunked upload collection, if the server is one
+	uploads *url.URL
+}
+
+// NewWebDAVBackend opens webdav://user@host/path (plain HTTP) or
+// webdavs://user@host/path (HTTPS). The password is taken from the URL or
+// the WEBDAV_PASSWORD environment variable.
+func NewWebDAVBackend(u *url.URL) (Backend, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("storage URL %q needs a host", u.Redacted())
+	}
+	base := &url.URL{Scheme: "http", Host: u.Host, Path: strings.TrimSuffix(u.Path, "/") + "/"}
+	if u.Scheme == "webdavs" {
+		base.Scheme = "https"
+	}
+	b := &WebDAVBackend{client: &http.Client{}, base: base}
+	if u.User != nil {
+		b.user = u.User.Username()
+		var ok bool
+		if b.password, ok = u.User.Password(); !ok {
+			b.password = os.Getenv("WEBDAV_PASSWORD")
+		}
+	}
+	// Nextcloud and ownCloud accept uploads in chunks that can be retried
+	if i := strings.Index(base.Path, "/remote.php/dav/files/"); i >= 0 {
+		rest := strings.SplitN(base.Path[i+len("/remote.php/dav/files/"):], "/", 2)
+		b.uploads = &url.URL{Scheme: base.Scheme, Host: base.Host, Path: base.Path[:i] + "/remote.php/dav/uploads/" + rest[0] + "/"}
+	}
+	if err := b.mkcol(b.base); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *WebDAVBackend) url(key string) *url.URL {
+	return b.base.ResolveReference(&url.URL{Path: strings.TrimPrefix(key, "/")})
+}
+
+// send sends one authenticated request.
+func (b *WebDAVBackend) send(method string, u *url.URL, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if b.user != "" {
+		req.SetBasicAuth(b.user, b.password)
+	}
+	return b.client.Do(req)
+}
+
+// do sends one request and fails on statuses other than the accepted ones.
+// A 404 is returned as os.ErrNotExist.
+func (b *WebDAVBackend) do(method string, u *url.URL, body io.Reader, header http.Header, accept ...int) (*http.Response, error) {
+	resp, err := b.send(method, u, body, header)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range accept {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s %s: %w", method, u.Path, os.ErrNotExist)
+	}
+	return nil, fmt.Errorf("%s %s: %s", method, u.Path, resp.Status)
+}
+
+// mkcol creates the collection u and its missing parents.
+// A 405 reply means the collection exists already.
+func (b *WebDAVBackend) mkcol(u *url.URL) error {
+	resp, err := b.send("MKCOL", u, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create collection %s: %w", u.Path, err)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusMethodNotAllowed:
+		return nil
+	case http.StatusConflict:
+		// The parent is missing
+		parent := path.Dir(strings.TrimSuffix(u.Path, "/"))
+		if parent == "/" || parent == "." {
+			break
+		}
+		if err := b.mkcol(&url.URL{Scheme: u.Scheme, Host: u.Host, Path: parent + "/"}); err != nil {
+			return err
+		}
+		if resp, err = b.do("MKCOL", u, nil, nil, http.StatusCreated, http.StatusMethodNotAllowed); err != nil {
+			return fmt.Errorf("failed to create collection: %w", err)
+		}
+		resp.Body.Close()
+		return nil
+	}
+	return fmt.Errorf("failed to create collection %s: %s", u.Path, resp.Status)
+}
+
+// move renames src to dest on the server, replacing dest.
+func (b *WebDAVBackend) move(src, dest *url.URL) error {
+	header := http.Header{"Destination": {dest.String()}, "Overwrite": {"T"}}
+	resp, err := b.do("MOVE", src, nil, header, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return fmt.Errorf("failed to move into place: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Put uploads r to a .part file next to key and moves it into place. On
+// Nextcloud the upload is sent in chunks, each retried on failure;
+// other servers receive a single streamed PUT.
+func (b *WebDAVBackend) Put(key string, r io.Reader) error {
+	dest := b.url(key)
+	if err := b.mkcol(b.url(path.Dir(key) + "/")); err != nil {
+		return err
+	}
+	if b.uploads != nil {
+		return b.putChunked(dest, r)
+	}
+	part := b.url(path.Join(path.Dir(key), "."+path.Base(key)+".part"))
+	resp, err := b.do(http.MethodPut, part, r, nil, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		// Servers may keep what arrived before the upload failed
+		b.do(http.MethodDelete, part, nil, nil)
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	resp.Body.Close()
+	if err := b.move(part, dest); err != nil {
+		b.do(http.MethodDelete, part, nil, nil)
+		return err
+	}
+	return nil
+}
+
+// putChunked uses Nextcloud's chunked upload: the chunks are stored in an
+// upload collection and assembled into dest by moving its .file member.
+func (b *WebDAVBackend) putChunked(dest *url.URL, r io.Reader) error {
+	upload := b.uploads.ResolveReference(&url.URL{Path: fmt.Sprintf("dbmigrate-%d/", time.Now().UnixNano())})
+	header := http.Header{"Destination": {dest.String()}}
+	resp, err := b.do("MKCOL", upload, nil, header, http.StatusCreated)
+	if err != nil {
+		return fmt.Errorf("failed to start upload: %w", err)
+	}
+	resp.Body.Close()
+
+	buf := make([]byte, webdavChunkSize)
+	for n := 1; ; n++ {
+		size, readErr := io.ReadFull(r, buf)
+		if size > 0 {
+			chunk := upload.ResolveReference(&url.URL{Path: fmt.Sprintf("%05d", n)})
+			for attempt := 1; ; attempt++ {
+				resp, err = b.do(http.MethodPut, chunk, bytes.NewReader(buf[:size]), header, http.StatusCreated, http.StatusNoContent)
+				if err == nil {
+					resp.Body.Close()
+					break
+				}
+				if attempt == webdavRetries {
+					b.do(http.MethodDelete, upload, nil, nil)
+					return fmt.Errorf("failed to upload chunk %d: %w", n, err)
+				}
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			b.do(http.MethodDelete, upload, nil, nil)
+			return readErr
+		}
+	}
+	return b.move(upload.ResolveReference(&url.URL{Path: ".file"}), dest)
+}
+
+func (b *WebDAVBackend) Get(key string) (io.ReadCloser, error) {
+	resp, err := b.do(http.MethodGet, b.url(key), nil, nil, http.StatusOK)
+	if err != nil {
+		// Servers differ in how they refuse to get a collection
+		if entries, propErr := b.propfind(b.url(key), "0"); propErr == nil && len(entries) > 0 && entries[0].Collection != nil {
+			return nil, notObject(key, nil)
+		}
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// davResponse is one entry of a PROPFIND multistatus reply.
+type davResponse struct {
+	Href         string    `xml:"href"`
+	Collection   *struct{} `xml:"propstat>prop>resourcetype>collection"`
+	Length       int64     `xml:"propstat>prop>getcontentlength"`
+	LastModified string    `xml:"propstat>prop>getlastmodified"`
+}
+
+// propfind lists u and, with depth "1", its members.
+func (b *WebDAVBackend) propfind(u *url.URL, depth string) ([]davResponse, error) {
+	header := http.Header{"Depth": {depth}, "Content-Type": {"application/xml"}}
+	body := `<?xml version="1.0"?><propfind xmlns="DAV:"><prop><resourcetype/><getcontentlength/><getlastmodified/></prop></propfind>`
+	resp, err := b.do("PROPFIND", u, strings.NewReader(body), header, http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var ms struct {
+		Responses []davResponse `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("invalid PROPFIND reply: %w", err)
+	}
+	return ms.Responses, nil
+}
+
+// key maps an href from a PROPFIND reply back to an object key.
+func (b *WebDAVBackend) key(href string) string {
+	if u, err := url.Parse(href); err == nil {
+		href = u.Path
+	}
+	return strings.TrimPrefix(href, b.base.Path)
+}
+
+func (b *WebDAVBackend) info(r davResponse) ObjectInfo {
+	modTime, _ := http.ParseTime(r.LastModified)
+	return ObjectInfo{Key: b.key(r.Href), Size: r.Length, ModTime: modTime}
+}
+
+// List walks the collections below the directory of prefix, one PROPFIND
+// per collection, since servers may refuse infinite depth.
+func (b *WebDAVBackend) List(prefix string) ([]ObjectInfo, error) {
+	dir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i+1]
+	}
+	var objects []ObjectInfo
+	pending := []string{dir}
+	for len(pending) > 0 {
+		col := pending[0]
+		pending = pending[1:]
+		entries, err := b.propfind(b.url(col), "1")
+		if err != nil {
+			if col == dir && errors.Is(err, os.ErrNotExist) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		for _, e := range entries {
+			key := b.key(e.Href)
+			if strings.TrimSuffix(key, "/") == strings.TrimSuffix(col, "/") {
+				continue
+			}
+			if e.Collection != nil {
+				pending = append(pending, strings.TrimSuffix(key, "/")+"/")
+				continue
+			}
+			if strings.HasPrefix(key, prefix) {
+				objects = append(objects, b.info(e))
+			}
+		}
+	}
+	return objects, nil
+}
+
+// Delete removes key and any collections it leaves empty.
+func (b *WebDAVBackend) Delete(key string) error {
+	resp, err := b.do(http.MethodDelete, b.url(key), nil, nil, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	for dir := path.Dir(key); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		entries, err := b.propfind(b.url(dir+"/"), "1")
+		if err != nil || len(entries) > 1 {
+			break
+		}
+		if resp, err := b.do(http.MethodDelete, b.url(dir+"/"), nil, nil, http.StatusOK, http.StatusNoContent); err == nil {
+			resp.Body.Close()
+		}
+	}
+	return nil
+}
+
+func (b *WebDAVBackend) Stat(key string) (ObjectInfo, error) {
+	entries, err := b.propfind(b.url(key), "0")
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if len(entries) == 0 || entries[0].Collection != nil {
+		return ObjectInfo{}, fmt.Errorf("stat %s: %w", key, os.ErrNotExist)
+	}
+	info := b.info(entries[0])
+	info.Key = key
+	return info, nil
+}
+
+func (b *WebDAVBackend) Location(key string) string {
+	u := *b.url(key)
+	u.Scheme = strings.Replace(u.Scheme, "http", "webdav", 1)
+	if b.user != "" {
+		u.User = url.User(b.user)
+	}
+	return u.String()
+}
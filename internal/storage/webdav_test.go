@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAVBackend(t *testing.T) {
+	server := httptest.NewServer(&webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()})
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewWebDAVBackend(&url.URL{Scheme: "webdav", Host: u.Host, Path: "/dav/store"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, b)
+}
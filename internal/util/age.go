@@ -0,0 +1,159 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"golang.org/x/crypto/ssh"
+)
+
+// ageHeader starts every file in the age format
+const ageHeader = "age-encryption.org/v1\n"
+
+// ErrNoIdentity is returned when an encrypted backup is read without an
+// identity to decrypt it.
+var ErrNoIdentity = errors.New("backup file is encrypted and no identity was given")
+
+// identities decrypt backup files when they are read
+var identities []age.Identity
+
+// SetIdentities makes encrypted backup files readable with ids.
+func SetIdentities(ids []age.Identity) {
+	identities = ids
+}
+
+// HasIdentities reports whether encrypted backup files can be read.
+func HasIdentities() bool {
+	return len(identities) > 0
+}
+
+// LoadIdentities reads an age identity file (AGE-SECRET-KEY-1... lines) or
+// an unencrypted OpenSSH ed25519 or RSA private key.
+func LoadIdentities(path string) ([]age.Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity: %w", err)
+	}
+	if ids, err := age.ParseIdentities(bytes.NewReader(data)); err == nil {
+		return ids, nil
+	}
+	id, err := agessh.ParseIdentity(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s is neither an age identity file nor an unencrypted SSH private key: %w", path, err)
+	}
+	return []age.Identity{id}, nil
+}
+
+// ParseRecipient reads an age public key (age1...), an SSH public key
+// (ssh-ed25519 ... or ssh-rsa ...) or a file with one of those per line.
+// The key IDs identify the keys in backup metadata: the age public key, or
+// the SHA256 fingerprint of an SSH key.
+func ParseRecipient(spec string) ([]age.Recipient, []string, error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case strings.HasPrefix(spec, "age1"):
+		r, err := age.ParseX25519Recipient(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []age.Recipient{r}, []string{spec}, nil
+	case strings.HasPrefix(spec, "ssh-"):
+		r, err := agessh.ParseRecipient(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(spec))
+		if err != nil {
+			return nil, nil, err
+		}
+		return []age.Recipient{r}, []string{ssh.FingerprintSHA256(pub)}, nil
+	}
+
+	file := spec
+	if strings.HasPrefix(file, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil, err
+		}
+		file = filepath.Join(home, file[2:])
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read recipients: %w", err)
+	}
+	var recipients []age.Recipient
+	var keyIDs []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, "age1") && !strings.HasPrefix(line, "ssh-") {
+			return nil, nil, fmt.Errorf("%s: unsupported recipient %q", spec, line)
+		}
+		r, ids, err := ParseRecipient(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", spec, err)
+		}
+		recipients = append(recipients, r...)
+		keyIDs = append(keyIDs, ids...)
+	}
+	if len(recipients) == 0 {
+		return nil, nil, fmt.Errorf("%s holds no recipients", spec)
+	}
+	return recipients, keyIDs, nil
+}
+
+// Seal encrypts everything written to the returned writer for recipients
+// and writes it to w. Close must be called to finish the file.
+func Seal(w io.Writer, recipients []age.Recipient) (io.WriteCloser, error) {
+	enc, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start encryption: %w", err)
+	}
+	return enc, nil
+}
+
+// Unseal returns the plaintext of r: decrypted with the identities if r is
+// in the age format, otherwise r itself.
+func Unseal(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(ageHeader))
+	if string(head) != ageHeader {
+		return br, nil
+	}
+	if len(identities) == 0 {
+		return nil, ErrNoIdentity
+	}
+	dec, err := age.Decrypt(br, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt backup file: %w", err)
+	}
+	return dec, nil
+}
+
+// OpenBackupFile opens a backup file for reading, decrypting it if needed.
+func OpenBackupFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup file: %w", err)
+	}
+	// Progress is measured on the file, as it may be encrypted
+	r, err := Unseal(countStored(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, f}, nil
+}
@@ -0,0 +1,235 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Codec compresses backup files.
+type Codec interface {
+	Name() string
+	// Extension is appended to backup file names, e.g. ".gz"
+	Extension() string
+	// Magic starts every compressed stream, nil if there is none
+	Magic() []byte
+	// Levels returns the range of valid compression levels. Level 0
+	// always selects the codec's default.
+	Levels() (min, max int)
+	NewWriter(w io.Writer, level int) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var codecs = make(map[string]Codec)
+
+// RegisterCodec makes a codec available by its name.
+func RegisterCodec(c Codec) {
+	codecs[c.Name()] = c
+}
+
+// GetCodec returns the codec called name.
+func GetCodec(name string) (Codec, error) {
+	c, ok := codecs[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %q (want %s)", name, strings.Join(CodecNames(), ", "))
+	}
+	return c, nil
+}
+
+// CodecNames lists the registered codecs.
+func CodecNames() []string {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// compression is the codec and level new backup files are written with
+var (
+	compression      Codec = gzipCodec{}
+	compressionLevel int
+)
+
+// SetCompression makes new backup files use codec c at level, 0 for the
+// codec's default.
+func SetCompression(c Codec, level int) error {
+	if min, max := c.Levels(); level != 0 && (level < min || level > max) {
+		return fmt.Errorf("%s compression level must be between %d and %d", c.Name(), min, max)
+	}
+	compression, compressionLevel = c, level
+	return nil
+}
+
+// Compression returns the codec new backup files are written with.
+func Compression() Codec {
+	return compression
+}
+
+// CompressedExt appends the extension of the current codec to a backup
+// file extension such as ".sql".
+func CompressedExt(ext string) string {
+	return ext + compression.Extension()
+}
+
+// TrimCompressedExt removes the extension of any codec from name.
+func TrimCompressedExt(name string) string {
+	for _, c := range codecs {
+		if ext := c.Extension(); ext != "" && strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+	return name
+}
+
+// compressor wraps w with the current codec.
+func compressor(w io.Writer) (io.WriteCloser, error) {
+	return countCompression(w, func(w io.Writer) (io.WriteCloser, error) {
+		cw, err := compression.NewWriter(w, compressionLevel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start %s compression: %w", compression.Name(), err)
+		}
+		return cw, nil
+	})
+}
+
+// DetectCodec returns the codec whose magic bytes start br, or the "none"
+// codec if no codec matches.
+func DetectCodec(br *bufio.Reader) Codec {
+	for _, c := range codecs {
+		magic := c.Magic()
+		if len(magic) == 0 {
+			continue
+		}
+		if head, err := br.Peek(len(magic)); err == nil && bytes.Equal(head, magic) {
+			return c
+		}
+	}
+	return noneCodec{}
+}
+
+// Decompress returns the uncompressed stream of r, detecting the codec
+// from its first bytes.
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	c := DetectCodec(br)
+	dr, err := c.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s reader: %w", c.Name(), err)
+	}
+	return countData(dr), nil
+}
+
+func init() {
+	RegisterCodec(gzipCodec{})
+	RegisterCodec(zstdCodec{})
+	RegisterCodec(lz4Codec{})
+	RegisterCodec(noneCodec{})
+}
+
+// gzipCodec compresses blocks in parallel; the output is plain gzip.
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string       { return "gzip" }
+func (gzipCodec) Extension() string  { return ".gz" }
+func (gzipCodec) Magic() []byte      { return []byte{0x1f, 0x8b} }
+func (gzipCodec) Levels() (int, int) { return 1, 9 }
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return gr, nil
+}
+
+func (gzipCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		level = pgzip.DefaultCompression
+	}
+	gw, err := pgzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+	return gw, nil
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string       { return "zstd" }
+func (zstdCodec) Extension() string  { return ".zst" }
+func (zstdCodec) Magic() []byte      { return []byte{0x28, 0xb5, 0x2f, 0xfd} }
+func (zstdCodec) Levels() (int, int) { return 1, 22 }
+
+func (zstdCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	opts := []zstd.EOption{zstd.WithEncoderConcurrency(runtime.GOMAXPROCS(0))}
+	if level != 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	zw, err := zstd.NewWriter(w, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return zw, nil
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+type lz4Codec struct{}
+
+func (lz4Codec) Name() string       { return "lz4" }
+func (lz4Codec) Extension() string  { return ".lz4" }
+func (lz4Codec) Magic() []byte      { return []byte{0x04, 0x22, 0x4d, 0x18} }
+func (lz4Codec) Levels() (int, int) { return 1, 9 }
+
+func (lz4Codec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	lw := lz4.NewWriter(w)
+	opts := []lz4.Option{lz4.ConcurrencyOption(-1)}
+	if level != 0 {
+		opts = append(opts, lz4.CompressionLevelOption(lz4.CompressionLevel(1<<(8+level))))
+	}
+	if err := lw.Apply(opts...); err != nil {
+		return nil, err
+	}
+	return lw, nil
+}
+
+func (lz4Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(lz4.NewReader(r)), nil
+}
+
+// noneCodec stores backup files uncompressed.
+type noneCodec struct{}
+
+func (noneCodec) Name() string       { return "none" }
+func (noneCodec) Extension() string  { return "" }
+func (noneCodec) Magic() []byte      { return nil }
+func (noneCodec) Levels() (int, int) { return 0, 0 }
+
+func (noneCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+func (noneCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
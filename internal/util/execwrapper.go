@@ -1,9 +1,8 @@
 package util
 
 import (
-	"compress/gzip"
 	"fmt"
-	"os"
+	"io"
 	"os/exec"
 )
 
@@ -13,65 +12,74 @@ func CommandExists(cmd string) bool {
 	return err == nil
 }
 
-// RunDumpToFile runs a dump command, compresses the output with gzip, and writes to a file.
+// RunDumpToFile runs a dump command, compresses the output, and writes to a file.
 // This avoids using 'sh -c' and handles piping in Go.
 func RunDumpToFile(dumpCmd *exec.Cmd, filePath string) error {
-	outFile, err := os.Create(filePath)
+	return CreateFile(filePath, func(w io.Writer) error {
+		return RunDump(dumpCmd, w)
+	})
+}
+
+// RunDump runs a dump command and writes its output, compressed with the
+// current codec, to w.
+func RunDump(dumpCmd *exec.Cmd, w io.Writer) error {
+	compressWriter, err := compressor(w)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return err
 	}
-	defer outFile.Close()
 
-	// Create gzip writer
-	gzipWriter := gzip.NewWriter(outFile)
-	defer gzipWriter.Close()
+	// Pipe dump command stdout to the compressor
+	dumpCmd.Stdout = compressWriter
 
-	// Pipe dump command stdout to gzip writer
-	dumpCmd.Stdout = gzipWriter
-	
-	// Capture stderr for debugging
-	dumpCmd.Stderr = os.Stderr
+	// Log stderr line by line
+	stderr := commandLog(dumpCmd)
+	dumpCmd.Stderr = stderr
 
 	if err := dumpCmd.Start(); err != nil {
+		compressWriter.Close()
 		return fmt.Errorf("failed to start dump command: %w", err)
 	}
 
 	if err := dumpCmd.Wait(); err != nil {
-		return fmt.Errorf("dump command failed: %w", err)
+		compressWriter.Close()
+		return fmt.Errorf("dump command failed: %w", stderr.wrap(err))
+	}
+	stderr.Close()
+
+	if err := compressWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finish compression: %w", err)
 	}
-    
-    // Ensure gzip is closed before file close (handled by defer, but we need to check error if flush fails)
-    if err := gzipWriter.Close(); err != nil {
-        return fmt.Errorf("failed to close gzip writer: %w", err)
-    }
 
 	return nil
 }
 
-// RestoreFromFile runs a restore command, reading from a gzipped file.
+// RestoreFromFile runs a restore command, reading from a compressed backup
+// file that may be encrypted. The codec is detected from the file.
 func RestoreFromFile(restoreCmd *exec.Cmd, filePath string) error {
-    inFile, err := os.Open(filePath)
+    inFile, err := OpenBackupFile(filePath)
     if err != nil {
-        return fmt.Errorf("failed to open backup file: %w", err)
+        return err
     }
     defer inFile.Close()
     
-    gzipReader, err := gzip.NewReader(inFile)
+    reader, err := Decompress(inFile)
     if err != nil {
-        return fmt.Errorf("failed to create gzip reader: %w", err)
+        return err
     }
-    defer gzipReader.Close()
+    defer reader.Close()
     
-    restoreCmd.Stdin = gzipReader
-    restoreCmd.Stderr = os.Stderr
+    restoreCmd.Stdin = reader
+    stderr := commandLog(restoreCmd)
+    restoreCmd.Stderr = stderr
     
     if err := restoreCmd.Start(); err != nil {
         return fmt.Errorf("failed to start restore command: %w", err)
     }
     
     if err := restoreCmd.Wait(); err != nil {
-        return fmt.Errorf("restore command failed: %w", err)
+        return fmt.Errorf("restore command failed: %w", stderr.wrap(err))
     }
+    stderr.Close()
     
     return nil
 }
@@ -0,0 +1,212 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// CompressFile compresses an existing file into destPath.
+// It is used by engines whose native tools can only write to a file
+// (e.g. SQLite's VACUUM INTO) instead of stdout.
+func CompressFile(srcPath string, destPath string) error {
+	return CreateFile(destPath, func(w io.Writer) error {
+		return CompressFileTo(srcPath, w)
+	})
+}
+
+// CompressFileTo compresses an existing file into w.
+func CompressFileTo(srcPath string, w io.Writer) error {
+	inFile, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer inFile.Close()
+
+	compressWriter, err := compressor(w)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(compressWriter, inFile); err != nil {
+		compressWriter.Close()
+		return fmt.Errorf("failed to compress file: %w", err)
+	}
+	if err := compressWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finish compression: %w", err)
+	}
+	return nil
+}
+
+// CreateFile creates destPath and passes it to fn as a writer.
+func CreateFile(destPath string, fn func(w io.Writer) error) error {
+	outFile, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer outFile.Close()
+
+	if err := fn(outFile); err != nil {
+		return err
+	}
+	return outFile.Close()
+}
+
+// DecompressFile expands a compressed backup file into destPath.
+func DecompressFile(srcPath string, destPath string) error {
+	inFile, err := OpenBackupFile(srcPath)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+
+	reader, err := Decompress(inFile)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	outFile, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer outFile.Close()
+
+	if _, err := io.Copy(outFile, reader); err != nil {
+		return fmt.Errorf("failed to decompress file: %w", err)
+	}
+	if err := outFile.Sync(); err != nil {
+		return err
+	}
+	return outFile.Close()
+}
+
+// WriteCompressed compresses whatever fn writes into destPath.
+// It is the in-process counterpart of RunDumpToFile for engines that
+// produce their dump stream in Go rather than through a native tool.
+func WriteCompressed(destPath string, fn func(w io.Writer) error) error {
+	return CreateFile(destPath, func(w io.Writer) error {
+		return Compress(w, fn)
+	})
+}
+
+// Compress compresses whatever fn writes into w with the current codec.
+func Compress(w io.Writer, fn func(w io.Writer) error) error {
+	compressWriter, err := compressor(w)
+	if err != nil {
+		return err
+	}
+	if err := fn(compressWriter); err != nil {
+		compressWriter.Close()
+		return err
+	}
+	if err := compressWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finish compression: %w", err)
+	}
+	return nil
+}
+
+// ReadCompressed opens a compressed backup file and passes the decompressed
+// stream to fn.
+func ReadCompressed(srcPath string, fn func(r io.Reader) error) error {
+	inFile, err := OpenBackupFile(srcPath)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+
+	reader, err := Decompress(inFile)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return fn(reader)
+}
+
+// ScanCompressedLines calls fn with every line of a compressed text file, until
+// fn returns false. Lines longer than 4 KiB (e.g. bulk INSERTs) are cut short.
+func ScanCompressedLines(srcPath string, fn func(line string) bool) error {
+	return ReadCompressed(srcPath, func(r io.Reader) error {
+		br := bufio.NewReaderSize(r, 64*1024)
+		for {
+			line, err := br.ReadSlice('\n')
+			text := line
+			if len(text) > 4096 {
+				text = text[:4096]
+			}
+			if len(text) > 0 && !fn(strings.TrimRight(string(text), "\r\n")) {
+				return nil
+			}
+			// Skip the rest of an overlong line
+			for err == bufio.ErrBufferFull {
+				_, err = br.ReadSlice('\n')
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// ScanCreateTables reads a compressed SQL dump and calls fn with each CREATE
+// TABLE statement, its lines joined, and other with every line outside them.
+func ScanCreateTables(srcPath string, other func(line string), fn func(stmt string)) error {
+	var stmt []string
+	return ScanCompressedLines(srcPath, func(line string) bool {
+		if stmt == nil && !strings.HasPrefix(line, "CREATE TABLE ") && !strings.HasPrefix(line, "CREATE UNLOGGED TABLE ") {
+			other(line)
+			return true
+		}
+		stmt = append(stmt, line)
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			fn(strings.Join(stmt, "\n"))
+			stmt = nil
+		}
+		return true
+	})
+}
+
+// RestoreFromFileRewriting is RestoreFromFile with every line of the
+// decompressed stream passed through rewrite first, e.g. to rename the
+// database a dump creates. Lines are passed with their trailing newline.
+func RestoreFromFileRewriting(restoreCmd *exec.Cmd, filePath string, rewrite func(line string) string) error {
+	return ReadCompressed(filePath, func(r io.Reader) error {
+		pr, pw := io.Pipe()
+		go func() {
+			br := bufio.NewReaderSize(r, 1<<20)
+			bw := bufio.NewWriterSize(pw, 1<<20)
+			var err error
+			for err == nil {
+				var line string
+				line, err = br.ReadString('\n')
+				if line != "" {
+					if _, werr := bw.WriteString(rewrite(line)); werr != nil {
+						err = werr
+					}
+				}
+			}
+			if err == io.EOF {
+				err = bw.Flush()
+			}
+			pw.CloseWithError(err)
+		}()
+
+		restoreCmd.Stdin = pr
+		stderr := commandLog(restoreCmd)
+		restoreCmd.Stderr = stderr
+		err := restoreCmd.Run()
+		// Unblock the rewriter if the command exited early
+		pr.CloseWithError(io.ErrClosedPipe)
+		if err != nil {
+			return fmt.Errorf("restore command failed: %w", stderr.wrap(err))
+		}
+		stderr.Close()
+		return nil
+	})
+}
@@ -0,0 +1,217 @@
+package util
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Logger receives the logs of all packages. Messages and string fields are
+// redacted before they are written.
+var Logger = newLogger()
+
+// RunID identifies this process in the logs of a shared log file.
+var RunID = newRunID()
+
+var (
+	logMu sync.Mutex
+	// logEntry carries the correlation fields of the current operation
+	logEntry = Logger.WithField("run_id", RunID)
+)
+
+func newLogger() *logrus.Logger {
+	l := logrus.New()
+	l.SetOutput(os.Stderr)
+	l.SetLevel(logrus.WarnLevel)
+	l.AddHook(redactHook{})
+	return l
+}
+
+func newRunID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// LogOptions configure the logger.
+type LogOptions struct {
+	// Level is a logrus level name; empty means "info" when logging to a
+	// file and "warn" otherwise
+	Level string
+	// Format is "text" or "json"
+	Format string
+	// File receives the logs instead of stderr. It is rotated once it
+	// exceeds MaxSize bytes.
+	File    string
+	MaxSize int64
+}
+
+// SetupLogging applies opts to Logger.
+func SetupLogging(opts LogOptions) error {
+	switch opts.Format {
+	case "", "text":
+		Logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true, DisableColors: opts.File != ""})
+	case "json":
+		Logger.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q (text, json)", opts.Format)
+	}
+
+	level := opts.Level
+	if level == "" {
+		level = "warn"
+		if opts.File != "" {
+			level = "info"
+		}
+	}
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("unknown log level %q (error, warn, info, debug, trace)", level)
+	}
+	Logger.SetLevel(lvl)
+
+	if opts.File != "" {
+		f, err := OpenLogFile(opts.File, opts.MaxSize)
+		if err != nil {
+			return err
+		}
+		Logger.SetOutput(f)
+	}
+	return nil
+}
+
+// Log returns the logger with the correlation fields of the current
+// operation: the run ID and whatever LogScope added.
+func Log() *logrus.Entry {
+	logMu.Lock()
+	defer logMu.Unlock()
+	return logEntry
+}
+
+// LogScope adds fields such as the source, database or attempt to the logs
+// until the returned function is called. Scopes nest:
+//
+//	defer util.LogScope(logrus.Fields{"database": db})()
+func LogScope(fields logrus.Fields) func() {
+	logMu.Lock()
+	defer logMu.Unlock()
+	prev := logEntry
+	logEntry = logEntry.WithFields(fields)
+	return func() {
+		logMu.Lock()
+		logEntry = prev
+		logMu.Unlock()
+	}
+}
+
+// LogAttempt runs attempt n of a retried operation with the attempt number
+// in the log fields, and logs its failure.
+func LogAttempt(n int, fn func() error) error {
+	defer LogScope(logrus.Fields{"attempt": n})()
+	err := fn()
+	if err != nil {
+		Log().WithError(err).Warn("attempt failed")
+	}
+	return err
+}
+
+// Command returns exec.Command(name, args...) and logs its command line,
+// with passwords masked.
+func Command(name string, args ...string) *exec.Cmd {
+	Log().WithField("command", strings.Join(RedactArgs(append([]string{name}, args...)), " ")).Debug("running command")
+	return exec.Command(name, args...)
+}
+
+// commandLog returns a writer for the stderr of cmd that logs each line as
+// a warning. The last line is kept for error messages.
+func commandLog(cmd *exec.Cmd) *lineLogger {
+	return &lineLogger{entry: Log().WithField("command", cmd.Args[0])}
+}
+
+type lineLogger struct {
+	entry *logrus.Entry
+	buf   []byte
+	last  string
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.log(string(l.buf[:i]))
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Close logs an unterminated last line.
+func (l *lineLogger) Close() error {
+	if len(l.buf) > 0 {
+		l.log(string(l.buf))
+		l.buf = nil
+	}
+	return nil
+}
+
+func (l *lineLogger) log(line string) {
+	line = strings.TrimRight(line, "\r")
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	l.last = line
+	l.entry.Warn(line)
+}
+
+// wrap adds the last line the command logged to its error.
+func (l *lineLogger) wrap(err error) error {
+	l.Close()
+	if l.last == "" {
+		return err
+	}
+	return fmt.Errorf("%w: %s", err, RedactString(l.last))
+}
+
+// redactHook masks passwords in messages and string fields.
+type redactHook struct{}
+
+func (redactHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (redactHook) Fire(e *logrus.Entry) error {
+	e.Message = RedactString(e.Message)
+	var fields logrus.Fields
+	for k, v := range e.Data {
+		var s string
+		switch v := v.(type) {
+		case string:
+			s = v
+		case error:
+			s = v.Error()
+		default:
+			continue
+		}
+		if r := RedactString(s); r != s {
+			if fields == nil {
+				fields = make(logrus.Fields, len(e.Data))
+				for k, v := range e.Data {
+					fields[k] = v
+				}
+			}
+			fields[k] = r
+		}
+	}
+	// Data may be shared with other entries, so it is replaced, not changed
+	if fields != nil {
+		e.Data = fields
+	}
+	return nil
+}
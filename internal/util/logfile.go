@@ -0,0 +1,85 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// DefaultLogMaxSize is the size at which log files are rotated.
+const DefaultLogMaxSize = 10 << 20
+
+// logKeep is the number of rotated log files kept: <path>.1 is the newest.
+const logKeep = 5
+
+// LogFile appends to a log file and rotates it once it grows past maxSize.
+type LogFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	f       *os.File
+	size    int64
+}
+
+// OpenLogFile opens path for appending. A maxSize of 0 selects
+// DefaultLogMaxSize.
+func OpenLogFile(path string, maxSize int64) (*LogFile, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultLogMaxSize
+	}
+	l := &LogFile{path: path, maxSize: maxSize}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *LogFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// Write appends p, rotating the file first if p would exceed its maximum
+// size. Entries are never split across files.
+func (l *LogFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate renames <path> to <path>.1, shifting older files up and dropping
+// the oldest, and starts a new file.
+func (l *LogFile) rotate() error {
+	l.f.Close()
+	os.Remove(fmt.Sprintf("%s.%d", l.path, logKeep))
+	for i := logKeep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	// If the file cannot be renamed, it keeps growing rather than losing
+	// entries
+	os.Rename(l.path, l.path+".1")
+	return l.open()
+}
+
+// Close closes the current file.
+func (l *LogFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
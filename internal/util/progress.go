@@ -0,0 +1,335 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/term"
+)
+
+// Progress modes.
+const (
+	ProgressAuto = "auto" // bars on a terminal, log lines otherwise
+	ProgressBars = "bars"
+	ProgressLog  = "log"
+	ProgressOff  = "off"
+)
+
+var (
+	progressMode = ProgressAuto
+	// ProgressInterval is how often progress is logged when it is not drawn
+	ProgressInterval = 30 * time.Second
+
+	// activeProgress is the dump or restore in progress, if any
+	activeProgress atomic.Pointer[Progress]
+)
+
+// SetProgressMode selects how progress is shown.
+func SetProgressMode(mode string) error {
+	switch mode {
+	case ProgressAuto, ProgressBars, ProgressLog, ProgressOff:
+		progressMode = mode
+		return nil
+	}
+	return fmt.Errorf("unknown progress mode %q (auto, bars, log, off)", mode)
+}
+
+// ProgressShown reports whether progress is shown at all.
+func ProgressShown() bool {
+	return progressMode != ProgressOff
+}
+
+// Progress counts the bytes of one dump or restore while it runs: the
+// uncompressed data, and the compressed bytes written to or read from the
+// backup file. Compression and decompression report to the active Progress,
+// so engines need no changes to be measured.
+type Progress struct {
+	label   string
+	restore bool
+	// total is the expected size of the data (dumps) or of the backup
+	// file (restores), 0 if unknown
+	total int64
+
+	data   atomic.Int64
+	stored atomic.Int64
+	start  time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// StartDumpProgress shows the progress of dumping database, whose size the
+// engine estimated as estimate bytes (0 if unknown). Call Done when the
+// dump ends.
+func StartDumpProgress(database string, estimate int64) *Progress {
+	return startProgress(&Progress{label: database, total: estimate})
+}
+
+// StartRestoreProgress shows the progress of restoring database from a
+// backup file of size bytes.
+func StartRestoreProgress(database string, size int64) *Progress {
+	return startProgress(&Progress{label: database, total: size, restore: true})
+}
+
+func startProgress(p *Progress) *Progress {
+	p.start = time.Now()
+	p.stop = make(chan struct{})
+	if prev := activeProgress.Swap(p); prev != nil {
+		prev.Done()
+	}
+
+	mode := progressMode
+	if mode == ProgressAuto {
+		mode = ProgressLog
+		if term.IsTerminal(int(os.Stderr.Fd())) {
+			mode = ProgressBars
+		}
+	}
+	switch mode {
+	case ProgressBars:
+		p.wg.Add(1)
+		go p.run(200*time.Millisecond, p.draw)
+	case ProgressLog:
+		p.wg.Add(1)
+		go p.run(ProgressInterval, p.log)
+	}
+	return p
+}
+
+func (p *Progress) run(interval time.Duration, show func()) {
+	defer p.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-t.C:
+			show()
+		}
+	}
+}
+
+// Done stops showing the progress. A bar is replaced by a summary line. It
+// may be called more than once, and on a nil Progress.
+func (p *Progress) Done() {
+	if p == nil {
+		return
+	}
+	activeProgress.CompareAndSwap(p, nil)
+	select {
+	case <-p.stop:
+		return
+	default:
+		close(p.stop)
+	}
+	p.wg.Wait()
+	if progressDrawn.Swap(false) {
+		final := p.snapshot()
+		final.ETA = 0
+		fmt.Fprintf(os.Stderr, "\r\033[K  %s  %s\n", p.label, final)
+	}
+}
+
+// progressDrawn is set while a bar is on the screen
+var progressDrawn atomic.Bool
+
+// draw redraws the progress bar on stderr.
+func (p *Progress) draw() {
+	s := p.snapshot()
+	line := "  " + p.label
+	if s.Percent >= 0 {
+		const width = 20
+		filled := int(s.Percent) * width / 100
+		line += fmt.Sprintf(" [%s%s] %3.0f%%", strings.Repeat("=", filled), strings.Repeat(" ", width-filled), s.Percent)
+	}
+	line += "  " + s.String()
+	fmt.Fprint(os.Stderr, "\r\033[K"+line)
+	progressDrawn.Store(true)
+}
+
+// log writes the progress as a log line.
+func (p *Progress) log() {
+	s := p.snapshot()
+	fields := logrus.Fields{
+		"data_bytes":   s.Data,
+		"stored_bytes": s.Stored,
+		"rate":         byteSize(int64(s.Rate)) + "/s",
+		"elapsed":      s.Elapsed.Round(time.Second).String(),
+	}
+	if s.Percent >= 0 {
+		fields["percent"] = int(s.Percent)
+	}
+	if s.ETA > 0 {
+		fields["eta"] = s.ETA.Round(time.Second).String()
+	}
+	op := "dump"
+	if p.restore {
+		op = "restore"
+	}
+	Log().WithFields(fields).Info(op + " progress")
+}
+
+// ProgressStatus is the state of a dump or restore at one moment.
+type ProgressStatus struct {
+	// Label names the database
+	Label        string
+	Restore      bool
+	Data, Stored int64
+	Elapsed      time.Duration
+	// Rate is the data throughput in bytes per second
+	Rate float64
+	// Percent is -1 and ETA 0 if they are unknown
+	Percent float64
+	ETA     time.Duration
+}
+
+// CurrentProgress returns the status of the running dump or restore, if any.
+func CurrentProgress() (ProgressStatus, bool) {
+	if p := activeProgress.Load(); p != nil {
+		return p.snapshot(), true
+	}
+	return ProgressStatus{}, false
+}
+
+func (p *Progress) snapshot() ProgressStatus {
+	s := ProgressStatus{
+		Label:   p.label,
+		Restore: p.restore,
+		Data:    p.data.Load(),
+		Stored:  p.stored.Load(),
+		Elapsed: time.Since(p.start),
+		Percent: -1,
+	}
+	if secs := s.Elapsed.Seconds(); secs > 0 {
+		s.Rate = float64(s.Data) / secs
+	}
+	// Dumps are measured by their data, restores by the file they read
+	done := s.Data
+	if p.restore {
+		done = s.Stored
+	}
+	if p.total > 0 {
+		s.Percent = 100 * float64(done) / float64(p.total)
+		// Dumps are often larger than the size estimate of the database
+		if s.Percent > 99 {
+			s.Percent = 99
+		} else if done > 0 {
+			s.ETA = time.Duration(float64(s.Elapsed) * float64(p.total-done) / float64(done))
+		}
+	}
+	return s
+}
+
+// String describes the bytes, rate, elapsed time and ETA, without the label
+// and percentage.
+func (s ProgressStatus) String() string {
+	var parts []string
+	if s.Restore {
+		parts = append(parts, byteSize(s.Stored)+" read", byteSize(s.Data)+" restored")
+	} else {
+		parts = append(parts, byteSize(s.Data)+" dumped", byteSize(s.Stored)+" written")
+	}
+	parts = append(parts, byteSize(int64(s.Rate))+"/s", formatElapsed(s.Elapsed))
+	if s.ETA > 0 {
+		parts = append(parts, "ETA "+formatElapsed(s.ETA))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatElapsed formats d as m:ss or h:mm:ss.
+func formatElapsed(d time.Duration) string {
+	secs := int(d.Round(time.Second).Seconds())
+	if secs >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+	}
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
+
+// byteSize formats n with a binary unit, e.g. "1.5 GiB".
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// counter adds the bytes passing through it to n.
+type counter struct {
+	n *atomic.Int64
+}
+
+type countingWriter struct {
+	io.Writer
+	counter
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n.Add(int64(n))
+	return n, err
+}
+
+type countingWriteCloser struct {
+	io.WriteCloser
+	counter
+}
+
+func (w countingWriteCloser) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.n.Add(int64(n))
+	return n, err
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	counter
+}
+
+func (r countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+// countCompression makes a compressor created by newCompressor report its
+// input and output to the active Progress, if any.
+func countCompression(w io.Writer, newCompressor func(w io.Writer) (io.WriteCloser, error)) (io.WriteCloser, error) {
+	p := activeProgress.Load()
+	if p == nil {
+		return newCompressor(w)
+	}
+	cw, err := newCompressor(countingWriter{w, counter{&p.stored}})
+	if err != nil {
+		return nil, err
+	}
+	return countingWriteCloser{cw, counter{&p.data}}, nil
+}
+
+// countStored makes reads of a backup file report to the active Progress.
+func countStored(r io.ReadCloser) io.ReadCloser {
+	if p := activeProgress.Load(); p != nil {
+		return countingReadCloser{r, counter{&p.stored}}
+	}
+	return r
+}
+
+// countData makes reads of decompressed data report to the active Progress.
+func countData(r io.ReadCloser) io.ReadCloser {
+	if p := activeProgress.Load(); p != nil {
+		return countingReadCloser{r, counter{&p.data}}
+	}
+	return r
+}